@@ -14,7 +14,10 @@
 
 package extend
 
-import "strconv"
+import (
+	"math"
+	"strconv"
+)
 
 func FormatInt(v int) string {
 	return strconv.FormatInt(int64(v), 10)
@@ -108,6 +111,48 @@ func FormatFloat32ToSlice(v float32) []byte {
 	return strconv.AppendFloat(nil, float64(v), 'f', -1, 32)
 }
 
+// maxExactInt is the largest magnitude below which every integer is exactly
+// representable by a float64.
+const maxExactInt = 1 << 53
+
+// FormatFloat64ToSlice formats v like redis replies scores: the shortest
+// representation that round-trips, in %.17g layout, so integers have no
+// decimal point and exponents below -4 or from 17 up use scientific notation.
+// Infinities and NaN keep the RESP2 spelling "+Inf", "-Inf" and "NaN".
 func FormatFloat64ToSlice(v float64) []byte {
-	return strconv.AppendFloat(nil, float64(v), 'f', -1, 64)
+	return formatFloat64(v, "+Inf", "-Inf", "NaN")
+}
+
+// FormatDoubleToSlice formats v like FormatFloat64ToSlice but spells the
+// non-finite values "inf", "-inf" and "nan" as RESP3 double replies require.
+func FormatDoubleToSlice(v float64) []byte {
+	return formatFloat64(v, "inf", "-inf", "nan")
+}
+
+func formatFloat64(v float64, posInf, negInf, nan string) []byte {
+	if v > -maxExactInt && v < maxExactInt && v == math.Trunc(v) && (v != 0 || !math.Signbit(v)) {
+		return strconv.AppendInt(nil, int64(v), 10)
+	}
+
+	switch {
+	case math.IsInf(v, 1):
+		return []byte(posInf)
+	case math.IsInf(v, -1):
+		return []byte(negInf)
+	case math.IsNaN(v):
+		return []byte(nan)
+	}
+
+	b := strconv.AppendFloat(nil, v, 'e', -1, 64)
+	exp := 0
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] == 'e' {
+			exp, _ = strconv.Atoi(string(b[i+1:]))
+			break
+		}
+	}
+	if exp < -4 || exp >= 17 {
+		return b
+	}
+	return strconv.AppendFloat(b[:0], v, 'f', -1, 64)
 }
@@ -0,0 +1,107 @@
+// Copyright 2019-2024 Xu Ruibo (hustxurb@163.com) and Contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package extend
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestFormatFloat64ToSlice(t *testing.T) {
+	tests := []struct {
+		input  float64
+		output string
+	}{
+		{1, "1"},
+		{-1, "-1"},
+		{0, "0"},
+		{math.Copysign(0, -1), "-0"},
+		{1.5, "1.5"},
+		{3.0e3, "3000"},
+		{0.1, "0.1"},
+		{3.14, "3.14"},
+		{-2.5, "-2.5"},
+		{0.0001, "0.0001"},
+		{0.00001, "1e-05"},
+		{1.5e-7, "1.5e-07"},
+		{1e16, "10000000000000000"},
+		{1e17, "1e+17"},
+		{1.2345e20, "1.2345e+20"},
+		{123456789.123, "123456789.123"},
+		{1.0 / 3, "0.3333333333333333"},
+		{math.MaxFloat64, "1.7976931348623157e+308"},
+		{math.SmallestNonzeroFloat64, "5e-324"},
+		{math.Inf(1), "+Inf"},
+		{math.Inf(-1), "-Inf"},
+		{math.NaN(), "NaN"},
+	}
+	for _, test := range tests {
+		if s := string(FormatFloat64ToSlice(test.input)); s != test.output {
+			t.Fatalf("format %v expected %q, got %q", test.input, test.output, s)
+		}
+	}
+}
+
+func TestFormatDoubleToSlice(t *testing.T) {
+	tests := []struct {
+		input  float64
+		output string
+	}{
+		{1.5, "1.5"},
+		{1e17, "1e+17"},
+		{math.Inf(1), "inf"},
+		{math.Inf(-1), "-inf"},
+		{math.NaN(), "nan"},
+	}
+	for _, test := range tests {
+		if s := string(FormatDoubleToSlice(test.input)); s != test.output {
+			t.Fatalf("format %v expected %q, got %q", test.input, test.output, s)
+		}
+	}
+}
+
+func TestFormatFloat64ToSliceIntegral(t *testing.T) {
+	reference := func(v float64) string {
+		b := strconv.AppendFloat(nil, v, 'e', -1, 64)
+		exp, _ := strconv.Atoi(string(b[bytes.IndexByte(b, 'e')+1:]))
+		if exp < -4 || exp >= 17 {
+			return string(b)
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+
+	values := []float64{
+		0, 1, -1, 2.5, -2.5, 100, 100.25, 1700000000, 1700000000.001,
+		maxExactInt - 1, -(maxExactInt - 1), maxExactInt, maxExactInt + 2,
+		1 << 56, -(1 << 56), 1e16, 1e16 + 2, 0.5, 1e-3, 123456789.5,
+	}
+	for _, v := range values {
+		if s, exp := string(FormatFloat64ToSlice(v)), reference(v); s != exp {
+			t.Fatalf("format %v expected %q, got %q", v, exp, s)
+		}
+	}
+}
+
+func BenchmarkFormatFloat64ToSlice(b *testing.B) {
+	for _, v := range []float64{1700000000, 1700000000.5} {
+		b.Run(strconv.FormatFloat(v, 'f', -1, 64), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				FormatFloat64ToSlice(v)
+			}
+		})
+	}
+}
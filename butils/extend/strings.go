@@ -16,6 +16,7 @@ package extend
 
 import (
 	"strconv"
+	"strings"
 	"unicode"
 )
 
@@ -100,6 +101,62 @@ func ParseFloat64(s string) (float64, error) {
 	return strconv.ParseFloat(s, 64)
 }
 
+// ParseStrictFloat64 parses s as a C-locale decimal float. Only digits, a dot
+// decimal separator, a sign and an exponent are accepted, plus inf/infinity.
+func ParseStrictFloat64(s string) (float64, error) {
+	if len(s) == 0 {
+		return 0, &strconv.NumError{Func: "ParseFloat", Num: s, Err: strconv.ErrSyntax}
+	}
+	if n, ok := parseSmallInt(s); ok {
+		return float64(n), nil
+	}
+
+	switch strings.ToLower(strings.TrimLeft(s, "+-")) {
+	case "inf", "infinity":
+		if len(s) > 1 && (s[1] == '+' || s[1] == '-') {
+			return 0, &strconv.NumError{Func: "ParseFloat", Num: s, Err: strconv.ErrSyntax}
+		}
+		return strconv.ParseFloat(s, 64)
+	}
+
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c >= '0' && c <= '9':
+		case c == '.' || c == '+' || c == '-' || c == 'e' || c == 'E':
+		default:
+			return 0, &strconv.NumError{Func: "ParseFloat", Num: s, Err: strconv.ErrSyntax}
+		}
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// parseSmallInt is the integer fast path of ParseStrictFloat64, it only takes
+// plain decimal integers short enough to be exact as float64.
+func parseSmallInt(s string) (n int64, ok bool) {
+	neg := false
+	if s[0] == '-' || s[0] == '+' {
+		neg = s[0] == '-'
+		s = s[1:]
+	}
+	if len(s) == 0 || len(s) > 15 {
+		return 0, false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int64(c-'0')
+	}
+	if neg {
+		if n == 0 {
+			return 0, false
+		}
+		n = -n
+	}
+	return n, true
+}
+
 func IsNumeric(s string) bool {
 	for _, r := range s {
 		if !unicode.IsDigit(r) {
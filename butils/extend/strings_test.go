@@ -0,0 +1,95 @@
+// Copyright 2019-2024 Xu Ruibo (hustxurb@163.com) and Contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package extend
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseStrictFloat64(t *testing.T) {
+	valid := []struct {
+		input  string
+		output float64
+	}{
+		{"1.5", 1.5},
+		{"-1.5", -1.5},
+		{"+1.5", 1.5},
+		{"10", 10},
+		{".5", 0.5},
+		{"1e3", 1000},
+		{"1.5E-2", 0.015},
+		{"inf", math.Inf(1)},
+		{"+inf", math.Inf(1)},
+		{"-inf", math.Inf(-1)},
+		{"-Infinity", math.Inf(-1)},
+		{"0", 0},
+		{"-7", -7},
+		{"+42", 42},
+		{"007", 7},
+		{"1700000000000", 1700000000000},
+		{"999999999999999", 999999999999999},
+		{"-1234567890123456", -1234567890123456},
+		{"12345678901234567890", 12345678901234567890},
+	}
+	for _, test := range valid {
+		v, err := ParseStrictFloat64(test.input)
+		if err != nil {
+			t.Fatalf("parse %q err: %v", test.input, err)
+		}
+		if v != test.output {
+			t.Fatalf("parse %q expected %v, got %v", test.input, test.output, v)
+		}
+	}
+
+	if v, err := ParseStrictFloat64("-0"); err != nil || v != 0 || !math.Signbit(v) {
+		t.Fatalf("parse -0 got %v %v", v, err)
+	}
+
+	invalid := []string{
+		"-",
+		"+",
+		"1-",
+		"",
+		"1,5",
+		" 1.5",
+		"1.5 ",
+		" 1.5 ",
+		"\t1.5",
+		"1_000",
+		"0x1p-2",
+		"nan",
+		"NaN",
+		"+-inf",
+		"1.5f",
+	}
+	for _, input := range invalid {
+		if v, err := ParseStrictFloat64(input); err == nil {
+			t.Fatalf("parse %q expected error, got %v", input, v)
+		}
+	}
+}
+
+func BenchmarkParseStrictFloat64(b *testing.B) {
+	for _, s := range []string{"1700000000", "1700000000.5"} {
+		b.Run(s, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := ParseStrictFloat64(s); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
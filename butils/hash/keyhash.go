@@ -0,0 +1,69 @@
+// Copyright 2019-2024 Xu Ruibo (hustxurb@163.com) and Contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hash
+
+import "fmt"
+
+const (
+	KeyHashFnv32 = "fnv32"
+	KeyHashCrc32 = "crc32"
+)
+
+var keyHashFuncs = map[string]func([]byte) uint32{
+	KeyHashFnv32: Fnv32,
+	KeyHashCrc32: Crc32,
+}
+
+var (
+	keyHashName = KeyHashFnv32
+	keyHashFunc = Fnv32
+)
+
+// CheckKeyHash validates a key hash name, empty selects fnv32.
+func CheckKeyHash(name string) error {
+	if name == "" {
+		return nil
+	}
+	if _, ok := keyHashFuncs[name]; !ok {
+		return fmt.Errorf("invalid key hash %q", name)
+	}
+	return nil
+}
+
+// SetKeyHash selects the hash behind KeyHash. The key hash decides the slot
+// of every key, so stored, proxy and dashboard must all be configured with
+// the same name. It is not synchronized: call it once at startup, before any
+// goroutine hashes a key.
+func SetKeyHash(name string) error {
+	if err := CheckKeyHash(name); err != nil {
+		return err
+	}
+	if name == "" {
+		name = KeyHashFnv32
+	}
+	keyHashName = name
+	keyHashFunc = keyHashFuncs[name]
+	return nil
+}
+
+// KeyHashName returns the name of the selected key hash.
+func KeyHashName() string {
+	return keyHashName
+}
+
+// KeyHash hashes a key with the hash chosen by SetKeyHash.
+func KeyHash(key []byte) uint32 {
+	return keyHashFunc(key)
+}
@@ -24,19 +24,16 @@ import (
 const (
 	groupSize       = 8
 	maxAvgGroupLoad = 7
-
-	loBits uint64 = 0x0101010101010101
-	hiBits uint64 = 0x8080808080808080
 )
 
 type bitset uint64
 
 func metaMatchH2(m *metadata, h h2) bitset {
-	return hasZeroByte(castUint64(m) ^ (loBits * uint64(h)))
+	return bitset(swarMatch(castUint64(m), int8(h)))
 }
 
 func metaMatchEmpty(m *metadata) bitset {
-	return hasZeroByte(castUint64(m) ^ hiBits)
+	return bitset(swarMatch(castUint64(m), empty))
 }
 
 func nextMatch(b *bitset) uint32 {
@@ -45,10 +42,6 @@ func nextMatch(b *bitset) uint32 {
 	return s >> 3   // div by 8
 }
 
-func hasZeroByte(x uint64) bitset {
-	return bitset(((x - loBits) & ^(x)) & hiBits)
-}
-
 func castUint64(m *metadata) uint64 {
 	return *(*uint64)((unsafe.Pointer)(m))
 }
@@ -18,6 +18,7 @@ package vectormap
 
 import (
 	"math/bits"
+	"os"
 	_ "unsafe"
 
 	"github.com/zuoyebang/bitalostored/butils/vectormap/simd"
@@ -28,18 +29,58 @@ const (
 	maxAvgGroupLoad = 14
 )
 
+// NoSIMDEnv forces the portable metadata matching when set to a non-empty value,
+// it is read once at init.
+const NoSIMDEnv = "VECTORMAP_NOSIMD"
+
 type bitset uint16
 
-func metaMatchH2(m *metadata, h h2) bitset {
+var (
+	metaMatchH2    = simdMatchH2
+	metaMatchEmpty = simdMatchEmpty
+)
+
+func init() {
+	if os.Getenv(NoSIMDEnv) != "" {
+		useSIMD(false)
+	}
+}
+
+func useSIMD(enable bool) {
+	if enable {
+		metaMatchH2, metaMatchEmpty = simdMatchH2, simdMatchEmpty
+	} else {
+		metaMatchH2, metaMatchEmpty = portableMatchH2, portableMatchEmpty
+	}
+}
+
+func simdMatchH2(m *metadata, h h2) bitset {
 	b := simd.MatchMetadata((*[16]int8)(m), int8(h))
 	return bitset(b)
 }
 
-func metaMatchEmpty(m *metadata) bitset {
+func simdMatchEmpty(m *metadata) bitset {
 	b := simd.MatchMetadata((*[16]int8)(m), empty)
 	return bitset(b)
 }
 
+func portableMatchH2(m *metadata, h h2) bitset {
+	return portableMatch(m, int8(h))
+}
+
+func portableMatchEmpty(m *metadata) bitset {
+	return portableMatch(m, empty)
+}
+
+func portableMatch(m *metadata, c int8) (b bitset) {
+	for i := range m {
+		if m[i] == c {
+			b |= 1 << i
+		}
+	}
+	return b
+}
+
 func nextMatch(b *bitset) (s uint32) {
 	s = uint32(bits.TrailingZeros16(uint16(*b)))
 	*b &= ^(1 << s) // clear bit |s|
@@ -0,0 +1,153 @@
+// Copyright 2019-2024 Xu Ruibo (hustxurb@163.com) and Contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build amd64 && !nosimd
+
+package vectormap
+
+import (
+	"fmt"
+	"math/bits"
+	"math/rand"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchMetadataNoSIMD(t *testing.T) {
+	var metas []metadata
+	for i := 0; i < 1000; i++ {
+		var meta metadata
+		for j := range meta {
+			switch rand.Intn(4) {
+			case 0:
+				meta[j] = empty
+			case 1:
+				meta[j] = tombstone
+			default:
+				meta[j] = int8(rand.Intn(128))
+			}
+		}
+		metas = append(metas, meta)
+	}
+
+	type result struct {
+		h2    [128]bitset
+		empty bitset
+	}
+	match := func() []result {
+		res := make([]result, len(metas))
+		for i := range metas {
+			for h := range res[i].h2 {
+				res[i].h2[h] = metaMatchH2(&metas[i], h2(h))
+			}
+			res[i].empty = metaMatchEmpty(&metas[i])
+		}
+		return res
+	}
+
+	simdRes := match()
+	useSIMD(false)
+	defer useSIMD(true)
+	assert.Equal(t, fmt.Sprintf("%p", portableMatchH2), fmt.Sprintf("%p", metaMatchH2))
+	assert.Equal(t, fmt.Sprintf("%p", portableMatchEmpty), fmt.Sprintf("%p", metaMatchEmpty))
+	assert.Equal(t, simdRes, match())
+
+	m := NewVectorMap(1024, WithSkipCheck(), WithBuckets(4))
+	defer m.Close()
+	for i := 0; i < 2000; i++ {
+		key := []byte(fmt.Sprintf("nosimd_%d", i))
+		assert.True(t, m.RePut(key, key))
+	}
+	for i := 0; i < 2000; i++ {
+		key := []byte(fmt.Sprintf("nosimd_%d", i))
+		v, closer, ok := m.Get(key)
+		assert.True(t, ok)
+		assert.Equal(t, key, v)
+		if closer != nil {
+			closer()
+		}
+	}
+}
+
+func TestMatchMetadataCrossCheck(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		meta := randMetadata(r)
+		h := h2(r.Intn(128))
+
+		simdH2, portableH2 := simdMatchH2(&meta, h), portableMatchH2(&meta, h)
+		if simdH2 != portableH2 {
+			t.Fatalf("meta %v h2 %d simd %016b portable %016b", meta, h, simdH2, portableH2)
+		}
+		assert.Equal(t, referenceMatch(&meta, int8(h)), drainMatches(simdH2))
+		assert.Equal(t, referenceMatch(&meta, int8(h)), swarMatchSlots(&meta, int8(h)))
+
+		simdEmpty, portableEmpty := simdMatchEmpty(&meta), portableMatchEmpty(&meta)
+		if simdEmpty != portableEmpty {
+			t.Fatalf("meta %v simd empty %016b portable %016b", meta, simdEmpty, portableEmpty)
+		}
+		assert.Equal(t, referenceMatch(&meta, empty), drainMatches(simdEmpty))
+		assert.Equal(t, referenceMatch(&meta, empty), swarMatchSlots(&meta, empty))
+	}
+}
+
+// swarMatchSlots runs the SWAR matcher used off amd64 over both halves of a 16
+// slot group.
+func swarMatchSlots(meta *metadata, c int8) (slots []uint32) {
+	for i, w := range (*[2]uint64)(unsafe.Pointer(meta)) {
+		for b := swarMatch(w, c); b != 0; b &= b - 1 {
+			slots = append(slots, uint32(i*8+bits.TrailingZeros64(b)>>3))
+		}
+	}
+	return slots
+}
+
+func BenchmarkMatchMetadataImpl(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	metas := make([]metadata, 1024)
+	for i := range metas {
+		metas[i] = randMetadata(r)
+	}
+	for _, impl := range []struct {
+		name  string
+		h2    func(*metadata, h2) bitset
+		empty func(*metadata) bitset
+	}{
+		{"simd", simdMatchH2, simdMatchEmpty},
+		{"portable", portableMatchH2, portableMatchEmpty},
+	} {
+		b.Run(impl.name+"/h2", func(b *testing.B) {
+			var n uint32
+			for i := 0; i < b.N; i++ {
+				mask := impl.h2(&metas[i&1023], h2(i&127))
+				for mask != 0 {
+					n += nextMatch(&mask)
+				}
+			}
+			_ = n
+		})
+		b.Run(impl.name+"/empty", func(b *testing.B) {
+			var n uint32
+			for i := 0; i < b.N; i++ {
+				mask := impl.empty(&metas[i&1023])
+				for mask != 0 {
+					n += nextMatch(&mask)
+				}
+			}
+			_ = n
+		})
+	}
+}
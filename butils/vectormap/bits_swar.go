@@ -0,0 +1,35 @@
+// Copyright 2019-2024 Xu Ruibo (hustxurb@163.com) and Contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vectormap
+
+const (
+	loBits uint64 = 0x0101010101010101
+	hiBits uint64 = 0x8080808080808080
+	loMask uint64 = 0x7f7f7f7f7f7f7f7f
+)
+
+// swarMatch sets the high bit of every byte of w equal to c. It backs the
+// metadata matching when SIMD is not available and is built on every platform
+// so it can be checked against the SIMD matcher.
+func swarMatch(w uint64, c int8) uint64 {
+	return hasZeroByte(w ^ (loBits * uint64(uint8(c))))
+}
+
+// hasZeroByte sets the high bit of exactly the zero bytes of x. The shorter
+// (x - loBits) & ^x & hiBits form also flags a 0x01 byte above a zero byte,
+// which made a slot holding h2^1 match right above a real h2 match.
+func hasZeroByte(x uint64) uint64 {
+	return ^(((x & loMask) + loMask) | x | loMask)
+}
@@ -63,6 +63,64 @@ func TestMatchMetadata(t *testing.T) {
 	})
 }
 
+// randMetadata fills a group with empty, tombstone and h2 slots, h2 values are
+// drawn from a few neighbours so the groups hold repeated and adjacent values.
+func randMetadata(r *rand.Rand) (meta metadata) {
+	base := r.Intn(128)
+	for i := range meta {
+		switch r.Intn(4) {
+		case 0:
+			meta[i] = empty
+		case 1:
+			meta[i] = tombstone
+		default:
+			meta[i] = int8((base + r.Intn(4)) & 127)
+		}
+	}
+	return meta
+}
+
+// referenceMatch returns the slots of meta holding c in ascending order.
+func referenceMatch(meta *metadata, c int8) (slots []uint32) {
+	for i := range meta {
+		if meta[i] == c {
+			slots = append(slots, uint32(i))
+		}
+	}
+	return slots
+}
+
+func drainMatches(b bitset) (slots []uint32) {
+	for b != 0 {
+		slots = append(slots, nextMatch(&b))
+	}
+	return slots
+}
+
+func TestMatchMetadataReference(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		meta := randMetadata(r)
+		h := h2(r.Intn(128))
+
+		assert.Equal(t, referenceMatch(&meta, int8(h)), drainMatches(metaMatchH2(&meta, h)))
+		assert.Equal(t, referenceMatch(&meta, empty), drainMatches(metaMatchEmpty(&meta)))
+	}
+}
+
+func TestSWARMatch(t *testing.T) {
+	// slot 0 holds h, slot 1 holds h^1, slot 2 is empty and the rest are
+	// tombstones, only slot 0 may match h
+	for h := 0; h < 128; h += 2 {
+		w := uint64(h) | uint64(h+1)<<8 | uint64(0x80)<<16 | 0xfefefefefe<<24
+		assert.Equal(t, uint64(0x80), swarMatch(w, int8(h)))
+		assert.Equal(t, uint64(0x8000), swarMatch(w, int8(h+1)))
+		assert.Equal(t, uint64(0x800000), swarMatch(w, empty))
+	}
+	assert.Equal(t, hiBits, swarMatch(0, 0))
+	assert.Equal(t, uint64(0), swarMatch(loBits*0x7f, 0))
+}
+
 func BenchmarkMatchMetadata(b *testing.B) {
 	var meta metadata
 	for i := range meta {
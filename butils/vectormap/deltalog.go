@@ -0,0 +1,84 @@
+// Copyright 2019-2024 Xu Ruibo (hustxurb@163.com) and Contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vectormap
+
+import "sync"
+
+// deltaLog stamps every written key with a monotonically increasing version
+// so the keys changed after a version can be listed. It keeps at least the
+// latest max changes, requests older than floor need a full snapshot.
+type deltaLog struct {
+	mu      sync.Mutex
+	max     int
+	version uint64
+	floor   uint64
+	changes map[[16]byte]uint64
+}
+
+func newDeltaLog(max int) *deltaLog {
+	return &deltaLog{
+		max:     max,
+		changes: make(map[[16]byte]uint64, max),
+	}
+}
+
+func (d *deltaLog) current() uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.version
+}
+
+func (d *deltaLog) record(key []byte) {
+	var k [16]byte
+	copy(k[:], key)
+	d.mu.Lock()
+	d.version++
+	d.changes[k] = d.version
+	if len(d.changes) >= 2*d.max {
+		cutoff := d.version - uint64(d.max)
+		for ck, v := range d.changes {
+			if v <= cutoff {
+				delete(d.changes, ck)
+			}
+		}
+		d.floor = cutoff
+	}
+	d.mu.Unlock()
+}
+
+// reset forgets all changes, used when the whole key space is replaced.
+func (d *deltaLog) reset() {
+	d.mu.Lock()
+	d.version++
+	d.floor = d.version
+	d.changes = make(map[[16]byte]uint64, d.max)
+	d.mu.Unlock()
+}
+
+// since returns the keys changed after version and the current version, ok
+// is false if changes after version were already dropped.
+func (d *deltaLog) since(version uint64) (keys [][16]byte, upto uint64, ok bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if version < d.floor || version > d.version {
+		return nil, d.version, false
+	}
+	for k, v := range d.changes {
+		if v > version {
+			keys = append(keys, k)
+		}
+	}
+	return keys, d.version, true
+}
@@ -31,7 +31,39 @@ const (
 	IdxOffsetMask       uint32 = 0x00ffffff
 )
 
-//go:inline
+type ValueEncoding uint8
+
+const (
+	EncodingInline ValueEncoding = iota
+	EncodingOverShort
+	EncodingOverLong
+)
+
+func (e ValueEncoding) String() string {
+	switch e {
+	case EncodingInline:
+		return "inline"
+	case EncodingOverShort:
+		return "overshort"
+	case EncodingOverLong:
+		return "overlong"
+	default:
+		return "unknown"
+	}
+}
+
+// EncodingOf returns the encoding a value of size bytes gets when it is put.
+func EncodingOf(size int) ValueEncoding {
+	switch {
+	case size >= int(overLongSize):
+		return EncodingOverLong
+	case size >= int(overShortSize):
+		return EncodingOverShort
+	default:
+		return EncodingInline
+	}
+}
+
 func (ki *kIdx) valType() uint32 {
 	return (uint32(*ki) & IdxTypeMask) >> 31
 }
@@ -46,8 +78,18 @@ func (ki *kIdx) offset() uint32 {
 	return uint32(*ki) & IdxOffsetMask
 }
 
+// kvLockStripes read-write locks guard slot index/header updates, slots of
+// group g use stripe g%kvLockStripes so readers of different groups do not
+// share a lock word.
+const kvLockStripes = 64
+
+type paddedRWMutex struct {
+	sync.RWMutex
+	_ [40]byte
+}
+
 type kvHolder struct {
-	mutex   sync.RWMutex
+	locks   [kvLockStripes]paddedRWMutex
 	tail    uint32
 	cap     uint32
 	valUsed uint32
@@ -58,6 +100,9 @@ type kvHolder struct {
 }
 
 func newKVHolder(size Byte) (hdr *kvHolder) {
+	if size > maxShardMemSize {
+		size = maxShardMemSize
+	}
 	b := manual.New(bufferSize + int(size))
 	bf := (*Buffer)(unsafe.Pointer(&b[0]))
 	bf.buf = b[bufferSize:]
@@ -69,6 +114,23 @@ func newKVHolder(size Byte) (hdr *kvHolder) {
 	return
 }
 
+//go:inline
+func (hdr *kvHolder) slotLock(g uint32) *sync.RWMutex {
+	return &hdr.locks[g%kvLockStripes].RWMutex
+}
+
+func (hdr *kvHolder) rlockAll() {
+	for i := range hdr.locks {
+		hdr.locks[i].RLock()
+	}
+}
+
+func (hdr *kvHolder) runlockAll() {
+	for i := range hdr.locks {
+		hdr.locks[i].RUnlock()
+	}
+}
+
 func (hdr *kvHolder) getValue(vOffset, vSize uint32) (v []byte, close func()) {
 	hdr.buffer.acquire()
 	return hdr.data[vOffset : vOffset+vSize], hdr.buffer.release
@@ -102,6 +164,39 @@ func (hdr *kvHolder) getKVUnlock(ki kIdx) (k, v []byte) {
 	}
 }
 
+// loadOverLong reads the size prefix of an overlong value at vOffset, ok is
+// false if the prefix or the value would run past the holder.
+func (hdr *kvHolder) loadOverLong(vOffset uint32) (vSize uint32, ok bool) {
+	if uint64(vOffset)+4 > uint64(hdr.cap) {
+		return 0, false
+	}
+	vSize = LoadUint32(hdr.data[vOffset:])
+	return vSize, uint64(vOffset)+4+uint64(vSize) <= uint64(hdr.cap)
+}
+
+// valueEncoding reports how the value of ki is stored, ErrCorrupted is
+// returned if its header or size prefix would run past the holder.
+func (hdr *kvHolder) valueEncoding(ki kIdx) (enc ValueEncoding, vSize uint32, err error) {
+	kEnd := ki.offset()*4 + 16
+	if uint64(kEnd)+4 > uint64(len(hdr.data)) {
+		return 0, 0, ErrCorrupted
+	}
+	vHeader := LoadUint32(hdr.data[kEnd:])
+	if ki.valType() == 0 {
+		return EncodingInline, (vHeader & IdxSmallSizeMask) >> 24, nil
+	}
+
+	vSize = vHeader&IdxSmallSizeMask>>24 + ki.capOrBigSize()<<8
+	if vSize == overLongSize {
+		var ok bool
+		if vSize, ok = hdr.loadOverLong((vHeader & IdxOffsetMask) * 4); !ok {
+			return 0, 0, ErrCorrupted
+		}
+		return EncodingOverLong, vSize, nil
+	}
+	return EncodingOverShort, vSize, nil
+}
+
 func (hdr *kvHolder) getKey(ki kIdx) (k []byte) {
 	if ki == 0 {
 		return nil
@@ -111,92 +206,74 @@ func (hdr *kvHolder) getKey(ki kIdx) (k []byte) {
 	return
 }
 
+// gcEntrySize returns the bytes gcSet takes for a value of lv bytes and the
+// part of them accounted in valUsed.
+func gcEntrySize(lv uint32) (size, vCap uint32) {
+	vCap = Cap4Size(lv)
+	if lv >= overLongSize {
+		vCap += 4
+	}
+	return 20 + vCap, vCap
+}
+
 func (hdr *kvHolder) gcSet(k, v []byte) (ki kIdx, fail bool) {
+	size, vCap := gcEntrySize(uint32(len(v)))
+	if hdr.tail+size > hdr.cap {
+		return 0, true
+	}
+	ki = hdr.gcSetAt(hdr.tail, k, v)
+	hdr.items++
+	hdr.valUsed += vCap
+	hdr.tail += size
+	return
+}
+
+// gcSetAt writes k and v at off like gcSet without moving the tail, the
+// caller has reserved the gcEntrySize bytes at off.
+func (hdr *kvHolder) gcSetAt(off uint32, k, v []byte) (ki kIdx) {
 	lv := uint32(len(v))
+	kEnd := off + 16
+	vOffset := kEnd + 4
+	copy(hdr.data[off:], k)
 	if lv >= overLongSize {
-		vCap := Cap4Size(lv) + 4
-		ntail := hdr.tail + 20 + vCap
-		if ntail > hdr.cap {
-			return 0, true
-		}
-		ki = kIdx(hdr.tail/4 + overLongStoreHeaderH + mapTypeHeader)
-		kEnd := hdr.tail + 16
-		copy(hdr.data[hdr.tail:], k)
-		vOffset := kEnd + 4
-		vHeader := vOffset/4 + overLongStoreHeaderL
-		StoreUint32(hdr.data[kEnd:], vHeader)
+		ki = kIdx(off/4 + overLongStoreHeaderH + mapTypeHeader)
+		StoreUint32(hdr.data[kEnd:], vOffset/4+overLongStoreHeaderL)
 		StoreUint32(hdr.data[vOffset:], lv)
 		copy(hdr.data[vOffset+4:], v)
-		hdr.items++
-		hdr.valUsed += vCap
-		hdr.tail = ntail
-		return
 	} else if lv >= 1<<7 {
-		vCap := Cap4Size(lv)
-		ntail := hdr.tail + 20 + vCap
-		if ntail > hdr.cap {
-			return 0, true
-		}
 		vBig := lv >> 8
 		vSmall := lv & 0xff
-		ki = kIdx(hdr.tail/4 + vBig<<24 + mapTypeHeader)
-		kEnd := hdr.tail + 16
-		copy(hdr.data[hdr.tail:], k)
-		vOffset := kEnd + 4
-		vHeader := vOffset/4 + (vSmall << 24)
-		StoreUint32(hdr.data[kEnd:], vHeader)
+		ki = kIdx(off/4 + vBig<<24 + mapTypeHeader)
+		StoreUint32(hdr.data[kEnd:], vOffset/4+(vSmall<<24))
 		copy(hdr.data[vOffset:], v)
-		hdr.items++
-		hdr.valUsed += vCap
-		hdr.tail = ntail
-		return
 	} else {
-		vCap := Cap4Size(lv)
-		ntail := hdr.tail + 20 + vCap
-		if ntail > hdr.cap {
-			return 0, true
-		}
-		vSmall := lv
-		ki = kIdx(hdr.tail/4 + vCap/4<<24)
-		kEnd := hdr.tail + 16
-		copy(hdr.data[hdr.tail:], k)
-		vOffset := kEnd + 4
-		vHeader := vOffset/4 + (vSmall << 24)
-		StoreUint32(hdr.data[kEnd:], vHeader)
+		ki = kIdx(off/4 + Cap4Size(lv)/4<<24)
+		StoreUint32(hdr.data[kEnd:], vOffset/4+(lv<<24))
 		copy(hdr.data[vOffset:], v)
-		hdr.items++
-		hdr.valUsed += vCap
-		hdr.tail = ntail
-		return
 	}
+	return
 }
 
 func (hdr *kvHolder) del(ki kIdx) {
 	if ki == 0 {
 		return
 	}
-	kEnd := ki.offset()*4 + 16
-	vType := ki.valType()
-	vHeader := LoadUint32(hdr.data[kEnd:])
-	if vType == 0 {
-		vSize := (vHeader & IdxSmallSizeMask) >> 24
-		vCap := Cap4Size(vSize)
-		hdr.valUsed -= vCap
-		hdr.items--
-		return
-	} else {
-		vBig := ki.capOrBigSize()
-		vSize := vHeader&IdxSmallSizeMask>>24 + vBig<<8
-		if vSize == overLongSize {
-			vOffset := (vHeader & IdxOffsetMask) * 4
-			vSize = LoadUint32(hdr.data[vOffset:])
-			hdr.valUsed -= Cap4Size(vSize) + 4
-		} else {
-			hdr.valUsed -= Cap4Size(vSize)
-		}
-		hdr.items--
-		return
+	hdr.valUsed -= hdr.valueCap(ki)
+	hdr.items--
+}
+
+// valueCap returns the value bytes accounted in valUsed for ki.
+func (hdr *kvHolder) valueCap(ki kIdx) uint32 {
+	vHeader := LoadUint32(hdr.data[ki.offset()*4+16:])
+	if ki.valType() == 0 {
+		return Cap4Size((vHeader & IdxSmallSizeMask) >> 24)
+	}
+	vSize := vHeader&IdxSmallSizeMask>>24 + ki.capOrBigSize()<<8
+	if vSize == overLongSize {
+		vSize = LoadUint32(hdr.data[(vHeader&IdxOffsetMask)*4:])
+		return Cap4Size(vSize) + 4
 	}
+	return Cap4Size(vSize)
 }
 
 //go:inline
@@ -16,7 +16,9 @@ package vectormap
 
 import (
 	"bytes"
+	"io"
 	"math"
+	"runtime"
 	"sync"
 	"sync/atomic"
 	"unsafe"
@@ -25,6 +27,19 @@ import (
 	"github.com/zuoyebang/bitalostored/butils/vectormap/simd"
 )
 
+const (
+	parallelRehashGroups = 1 << 14
+	maxRehashWorkers     = 8
+	rehashLockStripes    = 256
+	drainBatchGroups     = 256
+)
+
+// KV is a drained entry, Key is the 16 byte md5 of the original key.
+type KV struct {
+	Key   []byte
+	Value []byte
+}
+
 type LFUMap struct {
 	owner      *VectorMap
 	kvHolder   *kvHolder
@@ -37,10 +52,23 @@ type LFUMap struct {
 	rehashLock sync.RWMutex
 	putLock    sync.Mutex
 
-	queryCnt atomic.Uint64
-	missCnt  atomic.Uint64
-
-	rehashing bool
+	queryCnt  atomic.Uint64
+	missCnt   atomic.Uint64
+	evictCnt  atomic.Uint64
+	rehashCnt atomic.Uint64
+	gcCnt     atomic.Uint64
+
+	sketch           *cmSketch
+	admissionRejects atomic.Uint64
+	missCache        *missCache
+	missCacheHits    atomic.Uint64
+	delta            *deltaLog
+
+	// rehashing is set while GCCopy rebuilds the shard.
+	rehashing atomic.Bool
+	// leases counts GetRef values not yet released, rehash and GCCopy do
+	// not replace the table while it is nonzero.
+	leases atomic.Int32
 }
 
 func newInnerLFUMap(owner *VectorMap, sz uint32) (m *LFUMap) {
@@ -60,6 +88,15 @@ func newInnerLFUMap(owner *VectorMap, sz uint32) (m *LFUMap) {
 		m.ctrl[i] = newEmptyMetadata()
 	}
 	m.kvHolder = newKVHolder(memMax)
+	if owner.admission {
+		m.sketch = newCMSketch(m.limit)
+	}
+	if owner.missCacheSize > 0 && owner.missCacheTTL > 0 {
+		m.missCache = newMissCache(owner.missCacheSize, owner.missCacheTTL)
+	}
+	if owner.deltaSize > 0 {
+		m.delta = newDeltaLog(owner.deltaSize)
+	}
 	return
 }
 
@@ -116,6 +153,9 @@ func (m *LFUMap) memUseRate() float32 {
 
 func (m *LFUMap) Has(l uint64, key []byte) (ok bool) {
 	m.queryCnt.Add(1)
+	if m.sketch != nil {
+		m.sketch.increment(l)
+	}
 	m.rehashLock.RLock()
 	hi, lo := splitHash(l)
 	g := probeStart(hi, len(m.groups))
@@ -123,9 +163,9 @@ func (m *LFUMap) Has(l uint64, key []byte) (ok bool) {
 		matches := metaMatchH2(&m.ctrl[g], lo)
 		for matches != 0 {
 			s := nextMatch(&matches)
-			m.kvHolder.mutex.RLock()
+			m.kvHolder.slotLock(g).RLock()
 			k := m.kvHolder.getKey(m.groups[g][s])
-			m.kvHolder.mutex.RUnlock()
+			m.kvHolder.slotLock(g).RUnlock()
 			if bytes.Equal(key, k) {
 				m.add(g, s)
 				ok = true
@@ -150,13 +190,23 @@ func (m *LFUMap) Has(l uint64, key []byte) (ok bool) {
 
 //go:inline
 func (m *LFUMap) add(g, s uint32) {
-	if m.counters[g][s] < maxCount {
-		m.counters[g][s]++
-	}
+	m.counters[g].incr(s)
 }
 
 func (m *LFUMap) Get(l uint64, key []byte) (value []byte, closer func(), ok bool) {
 	m.queryCnt.Add(1)
+	if m.sketch != nil {
+		m.sketch.increment(l)
+	}
+	var missGen uint64
+	if m.missCache != nil {
+		if m.missCache.has(key) {
+			m.missCnt.Add(1)
+			m.missCacheHits.Add(1)
+			return
+		}
+		missGen = m.missCache.generation()
+	}
 	m.rehashLock.RLock()
 	hi, lo := splitHash(l)
 	g := probeStart(hi, len(m.groups))
@@ -165,9 +215,9 @@ func (m *LFUMap) Get(l uint64, key []byte) (value []byte, closer func(), ok bool
 		for matches != 0 {
 			s := nextMatch(&matches)
 
-			m.kvHolder.mutex.RLock()
+			m.kvHolder.slotLock(g).RLock()
 			if m.groups[g][s] == 0 {
-				m.kvHolder.mutex.RUnlock()
+				m.kvHolder.slotLock(g).RUnlock()
 				continue
 			}
 			kOffset := m.groups[g][s].offset() * 4
@@ -182,26 +232,31 @@ func (m *LFUMap) Get(l uint64, key []byte) (value []byte, closer func(), ok bool
 					vSize := vHeader & IdxSmallSizeMask >> 24
 					value, closer = VMBytePools.GetBytePool(int(vSize))
 					copy(value, m.kvHolder.data[vOffset:vOffset+vSize])
-					m.kvHolder.mutex.RUnlock()
+					m.kvHolder.slotLock(g).RUnlock()
 					value = value[:vSize]
 				} else {
 					vOffset := (vHeader & IdxOffsetMask) * 4
 					vBig := m.groups[g][s].capOrBigSize()
 					vSize := vHeader&IdxSmallSizeMask>>24 + vBig<<8
 					if vSize == overLongSize {
-						vSize = LoadUint32(m.kvHolder.data[vOffset:])
+						if vSize, ok = m.kvHolder.loadOverLong(vOffset); !ok {
+							m.kvHolder.slotLock(g).RUnlock()
+							m.rehashLock.RUnlock()
+							m.missCnt.Add(1)
+							return
+						}
 						value, closer = m.kvHolder.getValue(vOffset+4, vSize)
 					} else {
 						value, closer = m.kvHolder.getValue(vOffset, vSize)
 					}
-					m.kvHolder.mutex.RUnlock()
+					m.kvHolder.slotLock(g).RUnlock()
 				}
 
 				m.add(g, s)
 				m.rehashLock.RUnlock()
 				return
 			} else {
-				m.kvHolder.mutex.RUnlock()
+				m.kvHolder.slotLock(g).RUnlock()
 			}
 		}
 		matches = metaMatchEmpty(&m.ctrl[g])
@@ -209,6 +264,60 @@ func (m *LFUMap) Get(l uint64, key []byte) (value []byte, closer func(), ok bool
 			ok = false
 			m.rehashLock.RUnlock()
 			m.missCnt.Add(1)
+			if m.missCache != nil {
+				m.missCache.add(key, missGen)
+			}
+			return
+		}
+		g += 1
+		if g >= uint32(len(m.groups)) {
+			g = 0
+		}
+	}
+}
+
+// GetRef is Get for readers that hold the value until release instead of
+// copying it out. A value small enough to be overwritten in place is copied,
+// a larger one aliases the store. While the lease is out rehash and GCCopy
+// leave the table in place, and a Clear only drops the table since the lease
+// holds a reference on its buffer. The slice must not be retained or
+// modified after release.
+func (m *LFUMap) GetRef(l uint64, key []byte) (value []byte, release func(), ok bool) {
+	m.leases.Add(1)
+	value, closer, ok := m.Get(l, key)
+	if !ok {
+		m.leases.Add(-1)
+		return nil, nil, false
+	}
+	var once sync.Once
+	return value, func() {
+		once.Do(func() {
+			closer()
+			m.leases.Add(-1)
+		})
+	}, true
+}
+
+func (m *LFUMap) Encoding(l uint64, key []byte) (enc ValueEncoding, vSize uint32, ok bool, err error) {
+	m.rehashLock.RLock()
+	defer m.rehashLock.RUnlock()
+	hi, lo := splitHash(l)
+	g := probeStart(hi, len(m.groups))
+	for {
+		matches := metaMatchH2(&m.ctrl[g], lo)
+		for matches != 0 {
+			s := nextMatch(&matches)
+			m.kvHolder.slotLock(g).RLock()
+			if m.groups[g][s] != 0 && bytes.Equal(key, m.kvHolder.getKey(m.groups[g][s])) {
+				enc, vSize, err = m.kvHolder.valueEncoding(m.groups[g][s])
+				m.kvHolder.slotLock(g).RUnlock()
+				ok = err == nil
+				return
+			}
+			m.kvHolder.slotLock(g).RUnlock()
+		}
+		matches = metaMatchEmpty(&m.ctrl[g])
+		if matches != 0 {
 			return
 		}
 		g += 1
@@ -218,7 +327,20 @@ func (m *LFUMap) Get(l uint64, key []byte) (value []byte, closer func(), ok bool
 	}
 }
 
+func (m *LFUMap) ValueSizeHistogram(buckets []uint32) []int {
+	m.rehashLock.RLock()
+	defer m.rehashLock.RUnlock()
+	return valueSizeHistogram(m.kvHolder, m.ctrl, m.groups, buckets)
+}
+
 func (m *LFUMap) Put(l uint64, key []byte, value []byte) bool {
+	if m.missCache != nil {
+		defer m.missCache.del(key)
+	}
+	if m.delta != nil {
+		defer m.delta.record(key)
+	}
+
 	m.putLock.Lock()
 	hi, lo := splitHash(l)
 	g := probeStart(hi, len(m.groups))
@@ -236,44 +358,20 @@ func (m *LFUMap) Put(l uint64, key []byte, value []byte) bool {
 				if lv >= limitSize {
 					m.ctrl[g][s] = tombstone
 					m.dead++
-					m.counters[g][s] = 0
+					m.counters[g].store(s, 0)
 					m.kvHolder.items--
-					if vType == 0 {
-						m.kvHolder.valUsed -= m.groups[g][s].capOrBigSize()
-					} else {
-						vBig := m.groups[g][s].capOrBigSize()
-						vSize := vHeader&IdxSmallSizeMask>>24 + vBig<<8
-						if vSize == overLongSize {
-							vOffset := (vHeader & IdxOffsetMask) * 4
-							vSize = LoadUint32(m.kvHolder.data[vOffset:])
-							m.kvHolder.valUsed -= Cap4Size(vSize) + 4
-						} else {
-							m.kvHolder.valUsed -= Cap4Size(vSize)
-						}
-					}
+					m.kvHolder.valUsed -= m.kvHolder.valueCap(m.groups[g][s])
 
 					m.putLock.Unlock()
 					return false
 				} else if lv >= overLongSize {
 					vCap := Cap4Size(lv) + 4
-					ntail := m.kvHolder.tail + 20 + vCap
-					if vType == 0 {
-						m.kvHolder.valUsed -= m.groups[g][s].capOrBigSize()
-					} else {
-						vBig := m.groups[g][s].capOrBigSize()
-						vSize := vHeader&IdxSmallSizeMask>>24 + vBig<<8
-						if vSize == overLongSize {
-							vOffset := (vHeader & IdxOffsetMask) * 4
-							vSize = LoadUint32(m.kvHolder.data[vOffset:])
-							m.kvHolder.valUsed -= Cap4Size(vSize) + 4
-						} else {
-							m.kvHolder.valUsed -= Cap4Size(vSize)
-						}
-					}
+					ntail := m.kvHolder.tail + vCap
+					m.kvHolder.valUsed -= m.kvHolder.valueCap(m.groups[g][s])
 					if ntail > m.kvHolder.cap {
 						m.ctrl[g][s] = tombstone
 						m.dead++
-						m.counters[g][s] = 0
+						m.counters[g].store(s, 0)
 						m.kvHolder.items--
 						m.putLock.Unlock()
 						return false
@@ -283,33 +381,21 @@ func (m *LFUMap) Put(l uint64, key []byte, value []byte) bool {
 					StoreUint32(m.kvHolder.data[vOffset:], lv)
 					copy(m.kvHolder.data[vOffset+4:], value)
 
-					m.kvHolder.mutex.Lock()
+					m.kvHolder.slotLock(g).Lock()
 					m.groups[g][s] = kIdx(kOffset/storeUintBytes + overLongStoreHeaderH + mapTypeHeader)
 					StoreUint32(m.kvHolder.data[kEnd:], vOffset/storeUintBytes+overLongStoreHeaderL)
-					m.kvHolder.mutex.Unlock()
+					m.kvHolder.slotLock(g).Unlock()
 
 					m.kvHolder.tail = ntail
 					m.kvHolder.valUsed += vCap
 				} else if lv >= overShortSize {
 					vCap := Cap4Size(lv)
 					ntail := m.kvHolder.tail + vCap
-					if vType == 0 {
-						m.kvHolder.valUsed -= m.groups[g][s].capOrBigSize()
-					} else {
-						vBig := m.groups[g][s].capOrBigSize()
-						vSize := vHeader&IdxSmallSizeMask>>24 + vBig<<8
-						if vSize == overLongSize {
-							vOffset := (vHeader & IdxOffsetMask) * 4
-							vSize = LoadUint32(m.kvHolder.data[vOffset:])
-							m.kvHolder.valUsed -= Cap4Size(vSize) + 4
-						} else {
-							m.kvHolder.valUsed -= Cap4Size(vSize)
-						}
-					}
+					m.kvHolder.valUsed -= m.kvHolder.valueCap(m.groups[g][s])
 					if ntail > m.kvHolder.cap {
 						m.ctrl[g][s] = tombstone
 						m.dead++
-						m.counters[g][s] = 0
+						m.counters[g].store(s, 0)
 						m.kvHolder.items--
 						m.putLock.Unlock()
 						return false
@@ -319,40 +405,29 @@ func (m *LFUMap) Put(l uint64, key []byte, value []byte) bool {
 
 					copy(m.kvHolder.data[m.kvHolder.tail:], value)
 
-					m.kvHolder.mutex.Lock()
+					m.kvHolder.slotLock(g).Lock()
 					m.groups[g][s] = kIdx(kOffset/4 + vBig<<24 + mapTypeHeader)
 					StoreUint32(m.kvHolder.data[kEnd:], m.kvHolder.tail/4+vSmall<<24)
-					m.kvHolder.mutex.Unlock()
+					m.kvHolder.slotLock(g).Unlock()
 
 					m.kvHolder.tail = ntail
 					m.kvHolder.valUsed += vCap
 				} else if vType == 0 && lv <= m.groups[g][s].capOrBigSize()*4 && lv < overShortSize {
 					vOffset := vHeader & IdxOffsetMask
+					m.kvHolder.valUsed = m.kvHolder.valUsed - Cap4Size(vHeader&IdxSmallSizeMask>>24) + Cap4Size(lv)
 
-					m.kvHolder.mutex.Lock()
+					m.kvHolder.slotLock(g).Lock()
 					StoreUint32(m.kvHolder.data[kEnd:], vOffset+lv<<24)
 					copy(m.kvHolder.data[vOffset*4:], value)
-					m.kvHolder.mutex.Unlock()
+					m.kvHolder.slotLock(g).Unlock()
 				} else {
 					vCap := Cap4Size(lv)
 					ntail := m.kvHolder.tail + vCap
-					if vType == 0 {
-						m.kvHolder.valUsed -= m.groups[g][s].capOrBigSize()
-					} else {
-						vBig := m.groups[g][s].capOrBigSize()
-						vSize := vHeader&IdxSmallSizeMask>>24 + vBig<<8
-						if vSize == overLongSize {
-							vOffset := (vHeader & IdxOffsetMask) * 4
-							vSize = LoadUint32(m.kvHolder.data[vOffset:])
-							m.kvHolder.valUsed -= Cap4Size(vSize) + 4
-						} else {
-							m.kvHolder.valUsed -= Cap4Size(vSize)
-						}
-					}
+					m.kvHolder.valUsed -= m.kvHolder.valueCap(m.groups[g][s])
 					if ntail > m.kvHolder.cap {
 						m.ctrl[g][s] = tombstone
 						m.dead++
-						m.counters[g][s] = 0
+						m.counters[g].store(s, 0)
 						m.kvHolder.items--
 						m.groups[g][s] = kIdx(0)
 						m.putLock.Unlock()
@@ -360,10 +435,10 @@ func (m *LFUMap) Put(l uint64, key []byte, value []byte) bool {
 					}
 
 					copy(m.kvHolder.data[m.kvHolder.tail:], value)
-					m.kvHolder.mutex.Lock()
+					m.kvHolder.slotLock(g).Lock()
 					m.groups[g][s] = kIdx(kOffset/4 + vCap/4<<24)
 					StoreUint32(m.kvHolder.data[kEnd:], m.kvHolder.tail/4+(lv<<24))
-					m.kvHolder.mutex.Unlock()
+					m.kvHolder.slotLock(g).Unlock()
 
 					m.kvHolder.tail = ntail
 					m.kvHolder.valUsed += vCap
@@ -386,6 +461,13 @@ func (m *LFUMap) Put(l uint64, key []byte, value []byte) bool {
 }
 
 func (m *LFUMap) PutMultiValue(l uint64, key []byte, vlen uint32, vals [][]byte) bool {
+	if m.missCache != nil {
+		defer m.missCache.del(key)
+	}
+	if m.delta != nil {
+		defer m.delta.record(key)
+	}
+
 	m.putLock.Lock()
 	hi, lo := splitHash(l)
 	g := probeStart(hi, len(m.groups))
@@ -402,45 +484,20 @@ func (m *LFUMap) PutMultiValue(l uint64, key []byte, vlen uint32, vals [][]byte)
 				if vlen >= limitSize {
 					m.ctrl[g][s] = tombstone
 					m.dead++
-					m.counters[g][s] = 0
+					m.counters[g].store(s, 0)
 					m.kvHolder.items--
-					if vType == 0 {
-						m.kvHolder.valUsed -= m.groups[g][s].capOrBigSize()
-					} else {
-						vBig := m.groups[g][s].capOrBigSize()
-						vSize := vHeader&IdxSmallSizeMask>>24 + vBig<<8
-						if vSize == overLongSize {
-							vOffset := (vHeader & IdxOffsetMask) * 4
-							vSize = LoadUint32(m.kvHolder.data[vOffset:])
-							m.kvHolder.valUsed -= Cap4Size(vSize) + 4
-						} else {
-							m.kvHolder.valUsed -= Cap4Size(vSize)
-						}
-					}
+					m.kvHolder.valUsed -= m.kvHolder.valueCap(m.groups[g][s])
 
 					m.putLock.Unlock()
 					return false
 				} else if vlen >= overLongSize {
 					vCap := Cap4Size(vlen) + 4
-					ntail := m.kvHolder.tail + 20 + vCap
-					if vType == 0 {
-						m.kvHolder.valUsed -= m.groups[g][s].capOrBigSize()
-					} else {
-
-						vBig := m.groups[g][s].capOrBigSize()
-						vSize := vHeader&IdxSmallSizeMask>>24 + vBig<<8
-						if vSize == overLongSize {
-							vOffset := (vHeader & IdxOffsetMask) * 4
-							vSize = LoadUint32(m.kvHolder.data[vOffset:])
-							m.kvHolder.valUsed -= Cap4Size(vSize) + 4
-						} else {
-							m.kvHolder.valUsed -= Cap4Size(vSize)
-						}
-					}
+					ntail := m.kvHolder.tail + vCap
+					m.kvHolder.valUsed -= m.kvHolder.valueCap(m.groups[g][s])
 					if ntail > m.kvHolder.cap {
 						m.ctrl[g][s] = tombstone
 						m.dead++
-						m.counters[g][s] = 0
+						m.counters[g].store(s, 0)
 						m.kvHolder.items--
 						m.putLock.Unlock()
 						return false
@@ -452,33 +509,21 @@ func (m *LFUMap) PutMultiValue(l uint64, key []byte, vlen uint32, vals [][]byte)
 						copy(m.kvHolder.data[m.kvHolder.tail:], v)
 						m.kvHolder.tail += uint32(len(v))
 					}
-					m.kvHolder.mutex.Lock()
+					m.kvHolder.slotLock(g).Lock()
 					m.groups[g][s] = kIdx(kOffset/storeUintBytes + overLongStoreHeaderH + mapTypeHeader)
 					StoreUint32(m.kvHolder.data[kEnd:], vOffset/storeUintBytes+overLongStoreHeaderL)
-					m.kvHolder.mutex.Unlock()
+					m.kvHolder.slotLock(g).Unlock()
 
 					m.kvHolder.tail = ntail
 					m.kvHolder.valUsed += vCap
 				} else if vlen >= overShortSize {
 					vCap := Cap4Size(vlen)
 					ntail := m.kvHolder.tail + vCap
-					if vType == 0 {
-						m.kvHolder.valUsed -= m.groups[g][s].capOrBigSize()
-					} else {
-						vBig := m.groups[g][s].capOrBigSize()
-						vSize := vHeader&IdxSmallSizeMask>>24 + vBig<<8
-						if vSize == overLongSize {
-							vOffset := (vHeader & IdxOffsetMask) * 4
-							vSize = LoadUint32(m.kvHolder.data[vOffset:])
-							m.kvHolder.valUsed -= Cap4Size(vSize) + 4
-						} else {
-							m.kvHolder.valUsed -= Cap4Size(vSize)
-						}
-					}
+					m.kvHolder.valUsed -= m.kvHolder.valueCap(m.groups[g][s])
 					if ntail > m.kvHolder.cap {
 						m.ctrl[g][s] = tombstone
 						m.dead++
-						m.counters[g][s] = 0
+						m.counters[g].store(s, 0)
 						m.kvHolder.items--
 						m.putLock.Unlock()
 						return false
@@ -491,44 +536,33 @@ func (m *LFUMap) PutMultiValue(l uint64, key []byte, vlen uint32, vals [][]byte)
 						copy(m.kvHolder.data[m.kvHolder.tail:], v)
 						m.kvHolder.tail += uint32(len(v))
 					}
-					m.kvHolder.mutex.Lock()
+					m.kvHolder.slotLock(g).Lock()
 					m.groups[g][s] = kIdx(kOffset/4 + vBig<<24 + mapTypeHeader)
 					StoreUint32(m.kvHolder.data[kEnd:], vOffset/4+vSmall<<24)
-					m.kvHolder.mutex.Unlock()
+					m.kvHolder.slotLock(g).Unlock()
 
 					m.kvHolder.tail = ntail
 					m.kvHolder.valUsed += vCap
 				} else if vType == 0 && vlen <= m.groups[g][s].capOrBigSize()*4 && vlen < overShortSize {
 					vOffset := vHeader & IdxOffsetMask
+					m.kvHolder.valUsed = m.kvHolder.valUsed - Cap4Size(vHeader&IdxSmallSizeMask>>24) + Cap4Size(vlen)
 
-					m.kvHolder.mutex.Lock()
+					m.kvHolder.slotLock(g).Lock()
 					StoreUint32(m.kvHolder.data[kEnd:], vOffset+vlen<<24)
 					idx := vOffset * 4
 					for _, v := range vals {
 						copy(m.kvHolder.data[idx:], v)
 						idx += uint32(len(v))
 					}
-					m.kvHolder.mutex.Unlock()
+					m.kvHolder.slotLock(g).Unlock()
 				} else {
 					vCap := Cap4Size(vlen)
 					ntail := m.kvHolder.tail + vCap
-					if vType == 0 {
-						m.kvHolder.valUsed -= m.groups[g][s].capOrBigSize()
-					} else {
-						vBig := m.groups[g][s].capOrBigSize()
-						vSize := vHeader&IdxSmallSizeMask>>24 + vBig<<8
-						if vSize == overLongSize {
-							vOffset := (vHeader & IdxOffsetMask) * 4
-							vSize = LoadUint32(m.kvHolder.data[vOffset:])
-							m.kvHolder.valUsed -= Cap4Size(vSize) + 4
-						} else {
-							m.kvHolder.valUsed -= Cap4Size(vSize)
-						}
-					}
+					m.kvHolder.valUsed -= m.kvHolder.valueCap(m.groups[g][s])
 					if ntail > m.kvHolder.cap {
 						m.ctrl[g][s] = tombstone
 						m.dead++
-						m.counters[g][s] = 0
+						m.counters[g].store(s, 0)
 						m.kvHolder.items--
 						m.groups[g][s] = kIdx(0)
 						m.putLock.Unlock()
@@ -540,10 +574,10 @@ func (m *LFUMap) PutMultiValue(l uint64, key []byte, vlen uint32, vals [][]byte)
 						copy(m.kvHolder.data[m.kvHolder.tail:], v)
 						m.kvHolder.tail += uint32(len(v))
 					}
-					m.kvHolder.mutex.Lock()
+					m.kvHolder.slotLock(g).Lock()
 					m.groups[g][s] = kIdx(kOffset/4 + vCap/4<<24)
 					StoreUint32(m.kvHolder.data[kEnd:], vOffset/4+(vlen<<24))
-					m.kvHolder.mutex.Unlock()
+					m.kvHolder.slotLock(g).Unlock()
 					m.kvHolder.tail = ntail
 					m.kvHolder.valUsed += vCap
 				}
@@ -565,23 +599,125 @@ func (m *LFUMap) PutMultiValue(l uint64, key []byte, vlen uint32, vals [][]byte)
 }
 
 func (m *LFUMap) RePut(l uint64, key []byte, value []byte) bool {
-	if m.kvHolder.tail >= m.kvHolder.limit {
+	if m.missCache != nil {
+		defer m.missCache.del(key)
+	}
+	if m.delta != nil {
+		defer m.delta.record(key)
+	}
+
+	m.putLock.Lock()
+	ok := m.canRePutLocked() && m.rePutLocked(l, key, value)
+	m.putLock.Unlock()
+	return ok
+}
+
+// canRePutLocked reports whether the holder has room left and no GC runs,
+// putLock must be held.
+func (m *LFUMap) canRePutLocked() bool {
+	return m.kvHolder.tail < m.kvHolder.limit && !m.rehashing.Load()
+}
+
+// CompareAndSwap replaces the value of key by new only if it currently equals
+// old, a nil old means key is absent.
+func (m *LFUMap) CompareAndSwap(l uint64, key, old, new []byte) (swapped bool) {
+	m.putLock.Lock()
+	if !m.canRePutLocked() {
+		m.putLock.Unlock()
 		return false
 	}
+	cur, found := m.lookupLocked(l, key)
+	if found == (old != nil) && bytes.Equal(cur, old) {
+		swapped = m.rePutLocked(l, key, new)
+	}
+	m.putLock.Unlock()
+
+	if swapped {
+		if m.missCache != nil {
+			m.missCache.del(key)
+		}
+		if m.delta != nil {
+			m.delta.record(key)
+		}
+	}
+	return swapped
+}
+
+// PutIfAbsent is RePut that fails if key is present.
+func (m *LFUMap) PutIfAbsent(l uint64, key []byte, value []byte) bool {
+	return m.CompareAndSwap(l, key, nil, value)
+}
+
+// lookupLocked returns the value of key held in the kvHolder, putLock must
+// be held while the value is used.
+func (m *LFUMap) lookupLocked(l uint64, key []byte) (v []byte, found bool) {
+	hi, lo := splitHash(l)
+	g := probeStart(hi, len(m.groups))
+	for {
+		matches := metaMatchH2(&m.ctrl[g], lo)
+		for matches != 0 {
+			s := nextMatch(&matches)
+			k, v := m.kvHolder.getKVUnlock(m.groups[g][s])
+			if bytes.Equal(key, k) {
+				return v, true
+			}
+		}
+		if metaMatchEmpty(&m.ctrl[g]) != 0 {
+			return nil, false
+		}
+		g += 1
+		if g >= uint32(len(m.groups)) {
+			g = 0
+		}
+	}
+}
 
-	if m.rehashing {
+// PutReader is RePut with the value streamed from r, the value is the r.N
+// bytes left in r and fails if r ends before. The value is read past the
+// holder tail, leaving room for the largest key and value headers, so nothing
+// is committed until the read completes.
+func (m *LFUMap) PutReader(l uint64, key []byte, r *io.LimitedReader) bool {
+	if r.N < 0 || r.N >= int64(limitSize) {
 		return false
 	}
+	size := uint32(r.N)
+	if m.missCache != nil {
+		defer m.missCache.del(key)
+	}
+	if m.delta != nil {
+		defer m.delta.record(key)
+	}
 
 	m.putLock.Lock()
+	defer m.putLock.Unlock()
+
+	if !m.canRePutLocked() {
+		return false
+	}
+	if m.resident >= m.limit {
+		m.rehash()
+	}
+
+	stage := m.kvHolder.tail + 24
+	if stage+Cap4Size(size) > m.kvHolder.cap {
+		return false
+	}
+	value := m.kvHolder.data[stage : stage+size]
+	if _, err := io.ReadFull(r, value); err != nil {
+		return false
+	}
+	return m.rePutLocked(l, key, value)
+}
+
+// rePutLocked is RePut with putLock held.
+func (m *LFUMap) rePutLocked(l uint64, key []byte, value []byte) bool {
 	if m.resident >= m.limit {
-		m.rehashing = true
 		m.rehash()
-		m.rehashing = false
 	}
 
 	hi, lo := splitHash(l)
 	g := probeStart(hi, len(m.groups))
+	start := g
 	for {
 		matches := metaMatchH2(&m.ctrl[g], lo)
 		for matches != 0 {
@@ -595,61 +731,35 @@ func (m *LFUMap) RePut(l uint64, key []byte, value []byte) bool {
 				lv := uint32(len(value))
 				if lv >= overLongSize {
 					vCap := Cap4Size(lv) + 4
-					if vType == 0 {
-						m.kvHolder.valUsed -= m.groups[g][s].capOrBigSize()
-					} else {
-						vBig := m.groups[g][s].capOrBigSize()
-						vSize := vHeader&IdxSmallSizeMask>>24 + vBig<<8
-						if vSize == overLongSize {
-							vOffset := (vHeader & IdxOffsetMask) * 4
-							vSize = LoadUint32(m.kvHolder.data[vOffset:])
-							m.kvHolder.valUsed -= Cap4Size(vSize) + 4
-						} else {
-							m.kvHolder.valUsed -= Cap4Size(vSize)
-						}
-					}
+					m.kvHolder.valUsed -= m.kvHolder.valueCap(m.groups[g][s])
 					vOffset := m.kvHolder.tail + 4
-					ntail := vOffset + vCap
+					ntail := m.kvHolder.tail + vCap
 					if ntail > m.kvHolder.cap {
 						m.ctrl[g][s] = tombstone
 						m.dead++
-						m.counters[g][s] = 0
+						m.counters[g].store(s, 0)
 						m.kvHolder.items--
-						m.putLock.Unlock()
 						return false
 					}
 					StoreUint32(m.kvHolder.data[m.kvHolder.tail:], lv)
 					copy(m.kvHolder.data[vOffset:], value)
 
-					m.kvHolder.mutex.Lock()
+					m.kvHolder.slotLock(g).Lock()
 					m.groups[g][s] = kIdx(kOffset/storeUintBytes + overLongStoreHeaderH + mapTypeHeader)
 					StoreUint32(m.kvHolder.data[kEnd:], m.kvHolder.tail/storeUintBytes+overLongStoreHeaderL)
-					m.kvHolder.mutex.Unlock()
+					m.kvHolder.slotLock(g).Unlock()
 
 					m.kvHolder.tail = ntail
 					m.kvHolder.valUsed += vCap
 				} else if lv >= overShortSize {
 					vCap := Cap4Size(lv)
 					ntail := m.kvHolder.tail + vCap
-					if vType == 0 {
-						m.kvHolder.valUsed -= m.groups[g][s].capOrBigSize()
-					} else {
-						vBig := m.groups[g][s].capOrBigSize()
-						vSize := vHeader&IdxSmallSizeMask>>24 + vBig<<8
-						if vSize == overLongSize {
-							vOffset := (vHeader & IdxOffsetMask) * 4
-							vSize = LoadUint32(m.kvHolder.data[vOffset:])
-							m.kvHolder.valUsed -= Cap4Size(vSize) + 4
-						} else {
-							m.kvHolder.valUsed -= Cap4Size(vSize)
-						}
-					}
+					m.kvHolder.valUsed -= m.kvHolder.valueCap(m.groups[g][s])
 					if ntail > m.kvHolder.cap {
 						m.ctrl[g][s] = tombstone
 						m.dead++
-						m.counters[g][s] = 0
+						m.counters[g].store(s, 0)
 						m.kvHolder.items--
-						m.putLock.Unlock()
 						return false
 					}
 					vBig := lv & 0x7f00 >> 8
@@ -657,62 +767,57 @@ func (m *LFUMap) RePut(l uint64, key []byte, value []byte) bool {
 
 					copy(m.kvHolder.data[m.kvHolder.tail:], value)
 
-					m.kvHolder.mutex.Lock()
+					m.kvHolder.slotLock(g).Lock()
 					m.groups[g][s] = kIdx(kOffset/4 + vBig<<24 + mapTypeHeader)
 					StoreUint32(m.kvHolder.data[kEnd:], m.kvHolder.tail/4+vSmall<<24)
-					m.kvHolder.mutex.Unlock()
+					m.kvHolder.slotLock(g).Unlock()
 
 					m.kvHolder.tail = ntail
 					m.kvHolder.valUsed += vCap
 				} else if vType == 0 && lv <= m.groups[g][s].capOrBigSize()*4 && lv < overShortSize {
 					vOffset := vHeader & IdxOffsetMask
+					m.kvHolder.valUsed = m.kvHolder.valUsed - Cap4Size(vHeader&IdxSmallSizeMask>>24) + Cap4Size(lv)
 
-					m.kvHolder.mutex.Lock()
+					m.kvHolder.slotLock(g).Lock()
 					StoreUint32(m.kvHolder.data[kEnd:], vOffset+lv<<24)
 					copy(m.kvHolder.data[vOffset*4:], value)
-					m.kvHolder.mutex.Unlock()
+					m.kvHolder.slotLock(g).Unlock()
 				} else {
 					vCap := Cap4Size(lv)
 					ntail := m.kvHolder.tail + vCap
-					if vType == 0 {
-						m.kvHolder.valUsed -= m.groups[g][s].capOrBigSize()
-					} else {
-						vBig := m.groups[g][s].capOrBigSize()
-						vSize := vHeader&IdxSmallSizeMask>>24 + vBig<<8
-						if vSize == overLongSize {
-							vOffset := (vHeader & IdxOffsetMask) * 4
-							vSize = LoadUint32(m.kvHolder.data[vOffset:])
-							m.kvHolder.valUsed -= Cap4Size(vSize) + 4
-						} else {
-							m.kvHolder.valUsed -= Cap4Size(vSize)
-						}
-					}
+					m.kvHolder.valUsed -= m.kvHolder.valueCap(m.groups[g][s])
 					if ntail > m.kvHolder.cap {
 						m.ctrl[g][s] = tombstone
 						m.dead++
-						m.counters[g][s] = 0
+						m.counters[g].store(s, 0)
 						m.kvHolder.items--
 						m.groups[g][s] = kIdx(0)
-						m.putLock.Unlock()
 						return false
 					}
 
 					copy(m.kvHolder.data[m.kvHolder.tail:], value)
-					m.kvHolder.mutex.Lock()
+					m.kvHolder.slotLock(g).Lock()
 					m.groups[g][s] = kIdx(kOffset/4 + vCap/4<<24)
 					StoreUint32(m.kvHolder.data[kEnd:], m.kvHolder.tail/4+(lv<<24))
-					m.kvHolder.mutex.Unlock()
+					m.kvHolder.slotLock(g).Unlock()
 
 					m.kvHolder.tail = ntail
 					m.kvHolder.valUsed += vCap
 				}
-				m.putLock.Unlock()
 				return true
 			}
 		}
 
 		matches = metaMatchEmpty(&m.ctrl[g])
 		if matches != 0 {
+			freq := uint8(1)
+			if m.sketch != nil {
+				var admitted bool
+				if admitted, freq = m.admit(l, start); !admitted {
+					m.admissionRejects.Add(1)
+					return false
+				}
+			}
 			s := nextMatch(&matches)
 
 			lv := uint32(len(value))
@@ -720,7 +825,6 @@ func (m *LFUMap) RePut(l uint64, key []byte, value []byte) bool {
 				vCap := Cap4Size(lv) + 4
 				ntail := m.kvHolder.tail + 20 + vCap
 				if ntail > m.kvHolder.cap {
-					m.putLock.Unlock()
 					return false
 				}
 
@@ -729,26 +833,24 @@ func (m *LFUMap) RePut(l uint64, key []byte, value []byte) bool {
 				vOffset := kEnd + 4
 				StoreUint32(m.kvHolder.data[vOffset:], lv)
 				copy(m.kvHolder.data[vOffset+4:], value)
-				m.kvHolder.mutex.Lock()
+				m.kvHolder.slotLock(g).Lock()
 				m.groups[g][s] = kIdx(m.kvHolder.tail/storeUintBytes + overLongStoreHeaderH + mapTypeHeader)
 				StoreUint32(m.kvHolder.data[kEnd:], vOffset/storeUintBytes+(overLongStoreHeaderL))
-				m.kvHolder.mutex.Unlock()
+				m.kvHolder.slotLock(g).Unlock()
 
 				m.kvHolder.items++
 				m.kvHolder.valUsed += vCap
 				m.kvHolder.tail = ntail
 
 				m.ctrl[g][s] = int8(lo)
-				m.counters[g][s] = 1
+				m.counters[g].store(s, freq)
 				m.resident++
 
-				m.putLock.Unlock()
 				return true
 			} else if lv >= overShortSize {
 				vCap := Cap4Size(lv)
 				ntail := m.kvHolder.tail + 20 + vCap
 				if ntail > m.kvHolder.cap {
-					m.putLock.Unlock()
 					return false
 				}
 				vBig := lv >> 8
@@ -759,26 +861,24 @@ func (m *LFUMap) RePut(l uint64, key []byte, value []byte) bool {
 				vOffset := kEnd + 4
 				copy(m.kvHolder.data[vOffset:], value)
 
-				m.kvHolder.mutex.Lock()
+				m.kvHolder.slotLock(g).Lock()
 				m.groups[g][s] = kIdx(m.kvHolder.tail/4 + vBig<<24 + mapTypeHeader)
 				StoreUint32(m.kvHolder.data[kEnd:], vOffset/4+(vSmall<<24))
-				m.kvHolder.mutex.Unlock()
+				m.kvHolder.slotLock(g).Unlock()
 
 				m.kvHolder.items++
 				m.kvHolder.valUsed += vCap
 				m.kvHolder.tail = ntail
 
 				m.ctrl[g][s] = int8(lo)
-				m.counters[g][s] = 1
+				m.counters[g].store(s, freq)
 				m.resident++
 
-				m.putLock.Unlock()
 				return true
 			} else {
 				vCap := Cap4Size(lv)
 				ntail := m.kvHolder.tail + 20 + vCap
 				if ntail > m.kvHolder.cap {
-					m.putLock.Unlock()
 					return false
 				}
 				vSmall := lv
@@ -788,20 +888,19 @@ func (m *LFUMap) RePut(l uint64, key []byte, value []byte) bool {
 				vOffset := kEnd + 4
 				copy(m.kvHolder.data[vOffset:], value)
 
-				m.kvHolder.mutex.Lock()
+				m.kvHolder.slotLock(g).Lock()
 				m.groups[g][s] = kIdx(m.kvHolder.tail/4 + vCap/4<<24)
 				StoreUint32(m.kvHolder.data[kEnd:], vOffset/4+(vSmall<<24))
-				m.kvHolder.mutex.Unlock()
+				m.kvHolder.slotLock(g).Unlock()
 
 				m.kvHolder.items++
 				m.kvHolder.valUsed += vCap
 				m.kvHolder.tail = ntail
 
 				m.ctrl[g][s] = int8(lo)
-				m.counters[g][s] = 1
+				m.counters[g].store(s, freq)
 				m.resident++
 
-				m.putLock.Unlock()
 				return true
 			}
 		}
@@ -812,8 +911,86 @@ func (m *LFUMap) RePut(l uint64, key []byte, value []byte) bool {
 	}
 }
 
+// admit reports whether a new key may be inserted and the counter it starts
+// with. Below the eliminate watermark everything is admitted, otherwise the
+// candidate must be more frequent than the least used key of its probe start
+// group.
+func (m *LFUMap) admit(l uint64, g uint32) (bool, uint8) {
+	freq := m.sketch.estimate(l)
+	if freq == 0 {
+		freq = 1
+	}
+	if m.itemsMemUsage() < eliminateEnd {
+		return true, freq
+	}
+
+	victim := -1
+	for s := range m.ctrl[g] {
+		if c := m.ctrl[g][s]; c == empty || c == tombstone || m.groups[g][s] == 0 {
+			continue
+		}
+		if victim < 0 || m.counters[g].load(uint32(s)) < m.counters[g].load(uint32(victim)) {
+			victim = s
+		}
+	}
+	if victim < 0 {
+		return true, freq
+	}
+
+	_, vl := md5hash.MD5HL(m.kvHolder.getKey(m.groups[g][victim]))
+	return freq > m.sketch.estimate(vl), freq
+}
+
+// find locates the slot of key, the caller must hold putLock.
+func (m *LFUMap) find(l uint64, key []byte) (g, s uint32, ok bool) {
+	hi, lo := splitHash(l)
+	g = probeStart(hi, len(m.groups))
+	for {
+		matches := metaMatchH2(&m.ctrl[g], lo)
+		for matches != 0 {
+			s = nextMatch(&matches)
+			if m.groups[g][s] != 0 && bytes.Equal(key, m.kvHolder.getKey(m.groups[g][s])) {
+				return g, s, true
+			}
+		}
+		if metaMatchEmpty(&m.ctrl[g]) != 0 {
+			return 0, 0, false
+		}
+		g += 1
+		if g >= uint32(len(m.groups)) {
+			g = 0
+		}
+	}
+}
+
 func (m *LFUMap) Delete(l uint64, key []byte) (ok bool) {
+	if m.delta != nil {
+		defer m.delta.record(key)
+	}
+	m.putLock.Lock()
+	ok = m.delete(l, key)
+	m.putLock.Unlock()
+	return
+}
+
+// DeleteMulti removes the keys under one putLock and returns the count actually removed.
+func (m *LFUMap) DeleteMulti(hashes []uint64, keys [][]byte) (deleted int) {
 	m.putLock.Lock()
+	for i := range keys {
+		if m.delete(hashes[i], keys[i]) {
+			deleted++
+		}
+	}
+	m.putLock.Unlock()
+	if m.delta != nil {
+		for i := range keys {
+			m.delta.record(keys[i])
+		}
+	}
+	return
+}
+
+func (m *LFUMap) delete(l uint64, key []byte) bool {
 	hi, lo := splitHash(l)
 	g := probeStart(hi, len(m.groups))
 	for {
@@ -823,7 +1000,6 @@ func (m *LFUMap) Delete(l uint64, key []byte) (ok bool) {
 			k := m.kvHolder.getKey(m.groups[g][s])
 			if bytes.Equal(key, k) {
 				m.kvHolder.del(m.groups[g][s])
-				ok = true
 				if metaMatchEmpty(&m.ctrl[g]) != 0 {
 					m.ctrl[g][s] = empty
 					m.resident--
@@ -831,16 +1007,12 @@ func (m *LFUMap) Delete(l uint64, key []byte) (ok bool) {
 					m.ctrl[g][s] = tombstone
 					m.dead++
 				}
-				m.counters[g][s] = 0
-				m.putLock.Unlock()
-				return
+				m.counters[g].store(s, 0)
+				return true
 			}
 		}
-		matches = metaMatchEmpty(&m.ctrl[g])
-		if matches != 0 {
-			ok = false
-			m.putLock.Unlock()
-			return
+		if metaMatchEmpty(&m.ctrl[g]) != 0 {
+			return false
 		}
 		g += 1
 		if g >= uint32(len(m.groups)) {
@@ -859,7 +1031,7 @@ func (m *LFUMap) Clear() {
 	}
 	for i, c := range m.counters {
 		for j := range c {
-			m.counters[i][j] = 0
+			m.counters[i].store(uint32(j), 0)
 		}
 	}
 	for i, g := range m.groups {
@@ -873,10 +1045,75 @@ func (m *LFUMap) Clear() {
 	m.kvHolder.cap = 0
 	m.kvHolder.buffer.release()
 	m.kvHolder = kvholder
+	if m.delta != nil {
+		m.delta.reset()
+	}
+	if m.missCache != nil {
+		m.missCache.reset()
+	}
 	m.rehashLock.Unlock()
 	m.putLock.Unlock()
 }
 
+// Drain moves all live entries to out and removes them from the shard. The
+// lock is held for drainBatchGroups groups at a time and released while the
+// batch is sent, passes repeat until one finds the shard empty.
+func (m *LFUMap) Drain(out chan<- KV) (n int) {
+	batch := make([]KV, 0, drainBatchGroups)
+	for {
+		drained := 0
+		for start := 0; ; start += drainBatchGroups {
+			m.putLock.Lock()
+			if start >= len(m.groups) {
+				m.putLock.Unlock()
+				break
+			}
+			end := start + drainBatchGroups
+			if end > len(m.groups) {
+				end = len(m.groups)
+			}
+			m.rehashLock.Lock()
+			for g := start; g < end; g++ {
+				for s, c := range m.ctrl[g] {
+					if c == empty || c == tombstone || m.groups[g][s] == 0 {
+						continue
+					}
+					k, v := m.kvHolder.getKVUnlock(m.groups[g][s])
+					batch = append(batch, KV{
+						Key:   append([]byte(nil), k...),
+						Value: append([]byte(nil), v...),
+					})
+					if m.delta != nil {
+						m.delta.record(k)
+					}
+					m.kvHolder.del(m.groups[g][s])
+					m.groups[g][s] = 0
+					if metaMatchEmpty(&m.ctrl[g]) != 0 {
+						m.ctrl[g][s] = empty
+						m.resident--
+					} else {
+						m.ctrl[g][s] = tombstone
+						m.dead++
+					}
+					m.counters[g].store(uint32(s), 0)
+				}
+			}
+			m.rehashLock.Unlock()
+			m.putLock.Unlock()
+
+			for i := range batch {
+				out <- batch[i]
+			}
+			drained += len(batch)
+			batch = batch[:0]
+		}
+		n += drained
+		if drained == 0 {
+			return
+		}
+	}
+}
+
 func (m *LFUMap) Close() {
 	m.putLock.Lock()
 	m.rehashLock.Lock()
@@ -900,6 +1137,45 @@ func (m *LFUMap) MissCount() (count uint64) {
 	return m.missCnt.Load()
 }
 
+func (m *LFUMap) stats() ShardStats {
+	return ShardStats{
+		Items:     m.kvHolder.items,
+		UsedMem:   m.UsedMem(),
+		ItemsMem:  m.ItemsUsedMem(),
+		Queries:   m.queryCnt.Load(),
+		Misses:    m.missCnt.Load(),
+		Evictions: m.evictCnt.Load(),
+		Rehashes:  m.rehashCnt.Load(),
+		GCs:       m.gcCnt.Load(),
+	}
+}
+
+func (m *LFUMap) maintCount() uint64 {
+	return m.rehashCnt.Load() + m.gcCnt.Load()
+}
+
+func (m *LFUMap) ResetStats() {
+	m.queryCnt.Store(0)
+	m.missCnt.Store(0)
+}
+
+// GroupLoadHistogram counts groups by their number of live slots.
+func (m *LFUMap) GroupLoadHistogram() (hist [groupSize + 1]int) {
+	m.rehashLock.RLock()
+	defer m.rehashLock.RUnlock()
+
+	for g := range m.ctrl {
+		live := 0
+		for s := range m.ctrl[g] {
+			if c := m.ctrl[g][s]; c != empty && c != tombstone {
+				live++
+			}
+		}
+		hist[live]++
+	}
+	return
+}
+
 func (m *LFUMap) Count() int {
 	return int(m.resident - m.dead)
 }
@@ -918,6 +1194,21 @@ func (m *LFUMap) nextSize() (n uint32) {
 }
 
 func (m *LFUMap) rehash() {
+	if m.leases.Load() > 0 {
+		return
+	}
+	m.rehashCnt.Add(1)
+	workers := 1
+	if len(m.groups) >= parallelRehashGroups {
+		workers = runtime.GOMAXPROCS(0)
+		if workers > maxRehashWorkers {
+			workers = maxRehashWorkers
+		}
+	}
+	m.rehashWorkers(workers)
+}
+
+func (m *LFUMap) rehashWorkers(workers int) {
 	n := m.nextSize()
 	groups := make([]group, n)
 	ctrl := make([]metadata, n)
@@ -926,45 +1217,142 @@ func (m *LFUMap) rehash() {
 	for i := range ctrl {
 		ctrl[i] = newEmptyMetadata()
 	}
-	var resident uint32
-	for g := range m.ctrl {
-		for s := range m.ctrl[g] {
-			c := m.ctrl[g][s]
-			if c == empty || c == tombstone {
-				continue
-			}
-			k, v := m.kvHolder.getKVUnlock(m.groups[g][s])
 
-			_, l := md5hash.MD5HL(k)
-			hi, lo := splitHash(l)
-			gN := probeStart(hi, len(groups))
-			for {
-				matches := metaMatchEmpty(&ctrl[gN])
-				if matches != 0 {
-					sN := nextMatch(&matches)
-					groups[gN][sN], _ = kvholder.gcSet(k, v)
-					ctrl[gN][sN] = int8(lo)
-					counters[gN][sN] = m.counters[g][s]
-					resident++
-					break
+	if workers <= 1 {
+		var resident uint32
+		for g := range m.ctrl {
+			for s := range m.ctrl[g] {
+				c := m.ctrl[g][s]
+				if c == empty || c == tombstone {
+					continue
 				}
-				gN++
-				if gN >= uint32(len(groups)) {
-					gN = 0
+				k, v := m.kvHolder.getKVUnlock(m.groups[g][s])
+
+				_, l := md5hash.MD5HL(k)
+				hi, lo := splitHash(l)
+				gN := probeStart(hi, len(groups))
+				for {
+					matches := metaMatchEmpty(&ctrl[gN])
+					if matches != 0 {
+						sN := nextMatch(&matches)
+						groups[gN][sN], _ = kvholder.gcSet(k, v)
+						ctrl[gN][sN] = int8(lo)
+						counters[gN][sN] = m.counters[g].load(uint32(s))
+						resident++
+						break
+					}
+					gN++
+					if gN >= uint32(len(groups)) {
+						gN = 0
+					}
 				}
 			}
 		}
+		m.replaceState(groups, ctrl, counters, kvholder, resident)
+		return
 	}
 
+	var resident atomic.Uint32
+	var groupLocks [rehashLockStripes]sync.Mutex
+	var setLock sync.Mutex
+	var wg sync.WaitGroup
+	step := (len(m.ctrl) + workers - 1) / workers
+	for from := 0; from < len(m.ctrl); from += step {
+		to := from + step
+		if to > len(m.ctrl) {
+			to = len(m.ctrl)
+		}
+		wg.Add(1)
+		go func(from, to int) {
+			defer wg.Done()
+			var ks, vs [groupSize][]byte
+			var his [groupSize]h1
+			var los [groupSize]int8
+			var sizes, vCaps, offs [groupSize]uint32
+			for g := from; g < to; g++ {
+				for s := range m.ctrl[g] {
+					sizes[s] = 0
+					if c := m.ctrl[g][s]; c == empty || c == tombstone {
+						continue
+					}
+					ks[s], vs[s] = m.kvHolder.getKVUnlock(m.groups[g][s])
+					_, l := md5hash.MD5HL(ks[s])
+					hi, lo := splitHash(l)
+					his[s], los[s] = hi, int8(lo)
+					sizes[s], vCaps[s] = gcEntrySize(uint32(len(vs[s])))
+				}
+
+				// Only the space is reserved under setLock, the entries are
+				// copied into it in parallel.
+				setLock.Lock()
+				for s := range sizes {
+					offs[s] = 0
+					if sizes[s] == 0 || kvholder.tail+sizes[s] > kvholder.cap {
+						continue
+					}
+					offs[s] = kvholder.tail
+					kvholder.tail += sizes[s]
+					kvholder.items++
+					kvholder.valUsed += vCaps[s]
+				}
+				setLock.Unlock()
+
+				for s := range m.ctrl[g] {
+					if offs[s] == 0 {
+						continue
+					}
+					ki := kvholder.gcSetAt(offs[s], ks[s], vs[s])
+					gN := probeStart(his[s], len(groups))
+					for {
+						lock := &groupLocks[gN%rehashLockStripes]
+						lock.Lock()
+						matches := metaMatchEmpty(&ctrl[gN])
+						if matches != 0 {
+							sN := nextMatch(&matches)
+							groups[gN][sN] = ki
+							ctrl[gN][sN] = los[s]
+							counters[gN][sN] = m.counters[g].load(uint32(s))
+							lock.Unlock()
+							resident.Add(1)
+							break
+						}
+						lock.Unlock()
+						gN++
+						if gN >= uint32(len(groups)) {
+							gN = 0
+						}
+					}
+				}
+			}
+		}(from, to)
+	}
+	wg.Wait()
+
+	m.replaceState(groups, ctrl, counters, kvholder, resident.Load())
+}
+
+// replaceState swaps in a rebuilt table and releases the old buffer. It drops
+// the rebuilt table instead and returns false when a GetRef lease is out.
+// The caller must hold putLock; readers are excluded by rehashLock.
+func (m *LFUMap) replaceState(groups []group, ctrl []metadata, counters []counter, holder *kvHolder, resident uint32) bool {
 	m.rehashLock.Lock()
+	if m.leases.Load() > 0 {
+		m.rehashLock.Unlock()
+		holder.buffer.release()
+		return false
+	}
 	m.groups = groups
 	m.ctrl = ctrl
 	m.counters = counters
 	m.kvHolder.buffer.release()
-	m.kvHolder = kvholder
-	m.limit = n * maxAvgGroupLoad
+	m.kvHolder = holder
+	m.limit = uint32(len(groups)) * maxAvgGroupLoad
 	m.resident, m.dead = resident, 0
+	if m.missCache != nil {
+		m.missCache.reset()
+	}
 	m.rehashLock.Unlock()
+	return true
 }
 
 func (m *LFUMap) loadFactor() float32 {
@@ -990,6 +1378,9 @@ func (m *LFUMap) Eliminate() (delCount int, skipReason int) {
 		skipReason = skipReason3
 		return
 	}
+	if batch := m.owner.eliminateBatch; batch > 0 && n > batch {
+		n = batch
+	}
 
 	m.putLock.Lock()
 	item, x := BuildMinTopCounter[uint8](m.ctrl, m.counters, n)
@@ -999,6 +1390,9 @@ func (m *LFUMap) Eliminate() (delCount int, skipReason int) {
 		if m.ctrl[g][s] == tombstone || m.ctrl[g][s] == empty {
 			continue
 		}
+		if m.delta != nil {
+			m.delta.record(m.kvHolder.getKey(m.groups[g][s]))
+		}
 		m.rehashLock.Lock()
 		m.kvHolder.del(m.groups[g][s])
 		m.groups[g][s] = 0
@@ -1007,6 +1401,7 @@ func (m *LFUMap) Eliminate() (delCount int, skipReason int) {
 		m.dead++
 		delCount++
 	}
+	m.evictCnt.Add(uint64(delCount))
 
 	var level [16]uint8
 	for i := 0; i < 16; i++ {
@@ -1017,21 +1412,30 @@ func (m *LFUMap) Eliminate() (delCount int, skipReason int) {
 	for i := 0; i < ctrLen; i++ {
 		simd.MSubs128epu8(unsafe.Pointer(&(m.counters[i])), unsafe.Pointer(&level), unsafe.Pointer(&(m.counters[i])))
 	}
+	if m.sketch != nil {
+		m.sketch.halve()
+	}
 	m.putLock.Unlock()
 	return
 }
 
 func (m *LFUMap) GCCopy() (deadCount int, gcMem int, skipReason int) {
-	if m.garbageUsage() < garbageRate {
-		skipReason = skipReason1
+	if !m.rehashing.CompareAndSwap(false, true) {
+		skipReason = skipReason2
 		return
 	}
+	defer m.rehashing.Store(false)
 
-	if m.rehashing {
-		skipReason = skipReason2
+	m.putLock.Lock()
+	if m.leases.Load() > 0 {
+		m.putLock.Unlock()
+		skipReason = skipReason3
+		return
+	}
+	if m.garbageUsage() < garbageRate {
+		m.putLock.Unlock()
+		skipReason = skipReason1
 		return
-	} else {
-		m.rehashing = true
 	}
 	oldUsed := m.kvHolder.tail
 	deadCount = int(m.dead)
@@ -1040,8 +1444,6 @@ func (m *LFUMap) GCCopy() (deadCount int, gcMem int, skipReason int) {
 	ctrl := make([]metadata, n)
 	counters := make([]counter, n)
 	kvholder := newKVHolder(Byte(m.kvHolder.cap))
-
-	m.putLock.Lock()
 	for i := range ctrl {
 		ctrl[i] = newEmptyMetadata()
 	}
@@ -1063,7 +1465,7 @@ func (m *LFUMap) GCCopy() (deadCount int, gcMem int, skipReason int) {
 					sN := nextMatch(&matches)
 					groups[gN][sN], _ = kvholder.gcSet(k, v)
 					ctrl[gN][sN] = int8(lo)
-					counters[gN][sN] = m.counters[g][s]
+					counters[gN][sN] = m.counters[g].load(uint32(s))
 					break
 				}
 				gN++
@@ -1074,16 +1476,14 @@ func (m *LFUMap) GCCopy() (deadCount int, gcMem int, skipReason int) {
 		}
 	}
 
-	m.rehashLock.Lock()
-	m.groups = groups
-	m.ctrl = ctrl
-	m.counters = counters
-	m.kvHolder.buffer.release()
-	m.kvHolder = kvholder
-	m.resident, m.dead = m.resident-m.dead, 0
-	m.rehashLock.Unlock()
-	m.putLock.Unlock()
-	m.rehashing = false
+	if !m.replaceState(groups, ctrl, counters, kvholder, m.resident-m.dead) {
+		m.putLock.Unlock()
+		deadCount = 0
+		skipReason = skipReason3
+		return
+	}
 	gcMem = int(oldUsed - m.kvHolder.tail)
+	m.putLock.Unlock()
+	m.gcCnt.Add(1)
 	return
 }
@@ -0,0 +1,58 @@
+// Copyright 2019-2024 Xu Ruibo (hustxurb@163.com) and Contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vectormap
+
+import "sync"
+
+type Loader func(key []byte) ([]byte, bool)
+
+type loadCall struct {
+	wg  sync.WaitGroup
+	val []byte
+	ok  bool
+}
+
+// loadGroup dedups concurrent loads of the same key, the callers arriving
+// while a load is running wait for it and share its result.
+type loadGroup struct {
+	mu    sync.Mutex
+	calls map[string]*loadCall
+}
+
+func (g *loadGroup) do(key []byte, fn Loader) ([]byte, bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*loadCall)
+	}
+	if c, ok := g.calls[string(key)]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.val, c.ok
+	}
+	c := &loadCall{}
+	c.wg.Add(1)
+	sk := string(key)
+	g.calls[sk] = c
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, sk)
+		g.mu.Unlock()
+		c.wg.Done()
+	}()
+	c.val, c.ok = fn(key)
+	return c.val, c.ok
+}
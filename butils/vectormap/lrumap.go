@@ -48,6 +48,9 @@ type LRUMap struct {
 
 	queryCnt    atomic.Uint64
 	missCnt     atomic.Uint64
+	evictCnt    atomic.Uint64
+	rehashCnt   atomic.Uint64
+	gcCnt       atomic.Uint64
 	minTopSince uint16
 	rehashing   bool
 }
@@ -146,9 +149,9 @@ func (m *LRUMap) Has(l uint64, key []byte) (ok bool) {
 		matches := metaMatchH2(&m.ctrl[g], lo)
 		for matches != 0 {
 			s := nextMatch(&matches)
-			m.kvHolder.mutex.RLock()
+			m.kvHolder.slotLock(g).RLock()
 			k := m.kvHolder.getKey(m.groups[g][s])
-			m.kvHolder.mutex.RUnlock()
+			m.kvHolder.slotLock(g).RUnlock()
 			if bytes.Equal(key, k) {
 				ok = true
 				m.sinces[g][s] = uint16(time.Since(m.startTime) / UnitTime)
@@ -181,9 +184,9 @@ func (m *LRUMap) Get(l uint64, key []byte) (value []byte, closer func(), ok bool
 		for matches != 0 {
 			s := nextMatch(&matches)
 
-			m.kvHolder.mutex.RLock()
+			m.kvHolder.slotLock(g).RLock()
 			if m.groups[g][s] == 0 {
-				m.kvHolder.mutex.RUnlock()
+				m.kvHolder.slotLock(g).RUnlock()
 				continue
 			}
 			kOffset := m.groups[g][s].offset() * 4
@@ -198,27 +201,32 @@ func (m *LRUMap) Get(l uint64, key []byte) (value []byte, closer func(), ok bool
 					value, closer = VMBytePools.GetBytePool(int(vSize))
 					copy(value, m.kvHolder.data[vOffset:vOffset+vSize])
 					m.sinces[g][s] = uint16(time.Since(m.startTime) / UnitTime)
-					m.kvHolder.mutex.RUnlock()
+					m.kvHolder.slotLock(g).RUnlock()
 					value = value[:vSize]
 				} else {
 					vOffset := (vHeader & IdxOffsetMask) * 4
 					vBig := m.groups[g][s].capOrBigSize()
 					vSize := vHeader&IdxSmallSizeMask>>24 + vBig<<8
 					if vSize == overLongSize {
-						vSize = LoadUint32(m.kvHolder.data[vOffset:])
+						if vSize, ok = m.kvHolder.loadOverLong(vOffset); !ok {
+							m.kvHolder.slotLock(g).RUnlock()
+							m.rehashLock.RUnlock()
+							m.missCnt.Add(1)
+							return
+						}
 						value, closer = m.kvHolder.getValue(vOffset+4, vSize)
 					} else {
 						value, closer = m.kvHolder.getValue(vOffset, vSize)
 					}
 
 					m.sinces[g][s] = uint16(time.Since(m.startTime) / UnitTime)
-					m.kvHolder.mutex.RUnlock()
+					m.kvHolder.slotLock(g).RUnlock()
 				}
 
 				m.rehashLock.RUnlock()
 				return
 			} else {
-				m.kvHolder.mutex.RUnlock()
+				m.kvHolder.slotLock(g).RUnlock()
 			}
 		}
 		matches = metaMatchEmpty(&m.ctrl[g])
@@ -235,6 +243,41 @@ func (m *LRUMap) Get(l uint64, key []byte) (value []byte, closer func(), ok bool
 	}
 }
 
+func (m *LRUMap) Encoding(l uint64, key []byte) (enc ValueEncoding, vSize uint32, ok bool, err error) {
+	m.rehashLock.RLock()
+	defer m.rehashLock.RUnlock()
+	hi, lo := splitHash(l)
+	g := probeStart(hi, len(m.groups))
+	for {
+		matches := metaMatchH2(&m.ctrl[g], lo)
+		for matches != 0 {
+			s := nextMatch(&matches)
+			m.kvHolder.slotLock(g).RLock()
+			if m.groups[g][s] != 0 && bytes.Equal(key, m.kvHolder.getKey(m.groups[g][s])) {
+				enc, vSize, err = m.kvHolder.valueEncoding(m.groups[g][s])
+				m.kvHolder.slotLock(g).RUnlock()
+				ok = err == nil
+				return
+			}
+			m.kvHolder.slotLock(g).RUnlock()
+		}
+		matches = metaMatchEmpty(&m.ctrl[g])
+		if matches != 0 {
+			return
+		}
+		g += 1
+		if g >= uint32(len(m.groups)) {
+			g = 0
+		}
+	}
+}
+
+func (m *LRUMap) ValueSizeHistogram(buckets []uint32) []int {
+	m.rehashLock.RLock()
+	defer m.rehashLock.RUnlock()
+	return valueSizeHistogram(m.kvHolder, m.ctrl, m.groups, buckets)
+}
+
 func (m *LRUMap) Put(l uint64, key []byte, value []byte) bool {
 	m.putLock.Lock()
 	hi, lo := splitHash(l)
@@ -255,38 +298,14 @@ func (m *LRUMap) Put(l uint64, key []byte, value []byte) bool {
 					m.dead++
 
 					m.kvHolder.items--
-					if vType == 0 {
-						m.kvHolder.valUsed -= m.groups[g][s].capOrBigSize()
-					} else {
-						vBig := m.groups[g][s].capOrBigSize()
-						vSize := vHeader&IdxSmallSizeMask>>24 + vBig<<8
-						if vSize == overLongSize {
-							vOffset := (vHeader & IdxOffsetMask) * 4
-							vSize = LoadUint32(m.kvHolder.data[vOffset:])
-							m.kvHolder.valUsed -= Cap4Size(vSize) + 4
-						} else {
-							m.kvHolder.valUsed -= Cap4Size(vSize)
-						}
-					}
+					m.kvHolder.valUsed -= m.kvHolder.valueCap(m.groups[g][s])
 
 					m.putLock.Unlock()
 					return false
 				} else if lv >= overLongSize {
 					vCap := Cap4Size(lv) + 4
-					ntail := m.kvHolder.tail + 20 + vCap
-					if vType == 0 {
-						m.kvHolder.valUsed -= m.groups[g][s].capOrBigSize()
-					} else {
-						vBig := m.groups[g][s].capOrBigSize()
-						vSize := vHeader&IdxSmallSizeMask>>24 + vBig<<8
-						if vSize == overLongSize {
-							vOffset := (vHeader & IdxOffsetMask) * 4
-							vSize = LoadUint32(m.kvHolder.data[vOffset:])
-							m.kvHolder.valUsed -= Cap4Size(vSize) + 4
-						} else {
-							m.kvHolder.valUsed -= Cap4Size(vSize)
-						}
-					}
+					ntail := m.kvHolder.tail + vCap
+					m.kvHolder.valUsed -= m.kvHolder.valueCap(m.groups[g][s])
 					if ntail > m.kvHolder.cap {
 						m.ctrl[g][s] = tombstone
 						m.dead++
@@ -299,30 +318,18 @@ func (m *LRUMap) Put(l uint64, key []byte, value []byte) bool {
 					StoreUint32(m.kvHolder.data[vOffset:], lv)
 					copy(m.kvHolder.data[vOffset+4:], value)
 
-					m.kvHolder.mutex.Lock()
+					m.kvHolder.slotLock(g).Lock()
 					m.groups[g][s] = kIdx(kOffset/storeUintBytes + overLongStoreHeaderH + mapTypeHeader)
 					m.sinces[g][s] = uint16(time.Since(m.startTime) / UnitTime)
 					StoreUint32(m.kvHolder.data[kEnd:], vOffset/storeUintBytes+overLongStoreHeaderL)
-					m.kvHolder.mutex.Unlock()
+					m.kvHolder.slotLock(g).Unlock()
 
 					m.kvHolder.tail = ntail
 					m.kvHolder.valUsed += vCap
 				} else if lv >= overShortSize {
 					vCap := Cap4Size(lv)
 					ntail := m.kvHolder.tail + vCap
-					if vType == 0 {
-						m.kvHolder.valUsed -= m.groups[g][s].capOrBigSize()
-					} else {
-						vBig := m.groups[g][s].capOrBigSize()
-						vSize := vHeader&IdxSmallSizeMask>>24 + vBig<<8
-						if vSize == overLongSize {
-							vOffset := (vHeader & IdxOffsetMask) * 4
-							vSize = LoadUint32(m.kvHolder.data[vOffset:])
-							m.kvHolder.valUsed -= Cap4Size(vSize) + 4
-						} else {
-							m.kvHolder.valUsed -= Cap4Size(vSize)
-						}
-					}
+					m.kvHolder.valUsed -= m.kvHolder.valueCap(m.groups[g][s])
 					if ntail > m.kvHolder.cap {
 						m.ctrl[g][s] = tombstone
 						m.dead++
@@ -336,38 +343,27 @@ func (m *LRUMap) Put(l uint64, key []byte, value []byte) bool {
 
 					copy(m.kvHolder.data[m.kvHolder.tail:], value)
 
-					m.kvHolder.mutex.Lock()
+					m.kvHolder.slotLock(g).Lock()
 					m.groups[g][s] = kIdx(kOffset/storeUintBytes + vBig<<24 + mapTypeHeader)
 					m.sinces[g][s] = uint16(time.Since(m.startTime) / UnitTime)
 					StoreUint32(m.kvHolder.data[kEnd:], m.kvHolder.tail/storeUintBytes+vSmall<<24)
-					m.kvHolder.mutex.Unlock()
+					m.kvHolder.slotLock(g).Unlock()
 
 					m.kvHolder.tail = ntail
 					m.kvHolder.valUsed += vCap
 				} else if vType == 0 && lv <= m.groups[g][s].capOrBigSize()*4 && lv < overShortSize {
 					vOffset := vHeader & IdxOffsetMask
+					m.kvHolder.valUsed = m.kvHolder.valUsed - Cap4Size(vHeader&IdxSmallSizeMask>>24) + Cap4Size(lv)
 
-					m.kvHolder.mutex.Lock()
+					m.kvHolder.slotLock(g).Lock()
 					StoreUint32(m.kvHolder.data[kEnd:], vOffset+lv<<24)
 					m.sinces[g][s] = uint16(time.Since(m.startTime) / UnitTime)
 					copy(m.kvHolder.data[vOffset*4:], value)
-					m.kvHolder.mutex.Unlock()
+					m.kvHolder.slotLock(g).Unlock()
 				} else {
 					vCap := Cap4Size(lv)
 					ntail := m.kvHolder.tail + vCap
-					if vType == 0 {
-						m.kvHolder.valUsed -= m.groups[g][s].capOrBigSize()
-					} else {
-						vBig := m.groups[g][s].capOrBigSize()
-						vSize := vHeader&IdxSmallSizeMask>>24 + vBig<<8
-						if vSize == overLongSize {
-							vOffset := (vHeader & IdxOffsetMask) * 4
-							vSize = LoadUint32(m.kvHolder.data[vOffset:])
-							m.kvHolder.valUsed -= Cap4Size(vSize) + 4
-						} else {
-							m.kvHolder.valUsed -= Cap4Size(vSize)
-						}
-					}
+					m.kvHolder.valUsed -= m.kvHolder.valueCap(m.groups[g][s])
 					if ntail > m.kvHolder.cap {
 						m.ctrl[g][s] = tombstone
 						m.dead++
@@ -379,11 +375,11 @@ func (m *LRUMap) Put(l uint64, key []byte, value []byte) bool {
 					}
 
 					copy(m.kvHolder.data[m.kvHolder.tail:], value)
-					m.kvHolder.mutex.Lock()
+					m.kvHolder.slotLock(g).Lock()
 					m.groups[g][s] = kIdx(kOffset/storeUintBytes + vCap/storeUintBytes<<24)
 					m.sinces[g][s] = uint16(time.Since(m.startTime) / UnitTime)
 					StoreUint32(m.kvHolder.data[kEnd:], m.kvHolder.tail/storeUintBytes+(lv<<24))
-					m.kvHolder.mutex.Unlock()
+					m.kvHolder.slotLock(g).Unlock()
 
 					m.kvHolder.tail = ntail
 					m.kvHolder.valUsed += vCap
@@ -424,39 +420,14 @@ func (m *LRUMap) PutMultiValue(l uint64, key []byte, vlen uint32, vals [][]byte)
 					m.dead++
 
 					m.kvHolder.items--
-					if vType == 0 {
-						m.kvHolder.valUsed -= m.groups[g][s].capOrBigSize()
-					} else {
-						vBig := m.groups[g][s].capOrBigSize()
-						vSize := vHeader&IdxSmallSizeMask>>24 + vBig<<8
-						if vSize == overLongSize {
-							vOffset := (vHeader & IdxOffsetMask) * 4
-							vSize = LoadUint32(m.kvHolder.data[vOffset:])
-							m.kvHolder.valUsed -= Cap4Size(vSize) + 4
-						} else {
-							m.kvHolder.valUsed -= Cap4Size(vSize)
-						}
-					}
+					m.kvHolder.valUsed -= m.kvHolder.valueCap(m.groups[g][s])
 
 					m.putLock.Unlock()
 					return false
 				} else if vlen >= overLongSize {
 					vCap := Cap4Size(vlen) + 4
-					ntail := m.kvHolder.tail + 20 + vCap
-					if vType == 0 {
-						m.kvHolder.valUsed -= m.groups[g][s].capOrBigSize()
-					} else {
-
-						vBig := m.groups[g][s].capOrBigSize()
-						vSize := vHeader&IdxSmallSizeMask>>24 + vBig<<8
-						if vSize == overLongSize {
-							vOffset := (vHeader & IdxOffsetMask) * 4
-							vSize = LoadUint32(m.kvHolder.data[vOffset:])
-							m.kvHolder.valUsed -= Cap4Size(vSize) + 4
-						} else {
-							m.kvHolder.valUsed -= Cap4Size(vSize)
-						}
-					}
+					ntail := m.kvHolder.tail + vCap
+					m.kvHolder.valUsed -= m.kvHolder.valueCap(m.groups[g][s])
 					if ntail > m.kvHolder.cap {
 						m.ctrl[g][s] = tombstone
 						m.dead++
@@ -471,30 +442,18 @@ func (m *LRUMap) PutMultiValue(l uint64, key []byte, vlen uint32, vals [][]byte)
 						copy(m.kvHolder.data[m.kvHolder.tail:], v)
 						m.kvHolder.tail += uint32(len(v))
 					}
-					m.kvHolder.mutex.Lock()
+					m.kvHolder.slotLock(g).Lock()
 					m.groups[g][s] = kIdx(kOffset/storeUintBytes + overLongStoreHeaderH + mapTypeHeader)
 					m.sinces[g][s] = uint16(time.Since(m.startTime) / UnitTime)
 					StoreUint32(m.kvHolder.data[kEnd:], vOffset/storeUintBytes+overLongStoreHeaderL)
-					m.kvHolder.mutex.Unlock()
+					m.kvHolder.slotLock(g).Unlock()
 
 					m.kvHolder.tail = ntail
 					m.kvHolder.valUsed += vCap
 				} else if vlen >= overShortSize {
 					vCap := Cap4Size(vlen)
 					ntail := m.kvHolder.tail + vCap
-					if vType == 0 {
-						m.kvHolder.valUsed -= m.groups[g][s].capOrBigSize()
-					} else {
-						vBig := m.groups[g][s].capOrBigSize()
-						vSize := vHeader&IdxSmallSizeMask>>24 + vBig<<8
-						if vSize == overLongSize {
-							vOffset := (vHeader & IdxOffsetMask) * 4
-							vSize = LoadUint32(m.kvHolder.data[vOffset:])
-							m.kvHolder.valUsed -= Cap4Size(vSize) + 4
-						} else {
-							m.kvHolder.valUsed -= Cap4Size(vSize)
-						}
-					}
+					m.kvHolder.valUsed -= m.kvHolder.valueCap(m.groups[g][s])
 					if ntail > m.kvHolder.cap {
 						m.ctrl[g][s] = tombstone
 						m.dead++
@@ -510,18 +469,19 @@ func (m *LRUMap) PutMultiValue(l uint64, key []byte, vlen uint32, vals [][]byte)
 						copy(m.kvHolder.data[m.kvHolder.tail:], v)
 						m.kvHolder.tail += uint32(len(v))
 					}
-					m.kvHolder.mutex.Lock()
+					m.kvHolder.slotLock(g).Lock()
 					m.groups[g][s] = kIdx(kOffset/storeUintBytes + vBig<<24 + mapTypeHeader)
 					m.sinces[g][s] = uint16(time.Since(m.startTime) / UnitTime)
 					StoreUint32(m.kvHolder.data[kEnd:], vOffset/storeUintBytes+vSmall<<24)
-					m.kvHolder.mutex.Unlock()
+					m.kvHolder.slotLock(g).Unlock()
 
 					m.kvHolder.tail = ntail
 					m.kvHolder.valUsed += vCap
 				} else if vType == 0 && vlen <= m.groups[g][s].capOrBigSize()*4 && vlen < overShortSize {
 					vOffset := vHeader & IdxOffsetMask
+					m.kvHolder.valUsed = m.kvHolder.valUsed - Cap4Size(vHeader&IdxSmallSizeMask>>24) + Cap4Size(vlen)
 
-					m.kvHolder.mutex.Lock()
+					m.kvHolder.slotLock(g).Lock()
 					StoreUint32(m.kvHolder.data[kEnd:], vOffset+vlen<<24)
 					idx := vOffset * 4
 					for _, v := range vals {
@@ -529,23 +489,11 @@ func (m *LRUMap) PutMultiValue(l uint64, key []byte, vlen uint32, vals [][]byte)
 						idx += uint32(len(v))
 					}
 					m.sinces[g][s] = uint16(time.Since(m.startTime) / UnitTime)
-					m.kvHolder.mutex.Unlock()
+					m.kvHolder.slotLock(g).Unlock()
 				} else {
 					vCap := Cap4Size(vlen)
 					ntail := m.kvHolder.tail + vCap
-					if vType == 0 {
-						m.kvHolder.valUsed -= m.groups[g][s].capOrBigSize()
-					} else {
-						vBig := m.groups[g][s].capOrBigSize()
-						vSize := vHeader&IdxSmallSizeMask>>24 + vBig<<8
-						if vSize == overLongSize {
-							vOffset := (vHeader & IdxOffsetMask) * 4
-							vSize = LoadUint32(m.kvHolder.data[vOffset:])
-							m.kvHolder.valUsed -= Cap4Size(vSize) + 4
-						} else {
-							m.kvHolder.valUsed -= Cap4Size(vSize)
-						}
-					}
+					m.kvHolder.valUsed -= m.kvHolder.valueCap(m.groups[g][s])
 					if ntail > m.kvHolder.cap {
 						m.ctrl[g][s] = tombstone
 						m.dead++
@@ -561,11 +509,11 @@ func (m *LRUMap) PutMultiValue(l uint64, key []byte, vlen uint32, vals [][]byte)
 						copy(m.kvHolder.data[m.kvHolder.tail:], v)
 						m.kvHolder.tail += uint32(len(v))
 					}
-					m.kvHolder.mutex.Lock()
+					m.kvHolder.slotLock(g).Lock()
 					m.groups[g][s] = kIdx(kOffset/storeUintBytes + vCap/storeUintBytes<<24)
 					m.sinces[g][s] = uint16(time.Since(m.startTime) / UnitTime)
 					StoreUint32(m.kvHolder.data[kEnd:], vOffset/storeUintBytes+(vlen<<24))
-					m.kvHolder.mutex.Unlock()
+					m.kvHolder.slotLock(g).Unlock()
 					m.kvHolder.tail = ntail
 					m.kvHolder.valUsed += vCap
 				}
@@ -587,6 +535,15 @@ func (m *LRUMap) PutMultiValue(l uint64, key []byte, vlen uint32, vals [][]byte)
 }
 
 func (m *LRUMap) RePut(l uint64, key []byte, value []byte) bool {
+	return m.rePut(l, key, value, false)
+}
+
+// PutIfAbsent is RePut that fails if key is present.
+func (m *LRUMap) PutIfAbsent(l uint64, key []byte, value []byte) bool {
+	return m.rePut(l, key, value, true)
+}
+
+func (m *LRUMap) rePut(l uint64, key []byte, value []byte, onlyAbsent bool) bool {
 	if m.kvHolder.tail >= m.kvHolder.limit {
 		return false
 	}
@@ -610,6 +567,10 @@ func (m *LRUMap) RePut(l uint64, key []byte, value []byte) bool {
 			s := nextMatch(&matches)
 			k := m.kvHolder.getKey(m.groups[g][s])
 			if bytes.Equal(key, k) { // update
+				if onlyAbsent {
+					m.putLock.Unlock()
+					return false
+				}
 				kOffset := m.groups[g][s].offset() * 4
 				kEnd := kOffset + 16
 				vHeader := LoadUint32(m.kvHolder.data[kEnd:])
@@ -617,21 +578,9 @@ func (m *LRUMap) RePut(l uint64, key []byte, value []byte) bool {
 				lv := uint32(len(value))
 				if lv >= overLongSize {
 					vCap := Cap4Size(lv) + 4
-					if vType == 0 {
-						m.kvHolder.valUsed -= m.groups[g][s].capOrBigSize()
-					} else {
-						vBig := m.groups[g][s].capOrBigSize()
-						vSize := vHeader&IdxSmallSizeMask>>24 + vBig<<8
-						if vSize == overLongSize {
-							vOffset := (vHeader & IdxOffsetMask) * 4
-							vSize = LoadUint32(m.kvHolder.data[vOffset:])
-							m.kvHolder.valUsed -= Cap4Size(vSize) + 4
-						} else {
-							m.kvHolder.valUsed -= Cap4Size(vSize)
-						}
-					}
+					m.kvHolder.valUsed -= m.kvHolder.valueCap(m.groups[g][s])
 					vOffset := m.kvHolder.tail + 4
-					ntail := vOffset + vCap
+					ntail := m.kvHolder.tail + vCap
 					if ntail > m.kvHolder.cap {
 						m.ctrl[g][s] = tombstone
 						m.dead++
@@ -643,11 +592,11 @@ func (m *LRUMap) RePut(l uint64, key []byte, value []byte) bool {
 					StoreUint32(m.kvHolder.data[m.kvHolder.tail:], lv)
 					copy(m.kvHolder.data[vOffset:], value)
 
-					m.kvHolder.mutex.Lock()
+					m.kvHolder.slotLock(g).Lock()
 					m.groups[g][s] = kIdx(kOffset/storeUintBytes + overLongStoreHeaderH + mapTypeHeader)
 					StoreUint32(m.kvHolder.data[kEnd:], m.kvHolder.tail/storeUintBytes+overLongStoreHeaderL)
 					m.sinces[g][s] = uint16(time.Since(m.startTime) / UnitTime)
-					m.kvHolder.mutex.Unlock()
+					m.kvHolder.slotLock(g).Unlock()
 
 					m.kvHolder.tail = ntail
 					m.kvHolder.valUsed += vCap
@@ -655,19 +604,7 @@ func (m *LRUMap) RePut(l uint64, key []byte, value []byte) bool {
 					vCap := Cap4Size(lv)
 					ntail := m.kvHolder.tail + vCap
 
-					if vType == 0 {
-						m.kvHolder.valUsed -= m.groups[g][s].capOrBigSize()
-					} else {
-						vBig := m.groups[g][s].capOrBigSize()
-						vSize := vHeader&IdxSmallSizeMask>>24 + vBig<<8
-						if vSize == overLongSize {
-							vOffset := (vHeader & IdxOffsetMask) * 4
-							vSize = LoadUint32(m.kvHolder.data[vOffset:])
-							m.kvHolder.valUsed -= Cap4Size(vSize) + 4
-						} else {
-							m.kvHolder.valUsed -= Cap4Size(vSize)
-						}
-					}
+					m.kvHolder.valUsed -= m.kvHolder.valueCap(m.groups[g][s])
 
 					if ntail > m.kvHolder.cap {
 						m.ctrl[g][s] = tombstone
@@ -682,38 +619,27 @@ func (m *LRUMap) RePut(l uint64, key []byte, value []byte) bool {
 
 					copy(m.kvHolder.data[m.kvHolder.tail:], value)
 
-					m.kvHolder.mutex.Lock()
+					m.kvHolder.slotLock(g).Lock()
 					m.groups[g][s] = kIdx(kOffset/storeUintBytes + vBig<<24 + mapTypeHeader)
 					StoreUint32(m.kvHolder.data[kEnd:], m.kvHolder.tail/storeUintBytes+vSmall<<24)
 					m.sinces[g][s] = uint16(time.Since(m.startTime) / UnitTime)
-					m.kvHolder.mutex.Unlock()
+					m.kvHolder.slotLock(g).Unlock()
 
 					m.kvHolder.tail = ntail
 					m.kvHolder.valUsed += vCap
 				} else if vType == 0 && lv <= m.groups[g][s].capOrBigSize()*4 && lv < overShortSize {
 					vOffset := vHeader & IdxOffsetMask
+					m.kvHolder.valUsed = m.kvHolder.valUsed - Cap4Size(vHeader&IdxSmallSizeMask>>24) + Cap4Size(lv)
 
-					m.kvHolder.mutex.Lock()
+					m.kvHolder.slotLock(g).Lock()
 					StoreUint32(m.kvHolder.data[kEnd:], vOffset+lv<<24)
 					copy(m.kvHolder.data[vOffset*4:], value)
 					m.sinces[g][s] = uint16(time.Since(m.startTime) / UnitTime)
-					m.kvHolder.mutex.Unlock()
+					m.kvHolder.slotLock(g).Unlock()
 				} else {
 					vCap := Cap4Size(lv)
 					ntail := m.kvHolder.tail + vCap
-					if vType == 0 {
-						m.kvHolder.valUsed -= m.groups[g][s].capOrBigSize()
-					} else {
-						vBig := m.groups[g][s].capOrBigSize()
-						vSize := vHeader&IdxSmallSizeMask>>24 + vBig<<8
-						if vSize == overLongSize {
-							vOffset := (vHeader & IdxOffsetMask) * 4
-							vSize = LoadUint32(m.kvHolder.data[vOffset:])
-							m.kvHolder.valUsed -= Cap4Size(vSize) + 4
-						} else {
-							m.kvHolder.valUsed -= Cap4Size(vSize)
-						}
-					}
+					m.kvHolder.valUsed -= m.kvHolder.valueCap(m.groups[g][s])
 					if ntail > m.kvHolder.cap {
 						m.ctrl[g][s] = tombstone
 						m.dead++
@@ -725,11 +651,11 @@ func (m *LRUMap) RePut(l uint64, key []byte, value []byte) bool {
 					}
 
 					copy(m.kvHolder.data[m.kvHolder.tail:], value)
-					m.kvHolder.mutex.Lock()
+					m.kvHolder.slotLock(g).Lock()
 					m.groups[g][s] = kIdx(kOffset/storeUintBytes + vCap/storeUintBytes<<24)
 					m.sinces[g][s] = uint16(time.Since(m.startTime) / UnitTime)
 					StoreUint32(m.kvHolder.data[kEnd:], m.kvHolder.tail/storeUintBytes+(lv<<24))
-					m.kvHolder.mutex.Unlock()
+					m.kvHolder.slotLock(g).Unlock()
 
 					m.kvHolder.tail = ntail
 					m.kvHolder.valUsed += vCap
@@ -757,10 +683,10 @@ func (m *LRUMap) RePut(l uint64, key []byte, value []byte) bool {
 				vOffset := kEnd + 4
 				StoreUint32(m.kvHolder.data[vOffset:], lv)
 				copy(m.kvHolder.data[vOffset+4:], value)
-				m.kvHolder.mutex.Lock()
+				m.kvHolder.slotLock(g).Lock()
 				m.groups[g][s] = kIdx(m.kvHolder.tail/storeUintBytes + overLongStoreHeaderH + mapTypeHeader)
 				StoreUint32(m.kvHolder.data[kEnd:], vOffset/storeUintBytes+(overLongStoreHeaderL))
-				m.kvHolder.mutex.Unlock()
+				m.kvHolder.slotLock(g).Unlock()
 
 				m.kvHolder.items++
 				m.kvHolder.valUsed += vCap
@@ -787,10 +713,10 @@ func (m *LRUMap) RePut(l uint64, key []byte, value []byte) bool {
 				vOffset := kEnd + 4
 				copy(m.kvHolder.data[vOffset:], value)
 
-				m.kvHolder.mutex.Lock()
+				m.kvHolder.slotLock(g).Lock()
 				m.groups[g][s] = kIdx(m.kvHolder.tail/storeUintBytes + vBig<<24 + mapTypeHeader)
 				StoreUint32(m.kvHolder.data[kEnd:], vOffset/storeUintBytes+(vSmall<<24))
-				m.kvHolder.mutex.Unlock()
+				m.kvHolder.slotLock(g).Unlock()
 
 				m.kvHolder.items++
 				m.kvHolder.valUsed += vCap
@@ -816,10 +742,10 @@ func (m *LRUMap) RePut(l uint64, key []byte, value []byte) bool {
 				vOffset := kEnd + 4
 				copy(m.kvHolder.data[vOffset:], value)
 
-				m.kvHolder.mutex.Lock()
+				m.kvHolder.slotLock(g).Lock()
 				m.groups[g][s] = kIdx(m.kvHolder.tail/storeUintBytes + vCap/storeUintBytes<<24)
 				StoreUint32(m.kvHolder.data[kEnd:], vOffset/storeUintBytes+(vSmall<<24))
-				m.kvHolder.mutex.Unlock()
+				m.kvHolder.slotLock(g).Unlock()
 
 				m.kvHolder.items++
 				m.kvHolder.valUsed += vCap
@@ -928,6 +854,28 @@ func (m *LRUMap) MissCount() (count uint64) {
 	return m.missCnt.Load()
 }
 
+func (m *LRUMap) stats() ShardStats {
+	return ShardStats{
+		Items:     m.kvHolder.items,
+		UsedMem:   m.UsedMem(),
+		ItemsMem:  m.ItemsUsedMem(),
+		Queries:   m.queryCnt.Load(),
+		Misses:    m.missCnt.Load(),
+		Evictions: m.evictCnt.Load(),
+		Rehashes:  m.rehashCnt.Load(),
+		GCs:       m.gcCnt.Load(),
+	}
+}
+
+func (m *LRUMap) maintCount() uint64 {
+	return m.rehashCnt.Load() + m.gcCnt.Load()
+}
+
+func (m *LRUMap) ResetStats() {
+	m.queryCnt.Store(0)
+	m.missCnt.Store(0)
+}
+
 func (m *LRUMap) Count() int {
 	return int(m.resident - m.dead)
 }
@@ -946,6 +894,7 @@ func (m *LRUMap) nextSize() (n uint32) {
 }
 
 func (m *LRUMap) rehash() {
+	m.rehashCnt.Add(1)
 	n := m.nextSize()
 	groups := make([]group, n)
 	ctrl := make([]metadata, n)
@@ -1021,6 +970,9 @@ func (m *LRUMap) Eliminate() (delCount int, skipReason int) {
 		skipReason = skipReason3
 		return
 	}
+	if batch := m.owner.eliminateBatch; batch > 0 && n > batch {
+		n = batch
+	}
 
 	m.putLock.Lock()
 	var item []*Item[uint16]
@@ -1040,6 +992,7 @@ func (m *LRUMap) Eliminate() (delCount int, skipReason int) {
 		m.dead++
 		delCount++
 	}
+	m.evictCnt.Add(uint64(delCount))
 
 	m.putLock.Unlock()
 	return
@@ -1127,6 +1080,7 @@ func (m *LRUMap) GCCopy() (deadCount int, gcMem int, skipReason int) {
 	m.resident, m.dead = m.resident-m.dead, 0
 	m.rehashLock.Unlock()
 	m.putLock.Unlock()
+	m.gcCnt.Add(1)
 	m.rehashing = false
 	gcMem = int(oldUsed - m.kvHolder.tail)
 	return
@@ -105,7 +105,7 @@ func BuildMinTopCounter[V uint8 | uint16](ctrl []metadata, counters []counter, l
 				left--
 				continue
 			}
-			h.items[h.len] = &Item[V]{value: V(counters[g][i]), g: uint32(g), s: uint8(i)}
+			h.items[h.len] = &Item[V]{value: V(counters[g].load(uint32(i))), g: uint32(g), s: uint8(i)}
 			h.len++
 			left--
 			n := h.Len()
@@ -119,7 +119,7 @@ func BuildMinTopCounter[V uint8 | uint16](ctrl []metadata, counters []counter, l
 			if ctrl[g][s] == empty || ctrl[g][s] == tombstone {
 				continue
 			}
-			Push(h, &Item[V]{value: V(counters[g][s]), g: uint32(g), s: uint8(s)})
+			Push(h, &Item[V]{value: V(counters[g].load(uint32(s))), g: uint32(g), s: uint8(s)})
 		}
 	}
 	if h.len == 0 {
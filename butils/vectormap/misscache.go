@@ -0,0 +1,100 @@
+// Copyright 2019-2024 Xu Ruibo (hustxurb@163.com) and Contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vectormap
+
+import (
+	"sync"
+	"time"
+)
+
+// missCache remembers recently missed keys for a bounded time so repeated
+// lookups of absent keys skip the probe. Entries are evicted in insertion
+// order once the cache is full.
+type missCache struct {
+	mu    sync.RWMutex
+	ttl   int64
+	gen   uint64
+	items map[[16]byte]int64
+	ring  [][16]byte
+	pos   int
+}
+
+func newMissCache(size int, ttl time.Duration) *missCache {
+	return &missCache{
+		ttl:   int64(ttl),
+		items: make(map[[16]byte]int64, size),
+		ring:  make([][16]byte, 0, size),
+	}
+}
+
+func (c *missCache) generation() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.gen
+}
+
+func (c *missCache) has(key []byte) bool {
+	var k [16]byte
+	copy(k[:], key)
+	c.mu.RLock()
+	expire, ok := c.items[k]
+	c.mu.RUnlock()
+	return ok && time.Now().UnixNano() < expire
+}
+
+// add records a miss observed at generation gen. It is dropped if the key
+// space was written to since, so a concurrent put is never shadowed.
+func (c *missCache) add(key []byte, gen uint64) {
+	var k [16]byte
+	copy(k[:], key)
+	expire := time.Now().UnixNano() + c.ttl
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.gen {
+		return
+	}
+	if _, ok := c.items[k]; ok {
+		c.items[k] = expire
+		return
+	}
+	if len(c.ring) < cap(c.ring) {
+		c.ring = append(c.ring, k)
+	} else {
+		delete(c.items, c.ring[c.pos])
+		c.ring[c.pos] = k
+		c.pos = (c.pos + 1) % len(c.ring)
+	}
+	c.items[k] = expire
+}
+
+func (c *missCache) del(key []byte) {
+	var k [16]byte
+	copy(k[:], key)
+	c.mu.Lock()
+	c.gen++
+	delete(c.items, k)
+	c.mu.Unlock()
+}
+
+// reset drops every entry, used when the shard content is replaced as a whole.
+func (c *missCache) reset() {
+	c.mu.Lock()
+	c.gen++
+	c.items = make(map[[16]byte]int64, cap(c.ring))
+	c.ring = c.ring[:0]
+	c.pos = 0
+	c.mu.Unlock()
+}
@@ -0,0 +1,115 @@
+// Copyright 2019-2024 Xu Ruibo (hustxurb@163.com) and Contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vectormap
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newRehashTestMap(count int) *VectorMap {
+	m := NewVectorMap(uint32(count), WithSkipCheck(), WithBuckets(1), WithEliminate(64*MB, 0, time.Hour))
+	for i := 0; i < count; i++ {
+		m.RePut([]byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("value_%d", i)))
+	}
+	for i := 0; i < count; i += 7 {
+		m.Delete([]byte(fmt.Sprintf("key_%d", i)))
+	}
+	for i := 1; i < count; i += 3 {
+		for j := 0; j < i%5; j++ {
+			m.Has([]byte(fmt.Sprintf("key_%d", i)))
+		}
+	}
+	return m
+}
+
+func collectLFUEntries(m *LFUMap) map[string]string {
+	entries := make(map[string]string, m.Count())
+	for g := range m.ctrl {
+		for s := range m.ctrl[g] {
+			if c := m.ctrl[g][s]; c == empty || c == tombstone {
+				continue
+			}
+			k, v := m.kvHolder.getKVUnlock(m.groups[g][s])
+			entries[string(k)] = fmt.Sprintf("%s/%d", v, m.counters[g][s])
+		}
+	}
+	return entries
+}
+
+func TestLFUMap_ParallelRehash(t *testing.T) {
+	count := 50000
+	seq := newRehashTestMap(count)
+	defer seq.Close()
+	par := newRehashTestMap(count)
+	defer par.Close()
+
+	seqLFU := seq.shards[0].(*LFUMap)
+	parLFU := par.shards[0].(*LFUMap)
+	assert.Equal(t, collectLFUEntries(seqLFU), collectLFUEntries(parLFU))
+
+	for round := 0; round < 3; round++ {
+		seqLFU.putLock.Lock()
+		seqLFU.rehashWorkers(1)
+		seqLFU.putLock.Unlock()
+
+		parLFU.putLock.Lock()
+		parLFU.rehashWorkers(maxRehashWorkers)
+		parLFU.putLock.Unlock()
+
+		assert.Equal(t, len(seqLFU.groups), len(parLFU.groups))
+		assert.Equal(t, seqLFU.Count(), parLFU.Count())
+		assert.Equal(t, seqLFU.kvHolder.items, parLFU.kvHolder.items)
+		assert.Equal(t, collectLFUEntries(seqLFU), collectLFUEntries(parLFU))
+	}
+
+	for i := 0; i < count; i++ {
+		key := []byte(fmt.Sprintf("key_%d", i))
+		v, closer, ok := par.Get(key)
+		if i%7 == 0 {
+			assert.Equal(t, false, ok)
+		} else {
+			assert.Equal(t, true, ok)
+			assert.Equal(t, fmt.Sprintf("value_%d", i), string(v))
+		}
+		if closer != nil {
+			closer()
+		}
+	}
+}
+
+func BenchmarkLFUMap_Rehash(b *testing.B) {
+	for _, workers := range []int{1, maxRehashWorkers} {
+		b.Run(fmt.Sprintf("workers_%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				m := newRehashTestMap(200000)
+				lfu := m.shards[0].(*LFUMap)
+				b.StartTimer()
+
+				lfu.putLock.Lock()
+				lfu.rehashWorkers(workers)
+				lfu.putLock.Unlock()
+
+				b.StopTimer()
+				m.Close()
+				b.StartTimer()
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2019-2024 Xu Ruibo (hustxurb@163.com) and Contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vectormap
+
+import (
+	"math/bits"
+	"sync/atomic"
+)
+
+const (
+	sketchDepth      = 4
+	sketchMinWidth   = 64
+	sketchMaxCount   = 15
+	sketchSampleRate = 10
+)
+
+// cmSketch is a count-min sketch of recent key frequencies used as the
+// TinyLFU admission filter; counters are halved once every samples adds so it
+// ages. It sits on the Get and Has path, so it takes no lock: each row packs
+// four 8 bit counters in a uint32 updated with CAS, and the add that reaches
+// the sample size does the halving while others keep counting.
+type cmSketch struct {
+	rows    [sketchDepth][]uint32
+	mask    uint64
+	adds    atomic.Uint32
+	samples uint32
+}
+
+func newCMSketch(n uint32) *cmSketch {
+	width := uint32(sketchMinWidth)
+	if n > width {
+		width = 1 << bits.Len32(n-1)
+	}
+	s := &cmSketch{
+		mask:    uint64(width - 1),
+		samples: width * sketchSampleRate,
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint32, width/4)
+	}
+	return s
+}
+
+//go:inline
+func (s *cmSketch) index(l uint64, i int) uint64 {
+	return (l + uint64(i)*(l>>32|1)) & s.mask
+}
+
+func (s *cmSketch) increment(l uint64) {
+	for i := range s.rows {
+		idx := s.index(l, i)
+		p, shift := &s.rows[i][idx>>2], (idx&3)*8
+		for {
+			w := atomic.LoadUint32(p)
+			if (w>>shift)&0xff >= sketchMaxCount || atomic.CompareAndSwapUint32(p, w, w+1<<shift) {
+				break
+			}
+		}
+	}
+	if s.adds.Add(1) == s.samples {
+		s.halve()
+	}
+}
+
+func (s *cmSketch) estimate(l uint64) uint8 {
+	min := uint8(sketchMaxCount)
+	for i := range s.rows {
+		idx := s.index(l, i)
+		if c := uint8(atomic.LoadUint32(&s.rows[i][idx>>2]) >> ((idx & 3) * 8)); c < min {
+			min = c
+		}
+	}
+	return min
+}
+
+// halve ages every counter. Increments racing with it are kept, either before
+// or after the halving of their word.
+func (s *cmSketch) halve() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			p := &s.rows[i][j]
+			for {
+				w := atomic.LoadUint32(p)
+				if atomic.CompareAndSwapUint32(p, w, (w>>1)&0x7f7f7f7f) {
+					break
+				}
+			}
+		}
+	}
+	s.adds.Store(0)
+}
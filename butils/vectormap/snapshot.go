@@ -0,0 +1,307 @@
+// Copyright 2019-2024 Xu Ruibo (hustxurb@163.com) and Contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vectormap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/zuoyebang/bitalostored/butils/md5hash"
+)
+
+const (
+	snapshotVersion     uint32 = 1
+	snapshotHeaderSize         = 16
+	snapshotEntryHead          = 1 + 16 + 4
+	snapshotMinItemSize        = 16 + 4
+
+	deltaVersion    uint32 = 1
+	deltaHeaderSize        = 4 + 8 + 4 + 4
+)
+
+var (
+	ErrSnapshotVersion  = errors.New("vectormap: unsupported snapshot version")
+	ErrSnapshotOverflow = errors.New("vectormap: snapshot exceeds shard memory")
+	ErrDeltaDisabled    = errors.New("vectormap: delta snapshot disabled")
+	ErrDeltaStale       = errors.New("vectormap: delta snapshot version is stale")
+)
+
+// snapshot layout (big endian):
+// header: version(4) | items(4) | createdAt unix nano(8)
+// entry:  counter(1) | key(16) | vlen(4) | value(vlen)
+
+// WriteSnapshot copies the shard under putLock and writes the copy to w
+// without holding it, so a slow writer does not block puts on the shard.
+func (m *LFUMap) WriteSnapshot(w io.Writer) error {
+	m.putLock.Lock()
+	var items uint32
+	size := snapshotHeaderSize
+	for g := range m.ctrl {
+		for s := range m.ctrl[g] {
+			if c := m.ctrl[g][s]; c != empty && c != tombstone {
+				_, v := m.kvHolder.getKVUnlock(m.groups[g][s])
+				size += snapshotEntryHead + len(v)
+				items++
+			}
+		}
+	}
+
+	buf := make([]byte, snapshotHeaderSize, size)
+	binary.BigEndian.PutUint32(buf[0:], snapshotVersion)
+	binary.BigEndian.PutUint32(buf[4:], items)
+	binary.BigEndian.PutUint64(buf[8:], uint64(time.Now().UnixNano()))
+	for g := range m.ctrl {
+		for s := range m.ctrl[g] {
+			if c := m.ctrl[g][s]; c == empty || c == tombstone {
+				continue
+			}
+			k, v := m.kvHolder.getKVUnlock(m.groups[g][s])
+			buf = appendSnapshotEntry(buf, m.counters[g].load(uint32(s)), k, v)
+		}
+	}
+	m.putLock.Unlock()
+
+	_, err := w.Write(buf)
+	return err
+}
+
+func appendSnapshotEntry(buf []byte, count uint8, k, v []byte) []byte {
+	buf = append(buf, count)
+	buf = append(buf, k[:16]...)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(v)))
+	return append(buf, v...)
+}
+
+// ReadSnapshotHeader reads only the header written by WriteSnapshot. The
+// version is returned as is, callers check it against what they can load.
+func ReadSnapshotHeader(r io.Reader) (version uint32, itemCount uint32, createdAt time.Time, err error) {
+	var header [snapshotHeaderSize]byte
+	if _, err = io.ReadFull(r, header[:]); err != nil {
+		return
+	}
+	version = binary.BigEndian.Uint32(header[0:])
+	itemCount = binary.BigEndian.Uint32(header[4:])
+	createdAt = time.Unix(0, int64(binary.BigEndian.Uint64(header[8:])))
+	return
+}
+
+func (m *LFUMap) LoadSnapshot(r io.Reader) error {
+	br := bufio.NewReader(r)
+	version, items, _, err := ReadSnapshotHeader(br)
+	if err != nil {
+		return err
+	}
+	if version != snapshotVersion {
+		return fmt.Errorf("%w: %d", ErrSnapshotVersion, version)
+	}
+
+	// an item takes at least its key and value header in the holder, more
+	// items than that can not be loaded and would size the groups from a
+	// corrupted header
+	if uint64(items)*snapshotMinItemSize > uint64(m.kvHolder.cap) {
+		return ErrSnapshotOverflow
+	}
+
+	n := numGroups(items)
+	if cur := uint32(len(m.groups)); n < cur {
+		n = cur
+	}
+	groups := make([]group, n)
+	ctrl := make([]metadata, n)
+	counters := make([]counter, n)
+	kvholder := newKVHolder(Byte(m.kvHolder.cap))
+	for i := range ctrl {
+		ctrl[i] = newEmptyMetadata()
+	}
+
+	var entry [snapshotEntryHead]byte
+	var value []byte
+	for i := uint32(0); i < items; i++ {
+		if _, err := io.ReadFull(br, entry[:]); err != nil {
+			kvholder.buffer.release()
+			return err
+		}
+		vlen := binary.BigEndian.Uint32(entry[17:])
+		if vlen >= limitSize {
+			kvholder.buffer.release()
+			return ErrSnapshotOverflow
+		}
+		if uint32(cap(value)) < vlen {
+			value = make([]byte, vlen)
+		}
+		value = value[:vlen]
+		if _, err := io.ReadFull(br, value); err != nil {
+			kvholder.buffer.release()
+			return err
+		}
+
+		k := entry[1:17]
+		_, l := md5hash.MD5HL(k)
+		hi, lo := splitHash(l)
+		gN := probeStart(hi, len(groups))
+		for {
+			matches := metaMatchEmpty(&ctrl[gN])
+			if matches != 0 {
+				sN := nextMatch(&matches)
+				ki, fail := kvholder.gcSet(k, value)
+				if fail {
+					kvholder.buffer.release()
+					return ErrSnapshotOverflow
+				}
+				groups[gN][sN] = ki
+				ctrl[gN][sN] = int8(lo)
+				counters[gN][sN] = entry[0]
+				break
+			}
+			gN++
+			if gN >= uint32(len(groups)) {
+				gN = 0
+			}
+		}
+	}
+
+	m.putLock.Lock()
+	m.replaceState(groups, ctrl, counters, kvholder, items)
+	if m.delta != nil {
+		m.delta.reset()
+	}
+	m.putLock.Unlock()
+	return nil
+}
+
+// delta layout (big endian):
+// header:  version(4) | upto(8) | upserts(4) | deletes(4)
+// upserts: snapshot entries
+// deletes: key(16)
+
+// DeltaVersion returns the current write version of the shard. Read it before
+// WriteSnapshot to get the base version for WriteSnapshotSince.
+func (m *LFUMap) DeltaVersion() uint64 {
+	if m.delta == nil {
+		return 0
+	}
+	return m.delta.current()
+}
+
+// WriteSnapshotSince writes the keys changed after version: live keys with
+// their values and deleted or evicted keys as tombstones. The header carries
+// the version the delta is current up to, use it as the next base version.
+func (m *LFUMap) WriteSnapshotSince(w io.Writer, version uint64) error {
+	if m.delta == nil {
+		return ErrDeltaDisabled
+	}
+
+	m.putLock.Lock()
+	keys, upto, ok := m.delta.since(version)
+	if !ok {
+		m.putLock.Unlock()
+		return fmt.Errorf("%w: %d", ErrDeltaStale, version)
+	}
+	buf := make([]byte, deltaHeaderSize)
+	var upserts uint32
+	var deletes [][16]byte
+	for i := range keys {
+		_, l := md5hash.MD5HL(keys[i][:])
+		if g, s, found := m.find(l, keys[i][:]); found {
+			k, v := m.kvHolder.getKVUnlock(m.groups[g][s])
+			buf = appendSnapshotEntry(buf, 0, k, v)
+			upserts++
+		} else {
+			deletes = append(deletes, keys[i])
+		}
+	}
+	m.putLock.Unlock()
+
+	binary.BigEndian.PutUint32(buf[0:], deltaVersion)
+	binary.BigEndian.PutUint64(buf[4:], upto)
+	binary.BigEndian.PutUint32(buf[12:], upserts)
+	binary.BigEndian.PutUint32(buf[16:], uint32(len(deletes)))
+	for i := range deletes {
+		buf = append(buf, deletes[i][:]...)
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// ApplySnapshotDelta applies a delta written by WriteSnapshotSince and
+// returns the version it is current up to and the number of entries applied.
+// Nothing is applied if the delta can not be read completely. Entries are
+// applied in place, if the shard runs out of memory midway the first applied
+// entries stay and ErrSnapshotOverflow is returned, the shard is then neither
+// at the base nor at the delta version and should be reloaded from a full
+// snapshot.
+func (m *LFUMap) ApplySnapshotDelta(r io.Reader) (upto uint64, applied int, err error) {
+	br := bufio.NewReader(r)
+	var header [deltaHeaderSize]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		return 0, 0, err
+	}
+	if version := binary.BigEndian.Uint32(header[0:]); version != deltaVersion {
+		return 0, 0, fmt.Errorf("%w: %d", ErrSnapshotVersion, version)
+	}
+	upto = binary.BigEndian.Uint64(header[4:])
+	upserts := binary.BigEndian.Uint32(header[12:])
+	deletes := binary.BigEndian.Uint32(header[16:])
+
+	if uint64(upserts)*snapshotMinItemSize > uint64(m.kvHolder.cap) {
+		return 0, 0, ErrSnapshotOverflow
+	}
+
+	keys := make([][16]byte, upserts)
+	values := make([][]byte, upserts)
+	var entry [snapshotEntryHead]byte
+	for i := uint32(0); i < upserts; i++ {
+		if _, err := io.ReadFull(br, entry[:]); err != nil {
+			return 0, 0, err
+		}
+		vlen := binary.BigEndian.Uint32(entry[17:])
+		if vlen >= limitSize {
+			return 0, 0, ErrSnapshotOverflow
+		}
+		copy(keys[i][:], entry[1:17])
+		values[i] = make([]byte, vlen)
+		if _, err := io.ReadFull(br, values[i]); err != nil {
+			return 0, 0, err
+		}
+	}
+	// deletes are not bounded by the shard memory, grow with what is read
+	// instead of trusting the header
+	for i := uint32(0); i < deletes; i++ {
+		var k [16]byte
+		if _, err := io.ReadFull(br, k[:]); err != nil {
+			return 0, 0, err
+		}
+		keys = append(keys, k)
+	}
+
+	if m.missCache != nil {
+		m.missCache.reset()
+	}
+	for i := range keys {
+		_, l := md5hash.MD5HL(keys[i][:])
+		if i < len(values) {
+			if !m.RePut(l, keys[i][:], values[i]) {
+				return 0, i, ErrSnapshotOverflow
+			}
+		} else {
+			m.Delete(l, keys[i][:])
+		}
+	}
+	return upto, len(keys), nil
+}
@@ -0,0 +1,312 @@
+// Copyright 2019-2024 Xu Ruibo (hustxurb@163.com) and Contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vectormap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newSnapshotTestMap(count int, prefix string) *VectorMap {
+	m := NewVectorMap(uint32(count), WithSkipCheck(), WithBuckets(1), WithEliminate(4*MB, 0, 100*time.Millisecond))
+	for i := 0; i < count; i++ {
+		m.RePut([]byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("%s_%d", prefix, i)))
+	}
+	return m
+}
+
+func TestLFUMap_Snapshot(t *testing.T) {
+	count := 1000
+	src := newSnapshotTestMap(count, "value")
+	defer src.Close()
+
+	var buf bytes.Buffer
+	assert.NoError(t, src.shards[0].(*LFUMap).WriteSnapshot(&buf))
+
+	dst := NewVectorMap(16, WithSkipCheck(), WithBuckets(1), WithEliminate(4*MB, 0, 100*time.Millisecond))
+	defer dst.Close()
+	dst.RePut([]byte("stale"), []byte("stale"))
+	assert.NoError(t, dst.shards[0].(*LFUMap).LoadSnapshot(bytes.NewReader(buf.Bytes())))
+
+	assert.Equal(t, count, dst.Count())
+	assert.Equal(t, false, dst.Has([]byte("stale")))
+	for i := 0; i < count; i++ {
+		v, closer, ok := dst.Get([]byte(fmt.Sprintf("key_%d", i)))
+		assert.Equal(t, true, ok)
+		assert.Equal(t, fmt.Sprintf("value_%d", i), string(v))
+		if closer != nil {
+			closer()
+		}
+	}
+
+	broken := buf.Bytes()[:buf.Len()-1]
+	assert.Error(t, dst.shards[0].(*LFUMap).LoadSnapshot(bytes.NewReader(broken)))
+	assert.Equal(t, count, dst.Count())
+
+	huge := append([]byte{}, buf.Bytes()...)
+	huge[4], huge[5], huge[6], huge[7] = 0xff, 0xff, 0xff, 0xff
+	err := dst.shards[0].(*LFUMap).LoadSnapshot(bytes.NewReader(huge))
+	assert.True(t, errors.Is(err, ErrSnapshotOverflow), err)
+	assert.Equal(t, count, dst.Count())
+}
+
+type blockingWriter struct {
+	started chan struct{}
+	release chan struct{}
+	buf     bytes.Buffer
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	close(w.started)
+	<-w.release
+	return w.buf.Write(p)
+}
+
+func TestLFUMap_SnapshotWriteUnlocked(t *testing.T) {
+	m := newSnapshotTestMap(100, "value")
+	defer m.Close()
+
+	w := &blockingWriter{started: make(chan struct{}), release: make(chan struct{})}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- m.shards[0].(*LFUMap).WriteSnapshot(w)
+	}()
+	<-w.started
+
+	put := make(chan bool, 1)
+	go func() {
+		put <- m.RePut([]byte("key_new"), []byte("value_new"))
+	}()
+	select {
+	case ok := <-put:
+		assert.True(t, ok)
+	case <-time.After(5 * time.Second):
+		t.Fatal("put blocked by snapshot writer")
+	}
+	close(w.release)
+	assert.NoError(t, <-errCh)
+
+	dst := NewVectorMap(16, WithSkipCheck(), WithBuckets(1), WithEliminate(4*MB, 0, 100*time.Millisecond))
+	defer dst.Close()
+	assert.NoError(t, dst.shards[0].(*LFUMap).LoadSnapshot(bytes.NewReader(w.buf.Bytes())))
+	assert.Equal(t, 100, dst.Count())
+	assert.False(t, dst.Has([]byte("key_new")))
+}
+
+func TestLFUMap_SnapshotMissCache(t *testing.T) {
+	src := newSnapshotTestMap(10, "value")
+	defer src.Close()
+	var buf bytes.Buffer
+	assert.NoError(t, src.shards[0].(*LFUMap).WriteSnapshot(&buf))
+
+	dst := NewVectorMap(16, WithSkipCheck(), WithBuckets(1), WithMissCache(16, time.Hour))
+	defer dst.Close()
+	lm := dst.shards[0].(*LFUMap)
+	key := []byte("key_1")
+	for i := 0; i < 2; i++ {
+		_, _, ok := dst.Get(key)
+		assert.False(t, ok)
+	}
+	assert.Equal(t, uint64(1), dst.MissCacheHits())
+
+	assert.NoError(t, lm.LoadSnapshot(bytes.NewReader(buf.Bytes())))
+	v, closer, ok := dst.Get(key)
+	assert.True(t, ok)
+	assert.Equal(t, "value_1", string(v))
+	closer()
+
+	dst.Clear()
+	for i := 0; i < 2; i++ {
+		_, _, ok = dst.Get(key)
+		assert.False(t, ok)
+	}
+	assert.NoError(t, lm.LoadSnapshot(bytes.NewReader(buf.Bytes())))
+	_, closer, ok = dst.Get(key)
+	assert.True(t, ok)
+	closer()
+}
+
+func TestReadSnapshotHeader(t *testing.T) {
+	count := 100
+	m := newSnapshotTestMap(count, "value")
+	defer m.Close()
+
+	before := time.Now()
+	var buf bytes.Buffer
+	assert.NoError(t, m.shards[0].(*LFUMap).WriteSnapshot(&buf))
+
+	r := bytes.NewReader(buf.Bytes())
+	version, items, createdAt, err := ReadSnapshotHeader(r)
+	assert.NoError(t, err)
+	assert.Equal(t, snapshotVersion, version)
+	assert.Equal(t, uint32(count), items)
+	assert.False(t, createdAt.Before(before))
+	assert.False(t, createdAt.After(time.Now()))
+	assert.Equal(t, buf.Len()-snapshotHeaderSize, r.Len())
+
+	_, _, _, err = ReadSnapshotHeader(bytes.NewReader(buf.Bytes()[:snapshotHeaderSize-1]))
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestLFUMap_SnapshotConcurrentRead(t *testing.T) {
+	count := 1000
+	snapshots := make([][]byte, 2)
+	for i, prefix := range []string{"old", "new"} {
+		m := newSnapshotTestMap(count, prefix)
+		var buf bytes.Buffer
+		assert.NoError(t, m.shards[0].(*LFUMap).WriteSnapshot(&buf))
+		snapshots[i] = buf.Bytes()
+		m.Close()
+	}
+
+	m := newSnapshotTestMap(count, "old")
+	defer m.Close()
+	lfu := m.shards[0].(*LFUMap)
+
+	var stop atomic.Bool
+	var wg sync.WaitGroup
+	var bad atomic.Int64
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for j := r; !stop.Load(); j++ {
+				i := j % count
+				v, closer, ok := m.Get([]byte(fmt.Sprintf("key_%d", i)))
+				if !ok || (string(v) != fmt.Sprintf("old_%d", i) && string(v) != fmt.Sprintf("new_%d", i)) {
+					bad.Add(1)
+				}
+				if closer != nil {
+					closer()
+				}
+			}
+		}(r)
+	}
+
+	for i := 0; i < 50; i++ {
+		assert.NoError(t, lfu.LoadSnapshot(bytes.NewReader(snapshots[i%2])))
+	}
+	stop.Store(true)
+	wg.Wait()
+
+	assert.Equal(t, int64(0), bad.Load())
+	assert.Equal(t, count, m.Count())
+}
+
+func TestLFUMap_SnapshotDelta(t *testing.T) {
+	newMap := func(maxChanges int) *VectorMap {
+		return NewVectorMap(64, WithSkipCheck(), WithBuckets(1), WithDeltaSnapshot(maxChanges), WithEliminate(4*MB, 0, 100*time.Millisecond))
+	}
+	assertEqualMaps := func(src, dst *VectorMap, keys int) {
+		assert.Equal(t, src.Count(), dst.Count())
+		for i := 0; i < keys; i++ {
+			key := []byte(fmt.Sprintf("key_%d", i))
+			sv, sc, sok := src.Get(key)
+			dv, dc, dok := dst.Get(key)
+			assert.Equal(t, sok, dok, string(key))
+			assert.Equal(t, string(sv), string(dv), string(key))
+			if sc != nil {
+				sc()
+			}
+			if dc != nil {
+				dc()
+			}
+		}
+	}
+
+	src := newMap(1024)
+	defer src.Close()
+	lfu := src.shards[0].(*LFUMap)
+	for i := 0; i < 500; i++ {
+		src.RePut([]byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("base_%d", i)))
+	}
+
+	base := lfu.DeltaVersion()
+	var full bytes.Buffer
+	assert.NoError(t, lfu.WriteSnapshot(&full))
+
+	for i := 0; i < 100; i++ {
+		src.RePut([]byte(fmt.Sprintf("key_%d", i)), bytes.Repeat([]byte{byte(i)}, i%16+1))
+		src.Delete([]byte(fmt.Sprintf("key_%d", 100+i)))
+		src.RePut([]byte(fmt.Sprintf("key_%d", 500+i)), []byte(fmt.Sprintf("new_%d", i)))
+	}
+	src.Delete([]byte("key_missing"))
+
+	var delta bytes.Buffer
+	assert.NoError(t, lfu.WriteSnapshotSince(&delta, base))
+	assert.True(t, delta.Len() < full.Len())
+
+	dst := newMap(1024)
+	defer dst.Close()
+	dlfu := dst.shards[0].(*LFUMap)
+	assert.NoError(t, dlfu.LoadSnapshot(bytes.NewReader(full.Bytes())))
+	upto, applied, err := dlfu.ApplySnapshotDelta(bytes.NewReader(delta.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, 301, applied)
+	assert.Equal(t, lfu.DeltaVersion(), upto)
+	assertEqualMaps(src, dst, 600)
+
+	src.RePut([]byte("key_0"), []byte("again"))
+	src.Delete([]byte("key_1"))
+	delta.Reset()
+	assert.NoError(t, lfu.WriteSnapshotSince(&delta, upto))
+	upto, applied, err = dlfu.ApplySnapshotDelta(bytes.NewReader(delta.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, applied)
+	assert.Equal(t, lfu.DeltaVersion(), upto)
+	assertEqualMaps(src, dst, 600)
+
+	_, applied, err = dlfu.ApplySnapshotDelta(bytes.NewReader(delta.Bytes()[:delta.Len()-1]))
+	assert.Error(t, err)
+	assert.Equal(t, 0, applied)
+
+	big := newMap(1024)
+	defer big.Close()
+	blfu := big.shards[0].(*LFUMap)
+	bigBase := blfu.DeltaVersion()
+	for i := 0; i < 64; i++ {
+		big.RePut([]byte(fmt.Sprintf("key_%d", i)), bytes.Repeat([]byte{byte(i)}, 32<<10))
+	}
+	delta.Reset()
+	assert.NoError(t, blfu.WriteSnapshotSince(&delta, bigBase))
+	tiny := NewVectorMap(64, WithSkipCheck(), WithBuckets(1), WithDeltaSnapshot(1024), WithEliminate(1*MB, 0, time.Hour))
+	defer tiny.Close()
+	_, applied, err = tiny.shards[0].(*LFUMap).ApplySnapshotDelta(bytes.NewReader(delta.Bytes()))
+	assert.True(t, errors.Is(err, ErrSnapshotOverflow), err)
+	assert.True(t, applied > 0 && applied < 64, applied)
+	assert.Equal(t, applied, int(tiny.Count()))
+
+	small := newMap(8)
+	defer small.Close()
+	slfu := small.shards[0].(*LFUMap)
+	for i := 0; i < 100; i++ {
+		small.RePut([]byte(fmt.Sprintf("key_%d", i)), []byte("v"))
+	}
+	err = slfu.WriteSnapshotSince(&delta, 0)
+	assert.True(t, errors.Is(err, ErrDeltaStale), err)
+	assert.NoError(t, slfu.WriteSnapshotSince(io.Discard, slfu.DeltaVersion()-8))
+
+	plain := newSnapshotTestMap(10, "v")
+	defer plain.Close()
+	assert.Equal(t, ErrDeltaDisabled, plain.shards[0].(*LFUMap).WriteSnapshotSince(&delta, 0))
+}
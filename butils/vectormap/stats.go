@@ -0,0 +1,226 @@
+// Copyright 2019-2024 Xu Ruibo (hustxurb@163.com) and Contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vectormap
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/zuoyebang/bitalostored/butils/md5hash"
+)
+
+var ErrMetricPrefix = errors.New("vectormap: invalid metric prefix")
+
+type ShardStats struct {
+	Items     uint32
+	UsedMem   Byte
+	ItemsMem  Byte
+	Queries   uint64
+	Misses    uint64
+	Evictions uint64
+	Rehashes  uint64
+	GCs       uint64
+}
+
+func (s ShardStats) Hits() uint64 {
+	if s.Misses > s.Queries {
+		return 0
+	}
+	return s.Queries - s.Misses
+}
+
+func (s ShardStats) HitRatio() float64 {
+	if s.Queries == 0 {
+		return 0
+	}
+	return float64(s.Hits()) / float64(s.Queries)
+}
+
+// Fragmentation is the share of used memory not held by live items.
+func (s ShardStats) Fragmentation() float64 {
+	if s.UsedMem == 0 || s.ItemsMem >= s.UsedMem {
+		return 0
+	}
+	return 1 - float64(s.ItemsMem)/float64(s.UsedMem)
+}
+
+func (vm *VectorMap) Stats() []ShardStats {
+	stats := make([]ShardStats, len(vm.shards))
+	for i, m := range vm.shards {
+		stats[i] = m.stats()
+	}
+	return stats
+}
+
+// MaintenanceCount is the total number of rehashes and GC copies run by all
+// shards. Callers diff two readings to detect maintenance in between.
+func (vm *VectorMap) MaintenanceCount() (n uint64) {
+	for _, m := range vm.shards {
+		n += m.maintCount()
+	}
+	return
+}
+
+// KeyMaintenanceCount is the number of rehashes and GC copies run by the shard
+// holding k, so maintenance elsewhere does not show up in the diff.
+func (vm *VectorMap) KeyMaintenanceCount(k []byte) uint64 {
+	var h [16]byte
+	hi, _ := md5hash.MD5Sum(k, h[:])
+	return vm.slotAt(hi).maintCount()
+}
+
+// ValueClassBuckets returns the boundaries of the inline, overshort and
+// overlong value encodings.
+func ValueClassBuckets() []uint32 {
+	return []uint32{overShortSize, overLongSize}
+}
+
+// ValueSizeHistogram bins the value sizes of all live entries by the
+// ascending boundaries in buckets. hist[i] counts sizes in
+// [buckets[i-1], buckets[i]), the last bin counts sizes >= buckets[len-1].
+func (vm *VectorMap) ValueSizeHistogram(buckets []uint32) []int {
+	hist := make([]int, len(buckets)+1)
+	for _, m := range vm.shards {
+		for i, n := range m.ValueSizeHistogram(buckets) {
+			hist[i] += n
+		}
+	}
+	return hist
+}
+
+func valueSizeHistogram(hdr *kvHolder, ctrl []metadata, groups []group, buckets []uint32) []int {
+	hist := make([]int, len(buckets)+1)
+	hdr.rlockAll()
+	defer hdr.runlockAll()
+	for g := range ctrl {
+		for s, c := range ctrl[g] {
+			if c == empty || c == tombstone || groups[g][s] == 0 {
+				continue
+			}
+			_, vSize, err := hdr.valueEncoding(groups[g][s])
+			if err != nil {
+				continue
+			}
+			hist[sort.Search(len(buckets), func(i int) bool { return vSize < buckets[i] })]++
+		}
+	}
+	return hist
+}
+
+type promMetric struct {
+	name  string
+	typ   string
+	help  string
+	value func(s *ShardStats, b []byte) []byte
+}
+
+var promMetrics = []promMetric{
+	{"items", "gauge", "Live items in the shard.", func(s *ShardStats, b []byte) []byte {
+		return strconv.AppendUint(b, uint64(s.Items), 10)
+	}},
+	{"used_bytes", "gauge", "Bytes allocated from the shard arena.", func(s *ShardStats, b []byte) []byte {
+		return strconv.AppendUint(b, uint64(s.UsedMem), 10)
+	}},
+	{"items_bytes", "gauge", "Bytes held by live items.", func(s *ShardStats, b []byte) []byte {
+		return strconv.AppendUint(b, uint64(s.ItemsMem), 10)
+	}},
+	{"hits_total", "counter", "Lookups that found the key.", func(s *ShardStats, b []byte) []byte {
+		return strconv.AppendUint(b, s.Hits(), 10)
+	}},
+	{"misses_total", "counter", "Lookups that missed the key.", func(s *ShardStats, b []byte) []byte {
+		return strconv.AppendUint(b, s.Misses, 10)
+	}},
+	{"hit_ratio", "gauge", "Hits divided by lookups.", func(s *ShardStats, b []byte) []byte {
+		return strconv.AppendFloat(b, s.HitRatio(), 'g', -1, 64)
+	}},
+	{"evictions_total", "counter", "Items removed by elimination.", func(s *ShardStats, b []byte) []byte {
+		return strconv.AppendUint(b, s.Evictions, 10)
+	}},
+	{"rehashes_total", "counter", "Table grow rehashes.", func(s *ShardStats, b []byte) []byte {
+		return strconv.AppendUint(b, s.Rehashes, 10)
+	}},
+	{"gc_copies_total", "counter", "Arena compactions by GC copy.", func(s *ShardStats, b []byte) []byte {
+		return strconv.AppendUint(b, s.GCs, 10)
+	}},
+	{"fragmentation_ratio", "gauge", "Share of used bytes not held by live items.", func(s *ShardStats, b []byte) []byte {
+		return strconv.AppendFloat(b, s.Fragmentation(), 'g', -1, 64)
+	}},
+}
+
+func validMetricPrefix(prefix string) bool {
+	if prefix == "" {
+		return false
+	}
+	for i := 0; i < len(prefix); i++ {
+		c := prefix[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_', c == ':':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// WritePrometheus writes the per-shard cache metrics in the Prometheus text
+// exposition format, one family per metric labelled by shard.
+func (vm *VectorMap) WritePrometheus(w io.Writer, prefix string) error {
+	if !validMetricPrefix(prefix) {
+		return ErrMetricPrefix
+	}
+
+	stats := vm.Stats()
+	buf := make([]byte, 0, 4096)
+	for _, pm := range promMetrics {
+		buf = append(buf, "# HELP "...)
+		buf = append(buf, prefix...)
+		buf = append(buf, '_')
+		buf = append(buf, pm.name...)
+		buf = append(buf, ' ')
+		buf = append(buf, pm.help...)
+		buf = append(buf, "\n# TYPE "...)
+		buf = append(buf, prefix...)
+		buf = append(buf, '_')
+		buf = append(buf, pm.name...)
+		buf = append(buf, ' ')
+		buf = append(buf, pm.typ...)
+		buf = append(buf, '\n')
+		for i := range stats {
+			buf = append(buf, prefix...)
+			buf = append(buf, '_')
+			buf = append(buf, pm.name...)
+			buf = append(buf, `{shard="`...)
+			buf = strconv.AppendInt(buf, int64(i), 10)
+			buf = append(buf, `"} `...)
+			buf = pm.value(&stats[i], buf)
+			buf = append(buf, '\n')
+			if len(buf) >= 3072 {
+				if _, err := w.Write(buf); err != nil {
+					return err
+				}
+				buf = buf[:0]
+			}
+		}
+	}
+	if len(buf) > 0 {
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+// Copyright 2019-2024 Xu Ruibo (hustxurb@163.com) and Contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vectormap
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zuoyebang/bitalostored/butils/md5hash"
+)
+
+var (
+	promCommentRe = regexp.MustCompile(`^# (HELP|TYPE) ([a-zA-Z_:][a-zA-Z0-9_:]*) (.+)$`)
+	promSampleRe  = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)\{shard="(\d+)"\} (\S+)$`)
+)
+
+func TestVectorMap_WritePrometheus(t *testing.T) {
+	for _, mtype := range []MapType{MapTypeLFU, MapTypeLRU} {
+		m := NewVectorMap(1024, WithSkipCheck(), WithType(mtype), WithBuckets(4), WithEliminate(1*MB, 0, time.Second))
+		for i := 0; i < 100; i++ {
+			m.RePut([]byte(fmt.Sprintf("key_%d", i)), []byte("value"))
+		}
+		for i := 0; i < 150; i++ {
+			if _, closer, ok := m.Get([]byte(fmt.Sprintf("key_%d", i))); ok && closer != nil {
+				closer()
+			}
+		}
+
+		var buf bytes.Buffer
+		assert.Equal(t, ErrMetricPrefix, m.WritePrometheus(&buf, "1bad"))
+		assert.Equal(t, ErrMetricPrefix, m.WritePrometheus(&buf, ""))
+		assert.NoError(t, m.WritePrometheus(&buf, "vm_cache"))
+
+		types := make(map[string]string)
+		samples := make(map[string]map[string]float64)
+		for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+			if strings.HasPrefix(line, "#") {
+				sub := promCommentRe.FindStringSubmatch(line)
+				assert.NotNil(t, sub, line)
+				if sub != nil && sub[1] == "TYPE" {
+					assert.Contains(t, []string{"gauge", "counter"}, sub[3])
+					types[sub[2]] = sub[3]
+				}
+				continue
+			}
+			sub := promSampleRe.FindStringSubmatch(line)
+			if !assert.NotNil(t, sub, line) {
+				continue
+			}
+			_, ok := types[sub[1]]
+			assert.True(t, ok, "sample before TYPE: %s", line)
+			v, err := strconv.ParseFloat(sub[3], 64)
+			assert.NoError(t, err, line)
+			if samples[sub[1]] == nil {
+				samples[sub[1]] = make(map[string]float64)
+			}
+			samples[sub[1]][sub[2]] = v
+		}
+
+		for _, name := range []string{"items", "used_bytes", "items_bytes", "hits_total", "misses_total",
+			"hit_ratio", "evictions_total", "rehashes_total", "fragmentation_ratio"} {
+			assert.Len(t, samples["vm_cache_"+name], 4, name)
+		}
+
+		sum := func(name string) (total float64) {
+			for _, v := range samples["vm_cache_"+name] {
+				total += v
+			}
+			return
+		}
+		assert.Equal(t, float64(100), sum("items"))
+		assert.Equal(t, float64(100), sum("hits_total"))
+		assert.Equal(t, float64(50), sum("misses_total"))
+		m.Close()
+	}
+}
+
+func TestVectorMap_ValueSizeHistogram(t *testing.T) {
+	sizes := map[int]int{0: 2, 15: 3, 16: 1, 127: 4, 128: 2, 1000: 3, int(overLongSize) - 1: 1, int(overLongSize): 2, 40000: 1}
+	for _, mtype := range []MapType{MapTypeLFU, MapTypeLRU} {
+		m := NewVectorMap(1024, WithSkipCheck(), WithType(mtype), WithBuckets(4), WithEliminate(16*MB, 0, time.Second))
+		for n, cnt := range sizes {
+			for i := 0; i < cnt; i++ {
+				assert.True(t, m.RePut([]byte(fmt.Sprintf("hist_%d_%d", n, i)), make([]byte, n)))
+			}
+		}
+		m.RePut([]byte("hist_deleted"), make([]byte, 10))
+		m.Delete([]byte("hist_deleted"))
+
+		assert.Equal(t, []int{5, 5, 6, 3}, m.ValueSizeHistogram([]uint32{16, 128, 32767}))
+		assert.Equal(t, []int{10, 6, 3}, m.ValueSizeHistogram(ValueClassBuckets()))
+		assert.Equal(t, []int{19}, m.ValueSizeHistogram(nil))
+		m.Close()
+	}
+}
+
+func TestVectorMap_MaintenanceCount(t *testing.T) {
+	for _, mtype := range []MapType{MapTypeLFU, MapTypeLRU} {
+		m := NewVectorMap(4, WithSkipCheck(), WithType(mtype), WithBuckets(1), WithEliminate(3*KB, 0, time.Second))
+		assert.Equal(t, uint64(0), m.MaintenanceCount())
+
+		m.RePut([]byte("a"), []byte("b"))
+		m.RePut([]byte("c"), make([]byte, 1024))
+		m.Delete([]byte("c"))
+		_, _, skip := m.shards[0].GCCopy()
+		assert.Equal(t, 0, skip)
+		assert.Equal(t, uint64(1), m.shards[0].stats().GCs)
+		assert.Equal(t, uint64(1), m.MaintenanceCount())
+
+		before := m.MaintenanceCount()
+		for i := 0; i < 64; i++ {
+			m.RePut([]byte(fmt.Sprintf("grow_%d", i)), []byte("v"))
+		}
+		st := m.shards[0].stats()
+		assert.Less(t, uint64(0), st.Rehashes)
+		assert.Equal(t, before+st.Rehashes, m.MaintenanceCount())
+		m.Close()
+	}
+}
+
+func TestVectorMap_KeyMaintenanceCount(t *testing.T) {
+	m := NewVectorMap(4, WithSkipCheck(), WithBuckets(2), WithEliminate(3*KB, 0, time.Second))
+	defer m.Close()
+
+	var h [16]byte
+	key := []byte("a")
+	hi, _ := md5hash.MD5Sum(key, h[:])
+	own := m.shardIndex(hi)
+	other := m.shards[1-own]
+
+	other.RePut(1, make([]byte, 16), make([]byte, 1024))
+	other.Delete(1, make([]byte, 16))
+	_, _, skip := other.GCCopy()
+	assert.Equal(t, 0, skip)
+	assert.Equal(t, uint64(1), m.MaintenanceCount())
+	assert.Equal(t, uint64(0), m.KeyMaintenanceCount(key))
+
+	m.RePut(key, make([]byte, 1024))
+	m.Delete(key)
+	_, _, skip = m.shards[own].GCCopy()
+	assert.Equal(t, 0, skip)
+	assert.Equal(t, uint64(1), m.KeyMaintenanceCount(key))
+}
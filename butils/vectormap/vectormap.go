@@ -15,10 +15,13 @@
 package vectormap
 
 import (
+	"errors"
 	"fmt"
 	"math"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unsafe"
 
 	"github.com/zuoyebang/bitalostored/butils/md5hash"
 )
@@ -58,6 +61,23 @@ const (
 	MinEliminateDuration   = 180 * time.Second
 )
 
+// Slot indexes keep the key offset/4 in 24 bits, so a shard holder can address
+// at most MaxShardMemSize bytes, and a value must stay below the 4MB limitSize.
+const (
+	MaxValueSize    = int(limitSize) - 1
+	MaxShardMemSize = maxShardMemSize
+)
+
+var ErrValueTooLarge = errors.New("vectormap: value exceeds max value size")
+
+// CheckValueSize reports ErrValueTooLarge if a value of vlen bytes can't be cached.
+func CheckValueSize(vlen int) error {
+	if vlen < 0 || vlen > MaxValueSize {
+		return ErrValueTooLarge
+	}
+	return nil
+}
+
 const (
 	skipReason1 = 1
 	skipReason2 = 2
@@ -97,6 +117,12 @@ func WithSkipCheck() Option {
 	}
 }
 
+func WithRePutRetry() Option {
+	return func(vm *VectorMap) {
+		vm.reputRetry = true
+	}
+}
+
 func WithLRUUnitTime(unitTime time.Duration) Option {
 	return func(vm *VectorMap) {
 		UnitTime = unitTime
@@ -138,6 +164,22 @@ func WithEliminate(memCap Byte, goroutines int, duration time.Duration) Option {
 	}
 }
 
+type ShardSelector uint8
+
+const (
+	ShardModulo ShardSelector = iota
+	ShardJumpHash
+)
+
+// WithShardSelector picks how keys map to shards. ShardJumpHash keeps the
+// bucket count as given instead of rounding it to a power of two, and moves
+// only about 1/n of the keys when a bucket is added.
+func WithShardSelector(sel ShardSelector) Option {
+	return func(vm *VectorMap) {
+		vm.selector = sel
+	}
+}
+
 type MapType uint8
 
 const (
@@ -145,6 +187,50 @@ const (
 	MapTypeLRU
 )
 
+// WithAdmission enables a TinyLFU admission filter on LFU maps: once a shard
+// is full, RePut only inserts a new key whose estimated frequency is higher
+// than that of the key it would push out.
+func WithAdmission() Option {
+	return func(vm *VectorMap) {
+		vm.admission = true
+	}
+}
+
+// WithMissCache makes LFU maps remember up to size missed keys per shard for
+// ttl, so repeated Gets of absent keys return without probing. Any put of a
+// key drops its entry.
+func WithMissCache(size int, ttl time.Duration) Option {
+	return func(vm *VectorMap) {
+		vm.missCacheSize = size
+		vm.missCacheTTL = ttl
+	}
+}
+
+// WithDeltaSnapshot makes LFU maps stamp writes with a version so
+// WriteSnapshotSince can emit only the keys changed after a version. At least
+// the latest maxChanges changed keys are kept per shard.
+func WithDeltaSnapshot(maxChanges int) Option {
+	return func(vm *VectorMap) {
+		vm.deltaSize = maxChanges
+	}
+}
+
+// WithEliminateBatch caps the items evicted by one Eliminate pass of a shard,
+// the rest is evicted by the following passes.
+func WithEliminateBatch(maxItems int) Option {
+	return func(vm *VectorMap) {
+		vm.eliminateBatch = maxItems
+	}
+}
+
+// WithLoader makes Get load a missed key by loader and RePut the loaded value,
+// concurrent misses of the same key call loader once.
+func WithLoader(loader Loader) Option {
+	return func(vm *VectorMap) {
+		vm.loader = loader
+	}
+}
+
 func WithType(mtyp MapType) Option {
 	return func(vm *VectorMap) {
 		vm.mtype = mtyp
@@ -156,6 +242,16 @@ type VectorMap struct {
 	shards           []Map
 	globalMask       uint64
 	reputFails       uint64
+	loadPutFails     uint64
+	reputRetries     uint64
+	reputRetry       bool
+	admission        bool
+	missCacheSize    int
+	missCacheTTL     time.Duration
+	deltaSize        int
+	eliminateBatch   int
+	loader           Loader
+	loads            loadGroup
 	memCap           Byte
 	eliminateHandler *eliminateHandler
 	logger           ILogger
@@ -163,6 +259,7 @@ type VectorMap struct {
 	stop             bool
 	wg               sync.WaitGroup
 	mtype            MapType
+	selector         ShardSelector
 }
 
 func NewVectorMap(sz uint32, ops ...Option) (vm *VectorMap) {
@@ -186,7 +283,9 @@ func NewVectorMap(sz uint32, ops ...Option) (vm *VectorMap) {
 	}
 
 	power := math.Ceil(math.Log2(float64(vm.buckets)))
-	vm.buckets = int(math.Pow(2, power))
+	if vm.selector == ShardModulo {
+		vm.buckets = int(math.Pow(2, power))
+	}
 	globalMask := MaxUint64 >> (64 - uint32(power))
 	c := uint32(math.Ceil(float64(sz) / float64(vm.buckets)))
 
@@ -212,47 +311,197 @@ func NewVectorMap(sz uint32, ops ...Option) (vm *VectorMap) {
 
 //go:inline
 func (vm *VectorMap) slotAt(hi uint64) Map {
-	return vm.shards[hi%uint64(vm.buckets)]
+	return vm.shards[vm.shardIndex(hi)]
+}
+
+func (vm *VectorMap) shardIndex(hi uint64) int {
+	if vm.selector == ShardJumpHash {
+		return jumpHash(hi, vm.buckets)
+	}
+	return int(hi % uint64(vm.buckets))
 }
 
+// jumpHash is the Lamping-Veach jump consistent hash.
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+// Put stores v under the md5 digest of k, the original key is not kept, so
+// entries can't be matched or deleted by a key prefix.
 func (vm *VectorMap) Put(k []byte, v []byte) bool {
+	if CheckValueSize(len(v)) != nil {
+		return false
+	}
 	var h [16]byte
 	hi, lo := md5hash.MD5Sum(k, h[:])
 	return vm.slotAt(hi).Put(lo, h[:], v)
 }
 
 func (vm *VectorMap) PutMultiValue(k []byte, vlen int, vals ...[]byte) bool {
+	if CheckValueSize(vlen) != nil {
+		return false
+	}
 	var h [16]byte
 	hi, lo := md5hash.MD5Sum(k, h[:])
 	return vm.slotAt(hi).PutMultiValue(lo, h[:], uint32(vlen), vals)
 }
 
 func (vm *VectorMap) RePutFails() uint64 {
-	return vm.reputFails
+	return atomic.LoadUint64(&vm.reputFails)
+}
+
+// LoadPutFails returns how many loaded values could not be cached, the Get
+// that loaded them still returned them.
+func (vm *VectorMap) LoadPutFails() uint64 {
+	return atomic.LoadUint64(&vm.loadPutFails)
+}
+
+func (vm *VectorMap) RePutRetries() uint64 {
+	return atomic.LoadUint64(&vm.reputRetries)
+}
+
+func (vm *VectorMap) AdmissionRejects() (count uint64) {
+	for _, m := range vm.shards {
+		if lm, ok := m.(*LFUMap); ok {
+			count += lm.admissionRejects.Load()
+		}
+	}
+	return
+}
+
+func (vm *VectorMap) MissCacheHits() (count uint64) {
+	for _, m := range vm.shards {
+		if lm, ok := m.(*LFUMap); ok {
+			count += lm.missCacheHits.Load()
+		}
+	}
+	return
 }
 
 func (vm *VectorMap) RePut(k []byte, v []byte) (res bool) {
+	res, _ = vm.RePutWithRetry(k, v)
+	return
+}
+
+// RePutWithRetry behaves like RePut. With WithRePutRetry, a failed write runs
+// one eviction pass on the shard and is retried once; retried reports that.
+func (vm *VectorMap) RePutWithRetry(k []byte, v []byte) (res bool, retried bool) {
 	defer func() {
 		if !res {
-			vm.reputFails++
+			atomic.AddUint64(&vm.reputFails, 1)
 		}
 	}()
-	if len(v) >= int(limitSize) {
+	if CheckValueSize(len(v)) != nil {
 		res = false
 		return
 	}
 	var h [16]byte
 	hi, lo := md5hash.MD5Sum(k, h[:])
-	res = vm.slotAt(hi).RePut(lo, h[:], v)
+	m := vm.slotAt(hi)
+	res = m.RePut(lo, h[:], v)
+	if res || !vm.reputRetry {
+		return
+	}
+
+	m.Eliminate()
+	m.GCCopy()
+	atomic.AddUint64(&vm.reputRetries, 1)
+	retried = true
+	res = m.RePut(lo, h[:], v)
 	return
 }
 
+// Get returns the value of k. With WithLoader, a miss returns the loaded value
+// with a nil closer.
 func (vm *VectorMap) Get(k []byte) (v []byte, closer func(), ok bool) {
+	var h [16]byte
+	hi, lo := md5hash.MD5Sum(k, h[:])
+	v, closer, ok = vm.slotAt(hi).Get(lo, h[:])
+	if ok || vm.loader == nil {
+		return
+	}
+	return vm.load(k)
+}
+
+func (vm *VectorMap) load(k []byte) ([]byte, func(), bool) {
+	v, ok := vm.loads.do(k, func(key []byte) ([]byte, bool) {
+		if v, closer, ok := vm.getNoLoad(key); ok {
+			res := make([]byte, len(v))
+			copy(res, v)
+			if closer != nil {
+				closer()
+			}
+			return res, true
+		}
+		v, ok := vm.loader(key)
+		if !ok {
+			return nil, false
+		}
+		if vm.putLoaded(key, v) {
+			return v, true
+		}
+		// a writer racing the loader put a newer value than the loaded one
+		if cur, closer, found := vm.getNoLoad(key); found {
+			res := make([]byte, len(cur))
+			copy(res, cur)
+			if closer != nil {
+				closer()
+			}
+			return res, true
+		}
+		atomic.AddUint64(&vm.loadPutFails, 1)
+		return v, true
+	})
+	return v, nil, ok
+}
+
+// putLoaded caches a loaded value only if key is still absent, so it never
+// overwrites a value put while the loader ran.
+func (vm *VectorMap) putLoaded(k []byte, v []byte) bool {
+	if CheckValueSize(len(v)) != nil {
+		return false
+	}
+	var h [16]byte
+	hi, lo := md5hash.MD5Sum(k, h[:])
+	return vm.slotAt(hi).PutIfAbsent(lo, h[:], v)
+}
+
+func (vm *VectorMap) getNoLoad(k []byte) ([]byte, func(), bool) {
 	var h [16]byte
 	hi, lo := md5hash.MD5Sum(k, h[:])
 	return vm.slotAt(hi).Get(lo, h[:])
 }
 
+// GetConcat returns a detached copy of the value of k, which for values
+// written by PutMultiValue is the concatenation of the segments. It is meant
+// for verification and debugging, not the hot path.
+func (vm *VectorMap) GetConcat(k []byte) ([]byte, bool) {
+	v, closer, ok := vm.Get(k)
+	if !ok {
+		return nil, false
+	}
+	res := make([]byte, len(v))
+	copy(res, v)
+	if closer != nil {
+		closer()
+	}
+	return res, true
+}
+
+// Encoding reports how the value of k is stored, ok is false if k is absent
+// and err is set if its entry is corrupt.
+func (vm *VectorMap) Encoding(k []byte) (enc ValueEncoding, vSize uint32, ok bool, err error) {
+	var h [16]byte
+	hi, lo := md5hash.MD5Sum(k, h[:])
+	return vm.slotAt(hi).Encoding(lo, h[:])
+}
+
 func (vm *VectorMap) Delete(k []byte) {
 	var h [16]byte
 	hi, lo := md5hash.MD5Sum(k, h[:])
@@ -299,6 +548,21 @@ func (vm *VectorMap) Shards() int {
 	return vm.buckets
 }
 
+// ShardIndex returns the shard that k is placed in.
+func (vm *VectorMap) ShardIndex(k []byte) int {
+	var h [16]byte
+	hi, _ := md5hash.MD5Sum(k, h[:])
+	return vm.shardIndex(hi)
+}
+
+// GroupStart returns the group where the probe of k starts within its shard.
+func (vm *VectorMap) GroupStart(k []byte) int {
+	var h [16]byte
+	hi, lo := md5hash.MD5Sum(k, h[:])
+	hp, _ := splitHash(lo)
+	return int(probeStart(hp, len(vm.shards[vm.shardIndex(hi)].Groups())))
+}
+
 func (vm *VectorMap) Capacity() int {
 	var sum int
 	for _, m := range vm.shards {
@@ -321,6 +585,38 @@ func (vm *VectorMap) MissCount() (count uint64) {
 	return
 }
 
+func (vm *VectorMap) Eliminate() (delCount int) {
+	for _, m := range vm.shards {
+		n, _ := m.Eliminate()
+		delCount += n
+	}
+	return
+}
+
+func (vm *VectorMap) GCCopy() (deadCount int, gcMem int) {
+	for _, m := range vm.shards {
+		n, mem, _ := m.GCCopy()
+		deadCount += n
+		gcMem += mem
+	}
+	return
+}
+
+// GCCopyShard runs GCCopy on the shard i only, ok is false when i is out of range.
+func (vm *VectorMap) GCCopyShard(i int) (deadCount int, gcMem int, ok bool) {
+	if i < 0 || i >= len(vm.shards) {
+		return 0, 0, false
+	}
+	deadCount, gcMem, _ = vm.shards[i].GCCopy()
+	return deadCount, gcMem, true
+}
+
+func (vm *VectorMap) ResetStats() {
+	for _, m := range vm.shards {
+		m.ResetStats()
+	}
+}
+
 func (vm *VectorMap) MaxMem() Byte {
 	return vm.memCap
 }
@@ -343,7 +639,10 @@ type Map interface {
 	Put(uint64, []byte, []byte) bool
 	PutMultiValue(uint64, []byte, uint32, [][]byte) bool
 	RePut(uint64, []byte, []byte) bool
+	PutIfAbsent(uint64, []byte, []byte) bool
 	Get(uint64, []byte) ([]byte, func(), bool)
+	Encoding(uint64, []byte) (ValueEncoding, uint32, bool, error)
+	ValueSizeHistogram([]uint32) []int
 	Delete(uint64, []byte) bool
 	Has(uint64, []byte) bool
 	Items() uint32
@@ -357,16 +656,61 @@ type Map interface {
 	Capacity() int
 	QueryCount() uint64
 	MissCount() uint64
+	ResetStats()
 	Eliminate() (delCount int, skipReason int)
 	GCCopy() (deadCount int, gcMem int, skipReason int)
 	kvholder() *kvHolder
 	Groups() []group
 	Resident() uint32
 	Dead() uint32
+	stats() ShardStats
+	maintCount() uint64
 }
 
 type metadata [groupSize]int8
 type counter [groupSize]uint8
+
+// Readers bump the access counters while holding only the shared locks, so
+// the counters of a live table are read and written a 32 bit word at a time
+// through load, store and incr.
+func (c *counter) word(s uint32) *uint32 {
+	return (*uint32)(unsafe.Pointer(&c[s&^3]))
+}
+
+func (c *counter) load(s uint32) uint8 {
+	w := atomic.LoadUint32(c.word(s))
+	return (*[4]uint8)(unsafe.Pointer(&w))[s&3]
+}
+
+func (c *counter) store(s uint32, v uint8) {
+	p := c.word(s)
+	for {
+		o := atomic.LoadUint32(p)
+		n := o
+		(*[4]uint8)(unsafe.Pointer(&n))[s&3] = v
+		if n == o || atomic.CompareAndSwapUint32(p, o, n) {
+			return
+		}
+	}
+}
+
+// incr bumps the counter of slot s, saturating at maxCount.
+func (c *counter) incr(s uint32) {
+	p := c.word(s)
+	for {
+		o := atomic.LoadUint32(p)
+		n := o
+		b := &(*[4]uint8)(unsafe.Pointer(&n))[s&3]
+		if *b >= maxCount {
+			return
+		}
+		*b++
+		if atomic.CompareAndSwapUint32(p, o, n) {
+			return
+		}
+	}
+}
+
 type since [groupSize]uint16
 type group [groupSize]kIdx
 
@@ -16,13 +16,20 @@ package vectormap
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
+	"io"
+	"math"
+	"runtime"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+	"unsafe"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/zuoyebang/bitalostored/butils/md5hash"
 )
 
 func TestVectorGet(t *testing.T) {
@@ -390,6 +397,200 @@ func TestVectorMap_EliminateAndGC(t *testing.T) {
 	m.Clear()
 }
 
+func TestVectorMap_EliminateBatch(t *testing.T) {
+	for _, mtype := range []MapType{MapTypeLFU, MapTypeLRU} {
+		batch := 4
+		m := NewVectorMap(4096, WithSkipCheck(), WithType(mtype), WithBuckets(1),
+			WithEliminate(64*KB, 0, time.Second), WithEliminateBatch(batch))
+		for i := 0; m.shards[0].itemsMemUsage() < eliminateStart+0.02; i++ {
+			assert.True(t, m.RePut([]byte(fmt.Sprintf("batch_%d", i)), make([]byte, 100)))
+		}
+
+		items := m.shards[0].Items()
+		target := int(math.Ceil(float64(float32(items) * (eliminateStart - eliminateEnd) / eliminateStart)))
+		assert.True(t, target > batch)
+
+		passes, total := 0, 0
+		for {
+			delCount, skipReason := m.shards[0].Eliminate()
+			if skipReason != 0 {
+				assert.Equal(t, skipReason2, skipReason)
+				break
+			}
+			assert.True(t, delCount > 0 && delCount <= batch)
+			total += delCount
+			passes++
+		}
+		assert.True(t, passes > 1)
+		assert.True(t, m.shards[0].itemsMemUsage() < eliminateStart)
+		assert.Equal(t, items-uint32(total), m.shards[0].Items())
+		m.Close()
+	}
+}
+
+func TestVectorMap_GCCopyShard(t *testing.T) {
+	m := NewVectorMap(4096, WithSkipCheck(), WithBuckets(4), WithEliminate(512*KB, 0, time.Second))
+	defer m.Close()
+
+	var keys [][]byte
+	for i := 0; i < 2000; i++ {
+		key := []byte(fmt.Sprintf("gc_shard_%d", i))
+		assert.True(t, m.RePut(key, make([]byte, 100)))
+		keys = append(keys, key)
+	}
+	for i, key := range keys {
+		if i%2 == 0 {
+			m.Delete(key)
+		}
+	}
+
+	before := make([]Byte, m.Shards())
+	for i := range m.shards {
+		before[i] = m.shards[i].UsedMem()
+		assert.True(t, m.shards[i].kvholder().garbageUsage() >= garbageRate)
+	}
+
+	_, gcMem, ok := m.GCCopyShard(1)
+	assert.True(t, ok)
+	assert.True(t, gcMem > 0)
+	assert.Equal(t, float32(0), m.shards[1].kvholder().garbageUsage())
+	assert.True(t, m.shards[1].UsedMem() < before[1])
+	for i := range m.shards {
+		if i != 1 {
+			assert.Equal(t, before[i], m.shards[i].UsedMem())
+			assert.True(t, m.shards[i].kvholder().garbageUsage() >= garbageRate)
+		}
+	}
+
+	for _, i := range []int{-1, m.Shards()} {
+		_, _, ok = m.GCCopyShard(i)
+		assert.False(t, ok)
+	}
+	assert.NoError(t, m.shards[1].(*LFUMap).Verify())
+}
+
+func TestVectorMap_OversizeValue(t *testing.T) {
+	m := NewVectorMap(1024, WithSkipCheck(), WithBuckets(1), WithEliminate(64*MB, 0, time.Second))
+	defer m.Close()
+
+	assert.NoError(t, CheckValueSize(MaxValueSize))
+	assert.Equal(t, ErrValueTooLarge, CheckValueSize(MaxValueSize+1))
+	assert.Equal(t, ErrValueTooLarge, CheckValueSize(-1))
+
+	k := []byte("oversize")
+	big := make([]byte, MaxValueSize+1)
+	assert.False(t, m.Put(k, big))
+	assert.False(t, m.RePut(k, big))
+	assert.False(t, m.PutMultiValue(k, 1<<32+3, []byte("abc")))
+	assert.False(t, m.Has(k))
+	assert.Equal(t, 0, m.Count())
+
+	assert.True(t, m.RePut(k, []byte("abc")))
+	assert.False(t, m.PutMultiValue(k, 1<<32+3, []byte("abc")))
+	v, closer, ok := m.Get(k)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("abc"), v)
+	if closer != nil {
+		closer()
+	}
+
+	hdr := newKVHolder(4 * GB)
+	assert.Equal(t, uint32(MaxShardMemSize), hdr.cap)
+	hdr.buffer.release()
+}
+
+func TestVectorMap_Loader(t *testing.T) {
+	var loads atomic.Int32
+	loader := func(key []byte) ([]byte, bool) {
+		loads.Add(1)
+		if string(key) == "absent" {
+			return nil, false
+		}
+		time.Sleep(50 * time.Millisecond)
+		return append([]byte("v_"), key...), true
+	}
+	m := NewVectorMap(1024, WithSkipCheck(), WithBuckets(4), WithEliminate(64*MB, 0, time.Second), WithLoader(loader))
+	defer m.Close()
+
+	k := []byte("loaded")
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	vals := make([][]byte, 16)
+	for i := range vals {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			v, closer, ok := m.Get(k)
+			assert.True(t, ok)
+			vals[i] = append([]byte(nil), v...)
+			if closer != nil {
+				closer()
+			}
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), loads.Load())
+	for _, v := range vals {
+		assert.Equal(t, []byte("v_loaded"), v)
+	}
+
+	v, closer, ok := m.Get(k)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("v_loaded"), v)
+	assert.NotNil(t, closer)
+	closer()
+	assert.Equal(t, int32(1), loads.Load())
+
+	_, _, ok = m.Get([]byte("absent"))
+	assert.False(t, ok)
+	assert.False(t, m.Has([]byte("absent")))
+	assert.Equal(t, int32(2), loads.Load())
+}
+
+func TestVectorMap_LoaderRacingPut(t *testing.T) {
+	for _, mtype := range []MapType{MapTypeLFU, MapTypeLRU} {
+		loading := make(chan struct{})
+		release := make(chan struct{})
+		loader := func(key []byte) ([]byte, bool) {
+			if string(key) == "big" {
+				return make([]byte, limitSize), true
+			}
+			close(loading)
+			<-release
+			return []byte("loaded"), true
+		}
+		m := NewVectorMap(1024, WithSkipCheck(), WithType(mtype), WithBuckets(1), WithEliminate(64*MB, 0, time.Second), WithLoader(loader))
+
+		k := []byte("raced")
+		got := make(chan []byte, 1)
+		go func() {
+			v, _, ok := m.Get(k)
+			assert.True(t, ok)
+			got <- v
+		}()
+		<-loading
+		assert.True(t, m.RePut(k, []byte("written")))
+		close(release)
+
+		assert.Equal(t, []byte("written"), <-got)
+		v, closer, ok := m.Get(k)
+		assert.True(t, ok)
+		assert.Equal(t, []byte("written"), v)
+		closer()
+		assert.Equal(t, uint64(0), m.LoadPutFails())
+
+		v, _, ok = m.Get([]byte("big"))
+		assert.True(t, ok)
+		assert.Equal(t, int(limitSize), len(v))
+		assert.False(t, m.Has([]byte("big")))
+		assert.Equal(t, uint64(1), m.LoadPutFails())
+		m.Close()
+	}
+}
+
 func TestVectorMap_EliminateAndGC_LRU(t *testing.T) {
 	m := NewVectorMap(4, WithSkipCheck(), WithType(MapTypeLRU), WithBuckets(1), WithEliminate(3*KB, 0, 100*time.Millisecond))
 
@@ -873,6 +1074,78 @@ func TestVectorMapLFU_BigValue(t *testing.T) {
 	m.shards[0].GCCopy()
 }
 
+func TestVectorMap_Encoding(t *testing.T) {
+	for _, mtype := range []MapType{MapTypeLFU, MapTypeLRU} {
+		k := []byte("K1234567890")
+		m := NewVectorMap(4,
+			WithSkipCheck(),
+			WithType(mtype),
+			WithBuckets(1),
+			WithEliminate(Byte(1<<20), 0, 0))
+
+		_, _, ok, err := m.Encoding(k)
+		assert.Equal(t, false, ok)
+		assert.NoError(t, err)
+
+		assert.Equal(t, true, m.RePut(k, genBytesData(16, 1)[0]))
+		enc, vSize, ok, err := m.Encoding(k)
+		assert.Equal(t, true, ok)
+		assert.NoError(t, err)
+		assert.Equal(t, EncodingInline, enc)
+		assert.Equal(t, uint32(16), vSize)
+
+		cases := []struct {
+			size int
+			enc  ValueEncoding
+		}{
+			{int(overShortSize) - 1, EncodingInline},
+			{int(overShortSize), EncodingOverShort},
+			{int(overLongSize) - 1, EncodingOverShort},
+			{int(overLongSize), EncodingOverLong},
+			{int(overLongSize) + 1, EncodingOverLong},
+			{8, EncodingInline},
+		}
+		for _, c := range cases {
+			assert.Equal(t, c.enc, EncodingOf(c.size), "size:%d", c.size)
+			v := genBytesData(c.size, 1)[0]
+			assert.Equal(t, true, m.Put(k, v))
+			enc, vSize, ok, err = m.Encoding(k)
+			assert.Equal(t, true, ok)
+			assert.NoError(t, err)
+			assert.Equal(t, c.enc, enc, "size:%d", c.size)
+			assert.Equal(t, uint32(c.size), vSize)
+
+			res, closer, ok := m.Get(k)
+			assert.Equal(t, true, ok)
+			assert.Equal(t, v, res)
+			if closer != nil {
+				closer()
+			}
+		}
+		m.Close()
+	}
+}
+
+func TestKVHolder_ValueEncodingCorrupt(t *testing.T) {
+	hdr := newKVHolder(1 * MB)
+	defer hdr.buffer.release()
+
+	ki, fail := hdr.gcSet(make([]byte, 16), make([]byte, overLongSize+8))
+	assert.False(t, fail)
+	enc, vSize, err := hdr.valueEncoding(ki)
+	assert.NoError(t, err)
+	assert.Equal(t, EncodingOverLong, enc)
+	assert.Equal(t, uint32(overLongSize+8), vSize)
+
+	vOffset := (LoadUint32(hdr.data[ki.offset()*4+16:]) & IdxOffsetMask) * 4
+	StoreUint32(hdr.data[vOffset:], hdr.cap)
+	_, _, err = hdr.valueEncoding(ki)
+	assert.Equal(t, ErrCorrupted, err)
+
+	_, _, err = hdr.valueEncoding(kIdx(uint32(len(hdr.data))/4 - 4))
+	assert.Equal(t, ErrCorrupted, err)
+}
+
 func TestGCTime(t *testing.T) {
 	vs := genBytesData(128, 1)
 	m := NewVectorMap(4, WithSkipCheck(), WithBuckets(1), WithEliminate(64*MB, 0, 100*time.Millisecond))
@@ -964,3 +1237,871 @@ func genStringData(size, count int) (keys []string) {
 	}
 	return
 }
+
+func TestVectorMap_GCCopyAndEliminate(t *testing.T) {
+	for _, mtype := range []MapType{MapTypeLFU, MapTypeLRU} {
+		m := NewVectorMap(1024, WithSkipCheck(), WithType(mtype), WithBuckets(4), WithEliminate(512*KB, 0, 100*time.Millisecond))
+		deadCount, gcMem := m.GCCopy()
+		assert.Equal(t, 0, deadCount)
+		assert.Equal(t, 0, gcMem)
+
+		for i := 0; i < 512; i++ {
+			m.RePut([]byte(fmt.Sprintf("key_%d", i)), make([]byte, 256))
+		}
+		for i := 0; i < 512; i += 2 {
+			m.Delete([]byte(fmt.Sprintf("key_%d", i)))
+		}
+
+		_, gcMem = m.GCCopy()
+		assert.Less(t, 0, gcMem)
+		assert.Equal(t, 256, m.Count())
+		for i := 1; i < 512; i += 2 {
+			_, closer, ok := m.Get([]byte(fmt.Sprintf("key_%d", i)))
+			assert.Equal(t, true, ok)
+			if closer != nil {
+				closer()
+			}
+		}
+
+		deadCount, gcMem = m.GCCopy()
+		assert.Equal(t, 0, deadCount)
+		assert.Equal(t, 0, gcMem)
+		assert.Equal(t, 0, m.Eliminate())
+		m.Close()
+	}
+}
+
+func TestVectorMap_RePutRetry(t *testing.T) {
+	for _, mtype := range []MapType{MapTypeLFU, MapTypeLRU} {
+		vlen := 992
+		for _, retry := range []bool{false, true} {
+			ops := []Option{WithSkipCheck(), WithType(mtype), WithBuckets(1), WithEliminate(3*KB, 0, 100*time.Millisecond)}
+			if retry {
+				ops = append(ops, WithRePutRetry())
+			}
+			m := NewVectorMap(4, ops...)
+			for _, k := range []string{"a", "b", "c"} {
+				ok, retried := m.RePutWithRetry([]byte(k), make([]byte, vlen))
+				assert.Equal(t, true, ok)
+				assert.Equal(t, false, retried)
+			}
+
+			ok, retried := m.RePutWithRetry([]byte("d"), make([]byte, vlen))
+			assert.Equal(t, retry, ok)
+			assert.Equal(t, retry, retried)
+			assert.Equal(t, retry, m.Has([]byte("d")))
+			if retry {
+				assert.Equal(t, uint64(1), m.RePutRetries())
+				assert.Equal(t, uint64(0), m.RePutFails())
+
+				var wg sync.WaitGroup
+				var retries atomic.Uint64
+				for g := 0; g < 4; g++ {
+					wg.Add(1)
+					go func(g int) {
+						defer wg.Done()
+						for i := 0; i < 50; i++ {
+							if _, retried := m.RePutWithRetry([]byte(fmt.Sprintf("k%d_%d", g, i)), make([]byte, vlen)); retried {
+								retries.Add(1)
+							}
+						}
+					}(g)
+				}
+				wg.Wait()
+				assert.Equal(t, 1+retries.Load(), m.RePutRetries())
+			} else {
+				assert.Equal(t, uint64(0), m.RePutRetries())
+				assert.Equal(t, uint64(1), m.RePutFails())
+			}
+			m.Close()
+		}
+	}
+}
+
+func TestVectorMap_ResetStats(t *testing.T) {
+	for _, mtype := range []MapType{MapTypeLFU, MapTypeLRU} {
+		m := NewVectorMap(1024, WithSkipCheck(), WithType(mtype), WithBuckets(4), WithEliminate(4*MB, 0, 100*time.Millisecond))
+		m.RePut([]byte("a"), []byte("a"))
+		for i := 0; i < 100; i++ {
+			m.Has([]byte("a"))
+			m.Has([]byte("b"))
+		}
+		assert.Equal(t, uint64(200), m.QueryCount())
+		assert.Equal(t, uint64(100), m.MissCount())
+
+		m.ResetStats()
+		assert.Equal(t, uint64(0), m.QueryCount())
+		assert.Equal(t, uint64(0), m.MissCount())
+
+		m.Has([]byte("b"))
+		assert.Equal(t, uint64(1), m.QueryCount())
+		assert.Equal(t, uint64(1), m.MissCount())
+
+		var wg sync.WaitGroup
+		for g := 0; g < 4; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 1000; i++ {
+					m.Has([]byte("b"))
+				}
+			}()
+		}
+		for i := 0; i < 10; i++ {
+			m.ResetStats()
+		}
+		wg.Wait()
+		m.ResetStats()
+		assert.Equal(t, uint64(0), m.QueryCount())
+		assert.Equal(t, uint64(0), m.MissCount())
+		m.Close()
+	}
+}
+
+func TestLFUMap_GroupLoadHistogram(t *testing.T) {
+	m := NewVectorMap(1024, WithSkipCheck(), WithBuckets(1), WithEliminate(4*MB, 0, 100*time.Millisecond))
+	defer m.Close()
+	lfu := m.shards[0].(*LFUMap)
+	groups := len(lfu.ctrl)
+
+	hist := lfu.GroupLoadHistogram()
+	assert.Equal(t, groups, hist[0])
+
+	for s := 0; s < groupSize; s++ {
+		lfu.ctrl[0][s] = 1
+	}
+	for s := 0; s < 3; s++ {
+		lfu.ctrl[1][s] = 1
+	}
+	lfu.ctrl[1][3] = tombstone
+	lfu.ctrl[2][groupSize-1] = 1
+
+	hist = lfu.GroupLoadHistogram()
+	assert.Equal(t, groups-3, hist[0])
+	assert.Equal(t, 1, hist[1])
+	assert.Equal(t, 1, hist[3])
+	assert.Equal(t, 1, hist[groupSize])
+
+	m.Clear()
+	for i := 0; i < 500; i++ {
+		m.RePut([]byte(fmt.Sprintf("key_%d", i)), []byte("v"))
+	}
+	hist = lfu.GroupLoadHistogram()
+	total, live := 0, 0
+	for n, cnt := range hist {
+		total += cnt
+		live += n * cnt
+	}
+	assert.Equal(t, len(lfu.ctrl), total)
+	assert.Equal(t, m.Count(), live)
+}
+
+func TestCMSketch(t *testing.T) {
+	s := newCMSketch(100)
+	assert.Equal(t, uint64(127), s.mask)
+	assert.Equal(t, 32, len(s.rows[0]))
+
+	for i := 0; i < 5; i++ {
+		s.increment(1)
+	}
+	s.increment(2)
+	assert.Equal(t, uint8(5), s.estimate(1))
+	assert.Equal(t, uint8(1), s.estimate(2))
+	assert.Equal(t, uint8(0), s.estimate(3))
+
+	for i := 0; i < 100; i++ {
+		s.increment(1)
+	}
+	assert.LessOrEqual(t, s.estimate(1), uint8(sketchMaxCount))
+
+	s.halve()
+	assert.Equal(t, uint8(0), s.estimate(2))
+}
+
+func TestCMSketchConcurrent(t *testing.T) {
+	s := newCMSketch(64)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 10000; i++ {
+				s.increment(uint64(g*10000 + i))
+				s.estimate(uint64(i))
+			}
+		}(g)
+	}
+	wg.Wait()
+	assert.Less(t, s.adds.Load(), s.samples)
+	for i := range s.rows {
+		for _, w := range s.rows[i] {
+			for shift := 0; shift < 32; shift += 8 {
+				assert.LessOrEqual(t, (w>>shift)&0xff, uint32(sketchMaxCount))
+			}
+		}
+	}
+}
+
+func TestLFUMap_AdmissionScanFlood(t *testing.T) {
+	m := NewVectorMap(4096, WithSkipCheck(), WithBuckets(1), WithEliminate(64*KB, 0, time.Second), WithAdmission())
+	defer m.Close()
+
+	value := make([]byte, 100)
+	readThrough := func(k []byte) {
+		if _, closer, ok := m.Get(k); ok {
+			if closer != nil {
+				closer()
+			}
+			return
+		}
+		m.RePut(k, value)
+	}
+
+	hot := make([][]byte, 50)
+	for i := range hot {
+		hot[i] = []byte(fmt.Sprintf("hot_%d", i))
+	}
+	for r := 0; r < 5; r++ {
+		for _, k := range hot {
+			readThrough(k)
+		}
+	}
+
+	for i := 0; i < 20000; i++ {
+		readThrough([]byte(fmt.Sprintf("scan_%d", i)))
+		if i%100 == 0 {
+			for _, k := range hot {
+				readThrough(k)
+			}
+		}
+		if i%500 == 0 {
+			m.shards[0].Eliminate()
+			m.shards[0].GCCopy()
+		}
+	}
+
+	for _, k := range hot {
+		assert.True(t, m.Has(k), string(k))
+	}
+	assert.Greater(t, m.AdmissionRejects(), uint64(0))
+}
+
+func TestLFUMap_MissCache(t *testing.T) {
+	m := NewVectorMap(1024, WithSkipCheck(), WithBuckets(1), WithMissCache(2, 50*time.Millisecond))
+	defer m.Close()
+
+	key := []byte("miss_key")
+	_, _, ok := m.Get(key)
+	assert.False(t, ok)
+	assert.Equal(t, uint64(0), m.MissCacheHits())
+
+	_, _, ok = m.Get(key)
+	assert.False(t, ok)
+	assert.Equal(t, uint64(1), m.MissCacheHits())
+	assert.Equal(t, uint64(2), m.MissCount())
+
+	assert.True(t, m.RePut(key, []byte("v")))
+	v, closer, ok := m.Get(key)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("v"), v)
+	if closer != nil {
+		closer()
+	}
+	assert.Equal(t, uint64(1), m.MissCacheHits())
+
+	m.Delete(key)
+	m.Get(key)
+	m.Get([]byte("miss_key1"))
+	m.Get([]byte("miss_key2"))
+	m.Get(key)
+	assert.Equal(t, uint64(1), m.MissCacheHits())
+
+	m.Get([]byte("miss_key2"))
+	assert.Equal(t, uint64(2), m.MissCacheHits())
+
+	time.Sleep(60 * time.Millisecond)
+	m.Get([]byte("miss_key2"))
+	assert.Equal(t, uint64(2), m.MissCacheHits())
+}
+
+func TestLFUMap_GetRef(t *testing.T) {
+	m := NewVectorMap(1024, WithSkipCheck(), WithBuckets(1))
+	defer m.Close()
+	lm := m.shards[0].(*LFUMap)
+
+	for _, vlen := range []int{10, 200, int(overLongSize) + 10} {
+		key := []byte(fmt.Sprintf("ref_%d", vlen))
+		value := bytes.Repeat([]byte{'v'}, vlen)
+		assert.True(t, m.RePut(key, value))
+
+		var h [16]byte
+		_, l := md5hash.MD5Sum(key, h[:])
+		v, release, ok := lm.GetRef(l, h[:])
+		assert.True(t, ok)
+		assert.Equal(t, value, v)
+
+		base := uintptr(unsafe.Pointer(&lm.kvHolder.data[0]))
+		p := uintptr(unsafe.Pointer(&v[0]))
+		aliased := p >= base && p+uintptr(len(v)) <= base+uintptr(len(lm.kvHolder.data))
+		assert.Equal(t, uint32(vlen) >= overShortSize, aliased)
+
+		holder := lm.kvHolder
+		_, _, skipReason := lm.GCCopy()
+		assert.Equal(t, skipReason3, skipReason)
+		lm.putLock.Lock()
+		lm.rehash()
+		lm.putLock.Unlock()
+		assert.Same(t, holder, lm.kvHolder)
+
+		done := make(chan struct{})
+		go func() {
+			m.Clear()
+			assert.True(t, m.RePut(key, bytes.Repeat([]byte{'w'}, vlen)))
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("clear blocked by lease")
+		}
+		assert.Equal(t, value, v)
+
+		release()
+		release()
+		assert.Equal(t, int32(0), lm.leases.Load())
+	}
+
+	_, release, ok := lm.GetRef(1, []byte("ref_missing"))
+	assert.False(t, ok)
+	assert.Nil(t, release)
+	assert.Equal(t, int32(0), lm.leases.Load())
+
+	holder := lm.kvHolder
+	lm.putLock.Lock()
+	lm.rehash()
+	lm.putLock.Unlock()
+	assert.NotSame(t, holder, lm.kvHolder)
+}
+
+func TestLFUMap_GetRefOverwrite(t *testing.T) {
+	m := NewVectorMap(1024, WithSkipCheck(), WithBuckets(1), WithEliminate(16*MB, 0, time.Hour))
+	defer m.Close()
+	lm := m.shards[0].(*LFUMap)
+
+	for _, vlen := range []int{10, 200} {
+		key := []byte(fmt.Sprintf("ref_overwrite_%d", vlen))
+		var h [16]byte
+		_, l := md5hash.MD5Sum(key, h[:])
+		assert.True(t, m.RePut(key, bytes.Repeat([]byte{'a'}, vlen)))
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 2000; i++ {
+				assert.True(t, m.Put(key, bytes.Repeat([]byte{'a' + byte(i%26)}, vlen)))
+			}
+		}()
+
+		for running := true; running; {
+			select {
+			case <-done:
+				running = false
+			default:
+			}
+			v, release, ok := lm.GetRef(l, h[:])
+			assert.True(t, ok)
+			held := bytes.Clone(v)
+			assert.Equal(t, bytes.Repeat(held[:1], vlen), held)
+			runtime.Gosched()
+			assert.Equal(t, held, v)
+			release()
+		}
+	}
+}
+
+func TestVectorMap_JumpHashShards(t *testing.T) {
+	const n = 100
+	const samples = 100000
+
+	m1 := NewVectorMap(1024, WithSkipCheck(), WithBuckets(n), WithShardSelector(ShardJumpHash))
+	m2 := NewVectorMap(1024, WithSkipCheck(), WithBuckets(n+1), WithShardSelector(ShardJumpHash))
+	defer m1.Close()
+	defer m2.Close()
+	assert.Equal(t, n, m1.Shards())
+	assert.Equal(t, n+1, m2.Shards())
+
+	moved := 0
+	for i := 0; i < samples; i++ {
+		var h [16]byte
+		hi, _ := md5hash.MD5Sum([]byte(fmt.Sprintf("jump_%d", i)), h[:])
+		from, to := m1.shardIndex(hi), m2.shardIndex(hi)
+		assert.True(t, from >= 0 && from < n)
+		if from != to {
+			assert.Equal(t, n, to)
+			moved++
+		}
+	}
+	expect := float64(samples) / float64(n+1)
+	assert.InDelta(t, expect, float64(moved), expect*0.2)
+
+	key := []byte("jump_key")
+	assert.True(t, m1.RePut(key, []byte("v")))
+	v, closer, ok := m1.Get(key)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("v"), v)
+	if closer != nil {
+		closer()
+	}
+}
+
+func TestVectorMap_Placement(t *testing.T) {
+	m := NewVectorMap(1024, WithSkipCheck(), WithBuckets(16))
+	defer m.Close()
+
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("placement_%d", i))
+		assert.True(t, m.RePut(key, []byte("v")))
+
+		var h [16]byte
+		_, lo := md5hash.MD5Sum(key, h[:])
+		shard := m.ShardIndex(key)
+		assert.True(t, shard >= 0 && shard < m.Shards())
+		assert.True(t, m.shards[shard].Has(lo, h[:]))
+
+		g := m.GroupStart(key)
+		assert.True(t, g >= 0 && g < len(m.shards[shard].Groups()))
+	}
+}
+
+func TestVectorMap_PutMultiValueGetConcat(t *testing.T) {
+	sizes := []int{0, 1, 3, 4, 5, 127, 128, 129, 255, 256, 257,
+		int(overLongSize) - 1, int(overLongSize), int(overLongSize) + 1, 40000}
+	segments := func(n int) [][]byte {
+		v := make([]byte, n)
+		for i := range v {
+			v[i] = byte(i*7 + n)
+		}
+		a, b := n/3, 2*n/3
+		return [][]byte{v[:a], v[a:b], v[b:]}
+	}
+
+	for _, mtype := range []MapType{MapTypeLFU, MapTypeLRU} {
+		m := NewVectorMap(1024, WithSkipCheck(), WithType(mtype), WithBuckets(1), WithEliminate(16*MB, 0, time.Second))
+
+		for _, n := range sizes {
+			key := []byte(fmt.Sprintf("concat_%d", n))
+			segs := segments(n)
+			assert.False(t, m.PutMultiValue(key, n, segs...))
+			assert.True(t, m.RePut(key, []byte("seed")))
+			assert.True(t, m.PutMultiValue(key, n, segs...), n)
+			v, ok := m.GetConcat(key)
+			assert.True(t, ok, n)
+			assert.Equal(t, bytes.Join(segs, nil), v, "type:%d size:%d", mtype, n)
+		}
+
+		key := []byte("concat_update")
+		assert.True(t, m.RePut(key, nil))
+		for _, order := range [][]int{sizes, {40000, 5, 300, 1, int(overLongSize), 128, 0}} {
+			for _, n := range order {
+				segs := segments(n)
+				assert.True(t, m.PutMultiValue(key, n, segs...), n)
+				v, ok := m.GetConcat(key)
+				assert.True(t, ok, n)
+				assert.Equal(t, bytes.Join(segs, nil), v, "type:%d update size:%d", mtype, n)
+			}
+		}
+
+		_, ok := m.GetConcat([]byte("concat_missing"))
+		assert.False(t, ok)
+		m.Close()
+	}
+}
+
+func TestLFUMap_PutMultiValueAccounting(t *testing.T) {
+	sizes := []int{0, 3, 8, 5, 127, 128, 300, 2, int(overLongSize), int(overLongSize) - 1, 40000, 1, 40000, 60, 12}
+	valueCap := func(n int) uint32 {
+		if uint32(n) >= overLongSize {
+			return Cap4Size(uint32(n)) + 4
+		}
+		return Cap4Size(uint32(n))
+	}
+	segments := func(n int) [][]byte {
+		v := bytes.Repeat([]byte{byte(n)}, n)
+		return [][]byte{v[:n/2], v[n/2:]}
+	}
+
+	vm := NewVectorMap(1024, WithSkipCheck(), WithBuckets(1), WithEliminate(1*MB, 0, time.Second))
+	defer vm.Close()
+	m := vm.shards[0].(*LFUMap)
+
+	keys := [][]byte{[]byte("acct_a"), []byte("acct_b"), []byte("acct_c")}
+	final := make(map[string]int, len(keys))
+	for i, key := range keys {
+		assert.True(t, vm.RePut(key, []byte("seed")))
+		for j := range sizes {
+			n := sizes[(i*5+j)%len(sizes)]
+			assert.True(t, vm.PutMultiValue(key, n, segments(n)...), n)
+			final[string(key)] = n
+		}
+	}
+
+	expected := uint32(0)
+	for _, n := range final {
+		expected += valueCap(n)
+	}
+	assert.Equal(t, expected, m.kvHolder.valUsed)
+	assert.Equal(t, uint32(len(keys)), m.kvHolder.items)
+
+	live := m.kvHolder.valUsed + m.kvHolder.items*20 + uint32(bufferSize)
+	garbage := m.kvHolder.tail - live
+	_, gcMem, skipReason := m.GCCopy()
+	assert.Equal(t, 0, skipReason)
+	assert.Equal(t, int(garbage), gcMem)
+	assert.Equal(t, expected, m.kvHolder.valUsed)
+	assert.Equal(t, live, m.kvHolder.tail)
+
+	for _, key := range keys {
+		n := final[string(key)]
+		v, ok := vm.GetConcat(key)
+		assert.True(t, ok)
+		assert.Equal(t, bytes.Repeat([]byte{byte(n)}, n), v)
+	}
+}
+
+func TestLFUMap_Drain(t *testing.T) {
+	count := 5000
+	vm := NewVectorMap(1024, WithSkipCheck(), WithBuckets(1), WithEliminate(16*MB, 0, time.Second))
+	defer vm.Close()
+	m := vm.shards[0].(*LFUMap)
+
+	expected := make(map[string]string, count)
+	for i := 0; i < count; i++ {
+		key := []byte(fmt.Sprintf("drain_%d", i))
+		value := bytes.Repeat([]byte{byte(i)}, i%300)
+		assert.True(t, vm.RePut(key, value))
+		var h [16]byte
+		md5hash.MD5Sum(key, h[:])
+		expected[string(h[:])] = string(value)
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			v, closer, ok := vm.Get([]byte(fmt.Sprintf("drain_%d", i%count)))
+			if ok {
+				assert.Equal(t, i%count%300, len(v))
+			}
+			if closer != nil {
+				closer()
+			}
+		}
+	}()
+
+	out := make(chan KV, 16)
+	got := make(map[string]string, count)
+	done := make(chan struct{})
+	dup := 0
+	go func() {
+		for kv := range out {
+			if _, ok := got[string(kv.Key)]; ok {
+				dup++
+			}
+			got[string(kv.Key)] = string(kv.Value)
+		}
+		close(done)
+	}()
+
+	assert.Equal(t, count, m.Drain(out))
+	close(out)
+	<-done
+	close(stop)
+	wg.Wait()
+
+	assert.Equal(t, 0, dup)
+	assert.Equal(t, expected, got)
+	assert.Equal(t, 0, vm.Count())
+	assert.Equal(t, uint32(0), m.kvHolder.items)
+	assert.Equal(t, uint32(0), m.kvHolder.valUsed)
+	assert.NoError(t, m.Verify())
+	assert.Equal(t, 0, m.Drain(out))
+}
+
+func BenchmarkLFUMap_GetParallel(b *testing.B) {
+	count := 1 << 14
+	vm := NewVectorMap(uint32(count), WithSkipCheck(), WithBuckets(1), WithEliminate(64*MB, 0, time.Second))
+	defer vm.Close()
+	keys := make([][]byte, count)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("bench_%d", i))
+		vm.RePut(keys[i], bytes.Repeat([]byte{byte(i)}, i%200))
+	}
+
+	for _, writer := range []bool{false, true} {
+		b.Run(fmt.Sprintf("writer=%v", writer), func(b *testing.B) {
+			stop := make(chan struct{})
+			var wg sync.WaitGroup
+			if writer {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; ; i++ {
+						select {
+						case <-stop:
+							return
+						default:
+						}
+						vm.Put(keys[i%count], bytes.Repeat([]byte{byte(i)}, i%200))
+					}
+				}()
+			}
+			var seed atomic.Uint32
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := int(seed.Add(7919))
+				for pb.Next() {
+					_, closer, _ := vm.Get(keys[i%count])
+					if closer != nil {
+						closer()
+					}
+					i++
+				}
+			})
+			b.StopTimer()
+			close(stop)
+			wg.Wait()
+		})
+	}
+}
+
+// TestLFUMap_ConcurrentReadWriteStress checks readers never see a torn value
+// while writers move slots across encodings. ctrl bytes and LFU counters are
+// read without locks by design, so -race reports are expected outside the
+// slot lock protected value path.
+func TestLFUMap_ConcurrentReadWriteStress(t *testing.T) {
+	keys := 2000
+	sizes := []int{0, 3, 60, 127, 128, 300, 5000, int(overLongSize), 40000}
+	vm := NewVectorMap(256, WithSkipCheck(), WithBuckets(2), WithEliminate(32*MB, 0, time.Second))
+	defer vm.Close()
+	value := func(n int) []byte {
+		return bytes.Repeat([]byte{byte(n)}, n)
+	}
+	check := func(v []byte) bool {
+		for i := range v {
+			if v[i] != byte(len(v)) {
+				return false
+			}
+		}
+		return true
+	}
+
+	stop := make(chan struct{})
+	var bad atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < 2; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				key := []byte(fmt.Sprintf("stress_%d", i%keys))
+				n := sizes[i%len(sizes)]
+				switch i % 5 {
+				case 0:
+					vm.Delete(key)
+				case 1:
+					v := value(n)
+					vm.PutMultiValue(key, n, v[:n/2], v[n/2:])
+				case 2:
+					vm.Put(key, value(n))
+				default:
+					vm.RePut(key, value(n))
+				}
+			}
+		}(w)
+	}
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for i := r; ; i += 7 {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				key := []byte(fmt.Sprintf("stress_%d", i%keys))
+				if v, closer, ok := vm.Get(key); ok {
+					if !check(v) {
+						bad.Add(1)
+					}
+					if closer != nil {
+						closer()
+					}
+				}
+				vm.Has(key)
+				vm.Encoding(key)
+			}
+		}(r)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		vm.GCCopy()
+		time.Sleep(50 * time.Millisecond)
+	}
+	close(stop)
+	wg.Wait()
+
+	assert.Equal(t, int64(0), bad.Load())
+	for _, m := range vm.shards {
+		assert.NoError(t, m.(*LFUMap).Verify())
+	}
+}
+
+func TestLFUMap_DeleteMulti(t *testing.T) {
+	vm := NewVectorMap(1024, WithSkipCheck(), WithBuckets(1))
+	defer vm.Close()
+	m := vm.shards[0].(*LFUMap)
+
+	count := 1000
+	hashes := make([]uint64, 0, count)
+	keys := make([][]byte, 0, count)
+	present := 0
+	for i := 0; i < count; i++ {
+		var h [16]byte
+		_, l := md5hash.MD5Sum([]byte(fmt.Sprintf("delete_multi_%d", i)), h[:])
+		if i%3 != 0 {
+			assert.True(t, m.RePut(l, h[:], []byte("v")))
+			present++
+		}
+		hashes = append(hashes, l)
+		keys = append(keys, h[:])
+	}
+	items := m.Items()
+
+	assert.Equal(t, present, m.DeleteMulti(hashes, keys))
+	assert.Equal(t, items-uint32(present), m.Items())
+	for i := range keys {
+		assert.False(t, m.Has(hashes[i], keys[i]))
+	}
+	assert.Equal(t, 0, m.DeleteMulti(hashes, keys))
+	assert.NoError(t, m.Verify())
+}
+
+func TestLFUMap_CompareAndSwap(t *testing.T) {
+	vm := NewVectorMap(1024, WithSkipCheck(), WithBuckets(1))
+	defer vm.Close()
+	m := vm.shards[0].(*LFUMap)
+
+	var h [16]byte
+	_, l := md5hash.MD5Sum([]byte("compare_and_swap"), h[:])
+	key := h[:]
+
+	assert.False(t, m.CompareAndSwap(l, key, []byte("v0"), []byte("v1")))
+	assert.True(t, m.CompareAndSwap(l, key, nil, []byte("v0")))
+	assert.False(t, m.CompareAndSwap(l, key, nil, []byte("v1")))
+
+	workers := 16
+	var swaps atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if m.CompareAndSwap(l, key, []byte("v0"), []byte(fmt.Sprintf("w%d", i))) {
+				swaps.Add(1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), swaps.Load())
+
+	assert.True(t, m.RePut(l, key, make([]byte, 8)))
+	rounds := 200
+	swaps.Store(0)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < rounds; j++ {
+				for {
+					v, closer, ok := m.Get(l, key)
+					assert.True(t, ok)
+					old := append([]byte(nil), v...)
+					if closer != nil {
+						closer()
+					}
+					n := make([]byte, 8)
+					binary.BigEndian.PutUint64(n, binary.BigEndian.Uint64(old)+1)
+					if m.CompareAndSwap(l, key, old, n) {
+						swaps.Add(1)
+						break
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(workers*rounds), swaps.Load())
+	v, closer, ok := m.Get(l, key)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(workers*rounds), binary.BigEndian.Uint64(v))
+	if closer != nil {
+		closer()
+	}
+	assert.NoError(t, m.Verify())
+}
+
+func TestLFUMap_PutReader(t *testing.T) {
+	vm := NewVectorMap(1024, WithSkipCheck(), WithBuckets(1))
+	defer vm.Close()
+	m := vm.shards[0].(*LFUMap)
+
+	var h [16]byte
+	_, l := md5hash.MD5Sum([]byte("put_reader"), h[:])
+	key := h[:]
+
+	value := genBytesData(int(limitSize)-1, 1)[0]
+	tail := m.kvHolder.tail
+	short := &io.LimitedReader{R: bytes.NewReader(value[:len(value)-1]), N: int64(len(value))}
+	assert.False(t, m.PutReader(l, key, short))
+	assert.False(t, m.PutReader(l, key, &io.LimitedReader{R: bytes.NewReader(nil), N: int64(limitSize)}))
+	assert.Equal(t, tail, m.kvHolder.tail)
+	assert.Equal(t, uint32(0), m.Items())
+
+	r := bytes.NewReader(append(value, "next"...))
+	assert.True(t, m.PutReader(l, key, &io.LimitedReader{R: r, N: int64(len(value))}))
+	assert.Equal(t, 4, r.Len())
+	v, closer, ok := m.Get(l, key)
+	assert.True(t, ok)
+	assert.Equal(t, value, v)
+	if closer != nil {
+		closer()
+	}
+
+	for _, n := range []int{100, 1000, 64 << 10} {
+		value = genBytesData(n, 1)[0]
+		assert.True(t, m.PutReader(l, key, &io.LimitedReader{R: bytes.NewReader(value), N: int64(n)}))
+		v, closer, ok = m.Get(l, key)
+		assert.True(t, ok)
+		assert.Equal(t, value, v)
+		if closer != nil {
+			closer()
+		}
+	}
+	assert.Equal(t, uint32(1), m.Items())
+	assert.NoError(t, m.Verify())
+}
@@ -0,0 +1,128 @@
+// Copyright 2019-2024 Xu Ruibo (hustxurb@163.com) and Contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vectormap
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/zuoyebang/bitalostored/butils/md5hash"
+)
+
+var ErrCorrupted = errors.New("vectormap: shard corrupted")
+
+// Verify walks every live slot and checks the key/value headers, probe
+// reachability and the valUsed/items/dead accounting of the shard.
+func (m *LFUMap) Verify() error {
+	m.putLock.Lock()
+	defer m.putLock.Unlock()
+	m.rehashLock.RLock()
+	defer m.rehashLock.RUnlock()
+	m.kvHolder.rlockAll()
+	defer m.kvHolder.runlockAll()
+
+	hdr := m.kvHolder
+	var items, dead, valUsed uint32
+	for g := range m.ctrl {
+		for s := range m.ctrl[g] {
+			c := m.ctrl[g][s]
+			if c == tombstone {
+				dead++
+				continue
+			}
+			if c == empty {
+				continue
+			}
+			if err := m.verifySlot(uint32(g), uint32(s)); err != nil {
+				return err
+			}
+			items++
+			valUsed += hdr.valueCap(m.groups[g][s])
+		}
+	}
+
+	if items != hdr.items {
+		return fmt.Errorf("%w: live slots %d, items %d", ErrCorrupted, items, hdr.items)
+	}
+	if dead != m.dead {
+		return fmt.Errorf("%w: tombstones %d, dead %d", ErrCorrupted, dead, m.dead)
+	}
+	if items+dead != m.resident {
+		return fmt.Errorf("%w: live+tombstones %d, resident %d", ErrCorrupted, items+dead, m.resident)
+	}
+	if valUsed != hdr.valUsed {
+		return fmt.Errorf("%w: value bytes %d, valUsed %d", ErrCorrupted, valUsed, hdr.valUsed)
+	}
+	if used := valUsed + items*20 + uint32(bufferSize); used > hdr.tail {
+		return fmt.Errorf("%w: items use %d bytes beyond tail %d", ErrCorrupted, used, hdr.tail)
+	}
+	return nil
+}
+
+func (m *LFUMap) verifySlot(g, s uint32) error {
+	hdr := m.kvHolder
+	ki := m.groups[g][s]
+	if ki == 0 {
+		return fmt.Errorf("%w: slot %d/%d live without index", ErrCorrupted, g, s)
+	}
+
+	kOffset := ki.offset() * 4
+	if kOffset < uint32(bufferSize) || kOffset+20 > hdr.tail {
+		return fmt.Errorf("%w: slot %d/%d key offset %d out of [%d, %d)", ErrCorrupted, g, s, kOffset, bufferSize, hdr.tail)
+	}
+
+	vHeader := LoadUint32(hdr.data[kOffset+16:])
+	vOffset := (vHeader & IdxOffsetMask) * 4
+	vSize := vHeader & IdxSmallSizeMask >> 24
+	if ki.valType() == 0 {
+		if vSize >= overShortSize || vSize > ki.capOrBigSize()*4 {
+			return fmt.Errorf("%w: slot %d/%d inline size %d exceeds cap %d", ErrCorrupted, g, s, vSize, ki.capOrBigSize()*4)
+		}
+	} else {
+		vSize += ki.capOrBigSize() << 8
+		if vSize < overShortSize {
+			return fmt.Errorf("%w: slot %d/%d out-of-line size %d below %d", ErrCorrupted, g, s, vSize, overShortSize)
+		}
+		if vSize == overLongSize {
+			if vOffset < uint32(bufferSize) || vOffset+4 > hdr.tail {
+				return fmt.Errorf("%w: slot %d/%d overlong offset %d beyond tail %d", ErrCorrupted, g, s, vOffset, hdr.tail)
+			}
+			vSize = LoadUint32(hdr.data[vOffset:])
+			if vSize < overLongSize || vSize >= limitSize {
+				return fmt.Errorf("%w: slot %d/%d overlong size %d", ErrCorrupted, g, s, vSize)
+			}
+			vOffset += 4
+		}
+	}
+	if vOffset < uint32(bufferSize) || uint64(vOffset)+uint64(vSize) > uint64(hdr.tail) {
+		return fmt.Errorf("%w: slot %d/%d value [%d, %d) beyond tail %d", ErrCorrupted, g, s, vOffset, uint64(vOffset)+uint64(vSize), hdr.tail)
+	}
+
+	_, l := md5hash.MD5HL(hdr.data[kOffset : kOffset+16])
+	hi, lo := splitHash(l)
+	if m.ctrl[g][s] != int8(lo) {
+		return fmt.Errorf("%w: slot %d/%d ctrl %#x, key hash %#x", ErrCorrupted, g, s, uint8(m.ctrl[g][s]), uint8(lo))
+	}
+	for p := probeStart(hi, len(m.groups)); p != g; {
+		if metaMatchEmpty(&m.ctrl[p]) != 0 {
+			return fmt.Errorf("%w: slot %d/%d unreachable, probe stops at group %d", ErrCorrupted, g, s, p)
+		}
+		p++
+		if p >= uint32(len(m.groups)) {
+			p = 0
+		}
+	}
+	return nil
+}
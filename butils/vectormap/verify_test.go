@@ -0,0 +1,119 @@
+// Copyright 2019-2024 Xu Ruibo (hustxurb@163.com) and Contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vectormap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// corruptValueHeader points the value header of the first live slot past tail.
+func corruptValueHeader(m *LFUMap) bool {
+	for g := range m.ctrl {
+		for s, c := range m.ctrl[g] {
+			if c == empty || c == tombstone {
+				continue
+			}
+			kEnd := m.groups[g][s].offset()*4 + 16
+			vHeader := LoadUint32(m.kvHolder.data[kEnd:])
+			StoreUint32(m.kvHolder.data[kEnd:], vHeader&^IdxOffsetMask|(m.kvHolder.tail/4+1))
+			return true
+		}
+	}
+	return false
+}
+
+func TestLFUMap_Verify(t *testing.T) {
+	sizes := []int{0, 1, 7, 64, 127, 128, 200, 1000, int(overLongSize) - 1, int(overLongSize), 40000}
+	vm := NewVectorMap(256, WithSkipCheck(), WithBuckets(1), WithEliminate(16*MB, 0, time.Second))
+	defer vm.Close()
+	m := vm.shards[0].(*LFUMap)
+	assert.NoError(t, m.Verify())
+
+	for round := 0; round < 4; round++ {
+		for i := 0; i < 3000; i++ {
+			key := []byte(fmt.Sprintf("verify_%d", i))
+			n := sizes[(i+round)%len(sizes)]
+			if n > 1000 && i%50 != 0 {
+				n = 1000
+			}
+			switch (i + round) % 4 {
+			case 0:
+				vm.RePut(key, bytes.Repeat([]byte{byte(i)}, n))
+			case 1:
+				v := bytes.Repeat([]byte{byte(i)}, n)
+				if !vm.PutMultiValue(key, n, v[:n/2], v[n/2:]) {
+					vm.RePut(key, v)
+				}
+			case 2:
+				vm.Put(key, bytes.Repeat([]byte{byte(i)}, n))
+			default:
+				vm.Delete(key)
+			}
+		}
+		assert.NoError(t, m.Verify(), "round %d", round)
+		m.GCCopy()
+		assert.NoError(t, m.Verify(), "round %d after gc", round)
+	}
+	assert.True(t, m.kvHolder.items > 0)
+	assert.True(t, m.rehashCnt.Load() > 0)
+
+	assert.True(t, corruptValueHeader(m))
+	err := m.Verify()
+	assert.True(t, errors.Is(err, ErrCorrupted), err)
+}
+
+func TestLFUMap_GetCorruptOverLong(t *testing.T) {
+	vm := NewVectorMap(1024, WithSkipCheck(), WithBuckets(1), WithEliminate(1*MB, 0, time.Second))
+	defer vm.Close()
+	m := vm.shards[0].(*LFUMap)
+
+	key := []byte("overlong")
+	value := bytes.Repeat([]byte("v"), int(overLongSize)+10)
+	assert.True(t, vm.RePut(key, value))
+	v, closer, ok := vm.Get(key)
+	assert.True(t, ok)
+	assert.Equal(t, value, v)
+	if closer != nil {
+		closer()
+	}
+
+	var kEnd uint32
+	for g := range m.ctrl {
+		for s, c := range m.ctrl[g] {
+			if c != empty && c != tombstone {
+				kEnd = m.groups[g][s].offset()*4 + 16
+			}
+		}
+	}
+	vHeader := LoadUint32(m.kvHolder.data[kEnd:])
+	vOffset := (vHeader & IdxOffsetMask) * 4
+
+	StoreUint32(m.kvHolder.data[vOffset:], 0xffffffff)
+	missCount := vm.MissCount()
+	_, _, ok = vm.Get(key)
+	assert.False(t, ok)
+	assert.Equal(t, missCount+1, vm.MissCount())
+	assert.Error(t, m.Verify())
+
+	StoreUint32(m.kvHolder.data[kEnd:], vHeader&^IdxOffsetMask|(m.kvHolder.cap/4))
+	_, _, ok = vm.Get(key)
+	assert.False(t, ok)
+}
@@ -24,6 +24,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/zuoyebang/bitalostored/butils/hash"
 	"github.com/zuoyebang/bitalostored/dashboard/dashcore"
 
 	"github.com/docopt/docopt-go"
@@ -98,6 +99,9 @@ Options:
 			log.PanicErrorf(err, "load config %s failed", s)
 		}
 	}
+	if err := hash.SetKeyHash(config.KeyHash); err != nil {
+		log.PanicErrorf(err, "set key hash %s failed", config.KeyHash)
+	}
 
 	var db *gorm.DB
 
@@ -19,6 +19,7 @@ import (
 
 	"github.com/BurntSushi/toml"
 
+	"github.com/zuoyebang/bitalostored/butils/hash"
 	"github.com/zuoyebang/bitalostored/dashboard/internal/errors"
 	"github.com/zuoyebang/bitalostored/dashboard/internal/log"
 )
@@ -45,6 +46,9 @@ admin_addr = "0.0.0.0:18080"
 # Set Stored raft
 admin_model  = "raft"
 
+# Set slot hash of keys, fnv32 or crc32, must match stored and proxy.
+key_hash = "fnv32"
+
 [database]
 username = "demo"
 password = "demo"
@@ -63,6 +67,8 @@ type Config struct {
 
 	ReadCrossCloud int `toml:"read_cross_cloud" json:"read_cross_cloud"`
 
+	KeyHash string `toml:"key_hash" json:"key_hash"`
+
 	ProductName string   `toml:"product_name" json:"product_name"`
 	ProductAuth string   `toml:"product_auth" json:"product_auth"`
 	Database    DBConfig `toml:"database"`
@@ -111,6 +117,11 @@ func (c *Config) Validate() error {
 	if c.AdminModel != "raft" {
 		return errors.New("invalid admin model raft")
 	}
+	if c.KeyHash == "" {
+		c.KeyHash = hash.KeyHashFnv32
+	} else if err := hash.CheckKeyHash(c.KeyHash); err != nil {
+		return errors.Trace(err)
+	}
 	return nil
 }
 
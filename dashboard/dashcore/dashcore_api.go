@@ -715,8 +715,8 @@ func (s *apiServer) FindKeyGroup(session sessions.Session, req *http.Request, pa
 	if !ok || key == "" {
 		return rpc.ApiResponseError(errors.New("missing param key"))
 	}
-	slotId := hash.Fnv32(unsafe2.ByteSlice(key)) % 1024
-	return rpc.ApiResponseJson(fmt.Sprintf("slotId:%d(hash method fnv)", slotId))
+	slotId := hash.KeyHash(unsafe2.ByteSlice(key)) % 1024
+	return rpc.ApiResponseJson(fmt.Sprintf("slotId:%d(hash method %s)", slotId, hash.KeyHashName()))
 }
 
 func (s *apiServer) EnableReplicaGroups(session sessions.Session, req *http.Request, params martini.Params) (int, string) {
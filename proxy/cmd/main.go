@@ -26,6 +26,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/zuoyebang/bitalostored/butils/hash"
 	"github.com/zuoyebang/bitalostored/proxy/internal/anticc"
 	"github.com/zuoyebang/bitalostored/proxy/internal/cgroup"
 	"github.com/zuoyebang/bitalostored/proxy/internal/config"
@@ -49,6 +50,9 @@ func main() {
 			panic(fmt.Sprintf("load config failed err:%s", err.Error()))
 		}
 	}
+	if err := hash.SetKeyHash(cfg.KeyHash); err != nil {
+		panic(fmt.Sprintf("set key hash failed err:%s", err.Error()))
+	}
 
 	initLogger(cfg)
 	initCgroup(cfg)
@@ -20,6 +20,7 @@ import (
 	"time"
 
 	"github.com/zuoyebang/bitalostored/butils/bytesize"
+	"github.com/zuoyebang/bitalostored/butils/hash"
 	"github.com/zuoyebang/bitalostored/butils/timesize"
 	"github.com/zuoyebang/bitalostored/proxy/internal/log"
 	"github.com/zuoyebang/bitalostored/proxy/internal/models"
@@ -47,6 +48,9 @@ dashboard_password = "password"
 
 proxy_cloudtype = "baidu"
 
+# slot hash of keys, fnv32 or crc32, must match stored and dashboard
+key_hash = "fnv32"
+
 proxy_max_clients = 1000
 conn_read_buffersize = "4kb"
 conn_write_buffersize = "4kb"
@@ -161,6 +165,7 @@ type Config struct {
 	ProxyCloudType      string         `toml:"proxy_cloudtype" json:"proxy_cloudtype"`
 	ReadCrossCloud      int            `toml:"read_cross_cloud" json:"read_cross_cloud"`
 	OpenDistributedTx   bool           `toml:"open_distributed_tx" json:"open_distributed_tx"`
+	KeyHash             string         `toml:"key_hash" json:"key_hash"`
 
 	PprofSwitch  int    `toml:"pprof_switch" json:"pprof_switch"`
 	PprofAddress string `toml:"pprof_address" json:"pprof_address"`
@@ -233,6 +238,12 @@ func (c *Config) Validate() error {
 		c.MaxProcs = 4
 	}
 
+	if c.KeyHash == "" {
+		c.KeyHash = hash.KeyHashFnv32
+	} else if err := hash.CheckKeyHash(c.KeyHash); err != nil {
+		return err
+	}
+
 	const MaxInt = bytesize.Int64(^uint(0) >> 1)
 
 	if c := c.ConnReadBufferSize; c < 0 || c > MaxInt {
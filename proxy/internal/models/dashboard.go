@@ -14,6 +14,18 @@
 
 package models
 
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+const dashboardBinaryVersion byte = 1
+
+var ErrDashboardBinary = errors.New("invalid dashboard binary data")
+
 type DashboardModel struct {
 	Token     string `json:"token"`
 	StartTime string `json:"start_time"`
@@ -37,3 +49,129 @@ type DashboardModel struct {
 func (t *DashboardModel) Encode() []byte {
 	return jsonEncode(t)
 }
+
+// Diff returns the names of the fields that differ between t and other. A nil
+// model is treated as a zero one.
+func (t *DashboardModel) Diff(other *DashboardModel) []string {
+	var a, b DashboardModel
+	if t != nil {
+		a = *t
+	}
+	if other != nil {
+		b = *other
+	}
+
+	var fields []string
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	for i := 0; i < va.NumField(); i++ {
+		if va.Field(i).Interface() != vb.Field(i).Interface() {
+			fields = append(fields, va.Type().Field(i).Name)
+		}
+	}
+	return fields
+}
+
+// EncodeBinary encodes the model as a version byte followed by length-prefixed
+// fields. Fields added later are appended, so older decoders ignore them.
+func (t *DashboardModel) EncodeBinary() []byte {
+	b := make([]byte, 0, 256)
+	b = append(b, dashboardBinaryVersion)
+	for _, s := range []string{t.Token, t.StartTime, t.AdminAddr, t.HostPort,
+		t.BackupAddr, t.BackupHostPort, t.ProductName} {
+		b = appendBinaryString(b, s)
+	}
+	if t.ReadCrossCloud {
+		b = append(b, 1)
+	} else {
+		b = append(b, 0)
+	}
+	b = binary.AppendVarint(b, int64(t.Pid))
+	for _, s := range []string{t.Pwd, t.Sys, t.CgroupConfig} {
+		b = appendBinaryString(b, s)
+	}
+	return b
+}
+
+func (t *DashboardModel) DecodeBinary(b []byte) error {
+	if len(b) == 0 || b[0] == 0 {
+		return ErrDashboardBinary
+	}
+	d := &binaryDecoder{b: b[1:]}
+	m := DashboardModel{}
+	for _, s := range []*string{&m.Token, &m.StartTime, &m.AdminAddr, &m.HostPort,
+		&m.BackupAddr, &m.BackupHostPort, &m.ProductName} {
+		*s = d.string()
+	}
+	m.ReadCrossCloud = d.byte() == 1
+	m.Pid = int(d.varint())
+	for _, s := range []*string{&m.Pwd, &m.Sys, &m.CgroupConfig} {
+		*s = d.string()
+	}
+	if d.err != nil {
+		return d.err
+	}
+	*t = m
+	return nil
+}
+
+// DecodeDashboard decodes a model encoded either by Encode or EncodeBinary.
+func DecodeDashboard(b []byte) (*DashboardModel, error) {
+	t := &DashboardModel{}
+	if len(b) > 0 && b[0] == '{' {
+		if err := jsoniter.Unmarshal(b, t); err != nil {
+			return nil, err
+		}
+		return t, nil
+	}
+	if err := t.DecodeBinary(b); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func appendBinaryString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+type binaryDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *binaryDecoder) byte() byte {
+	if d.err != nil || len(d.b) < 1 {
+		d.err = ErrDashboardBinary
+		return 0
+	}
+	v := d.b[0]
+	d.b = d.b[1:]
+	return v
+}
+
+func (d *binaryDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.err = ErrDashboardBinary
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *binaryDecoder) string() string {
+	if d.err != nil {
+		return ""
+	}
+	l, n := binary.Uvarint(d.b)
+	if n <= 0 || uint64(len(d.b)-n) < l {
+		d.err = ErrDashboardBinary
+		return ""
+	}
+	v := string(d.b[n : n+int(l)])
+	d.b = d.b[n+int(l):]
+	return v
+}
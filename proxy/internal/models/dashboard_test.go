@@ -0,0 +1,100 @@
+// Copyright 2019-2024 Xu Ruibo (hustxurb@163.com) and Contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDashboardModel_EncodeBinary(t *testing.T) {
+	m := &DashboardModel{
+		Token:          "d4f2c8e1a9b7",
+		StartTime:      "2024-01-02 15:04:05",
+		AdminAddr:      "10.0.0.1:18080",
+		HostPort:       "10.0.0.1:18080",
+		BackupAddr:     "10.0.0.2:18080",
+		BackupHostPort: "10.0.0.2:18080",
+		ProductName:    "stored-test",
+		ReadCrossCloud: true,
+		Pid:            12345,
+		Pwd:            "/home/work/dashboard",
+		Sys:            "linux",
+		CgroupConfig:   "cpu=4",
+	}
+
+	bin := m.EncodeBinary()
+	js := m.Encode()
+	if len(bin) >= len(js) {
+		t.Fatalf("binary size %d not smaller than json size %d", len(bin), len(js))
+	}
+
+	for _, b := range [][]byte{bin, js} {
+		d, err := DecodeDashboard(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *d != *m {
+			t.Fatalf("decode mismatch %+v", d)
+		}
+	}
+
+	newer := append(append([]byte{}, bin...), 0x01, 0x02)
+	newer[0] = dashboardBinaryVersion + 1
+	if d, err := DecodeDashboard(newer); err != nil || *d != *m {
+		t.Fatalf("decode newer version failed err:%v", err)
+	}
+
+	for i := 0; i < len(bin); i++ {
+		d := &DashboardModel{}
+		if err := d.DecodeBinary(bin[:i]); err != ErrDashboardBinary {
+			t.Fatalf("truncated at %d err:%v", i, err)
+		}
+	}
+}
+
+func TestDashboardModel_Diff(t *testing.T) {
+	a := &DashboardModel{
+		Token:      "d4f2c8e1a9b7",
+		AdminAddr:  "10.0.0.1:18080",
+		HostPort:   "10.0.0.1:18080",
+		BackupAddr: "10.0.0.2:18080",
+		Pid:        12345,
+	}
+	b := *a
+	b.HostPort = "10.0.0.3:18080"
+
+	if diff := a.Diff(&b); !reflect.DeepEqual(diff, []string{"HostPort"}) {
+		t.Fatalf("diff err %v", diff)
+	}
+	if diff := a.Diff(a); len(diff) != 0 {
+		t.Fatalf("diff self err %v", diff)
+	}
+
+	c := *a
+	c.BackupAddr = ""
+	c.BackupHostPort = "10.0.0.2:18080"
+	if diff := a.Diff(&c); !reflect.DeepEqual(diff, []string{"BackupAddr", "BackupHostPort"}) {
+		t.Fatalf("diff backup err %v", diff)
+	}
+
+	if diff := a.Diff(nil); !reflect.DeepEqual(diff, []string{"Token", "AdminAddr", "HostPort", "BackupAddr", "Pid"}) {
+		t.Fatalf("diff nil err %v", diff)
+	}
+	var empty *DashboardModel
+	if diff := empty.Diff(&DashboardModel{}); len(diff) != 0 {
+		t.Fatalf("diff empty err %v", diff)
+	}
+}
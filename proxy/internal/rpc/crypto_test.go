@@ -0,0 +1,101 @@
+// Copyright 2019-2024 Xu Ruibo (hustxurb@163.com) and Contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpc
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyWithKeyring(t *testing.T) {
+	secretA, secretB := []byte("secret-a"), []byte("secret-b")
+
+	tokenA := NewHMACToken("a", secretA, "stored-test", "10.0.0.1:18080")
+	if !VerifyWithKeyring(map[string][]byte{"a": secretA}, tokenA, "stored-test", "10.0.0.1:18080") {
+		t.Fatal("token A should verify")
+	}
+
+	overlap := map[string][]byte{"a": secretA, "b": secretB}
+	tokenB := NewHMACToken("b", secretB, "stored-test", "10.0.0.1:18080")
+	for _, token := range []string{tokenA, tokenB} {
+		if !VerifyWithKeyring(overlap, token, "stored-test", "10.0.0.1:18080") {
+			t.Fatalf("token %s should verify during rotation", token)
+		}
+	}
+	if VerifyWithKeyring(overlap, tokenA, "stored-test", "10.0.0.2:18080") {
+		t.Fatal("token with other segs should not verify")
+	}
+
+	rotated := map[string][]byte{"b": secretB}
+	if VerifyWithKeyring(rotated, tokenA, "stored-test", "10.0.0.1:18080") {
+		t.Fatal("token A should not verify after rotation")
+	}
+	if !VerifyWithKeyring(rotated, tokenB, "stored-test", "10.0.0.1:18080") {
+		t.Fatal("token B should verify after rotation")
+	}
+
+	forged := "b" + tokenA[1:]
+	for _, token := range []string{forged, "b", "b:zz", ""} {
+		if VerifyWithKeyring(overlap, token, "stored-test", "10.0.0.1:18080") {
+			t.Fatalf("token %q should not verify", token)
+		}
+	}
+}
+
+func TestVerifyToken(t *testing.T) {
+	secret := []byte("secret-a")
+	token := NewTokenWithExpiry(secret, time.Now().Add(time.Hour), "stored-test", "10.0.0.1:18080")
+	if ok, err := VerifyToken(secret, token, "stored-test", "10.0.0.1:18080"); !ok || err != nil {
+		t.Fatalf("token should verify before expiry err:%v", err)
+	}
+	if ok, err := VerifyToken(secret, token, "stored-test", "10.0.0.2:18080"); ok || err != ErrTokenInvalid {
+		t.Fatalf("token with other segs should be invalid err:%v", err)
+	}
+
+	expired := NewTokenWithExpiry(secret, time.Now().Add(-time.Second), "stored-test", "10.0.0.1:18080")
+	if ok, err := VerifyToken(secret, expired, "stored-test", "10.0.0.1:18080"); ok || err != ErrTokenExpired {
+		t.Fatalf("token should fail after expiry err:%v", err)
+	}
+
+	_, mac, _ := strings.Cut(expired, ".")
+	extended := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10) + "." + mac
+	if ok, err := VerifyToken(secret, extended, "stored-test", "10.0.0.1:18080"); ok || err != ErrTokenInvalid {
+		t.Fatalf("tampered expiry should be invalid err:%v", err)
+	}
+
+	for _, token := range []string{"", "abc", "abc.def", "1." + mac} {
+		if ok, err := VerifyToken(secret, token, "stored-test", "10.0.0.1:18080"); ok || err != ErrTokenInvalid {
+			t.Fatalf("token %q should be invalid err:%v", token, err)
+		}
+	}
+}
+
+func TestVerifyTokenWithoutSecret(t *testing.T) {
+	secret := []byte("secret-a")
+	expireAt := time.Now().Add(24 * time.Hour)
+	for _, other := range [][]byte{nil, []byte("secret-b")} {
+		forged := NewTokenWithExpiry(other, expireAt, "stored-test", "10.0.0.1:18080")
+		if ok, err := VerifyToken(secret, forged, "stored-test", "10.0.0.1:18080"); ok || err != ErrTokenInvalid {
+			t.Fatalf("token minted with secret %q should be invalid err:%v", other, err)
+		}
+	}
+
+	token := NewTokenWithExpiry(nil, expireAt, "stored-test", "10.0.0.1:18080")
+	if ok, err := VerifyToken(nil, token, "stored-test", "10.0.0.1:18080"); ok || err != ErrTokenInvalid {
+		t.Fatalf("empty secret should never verify err:%v", err)
+	}
+}
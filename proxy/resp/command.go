@@ -137,6 +137,18 @@ const (
 	ZKEYEXISTS  string = "ZKEYEXISTS"
 	ZRANGEBYLEX string = "ZRANGEBYLEX"
 
+	ZUNION      string = "ZUNION"
+	ZINTER      string = "ZINTER"
+	ZUNLINK     string = "ZUNLINK"
+	ZRANGESTORE string = "ZRANGESTORE"
+	ZUNIONSTORE string = "ZUNIONSTORE"
+	ZINTERSTORE string = "ZINTERSTORE"
+	ZDIFF       string = "ZDIFF"
+	ZDIFFSTORE  string = "ZDIFFSTORE"
+	ZRANDMEMBER string = "ZRANDMEMBER"
+	ZMPOP       string = "ZMPOP"
+	ZINTERCARD  string = "ZINTERCARD"
+
 	LPUSH   string = "LPUSH"
 	RPUSH   string = "RPUSH"
 	LPOP    string = "LPOP"
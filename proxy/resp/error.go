@@ -32,6 +32,7 @@ var (
 	ValueErr                  = errors.New("ERR value is not an integer or out of range")
 	FloatErr                  = errors.New("ERR value is not a valid float")
 	HashTagErr                = errors.New("ERR hashtag mismatch or missing")
+	CrossSlotErr              = errors.New("CROSSSLOT Keys in request don't hash to the same slot")
 	TxGroupChangedErr         = errors.New("ERR group changed in tx")
 	TxAbortErr                = errors.New("EXECABORT Transaction discarded because of previous errors.")
 )
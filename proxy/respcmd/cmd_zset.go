@@ -51,6 +51,17 @@ func init() {
 	resp.Register(resp.ZPERSIST, ZPersistCommand)
 	resp.Register(resp.ZKEYEXISTS, ZKeyExistsCommand)
 	resp.Register(resp.ZRANGEBYLEX, ZRangeByLexCommand)
+	resp.Register(resp.ZUNION, ZunionCommand)
+	resp.Register(resp.ZINTER, ZinterCommand)
+	resp.Register(resp.ZUNLINK, ZunlinkCommand)
+	resp.Register(resp.ZRANGESTORE, ZrangestoreCommand)
+	resp.Register(resp.ZUNIONSTORE, ZunionstoreCommand)
+	resp.Register(resp.ZINTERSTORE, ZinterstoreCommand)
+	resp.Register(resp.ZDIFF, ZdiffCommand)
+	resp.Register(resp.ZDIFFSTORE, ZdiffstoreCommand)
+	resp.Register(resp.ZRANDMEMBER, ZrandmemberCommand)
+	resp.Register(resp.ZMPOP, ZmpopCommand)
+	resp.Register(resp.ZINTERCARD, ZintercardCommand)
 }
 
 func ZaddCommand(s *resp.Session) error {
@@ -425,6 +436,14 @@ func zrangeGeneric(s *resp.Session, reverse bool) error {
 
 	}
 
+	if !reverse {
+		if plain, err := zrangeIsPlain(args[3:]); err != nil {
+			return err
+		} else if !plain {
+			return zrangeWithOptions(s)
+		}
+	}
+
 	key := unsafe2.String(args[0])
 
 	start, stop, err := zparseRange(s, args[1], args[2])
@@ -486,6 +505,61 @@ func ZrangeCommand(s *resp.Session) error {
 	return zrangeGeneric(s, false)
 }
 
+// zrangeIsPlain checks the ZRANGE options and reports whether they are at most
+// WITHSCORES, the BYSCORE, BYLEX, REV and LIMIT forms are passed on as given.
+func zrangeIsPlain(args [][]byte) (bool, error) {
+	var by string
+	var rev, limit, withScores bool
+	for i := 0; i < len(args); i++ {
+		switch opt := strings.ToLower(unsafe2.String(args[i])); opt {
+		case "withscores":
+			withScores = true
+		case "byscore", "bylex":
+			if by != "" {
+				return false, resp.SyntaxErr
+			}
+			by = opt
+		case "rev":
+			rev = true
+		case "limit":
+			if i+2 >= len(args) {
+				return false, resp.SyntaxErr
+			}
+			if _, err := strconv.Atoi(unsafe2.String(args[i+1])); err != nil {
+				return false, resp.ValueErr
+			}
+			if _, err := strconv.Atoi(unsafe2.String(args[i+2])); err != nil {
+				return false, resp.ValueErr
+			}
+			limit = true
+			i += 2
+		default:
+			return false, resp.SyntaxErr
+		}
+	}
+	if (limit && by == "") || (withScores && by == "bylex") {
+		return false, resp.SyntaxErr
+	}
+	return by == "" && !rev, nil
+}
+
+func zrangeWithOptions(s *resp.Session) error {
+	proxyClient, err := router.GetProxyClient()
+	if err != nil {
+		return err
+	}
+	res, err := proxyClient.ZRangeWithOptions(s, resp.InterfaceByte(s.Args)...)
+	if s.TxCommandQueued {
+		return s.SendTxQueued(err)
+	}
+	datas, err := redis.ByteSlices(res, err)
+	if err != nil && err != redis.ErrNil {
+		return err
+	}
+	s.RespWriter.WriteSliceArray(datas)
+	return nil
+}
+
 func ZrevrangeCommand(s *resp.Session) error {
 	return zrangeGeneric(s, true)
 }
@@ -982,3 +1056,120 @@ func ZRangeByLexCommand(s *resp.Session) error {
 	res, err := proxyClient.ZRangeByLex(s, unsafe2.String(args[0]), unsafe2.String(args[1]), unsafe2.String(args[2]), "limit", offset, count)
 	return output(s, res, err)
 }
+
+// zparseNumKeys returns the numkeys keys following args[0].
+func zparseNumKeys(args [][]byte) ([][]byte, error) {
+	n, err := strconv.Atoi(unsafe2.String(args[0]))
+	if err != nil {
+		return nil, resp.ValueErr
+	}
+	if n <= 0 || n > len(args)-1 {
+		return nil, resp.SyntaxErr
+	}
+	return args[1 : 1+n], nil
+}
+
+// zmultiKeyGeneric forwards a zset command over several keys to the group
+// holding them and writes the stored reply back unchanged. keysFn picks the
+// keys out of the arguments.
+func zmultiKeyGeneric(s *resp.Session, cmd string, minArgs int, keysFn func(args [][]byte) ([][]byte, error)) error {
+	args := s.Args
+	if len(args) < minArgs {
+		return resp.CmdParamsErr(cmd)
+	}
+	keys, err := keysFn(args)
+	if err != nil {
+		return err
+	}
+
+	proxyClient, err := router.GetProxyClient()
+	if err != nil {
+		return err
+	}
+	res, err := proxyClient.ZMultiKey(cmd, s, keys, resp.InterfaceByte(args)...)
+	if s.TxCommandQueued {
+		return s.SendTxQueued(err)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch v := res.(type) {
+	case []interface{}:
+		s.RespWriter.WriteArray(v)
+	case []byte:
+		s.RespWriter.WriteBulk(v)
+	case int64:
+		s.RespWriter.WriteInteger(v)
+	case string:
+		s.RespWriter.WriteStatus(v)
+	case nil:
+		if cmd == resp.ZMPOP {
+			s.RespWriter.WriteArray(nil)
+		} else {
+			s.RespWriter.WriteBulk(nil)
+		}
+	default:
+		return errors.New("unexpected reply type")
+	}
+	return nil
+}
+
+// zkeysStoreNumKeys returns the destination followed by the numkeys source keys.
+func zkeysStoreNumKeys(args [][]byte) ([][]byte, error) {
+	keys, err := zparseNumKeys(args[1:])
+	if err != nil {
+		return nil, err
+	}
+	return append([][]byte{args[0]}, keys...), nil
+}
+
+func ZunionCommand(s *resp.Session) error {
+	return zmultiKeyGeneric(s, resp.ZUNION, 2, zparseNumKeys)
+}
+
+func ZinterCommand(s *resp.Session) error {
+	return zmultiKeyGeneric(s, resp.ZINTER, 2, zparseNumKeys)
+}
+
+func ZdiffCommand(s *resp.Session) error {
+	return zmultiKeyGeneric(s, resp.ZDIFF, 2, zparseNumKeys)
+}
+
+func ZintercardCommand(s *resp.Session) error {
+	return zmultiKeyGeneric(s, resp.ZINTERCARD, 2, zparseNumKeys)
+}
+
+func ZmpopCommand(s *resp.Session) error {
+	return zmultiKeyGeneric(s, resp.ZMPOP, 3, zparseNumKeys)
+}
+
+func ZunionstoreCommand(s *resp.Session) error {
+	return zmultiKeyGeneric(s, resp.ZUNIONSTORE, 3, zkeysStoreNumKeys)
+}
+
+func ZinterstoreCommand(s *resp.Session) error {
+	return zmultiKeyGeneric(s, resp.ZINTERSTORE, 3, zkeysStoreNumKeys)
+}
+
+func ZdiffstoreCommand(s *resp.Session) error {
+	return zmultiKeyGeneric(s, resp.ZDIFFSTORE, 3, zkeysStoreNumKeys)
+}
+
+func ZrangestoreCommand(s *resp.Session) error {
+	return zmultiKeyGeneric(s, resp.ZRANGESTORE, 4, func(args [][]byte) ([][]byte, error) {
+		return args[:2], nil
+	})
+}
+
+func ZunlinkCommand(s *resp.Session) error {
+	return zmultiKeyGeneric(s, resp.ZUNLINK, 1, func(args [][]byte) ([][]byte, error) {
+		return args, nil
+	})
+}
+
+func ZrandmemberCommand(s *resp.Session) error {
+	return zmultiKeyGeneric(s, resp.ZRANDMEMBER, 1, func(args [][]byte) ([][]byte, error) {
+		return args[:1], nil
+	})
+}
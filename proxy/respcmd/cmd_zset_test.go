@@ -499,7 +499,7 @@ func TestZsetErrorParams(t *testing.T) {
 
 	_, err = c.Do("zrange", "test_zrange", 0, 1, "withscores", "a")
 	assert.Error(t, err)
-	assert.Equal(t, resp.CmdParamsErr("ZRANGE").Error(), err.Error())
+	assert.Equal(t, resp.SyntaxErr.Error(), err.Error())
 
 	_, err = c.Do("zrevrange", "test_zrevrange")
 	assert.Error(t, err)
@@ -567,3 +567,67 @@ func TestZSetLex(t *testing.T) {
 	}
 
 }
+
+func TestZSetRangeOptions(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	key := "myzrangeopts"
+	c.Do("del", key)
+	if _, err := c.Do("zadd", key, 1, "a", 2, "b", 3, "c", 4, "d"); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	res, err := redis.Strings(c.Do("zrange", key, "(1", "+inf", "byscore", "limit", 1, 2, "withscores"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c", "3", "d", "4"}, res)
+
+	res, err = redis.Strings(c.Do("zrange", key, "+inf", "-inf", "byscore", "rev", "limit", 0, 1))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"d"}, res)
+
+	res, err = redis.Strings(c.Do("zrange", key, 0, 1, "rev"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"d", "c"}, res)
+
+	_, err = c.Do("zrange", key, 0, 1, "limit", 0, 1)
+	assert.Equal(t, resp.SyntaxErr.Error(), err.Error())
+	_, err = c.Do("zrange", key, "-", "+", "bylex", "withscores")
+	assert.Equal(t, resp.SyntaxErr.Error(), err.Error())
+}
+
+func TestZSetMultiKey(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	key, dst := "myzmultikey", "myzmultikey_dst"
+	c.Do("del", key, dst)
+	if _, err := c.Do("zadd", key, 1, "a", 2, "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	res, err := redis.Strings(c.Do("zunion", 1, key, "withscores"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "1", "b", "2"}, res)
+
+	n, err := redis.Int(c.Do("zintercard", 1, key))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	member, err := redis.String(c.Do("zrandmember", key))
+	assert.NoError(t, err)
+	assert.Contains(t, []string{"a", "b"}, member)
+
+	pop, err := redis.Values(c.Do("zmpop", 1, key, "min"))
+	assert.NoError(t, err)
+	assert.Equal(t, key, string(pop[0].([]byte)))
+
+	_, err = c.Do("zunion", 2, key)
+	assert.Equal(t, resp.SyntaxErr.Error(), err.Error())
+	_, err = c.Do("zdiff", "x", key)
+	assert.Equal(t, resp.ValueErr.Error(), err.Error())
+	_, err = c.Do("zunionstore", dst)
+	assert.Equal(t, resp.CmdParamsErr(resp.ZUNIONSTORE).Error(), err.Error())
+}
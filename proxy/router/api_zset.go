@@ -164,3 +164,12 @@ func (pc *ProxyClient) ZPersist(s *resp.Session, key []byte) (interface{}, error
 func (pc *ProxyClient) ZRangeByLex(s *resp.Session, args ...interface{}) (interface{}, error) {
 	return pc.do(resp.ZRANGEBYLEX, s, args...)
 }
+
+func (pc *ProxyClient) ZRangeWithOptions(s *resp.Session, args ...interface{}) (interface{}, error) {
+	return pc.do(resp.ZRANGE, s, args...)
+}
+
+// ZMultiKey runs a zset command touching all of keys, they must share a group.
+func (pc *ProxyClient) ZMultiKey(commandName string, s *resp.Session, keys [][]byte, args ...interface{}) (interface{}, error) {
+	return pc.doKeys(commandName, s, keys, args...)
+}
@@ -63,21 +63,47 @@ func (pc *ProxyClient) doWithClients(commandName string, s *resp.Session, args .
 		recorder.CmdNum++
 		return execStoredTxDel(pc, recorder, commandName, args...)
 	default:
-		if s.TxState&resp.TxStateCancel != 0 {
-			return nil, nil
+		return pc.doWithClientsSlot(commandName, s, pc.router.Hash(args[0]), args...)
+	}
+}
+
+func (pc *ProxyClient) doWithClientsSlot(commandName string, s *resp.Session, slotId int, args ...interface{}) (res interface{}, err error) {
+	if s.TxState&resp.TxStateCancel != 0 {
+		return nil, nil
+	}
+	recorder := s.Recorder
+	gid := pc.router.GetSlot(slotId).MasterAddrGroupId
+	if conn, ok := recorder.ServerClients[gid]; ok {
+		res, err = goStoredDoTx(pc, conn, commandName, args...)
+		if err == nil {
+			recorder.CmdNum++
+			recorder.AddCommand(gid)
 		}
-		slotId := pc.router.Hash(args[0])
-		gid := pc.router.GetSlot(slotId).MasterAddrGroupId
-		if conn, ok := clients[gid]; ok {
-			res, err = goStoredDoTx(pc, conn, commandName, args...)
-			if err == nil {
-				recorder.CmdNum++
-				recorder.AddCommand(gid)
-			}
-		} else {
-			return nil, resp.TxGroupChangedErr
+	} else {
+		return nil, resp.TxGroupChangedErr
+	}
+	return res, err
+}
+
+// doKeys runs a command whose first argument need not be a key on the group
+// owning keys[0]. All keys must live in that group, a stored node only holds
+// the slots of its own group.
+func (pc *ProxyClient) doKeys(commandName string, s *resp.Session, keys [][]byte, args ...interface{}) (res interface{}, err error) {
+	slotId := pc.router.Hash(keys[0])
+	gid, err := pc.router.GetGroupId(slotId)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys[1:] {
+		if g, err := pc.router.GetGroupId(pc.router.Hash(key)); err != nil || g != gid {
+			return nil, resp.CrossSlotErr
 		}
 	}
+
+	if s != nil && s.OpenDistributedTx && s.TxCommandQueued {
+		return pc.doWithClientsSlot(commandName, s, slotId, args...)
+	}
+	res, err, _ = goStoredDo(pc, slotId, commandName, nil, args...)
 	return res, err
 }
 
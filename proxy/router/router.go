@@ -387,10 +387,10 @@ func (r *Router) Hash(key interface{}) int {
 	switch key.(type) {
 	case string:
 		keyByte := unsafe2.ByteSlice(key.(string))
-		return int(hash.Fnv32(keyByte) % MaxSlotNum)
+		return int(hash.KeyHash(keyByte) % MaxSlotNum)
 	case []byte:
 		keyByte := key.([]byte)
-		return int(hash.Fnv32(keyByte) % MaxSlotNum)
+		return int(hash.KeyHash(keyByte) % MaxSlotNum)
 	default:
 		return -1
 	}
@@ -398,7 +398,7 @@ func (r *Router) Hash(key interface{}) int {
 
 func (r *Router) HashForLua(key string) int {
 	keybyte := resp.ExtractHashTag(key)
-	index := hash.Fnv32(keybyte) % MaxSlotNum
+	index := hash.KeyHash(keybyte) % MaxSlotNum
 	return int(index)
 }
 
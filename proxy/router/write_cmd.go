@@ -146,6 +146,15 @@ var writeCommand = map[string]bool{
 	"ZSCAN":            true,
 	"ZUNIONSTORE":      true,
 	"ZINTERSTORE":      true,
+	resp.ZUNION:        false,
+	resp.ZINTER:        false,
+	resp.ZUNLINK:       true,
+	resp.ZRANGESTORE:   true,
+	resp.ZDIFF:         false,
+	resp.ZDIFFSTORE:    true,
+	resp.ZRANDMEMBER:   false,
+	resp.ZMPOP:         true,
+	resp.ZINTERCARD:    false,
 	resp.ZCLEAR:        true,
 	resp.ZEXPIRE:       true,
 	resp.ZEXPIREAT:     true,
@@ -779,6 +779,16 @@ type LogDBConfig struct {
 	KVBlockSize                        uint64
 	SaveBufferSize                     uint64
 	MaxSaveBufferSize                  uint64
+	// KVBloomFilterBitsPerKey enables a bloom filter of the given bits per key
+	// on every level, making lookups of missing keys cheaper. 0 disables it.
+	KVBloomFilterBitsPerKey uint64
+	// KVDisableWAL runs the bitable log db without its own WAL, the memtable is
+	// flushed on close but unsynced writes are lost on a crash.
+	KVDisableWAL bool
+	// KVMaxConcurrentCompactions caps the compactions bitable runs at once,
+	// bounding the disk bandwidth taken from foreground writes. 0 keeps the
+	// bitable default.
+	KVMaxConcurrentCompactions uint64
 }
 
 // GetDefaultLogDBConfig returns the default configurations for the LogDB
@@ -16,8 +16,10 @@ package fileutil
 
 import (
 	"archive/tar"
+	"bufio"
 	"bytes"
 	"compress/bzip2"
+	"compress/gzip"
 	"crypto/md5"
 	"fmt"
 	"io"
@@ -26,6 +28,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/cockroachdb/errors"
@@ -41,6 +44,9 @@ const (
 	SnapshotFlagFilename = "dragonboat.snapshot.message"
 	defaultDirFileMode   = 0750
 	deleteFilename       = "DELETED.dragonboat"
+
+	syncRetryTimes   = 3
+	syncRetryBackoff = 10 * time.Millisecond
 )
 
 var firstError = utils.FirstError
@@ -162,7 +168,22 @@ func SyncDir(dir string, fs vfs.IFS) (err error) {
 	defer func() {
 		err = firstError(err, ws(df.Close()))
 	}()
-	return ws(df.Sync())
+	return ws(syncWithRetry(df))
+}
+
+// syncWithRetry retries Sync with backoff when it fails with a transient
+// error, as seen on some network filesystems.
+func syncWithRetry(f vfs.File) error {
+	err := f.Sync()
+	for i := 0; err != nil && isTransientSyncError(err) && i < syncRetryTimes; i++ {
+		time.Sleep(syncRetryBackoff << i)
+		err = f.Sync()
+	}
+	return err
+}
+
+func isTransientSyncError(err error) bool {
+	return errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.EAGAIN)
 }
 
 // MarkDirAsDeleted marks the specified directory as deleted.
@@ -270,9 +291,29 @@ func RemoveFlagFile(dir string, filename string, fs vfs.IFS) error {
 	return fs.Remove(fs.PathJoin(dir, filename))
 }
 
+// ErrExtractLimitExceeded indicates that an archive exceeds the configured
+// extraction limits.
+var ErrExtractLimitExceeded = errors.New("extraction limit exceeded")
+
+// ExtractLimit defines the limits applied when extracting an archive. Zero
+// values mean unlimited.
+type ExtractLimit struct {
+	MaxBytes int64
+	MaxFiles int
+}
+
 // ExtractTarBz2 extracts files and directories from the specified tar.bz2 file
-// to the specified target directory.
-func ExtractTarBz2(bz2fn string, toDir string, fs vfs.IFS) (err error) {
+// to the specified target directory. Gzip compressed and plain tar files are
+// also accepted.
+func ExtractTarBz2(bz2fn string, toDir string, fs vfs.IFS) error {
+	return ExtractTarBz2WithLimit(bz2fn, toDir, fs, ExtractLimit{})
+}
+
+// ExtractTarBz2WithLimit extracts files and directories from the specified
+// tar.bz2 file to the specified target directory, aborting with
+// ErrExtractLimitExceeded once the extracted data exceeds the limit.
+func ExtractTarBz2WithLimit(bz2fn string, toDir string,
+	fs vfs.IFS, limit ExtractLimit) (err error) {
 	f, err := fs.Open(bz2fn)
 	if err != nil {
 		return err
@@ -280,8 +321,70 @@ func ExtractTarBz2(bz2fn string, toDir string, fs vfs.IFS) (err error) {
 	defer func() {
 		err = firstError(err, f.Close())
 	}()
-	ts := bzip2.NewReader(f)
-	tarReader := tar.NewReader(ts)
+	r, err := newArchiveReader(f)
+	if err != nil {
+		return err
+	}
+	return extractTar(tar.NewReader(r), toDir, fs, limit)
+}
+
+// VerifyArchive streams through the specified archive without writing any
+// file, returning the number of entries and the total uncompressed size of
+// the regular files, or the first error found in the archive.
+func VerifyArchive(fn string, fs vfs.IFS) (entries int, totalBytes int64, err error) {
+	f, err := fs.Open(fn)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		err = firstError(err, f.Close())
+	}()
+	r, err := newArchiveReader(f)
+	if err != nil {
+		return 0, 0, err
+	}
+	tarReader := tar.NewReader(r)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return entries, totalBytes, nil
+		}
+		if err != nil {
+			return entries, totalBytes, err
+		}
+		entries++
+		if header.Typeflag == tar.TypeReg {
+			n, err := io.Copy(io.Discard, tarReader)
+			totalBytes += n
+			if err != nil {
+				return entries, totalBytes, err
+			}
+		}
+	}
+}
+
+// newArchiveReader detects the compression codec of the archive from its
+// magic bytes, tar files are returned as is.
+func newArchiveReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(3)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		return bzip2.NewReader(br), nil
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return gzip.NewReader(br)
+	default:
+		return br, nil
+	}
+}
+
+func extractTar(tarReader *tar.Reader,
+	toDir string, fs vfs.IFS, limit ExtractLimit) error {
+	var totalBytes int64
+	var totalFiles int
 	for {
 		header, err := tarReader.Next()
 		if err == io.EOF {
@@ -297,7 +400,21 @@ func ExtractTarBz2(bz2fn string, toDir string, fs vfs.IFS) (err error) {
 				return err
 			}
 		case tar.TypeReg:
-			if err := func() error {
+			totalFiles++
+			if limit.MaxFiles > 0 && totalFiles > limit.MaxFiles {
+				return errors.Wrapf(ErrExtractLimitExceeded,
+					"more than %d files", limit.MaxFiles)
+			}
+			var src io.Reader = tarReader
+			if limit.MaxBytes > 0 {
+				remaining := limit.MaxBytes - totalBytes
+				if header.Size > remaining {
+					return errors.Wrapf(ErrExtractLimitExceeded,
+						"more than %d bytes", limit.MaxBytes)
+				}
+				src = io.LimitReader(tarReader, remaining+1)
+			}
+			if err := func() (err error) {
 				fp := fs.PathJoin(toDir, header.Name)
 				nf, err := fs.Create(fp)
 				if err != nil {
@@ -306,11 +423,16 @@ func ExtractTarBz2(bz2fn string, toDir string, fs vfs.IFS) (err error) {
 				defer func() {
 					err = firstError(err, nf.Close())
 				}()
-				_, err = io.Copy(nf, tarReader)
+				n, err := io.Copy(nf, src)
+				totalBytes += n
 				return err
 			}(); err != nil {
 				return err
 			}
+			if limit.MaxBytes > 0 && totalBytes > limit.MaxBytes {
+				return errors.Wrapf(ErrExtractLimitExceeded,
+					"more than %d bytes", limit.MaxBytes)
+			}
 		default:
 			panic("unknown type")
 		}
@@ -323,25 +445,47 @@ func ExtractTarBz2(bz2fn string, toDir string, fs vfs.IFS) (err error) {
 // Copyright 2010 The Go Authors. All rights reserved.
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
-var rand uint32
-var randmu sync.Mutex
+
+// nameGenerator is a linear congruential generator used for temp names.
+type nameGenerator struct {
+	mu     sync.Mutex
+	r      uint32
+	seeded bool
+}
+
+var defaultNameGenerator = &nameGenerator{}
+
+func newSeededNameGenerator(seed uint32) *nameGenerator {
+	return &nameGenerator{r: seed, seeded: true}
+}
 
 func reseed() uint32 {
 	return uint32(time.Now().UnixNano())
 }
 
-func nextRandom() string {
-	randmu.Lock()
-	r := rand
-	if r == 0 {
+func (g *nameGenerator) next() string {
+	g.mu.Lock()
+	r := g.r
+	if r == 0 && !g.seeded {
 		r = reseed()
 	}
 	r = r*1664525 + 1013904223 // constants from Numerical Recipes
-	rand = r
-	randmu.Unlock()
+	g.r = r
+	g.mu.Unlock()
 	return strconv.Itoa(int(1e9 + r%1e9))[1:]
 }
 
+// conflict is called on too many name conflicts, the default generator is
+// reseeded from the clock while seeded ones keep their sequence.
+func (g *nameGenerator) conflict() {
+	if g.seeded {
+		return
+	}
+	g.mu.Lock()
+	g.r = reseed()
+	g.mu.Unlock()
+}
+
 // TempFile and TempDir functions below are modified from golang's
 // TempFile and TempDir functions.
 //
@@ -352,6 +496,18 @@ func nextRandom() string {
 // TempFile returns a temp file.
 func TempFile(dir,
 	pattern string, fs vfs.IFS) (f vfs.File, name string, err error) {
+	return tempFile(dir, pattern, fs, defaultNameGenerator)
+}
+
+// TempFileSeeded is similar to TempFile, the random part of the file name is
+// generated from the specified seed so the name is reproducible.
+func TempFileSeeded(dir,
+	pattern string, seed uint32, fs vfs.IFS) (f vfs.File, name string, err error) {
+	return tempFile(dir, pattern, fs, newSeededNameGenerator(seed))
+}
+
+func tempFile(dir, pattern string,
+	fs vfs.IFS, gen *nameGenerator) (f vfs.File, name string, err error) {
 	if dir == "" {
 		dir = vfs.TempDir()
 		if fs != vfs.DefaultFS {
@@ -368,13 +524,11 @@ func TempFile(dir,
 	}
 	nconflict := 0
 	for i := 0; i < 10000; i++ {
-		name = fs.PathJoin(dir, prefix+nextRandom()+suffix)
+		name = fs.PathJoin(dir, prefix+gen.next()+suffix)
 		f, err = fs.Create(name)
 		if vfs.IsExist(err) {
 			if nconflict++; nconflict > 10 {
-				randmu.Lock()
-				rand = reseed()
-				randmu.Unlock()
+				gen.conflict()
 			}
 			continue
 		}
@@ -410,6 +564,17 @@ func prefixAndSuffix(pattern string) (prefix, suffix string, err error) {
 // will not choose the same directory. It is the caller's responsibility
 // to remove the directory when no longer needed.
 func TempDir(dir, pattern string, fs vfs.IFS) (name string, err error) {
+	return tempDir(dir, pattern, fs, defaultNameGenerator)
+}
+
+// TempDirSeeded is similar to TempDir, the random part of the directory name
+// is generated from the specified seed so the name is reproducible.
+func TempDirSeeded(dir, pattern string, seed uint32, fs vfs.IFS) (name string, err error) {
+	return tempDir(dir, pattern, fs, newSeededNameGenerator(seed))
+}
+
+func tempDir(dir, pattern string,
+	fs vfs.IFS, gen *nameGenerator) (name string, err error) {
 	if dir == "" {
 		dir = os.TempDir()
 	}
@@ -421,13 +586,11 @@ func TempDir(dir, pattern string, fs vfs.IFS) (name string, err error) {
 
 	nconflict := 0
 	for i := 0; i < 10000; i++ {
-		try := fs.PathJoin(dir, prefix+nextRandom()+suffix)
+		try := fs.PathJoin(dir, prefix+gen.next()+suffix)
 		err = fs.MkdirAll(try, 0700)
 		if oserror.IsExist(err) {
 			if nconflict++; nconflict > 10 {
-				randmu.Lock()
-				rand = reseed()
-				randmu.Unlock()
+				gen.conflict()
 			}
 			continue
 		}
@@ -15,8 +15,14 @@
 package fileutil
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"path/filepath"
+	"syscall"
 	"testing"
 
+	"github.com/cockroachdb/errors"
 	"github.com/stretchr/testify/require"
 
 	"github.com/zuoyebang/bitalostored/raft/internal/vfs"
@@ -29,3 +35,172 @@ func TestTempDir(t *testing.T) {
 	require.NoError(t, err)
 	require.NotEqual(t, dir1, dir2)
 }
+
+func makeTestTar(t *testing.T, files map[string]int) *bytes.Buffer {
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{
+		Name:     "data",
+		Typeflag: tar.TypeDir,
+		Mode:     0750,
+	}))
+	for name, size := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Name:     "data/" + name,
+			Typeflag: tar.TypeReg,
+			Mode:     0640,
+			Size:     int64(size),
+		}))
+		_, err := tw.Write(make([]byte, size))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return buf
+}
+
+func TestExtractTarLimit(t *testing.T) {
+	files := map[string]int{"a": 1024, "b": 2048, "c": 4096}
+	tests := []struct {
+		limit ExtractLimit
+		ok    bool
+	}{
+		{ExtractLimit{}, true},
+		{ExtractLimit{MaxBytes: 7168, MaxFiles: 3}, true},
+		{ExtractLimit{MaxBytes: 7167}, false},
+		{ExtractLimit{MaxBytes: 1024}, false},
+		{ExtractLimit{MaxFiles: 2}, false},
+	}
+	for idx, tt := range tests {
+		fs := vfs.NewMemFS()
+		require.NoError(t, fs.MkdirAll("target", 0750))
+		tr := tar.NewReader(makeTestTar(t, files))
+		err := extractTar(tr, "target", fs, tt.limit)
+		if tt.ok {
+			require.NoError(t, err, "idx %d", idx)
+			for name, size := range files {
+				fi, err := fs.Stat(fs.PathJoin("target", "data", name))
+				require.NoError(t, err)
+				require.Equal(t, int64(size), fi.Size())
+			}
+		} else {
+			require.True(t, errors.Is(err, ErrExtractLimitExceeded), "idx %d err %v", idx, err)
+		}
+	}
+}
+
+func TestVerifyArchive(t *testing.T) {
+	fs := vfs.NewMemFS()
+	files := map[string]int{"a": 1024, "b": 2048, "c": 4096}
+
+	data := makeTestTar(t, files).Bytes()
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	_, err := zw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	write := func(fn string, content []byte) {
+		f, err := fs.Create(fn)
+		require.NoError(t, err)
+		_, err = f.Write(content)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+
+	write("good.tar", data)
+	write("good.tar.gz", gz.Bytes())
+	for _, fn := range []string{"good.tar", "good.tar.gz"} {
+		entries, totalBytes, err := VerifyArchive(fn, fs)
+		require.NoError(t, err, fn)
+		require.Equal(t, len(files)+1, entries, fn)
+		require.Equal(t, int64(7168), totalBytes, fn)
+	}
+
+	write("truncated.tar", data[:2148])
+	write("truncated.tar.gz", gz.Bytes()[:gz.Len()/2])
+	for _, fn := range []string{"truncated.tar", "truncated.tar.gz"} {
+		_, _, err := VerifyArchive(fn, fs)
+		require.Error(t, err, fn)
+	}
+}
+
+type syncErrorFile struct {
+	vfs.File
+	fs *syncErrorFS
+}
+
+func (f *syncErrorFile) Sync() error {
+	f.fs.syncs++
+	if f.fs.failures > 0 {
+		f.fs.failures--
+		return f.fs.err
+	}
+	return f.File.Sync()
+}
+
+type syncErrorFS struct {
+	vfs.IFS
+	err      error
+	failures int
+	syncs    int
+}
+
+func (fs *syncErrorFS) OpenDir(name string) (vfs.File, error) {
+	f, err := fs.IFS.OpenDir(name)
+	if err != nil {
+		return nil, err
+	}
+	return &syncErrorFile{File: f, fs: fs}, nil
+}
+
+func TestSyncDirRetry(t *testing.T) {
+	memfs := vfs.NewMemFS()
+	require.NoError(t, memfs.MkdirAll("dir", 0750))
+
+	fs := &syncErrorFS{IFS: memfs, err: syscall.EINTR, failures: 2}
+	require.NoError(t, SyncDir("dir", fs))
+	require.Equal(t, 3, fs.syncs)
+
+	fs = &syncErrorFS{IFS: memfs, err: syscall.EAGAIN, failures: syncRetryTimes + 1}
+	require.True(t, errors.Is(SyncDir("dir", fs), syscall.EAGAIN))
+	require.Equal(t, syncRetryTimes+1, fs.syncs)
+
+	fs = &syncErrorFS{IFS: memfs, err: syscall.ENOSPC, failures: 1}
+	require.True(t, errors.Is(SyncDir("dir", fs), syscall.ENOSPC))
+	require.Equal(t, 1, fs.syncs)
+}
+
+func TestSeededNameGenerator(t *testing.T) {
+	g1 := newSeededNameGenerator(42)
+	g2 := newSeededNameGenerator(42)
+	g3 := newSeededNameGenerator(43)
+	diff := false
+	for i := 0; i < 100; i++ {
+		n1, n2, n3 := g1.next(), g2.next(), g3.next()
+		require.Equal(t, n1, n2)
+		if n1 != n3 {
+			diff = true
+		}
+	}
+	require.True(t, diff)
+}
+
+func TestTempSeeded(t *testing.T) {
+	fs := vfs.NewMemFS()
+	require.NoError(t, fs.MkdirAll("d1", 0750))
+	require.NoError(t, fs.MkdirAll("d2", 0750))
+
+	name1, err := TempDirSeeded("d1", "snapshot-*", 7, fs)
+	require.NoError(t, err)
+	name2, err := TempDirSeeded("d2", "snapshot-*", 7, fs)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Base(name1), filepath.Base(name2))
+
+	f1, fn1, err := TempFileSeeded("d1", "tmp-*.data", 7, fs)
+	require.NoError(t, err)
+	require.NoError(t, f1.Close())
+	f2, fn2, err := TempFileSeeded("d2", "tmp-*.data", 7, fs)
+	require.NoError(t, err)
+	require.NoError(t, f2.Close())
+	require.Equal(t, filepath.Base(fn1), filepath.Base(fn2))
+}
@@ -351,7 +351,7 @@ func (be *batchedEntries) getBatchFromDB(clusterID uint64,
 	k := be.keys.get()
 	defer k.Release()
 	k.SetEntryBatchKey(clusterID, nodeID, batchID)
-	if err := be.kvs.GetValue(k.Key(), func(data []byte) error {
+	if err := be.kvs.GetValue(k.Key(), func(data []byte, _ bool) error {
 		if len(data) == 0 {
 			return errors.New("no such entry")
 		}
@@ -115,6 +115,10 @@ func (r *db) close() error {
 	return r.kvs.Close()
 }
 
+func (r *db) flush() error {
+	return r.kvs.Flush()
+}
+
 func (r *db) getWriteBatch(ctx IContext) kv.IWriteBatch {
 	if ctx != nil {
 		wb := ctx.GetWriteBatch()
@@ -331,8 +335,8 @@ func (r *db) getBootstrapInfo(clusterID uint64,
 	k := newKey(maxKeySize, nil)
 	k.setBootstrapKey(clusterID, nodeID)
 	bootstrap := pb.Bootstrap{}
-	if err := r.kvs.GetValue(k.Key(), func(data []byte) error {
-		if len(data) == 0 {
+	if err := r.kvs.GetValue(k.Key(), func(data []byte, found bool) error {
+		if !found {
 			return raftio.ErrNoBootstrapInfo
 		}
 		pb.MustUnmarshal(&bootstrap, data)
@@ -407,7 +411,7 @@ func (r *db) getMaxIndex(clusterID uint64, nodeID uint64) (uint64, error) {
 	defer k.Release()
 	k.SetMaxIndexKey(clusterID, nodeID)
 	maxIndex := uint64(0)
-	if err := r.kvs.GetValue(k.Key(), func(data []byte) error {
+	if err := r.kvs.GetValue(k.Key(), func(data []byte, _ bool) error {
 		if len(data) == 0 {
 			return raftio.ErrNoSavedLog
 		}
@@ -424,7 +428,7 @@ func (r *db) getState(clusterID uint64, nodeID uint64) (pb.State, error) {
 	defer k.Release()
 	k.SetStateKey(clusterID, nodeID)
 	hs := pb.State{}
-	if err := r.kvs.GetValue(k.Key(), func(data []byte) error {
+	if err := r.kvs.GetValue(k.Key(), func(data []byte, _ bool) error {
 		if len(data) == 0 {
 			return raftio.ErrNoSavedLog
 		}
@@ -116,6 +116,36 @@ func runBatchedLogDBTest(t *testing.T, tf func(t *testing.T, db raftio.ILogDB),
 	runLogDBTestAs(t, true, tf, fs)
 }
 
+func TestLogDBName(t *testing.T) {
+	fs := vfs.GetTestFS()
+	tf := func(t *testing.T, db raftio.ILogDB) {
+		if name := db.Name(); name != "sharded-bitable" {
+			t.Errorf("unexpected name %s", name)
+		}
+		if name := NewDefaultFactory().Name(); name != db.Name() {
+			t.Errorf("factory name %s, logdb name %s", name, db.Name())
+		}
+	}
+	runLogDBTestAs(t, false, tf, fs)
+}
+
+func TestLogDBFlush(t *testing.T) {
+	fs := vfs.GetTestFS()
+	tf := func(t *testing.T, db raftio.ILogDB) {
+		bs := pb.Bootstrap{Addresses: map[uint64]string{1: "address1"}}
+		if err := db.SaveBootstrapInfo(1, 1, bs); err != nil {
+			t.Fatalf("failed to save bootstrap info %v", err)
+		}
+		if err := db.(*ShardedDB).Flush(); err != nil {
+			t.Fatalf("flush failed %v", err)
+		}
+		if _, err := db.GetBootstrapInfo(1, 1); err != nil {
+			t.Errorf("failed to get bootstrap info after flush %v", err)
+		}
+	}
+	runLogDBTest(t, tf, fs)
+}
+
 func TestRDBReturnErrNoBootstrapInfoWhenNoBootstrap(t *testing.T) {
 	fs := vfs.GetTestFS()
 	tf := func(t *testing.T, db raftio.ILogDB) {
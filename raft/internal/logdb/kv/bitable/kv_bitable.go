@@ -20,8 +20,10 @@ import (
 	"sync"
 	"time"
 
+	"github.com/cockroachdb/errors"
 	"github.com/lni/goutils/syncutil"
 	bitable "github.com/zuoyebang/bitalostable"
+	"github.com/zuoyebang/bitalostable/bloom"
 	"github.com/zuoyebang/bitalostored/raft/config"
 	"github.com/zuoyebang/bitalostored/raft/internal/fileutil"
 	"github.com/zuoyebang/bitalostored/raft/internal/logdb/kv"
@@ -36,9 +38,16 @@ var plog = logger.GetLogger("bitablekv")
 
 var firstError = utils.FirstError
 
+var (
+	errUnknownWriteBatch   = errors.New("unknown write batch type")
+	errWriteBatchMismatch  = errors.New("write batch belongs to another db")
+	errDuplicateWriteBatch = errors.New("duplicate write batch")
+)
+
 type eventListener struct {
 	kv      *KV
 	stopper *syncutil.Stopper
+	metrics metrics
 }
 
 func (l *eventListener) close() {
@@ -65,11 +74,29 @@ func (l *eventListener) notify() {
 
 func (l *eventListener) onCompactionEnd(info bitable.CompactionInfo) {
 	plog.Infof("%s %s", bitableLogTag, info)
+	if info.Err == nil {
+		var bytes uint64
+		for _, t := range info.Output.Tables {
+			bytes += t.Size
+		}
+		l.metrics.compactionCount.Add(1)
+		l.metrics.compactionDuration.Add(int64(info.Duration))
+		l.metrics.compactionBytes.Add(bytes)
+	}
 	l.notify()
 }
 
 func (l *eventListener) onFlushEnd(info bitable.FlushInfo) {
 	plog.Infof("%s %s", bitableLogTag, info)
+	if info.Err == nil {
+		var bytes uint64
+		for _, t := range info.Output {
+			bytes += t.Size
+		}
+		l.metrics.flushCount.Add(1)
+		l.metrics.flushDuration.Add(int64(info.Duration))
+		l.metrics.flushBytes.Add(bytes)
+	}
 	l.notify()
 }
 
@@ -186,22 +213,10 @@ var _ kv.IKVStore = (*KV)(nil)
 
 var bitableWarning sync.Once
 
-func openBitableDB(config config.LogDBConfig, callback kv.LogDBCallback,
-	dir string, walDir string, fs vfs.IFS) (kv.IKVStore, error) {
-	if config.IsEmpty() {
-		panic("invalid LogDBConfig")
-	}
-	bitableWarning.Do(func() {
-		if fs == vfs.MemStrictFS {
-			plog.Warningf("running in bitable memfs test mode")
-		}
-	})
+func getLevelOptions(config config.LogDBConfig) []bitable.LevelOptions {
 	//blockSize := int(config.KVBlockSize)
 	blockSize := 128 << 10
-	writeBufferSize := 128 << 20
 	targetFileSizeBase := int64(128 << 20)
-	//cacheSize := int64(config.KVLRUCacheSize)
-	cacheSize := int64(0)
 	//levelSizeMultiplier := int64(config.KVTargetFileSizeMultiplier)
 	levelSizeMultiplier := int64(2)
 	//numOfLevels := int64(config.KVNumOfLevels)
@@ -214,9 +229,31 @@ func openBitableDB(config config.LogDBConfig, callback kv.LogDBCallback,
 			BlockSize:      blockSize,
 			TargetFileSize: sz,
 		}
+		if config.KVBloomFilterBitsPerKey > 0 {
+			opt.FilterPolicy = bloom.FilterPolicy(config.KVBloomFilterBitsPerKey)
+			opt.FilterType = bitable.TableFilter
+		}
 		sz = sz * levelSizeMultiplier
 		lopts = append(lopts, opt)
 	}
+	return lopts
+}
+
+func openBitableDB(config config.LogDBConfig, callback kv.LogDBCallback,
+	dir string, walDir string, fs vfs.IFS) (kv.IKVStore, error) {
+	if config.IsEmpty() {
+		panic("invalid LogDBConfig")
+	}
+	bitableWarning.Do(func() {
+		if fs == vfs.MemStrictFS {
+			plog.Warningf("running in bitable memfs test mode")
+		}
+	})
+	//blockSize := int(config.KVBlockSize)
+	writeBufferSize := 128 << 20
+	//cacheSize := int64(config.KVLRUCacheSize)
+	cacheSize := int64(0)
+	lopts := getLevelOptions(config)
 	if inMonkeyTesting {
 		writeBufferSize = 4 << 20
 	}
@@ -235,6 +272,10 @@ func openBitableDB(config config.LogDBConfig, callback kv.LogDBCallback,
 		Logger:                      bitableLogger{},
 		LogTag:                      bitableLogTag,
 		MaxOpenFiles:                8000,
+		DisableWAL:                  config.KVDisableWAL,
+	}
+	if config.KVMaxConcurrentCompactions > 0 {
+		opts.MaxConcurrentCompactions = int(config.KVMaxConcurrentCompactions)
 	}
 	if fs != vfs.DefaultFS {
 		opts.FS = vfs.NewBitableFS(fs)
@@ -256,7 +297,7 @@ func openBitableDB(config config.LogDBConfig, callback kv.LogDBCallback,
 		FlushEnd:      event.onFlushEnd,
 		CompactionEnd: event.onCompactionEnd,
 	}
-	if len(walDir) > 0 {
+	if len(walDir) > 0 && !opts.DisableWAL {
 		if err := fileutil.MkdirAll(walDir, fs); err != nil {
 			return nil, err
 		}
@@ -293,11 +334,26 @@ func (r *KV) setEventListener(event *eventListener) {
 
 // Name returns the IKVStore type name.
 func (r *KV) Name() string {
-	return "pebble"
+	return "bitable"
+}
+
+// Metrics returns the flush and compaction totals since the store was opened.
+func (r *KV) Metrics() Metrics {
+	return r.event.metrics.load()
+}
+
+// Flush flushes the memtable and waits for the flushed tables to be synced.
+func (r *KV) Flush() error {
+	return r.db.Flush()
 }
 
 // Close closes the RDB object.
 func (r *KV) Close() error {
+	if r.opts.DisableWAL {
+		if err := r.db.Flush(); err != nil {
+			return err
+		}
+	}
 	if err := r.db.Close(); err != nil {
 		return err
 	}
@@ -344,17 +400,32 @@ func (r *KV) IterateValue(fk []byte, lk []byte, inc bool,
 }
 
 // GetValue ...
-func (r *KV) GetValue(key []byte, op func([]byte) error) (err error) {
+func (r *KV) GetValue(key []byte, op func(val []byte, found bool) error) (err error) {
 	val, closer, err := r.db.Get(key)
 	if err != nil && err != bitable.ErrNotFound {
 		return err
 	}
+	found := err == nil
 	defer func() {
 		if closer != nil {
 			err = firstError(err, closer.Close())
 		}
 	}()
-	return op(val)
+	return op(val, found)
+}
+
+// GetPrevValue ...
+func (r *KV) GetPrevValue(key []byte,
+	op func(prevKey []byte, val []byte, found bool) error) (err error) {
+	iter := r.db.NewIter(r.ro)
+	defer func() {
+		err = firstError(err, iter.Close())
+	}()
+	iter.SeekLT(key)
+	if iteratorIsValid(iter) {
+		return op(iter.Key(), iter.Value(), true)
+	}
+	return op(nil, nil, false)
 }
 
 // SaveValue ...
@@ -388,6 +459,41 @@ func (r *KV) CommitWriteBatch(wb kv.IWriteBatch) error {
 	return r.db.Apply(pwb.wb, r.wo)
 }
 
+// MergeBatches ...
+func (r *KV) MergeBatches(batches ...kv.IWriteBatch) (kv.IWriteBatch, error) {
+	pwbs := make([]*bitableWriteBatch, 0, len(batches))
+	for _, wb := range batches {
+		pwb, ok := wb.(*bitableWriteBatch)
+		if !ok {
+			return nil, errUnknownWriteBatch
+		}
+		if pwb.db != r.db {
+			return nil, errWriteBatchMismatch
+		}
+		for _, p := range pwbs {
+			if p == pwb {
+				return nil, errDuplicateWriteBatch
+			}
+		}
+		pwbs = append(pwbs, pwb)
+	}
+
+	merged := r.db.NewBatch()
+	for _, pwb := range pwbs {
+		if err := merged.Apply(pwb.wb, r.wo); err != nil {
+			return nil, firstError(err, merged.Close())
+		}
+	}
+	for _, pwb := range pwbs {
+		pwb.Destroy()
+	}
+	return &bitableWriteBatch{
+		wb: merged,
+		db: r.db,
+		wo: r.wo,
+	}, nil
+}
+
 // BulkRemoveEntries ...
 func (r *KV) BulkRemoveEntries(fk []byte, lk []byte) (err error) {
 	wb := r.db.NewBatch()
@@ -0,0 +1,141 @@
+// Copyright 2017-2021 Bitalostored author and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bitable
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lni/goutils/syncutil"
+	"github.com/stretchr/testify/require"
+	bitable "github.com/zuoyebang/bitalostable"
+	"github.com/zuoyebang/bitalostable/bloom"
+
+	"github.com/zuoyebang/bitalostored/raft/config"
+	"github.com/zuoyebang/bitalostored/raft/internal/vfs"
+)
+
+func TestBloomFilterLevelOptions(t *testing.T) {
+	cfg := config.GetDefaultLogDBConfig()
+	for _, opt := range getLevelOptions(cfg) {
+		require.Nil(t, opt.FilterPolicy)
+	}
+
+	cfg.KVBloomFilterBitsPerKey = 10
+	lopts := getLevelOptions(cfg)
+	require.Equal(t, 7, len(lopts))
+	for _, opt := range lopts {
+		require.Equal(t, bloom.FilterPolicy(10), opt.FilterPolicy)
+		require.Equal(t, bitable.TableFilter, opt.FilterType)
+	}
+
+	fs := vfs.GetTestFS()
+	dir := "bloom_filter_test_dir"
+	defer func() {
+		require.NoError(t, fs.RemoveAll(dir))
+	}()
+	kvs, err := openBitableDB(cfg, nil, dir, "", fs)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, kvs.Close())
+	}()
+	r := kvs.(*KV)
+	for _, opt := range r.opts.Levels {
+		require.Equal(t, bloom.FilterPolicy(10), opt.FilterPolicy)
+	}
+
+	require.NoError(t, kvs.SaveValue([]byte("key"), []byte("val")))
+	require.NoError(t, kvs.GetValue([]byte("missing"), func(val []byte, found bool) error {
+		require.Nil(t, val)
+		require.False(t, found)
+		return nil
+	}))
+}
+
+func TestDisableWAL(t *testing.T) {
+	cfg := config.GetDefaultLogDBConfig()
+	require.False(t, cfg.KVDisableWAL)
+	cfg.KVDisableWAL = true
+
+	fs := vfs.GetTestFS()
+	dir := "disable_wal_test_dir"
+	defer func() {
+		require.NoError(t, fs.RemoveAll(dir))
+	}()
+	kvs, err := openBitableDB(cfg, nil, dir, "", fs)
+	require.NoError(t, err)
+	require.True(t, kvs.(*KV).opts.DisableWAL)
+	require.NoError(t, kvs.SaveValue([]byte("key"), []byte("val")))
+	require.NoError(t, kvs.Close())
+
+	kvs, err = openBitableDB(cfg, nil, dir, "", fs)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, kvs.Close())
+	}()
+	require.NoError(t, kvs.GetValue([]byte("key"), func(val []byte, found bool) error {
+		require.True(t, found)
+		require.Equal(t, []byte("val"), val)
+		return nil
+	}))
+}
+
+func TestMaxConcurrentCompactions(t *testing.T) {
+	cfg := config.GetDefaultLogDBConfig()
+	require.Equal(t, uint64(0), cfg.KVMaxConcurrentCompactions)
+	cfg.KVMaxConcurrentCompactions = 3
+
+	fs := vfs.GetTestFS()
+	dir := "max_concurrent_compactions_test_dir"
+	defer func() {
+		require.NoError(t, fs.RemoveAll(dir))
+	}()
+	kvs, err := openBitableDB(cfg, nil, dir, "", fs)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, kvs.Close())
+	}()
+	require.Equal(t, 3, kvs.(*KV).opts.MaxConcurrentCompactions)
+}
+
+func TestEventListenerMetrics(t *testing.T) {
+	l := &eventListener{
+		kv:      &KV{dbSet: make(chan struct{})},
+		stopper: syncutil.NewStopper(),
+	}
+	defer l.close()
+
+	for i := 0; i < 3; i++ {
+		l.onFlushEnd(bitable.FlushInfo{
+			Duration: 10 * time.Millisecond,
+			Output:   []bitable.TableInfo{{Size: 100}, {Size: 20}},
+		})
+	}
+	l.onFlushEnd(bitable.FlushInfo{Duration: time.Second, Err: errors.New("flush failed")})
+	for i := 0; i < 2; i++ {
+		info := bitable.CompactionInfo{Duration: time.Second}
+		info.Output.Tables = []bitable.TableInfo{{Size: 1 << 20}}
+		l.onCompactionEnd(info)
+	}
+
+	m := l.metrics.load()
+	require.Equal(t, uint64(3), m.FlushCount)
+	require.Equal(t, 30*time.Millisecond, m.FlushDuration)
+	require.Equal(t, uint64(360), m.FlushBytes)
+	require.Equal(t, uint64(2), m.CompactionCount)
+	require.Equal(t, 2*time.Second, m.CompactionDuration)
+	require.Equal(t, uint64(2<<20), m.CompactionBytes)
+}
@@ -0,0 +1,51 @@
+// Copyright 2017-2021 Bitalostored author and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bitable
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Metrics is the accumulated flush and compaction activity of the log db,
+// Bytes counts the size of the sstables written.
+type Metrics struct {
+	FlushCount         uint64
+	FlushDuration      time.Duration
+	FlushBytes         uint64
+	CompactionCount    uint64
+	CompactionDuration time.Duration
+	CompactionBytes    uint64
+}
+
+type metrics struct {
+	flushCount         atomic.Uint64
+	flushDuration      atomic.Int64
+	flushBytes         atomic.Uint64
+	compactionCount    atomic.Uint64
+	compactionDuration atomic.Int64
+	compactionBytes    atomic.Uint64
+}
+
+func (m *metrics) load() Metrics {
+	return Metrics{
+		FlushCount:         m.flushCount.Load(),
+		FlushDuration:      time.Duration(m.flushDuration.Load()),
+		FlushBytes:         m.flushBytes.Load(),
+		CompactionCount:    m.compactionCount.Load(),
+		CompactionDuration: time.Duration(m.compactionDuration.Load()),
+		CompactionBytes:    m.compactionBytes.Load(),
+	}
+}
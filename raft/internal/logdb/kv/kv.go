@@ -56,8 +56,15 @@ type IKVStore interface {
 	IterateValue(fk []byte,
 		lk []byte, inc bool, op func(key []byte, data []byte) (bool, error)) error
 	// GetValue queries the value specified the input key, the returned value
-	// byte slice is passed to the specified op func.
-	GetValue(key []byte, op func([]byte) error) error
+	// byte slice is passed to the specified op func. found is false when the
+	// key has never been written, an empty value saved by SaveValue is passed
+	// with found set to true.
+	GetValue(key []byte, op func(val []byte, found bool) error) error
+	// GetPrevValue looks up the largest key strictly less than the input key
+	// and passes it with its value to op. found is false when no such key
+	// exists.
+	GetPrevValue(key []byte,
+		op func(prevKey []byte, val []byte, found bool) error) error
 	// Save value saves the specified key value pair to the underlying key-value
 	// pair.
 	SaveValue(key []byte, value []byte) error
@@ -68,6 +75,9 @@ type IKVStore interface {
 	// CommitWriteBatch atomically writes everything included in the write batch
 	// to the underlying key-value store.
 	CommitWriteBatch(wb IWriteBatch) error
+	// MergeBatches combines the operations of the input write batches into a
+	// single batch that commits atomically. The input batches are destroyed.
+	MergeBatches(batches ...IWriteBatch) (IWriteBatch, error)
 	// BulkRemoveEntries removes entries specified by the range [firstKey,
 	// lastKey). BulkRemoveEntries is called in the main execution thread of raft,
 	// it is suppose to immediately return without significant delay.
@@ -78,4 +88,6 @@ type IKVStore interface {
 	CompactEntries(firstKey []byte, lastKey []byte) error
 	// FullCompaction compact the entire key space.
 	FullCompaction() error
+	// Flush persists the memtable to disk and returns once it is synced.
+	Flush() error
 }
@@ -0,0 +1,56 @@
+// Copyright 2017-2021 Bitalostored author and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kv
+
+import (
+	"github.com/juju/ratelimit"
+)
+
+// minLimiterBurst keeps a single large entry from stalling on a bucket that
+// can never hold it.
+const minLimiterBurst = 64 << 10
+
+// Limiter paces byte consumption. Wait blocks until n bytes may be used.
+// *ratelimit.Bucket satisfies it.
+type Limiter interface {
+	Wait(n int64)
+}
+
+// NewRateLimiter returns a token bucket limiter allowing bytesPerSecond with
+// a burst of a tenth of a second, or nil when bytesPerSecond is not positive.
+func NewRateLimiter(bytesPerSecond int64) Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	burst := bytesPerSecond / 10
+	if burst < minLimiterBurst {
+		burst = minLimiterBurst
+	}
+	return ratelimit.NewBucketWithRate(float64(bytesPerSecond), burst)
+}
+
+// IterateValueWithLimit behaves like IKVStore.IterateValue but charges the
+// key and value size of each entry to limiter before op is invoked, so a slow
+// consumer is not flooded. A nil limiter does not pace.
+func IterateValueWithLimit(kvs IKVStore, fk []byte, lk []byte, inc bool,
+	limiter Limiter, op func(key []byte, data []byte) (bool, error)) error {
+	if limiter == nil {
+		return kvs.IterateValue(fk, lk, inc, op)
+	}
+	return kvs.IterateValue(fk, lk, inc, func(key []byte, data []byte) (bool, error) {
+		limiter.Wait(int64(len(key) + len(data)))
+		return op(key, data)
+	})
+}
@@ -21,6 +21,7 @@ import (
 	"math/rand"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/cockroachdb/errors"
 	"github.com/lni/goutils/leaktest"
@@ -69,7 +70,7 @@ func TestKVGetAndSet(t *testing.T) {
 		}
 		found := false
 		opcalled := false
-		op := func(val []byte) error {
+		op := func(val []byte, _ bool) error {
 			opcalled = true
 			if string(val) == "test-value" {
 				found = true
@@ -90,6 +91,40 @@ func TestKVGetAndSet(t *testing.T) {
 	runKVTest(t, tf, fs)
 }
 
+func TestKVEmptyValueIsFound(t *testing.T) {
+	tf := func(t *testing.T, kvs kv.IKVStore) {
+		if err := kvs.SaveValue([]byte("empty-key"), []byte{}); err != nil {
+			t.Errorf("failed to save the value")
+		}
+		for _, tt := range []struct {
+			key   string
+			found bool
+		}{
+			{"empty-key", true},
+			{"missing-key", false},
+		} {
+			opcalled := false
+			if err := kvs.GetValue([]byte(tt.key), func(val []byte, found bool) error {
+				opcalled = true
+				if len(val) != 0 {
+					t.Errorf("%s, unexpected value %v", tt.key, val)
+				}
+				if found != tt.found {
+					t.Errorf("%s, found %t, want %t", tt.key, found, tt.found)
+				}
+				return nil
+			}); err != nil {
+				t.Errorf("get value failed %v", err)
+			}
+			if !opcalled {
+				t.Errorf("op func not called")
+			}
+		}
+	}
+	fs := vfs.GetTestFS()
+	runKVTest(t, tf, fs)
+}
+
 func TestKVValueCanBeDeleted(t *testing.T) {
 	tf := func(t *testing.T, kvs kv.IKVStore) {
 		if err := kvs.SaveValue([]byte("test-key"), []byte("test-value")); err != nil {
@@ -100,7 +135,7 @@ func TestKVValueCanBeDeleted(t *testing.T) {
 		}
 		found := false
 		opcalled := false
-		op := func(val []byte) error {
+		op := func(val []byte, _ bool) error {
 			opcalled = true
 			if string(val) == "test-value" {
 				found = true
@@ -134,7 +169,7 @@ func TestKVWriteBatch(t *testing.T) {
 		}
 		found := false
 		opcalled := false
-		op := func(val []byte) error {
+		op := func(val []byte, _ bool) error {
 			opcalled = true
 			if string(val) == "test-value" {
 				found = true
@@ -186,6 +221,156 @@ func TestKVIterateValue(t *testing.T) {
 	testKVIterateValue(t, []byte("key0"), []byte("key5"), false, 5)
 }
 
+func TestKVIterateValueWithLimit(t *testing.T) {
+	tf := func(t *testing.T, kvs kv.IKVStore) {
+		val := make([]byte, 1024)
+		for i := 0; i < 256; i++ {
+			if err := kvs.SaveValue([]byte(fmt.Sprintf("key%03d", i)), val); err != nil {
+				t.Fatalf("failed to save the value %v", err)
+			}
+		}
+		iterate := func(limiter kv.Limiter) (time.Duration, int) {
+			count := 0
+			op := func(k []byte, v []byte) (bool, error) {
+				count++
+				return true, nil
+			}
+			start := time.Now()
+			if err := kv.IterateValueWithLimit(kvs, []byte("key000"), []byte("key255"),
+				true, limiter, op); err != nil {
+				t.Fatalf("iterate value failed %v", err)
+			}
+			return time.Since(start), count
+		}
+		unlimited, count := iterate(nil)
+		if count != 256 {
+			t.Fatalf("op called %d times, want 256", count)
+		}
+		// ~257KB at 512KB/s with a 64KB burst takes about 380ms.
+		limited, count := iterate(kv.NewRateLimiter(512 << 10))
+		if count != 256 {
+			t.Fatalf("op called %d times, want 256", count)
+		}
+		if limited < 250*time.Millisecond || limited < 2*unlimited {
+			t.Errorf("limited iteration took %v, unlimited %v", limited, unlimited)
+		}
+	}
+	fs := vfs.GetTestFS()
+	runKVTest(t, tf, fs)
+}
+
+func TestKVGetPrevValue(t *testing.T) {
+	tf := func(t *testing.T, kvs kv.IKVStore) {
+		for _, i := range []int{2, 4, 6} {
+			key := fmt.Sprintf("key%d", i)
+			val := fmt.Sprintf("val%d", i)
+			if err := kvs.SaveValue([]byte(key), []byte(val)); err != nil {
+				t.Fatalf("failed to save the value %v", err)
+			}
+		}
+		tests := []struct {
+			key     string
+			prevKey string
+			found   bool
+		}{
+			{"key1", "", false},
+			{"key2", "", false},
+			{"key3", "key2", true},
+			{"key4", "key2", true},
+			{"key5", "key4", true},
+			{"key6", "key4", true},
+			{"key7", "key6", true},
+		}
+		for idx, tt := range tests {
+			opcalled := false
+			op := func(prevKey []byte, val []byte, found bool) error {
+				opcalled = true
+				if found != tt.found {
+					t.Errorf("%d, found %t, want %t", idx, found, tt.found)
+				}
+				if string(prevKey) != tt.prevKey {
+					t.Errorf("%d, prev key %s, want %s", idx, prevKey, tt.prevKey)
+				}
+				if found && string(val) != strings.Replace(tt.prevKey, "key", "val", 1) {
+					t.Errorf("%d, unexpected value %s", idx, val)
+				}
+				return nil
+			}
+			if err := kvs.GetPrevValue([]byte(tt.key), op); err != nil {
+				t.Fatalf("get prev value failed %v", err)
+			}
+			if !opcalled {
+				t.Errorf("%d, op not called", idx)
+			}
+		}
+	}
+	fs := vfs.GetTestFS()
+	runKVTest(t, tf, fs)
+}
+
+type testUnknownWriteBatch struct {
+	kv.IWriteBatch
+}
+
+func TestKVMergeBatches(t *testing.T) {
+	tf := func(t *testing.T, kvs kv.IKVStore) {
+		if err := kvs.SaveValue([]byte("key-0"), []byte("val-0")); err != nil {
+			t.Fatalf("failed to save the value %v", err)
+		}
+		wb1 := kvs.GetWriteBatch()
+		wb1.Put([]byte("key-1"), []byte("val-1"))
+		wb1.Put([]byte("key-2"), []byte("val-2"))
+		wb2 := kvs.GetWriteBatch()
+		wb2.Put([]byte("key-3"), []byte("val-3"))
+		wb2.Delete([]byte("key-0"))
+
+		if _, err := kvs.MergeBatches(wb1, testUnknownWriteBatch{}); err == nil {
+			t.Fatalf("merging unknown batch type not rejected")
+		}
+		if _, err := kvs.MergeBatches(wb1, wb1); err == nil {
+			t.Fatalf("merging duplicate batch not rejected")
+		}
+		wb, err := kvs.MergeBatches(wb1, wb2)
+		if err != nil {
+			t.Fatalf("failed to merge batches %v", err)
+		}
+		defer wb.Destroy()
+		if wb.Count() != 4 {
+			t.Errorf("unexpected count %d, want 4", wb.Count())
+		}
+		for _, key := range []string{"key-1", "key-3"} {
+			if err := kvs.GetValue([]byte(key), func(val []byte, _ bool) error {
+				if len(val) != 0 {
+					t.Errorf("%s written before commit", key)
+				}
+				return nil
+			}); err != nil {
+				t.Fatalf("get value failed %v", err)
+			}
+		}
+		if err := kvs.CommitWriteBatch(wb); err != nil {
+			t.Fatalf("failed to commit write batch %v", err)
+		}
+		for i := 0; i < 4; i++ {
+			key := fmt.Sprintf("key-%d", i)
+			want := fmt.Sprintf("val-%d", i)
+			if i == 0 {
+				want = ""
+			}
+			if err := kvs.GetValue([]byte(key), func(val []byte, _ bool) error {
+				if string(val) != want {
+					t.Errorf("%s, got %s, want %s", key, val, want)
+				}
+				return nil
+			}); err != nil {
+				t.Fatalf("get value failed %v", err)
+			}
+		}
+	}
+	fs := vfs.GetTestFS()
+	runKVTest(t, tf, fs)
+}
+
 func TestWriteBatchCanBeCleared(t *testing.T) {
 	tf := func(t *testing.T, kvs kv.IKVStore) {
 		wb := kvs.GetWriteBatch()
@@ -199,7 +384,7 @@ func TestWriteBatchCanBeCleared(t *testing.T) {
 			t.Fatalf("failed to commit write batch")
 		}
 		if err := kvs.GetValue([]byte("key-1"),
-			func(data []byte) error {
+			func(data []byte, _ bool) error {
 				if len(data) != 0 {
 					t.Fatalf("unexpected value")
 				}
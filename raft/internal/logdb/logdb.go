@@ -89,7 +89,7 @@ func (f *DefaultFactory) Create(cfg config.NodeHostConfig,
 
 // Name returns the name of the default LogDB instance.
 func (f *DefaultFactory) Name() string {
-	return "sharded-pebble"
+	return "sharded-bitable"
 }
 
 // NewDefaultLogDB creates a Log DB instance using the default KV store
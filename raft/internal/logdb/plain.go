@@ -107,7 +107,7 @@ func (pe *plainEntries) getEntry(clusterID uint64,
 	defer k.Release()
 	k.SetEntryKey(clusterID, nodeID, index)
 	var e pb.Entry
-	op := func(data []byte) error {
+	op := func(data []byte, _ bool) error {
 		pb.MustUnmarshal(&e, data)
 		return nil
 	}
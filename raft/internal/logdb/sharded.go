@@ -157,6 +157,16 @@ func (s *ShardedDB) SelfCheckFailed() (bool, error) {
 	return false, nil
 }
 
+// Flush flushes the memtables of all db shards to disk.
+func (s *ShardedDB) Flush() error {
+	for _, shard := range s.shards {
+		if err := shard.flush(); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
 // SaveRaftState saves the raft state and logs found in the raft.Update list
 // to the log db.
 func (s *ShardedDB) SaveRaftState(updates []pb.Update, shardID uint64) error {
@@ -86,6 +86,11 @@ func Launch(config config.Config,
 //	p.raft.setLogCommitedIndex(idx)
 //}
 
+// CommittedIndex returns the index of the last committed log entry.
+func (p *Peer) CommittedIndex() uint64 {
+	return p.raft.log.committed
+}
+
 func (p *Peer) HasSnapshot() bool {
 	return p.raft.hasSnapshot
 }
@@ -355,7 +355,11 @@ func compatibleLogDBType(saved string, name string) bool {
 		(saved == "rocksdb" && name == "pebble") ||
 		(saved == "pebble" && name == "rocksdb") ||
 		(saved == "sharded-pebble" && name == "sharded-rocksdb") ||
-		(saved == "sharded-rocksdb" && name == "sharded-pebble")
+		(saved == "sharded-rocksdb" && name == "sharded-pebble") ||
+		(saved == "pebble" && name == "bitable") ||
+		(saved == "bitable" && name == "pebble") ||
+		(saved == "sharded-pebble" && name == "sharded-bitable") ||
+		(saved == "sharded-bitable" && name == "sharded-pebble")
 }
 
 func (env *Env) check(cfg config.NodeHostConfig,
@@ -352,6 +352,11 @@ func TestCompatibleLogDBType(t *testing.T) {
 		{"rocksdb", "bitable", true},
 		{"bitable", "tee", false},
 		{"tee", "bitable", false},
+		{"pebble", "bitable", true},
+		{"bitable", "pebble", true},
+		{"sharded-pebble", "sharded-bitable", true},
+		{"sharded-bitable", "sharded-pebble", true},
+		{"sharded-pebble", "bitable", false},
 		{"rocksdb", "tee", false},
 		{"tee", "rocksdb", false},
 		{"tee", "tee", true},
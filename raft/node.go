@@ -1123,6 +1123,13 @@ func (n *node) hasEntryToApply() bool {
 	return n.p.HasEntryToApply()
 }
 
+func (n *node) raftIndex() (committed uint64, applied uint64) {
+	n.raftMu.Lock()
+	committed = n.p.CommittedIndex()
+	n.raftMu.Unlock()
+	return committed, n.sm.GetLastApplied()
+}
+
 func (n *node) updateAppliedIndex() uint64 {
 	n.appliedIndex = n.sm.GetLastApplied()
 	//plog.Infof("n.appliedIndex :%d", n.appliedIndex)
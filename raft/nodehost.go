@@ -626,6 +626,37 @@ func (nh *NodeHost) GetLeaderID(clusterID uint64) (uint64, bool, error) {
 	return leaderID, valid, nil
 }
 
+// GetRaftIndex returns the last committed and the last applied log index of
+// the specified Raft cluster on the local node, the difference is the number
+// of committed entries still to be applied.
+func (nh *NodeHost) GetRaftIndex(clusterID uint64) (committed uint64, applied uint64, err error) {
+	if atomic.LoadInt32(&nh.closed) != 0 {
+		return 0, 0, ErrClosed
+	}
+	v, ok := nh.getCluster(clusterID)
+	if !ok {
+		return 0, 0, ErrClusterNotFound
+	}
+	committed, applied = v.raftIndex()
+	return committed, applied, nil
+}
+
+// FlushLogDB flushes the memtables of the log DB to disk and fsyncs them, so
+// a filesystem level copy of the NodeHost dir taken afterwards is consistent.
+// ErrInvalidOperation is returned when the log DB can not be flushed.
+func (nh *NodeHost) FlushLogDB() error {
+	nh.mu.RLock()
+	defer nh.mu.RUnlock()
+	if atomic.LoadInt32(&nh.closed) != 0 {
+		return ErrClosed
+	}
+	shardedrdb, ok := nh.mu.logdb.(*logdb.ShardedDB)
+	if !ok {
+		return ErrInvalidOperation
+	}
+	return shardedrdb.Flush()
+}
+
 // GetNoOPSession returns a NO-OP client session ready to be used for making
 // proposals. The NO-OP client session is a dummy client session that will not
 // be checked or enforced. Use this No-OP client session when you want to ignore
@@ -4737,7 +4737,7 @@ func TestV2DataCanBeHandled(t *testing.T) {
 		},
 		tf: func(nh *NodeHost) {
 			name := nh.mu.logdb.Name()
-			if name != "sharded-pebble" && name != "sharded-rocksdb" {
+			if name != "sharded-bitable" && name != "sharded-rocksdb" {
 				// v2-rocksdb-batched.tar.bz2 contains rocksdb format data
 				t.Skip("skipped as not using rocksdb")
 			}
@@ -24,12 +24,16 @@ import (
 	"github.com/zuoyebang/bitalostored/stored/engine/bitsdb/dbconfig"
 	"github.com/zuoyebang/bitalostored/stored/engine/bitsdb/dbmeta"
 	"github.com/zuoyebang/bitalostored/stored/internal/config"
+	"github.com/zuoyebang/bitalostored/stored/internal/errn"
 	"github.com/zuoyebang/bitalostored/stored/internal/log"
 )
 
 type Bitalos struct {
 	Meta    *dbmeta.Meta
 	Migrate *Migrate
+	// BeforeCompact, when set, runs in the compaction goroutine before the
+	// stores are compacted.
+	BeforeCompact func()
 
 	bitsdb *bitsdb.BitsDB
 }
@@ -201,14 +205,28 @@ func (b *Bitalos) ScanDelExpireAsync() {
 	}()
 }
 
-func (b *Bitalos) Compact() {
+func (b *Bitalos) Compact() error {
 	if b.bitsdb == nil {
-		return
+		return nil
+	}
+	if !b.bitsdb.StartCompact() {
+		return errn.ErrCompactRunning
 	}
 
 	go func() {
+		if b.BeforeCompact != nil {
+			b.BeforeCompact()
+		}
 		b.bitsdb.Compact()
 	}()
+	return nil
+}
+
+func (b *Bitalos) IsCompactRun() bool {
+	if b.bitsdb == nil {
+		return false
+	}
+	return b.bitsdb.IsCompactRun() == 1
 }
 
 func (b *Bitalos) DebugInfo() []byte {
@@ -227,6 +245,62 @@ func (b *Bitalos) CacheInfo() []byte {
 	return b.bitsdb.CacheInfo()
 }
 
+func (b *Bitalos) GCCache() (int, int) {
+	if b.bitsdb == nil {
+		return 0, 0
+	}
+
+	return b.bitsdb.GCCache()
+}
+
+func (b *Bitalos) GCCacheShard(i int) (int, int, bool) {
+	if b.bitsdb == nil {
+		return 0, 0, false
+	}
+
+	return b.bitsdb.GCCacheShard(i)
+}
+
+func (b *Bitalos) CacheKeyMaintenanceCount(key []byte, khash uint32) uint64 {
+	if b.bitsdb == nil {
+		return 0
+	}
+
+	return b.bitsdb.CacheKeyMaintenanceCount(key, khash)
+}
+
+func (b *Bitalos) EvictCache() int {
+	if b.bitsdb == nil {
+		return 0
+	}
+
+	return b.bitsdb.EvictCache()
+}
+
+func (b *Bitalos) CacheValueSizeHistogram(buckets []uint32) []int {
+	if b.bitsdb == nil {
+		return make([]int, len(buckets)+1)
+	}
+
+	return b.bitsdb.CacheValueSizeHistogram(buckets)
+}
+
+func (b *Bitalos) CacheMemory() (int64, int64) {
+	if b.bitsdb == nil {
+		return 0, 0
+	}
+
+	return b.bitsdb.CacheMemory()
+}
+
+func (b *Bitalos) CachePlacement(key []byte, khash uint32) (int, int) {
+	if b.bitsdb == nil {
+		return -1, -1
+	}
+
+	return b.bitsdb.CachePlacement(key, khash)
+}
+
 func (b *Bitalos) GetIsDelExpire() int {
 	if b.bitsdb == nil {
 		return 0
@@ -250,8 +324,16 @@ func (b *Bitalos) CheckpointPrepareEnd() {
 	b.bitsdb.SetCheckpointHighPriority(false)
 }
 
-func (b *Bitalos) FlushAllDB() {
-	b.bitsdb.FlushAllDB()
+func (b *Bitalos) FlushAllDB() error {
+	return b.bitsdb.FlushAllDB()
+}
+
+func (b *Bitalos) FlushDisk() error {
+	if b.bitsdb == nil {
+		return nil
+	}
+
+	return b.bitsdb.FlushDisk()
 }
 
 func (b *Bitalos) SetQPS(qps uint64) {
@@ -15,7 +15,6 @@
 package base
 
 import (
-	"github.com/zuoyebang/bitalostored/butils/hash"
 	"github.com/zuoyebang/bitalostored/stored/engine/bitsdb/btools"
 	"github.com/zuoyebang/bitalostored/stored/internal/tclock"
 	"github.com/zuoyebang/bitalostored/stored/internal/utils"
@@ -23,17 +22,17 @@ import (
 
 func (bo *BaseObject) Del(khash uint32, keys ...[]byte) (n int64, err error) {
 	var isHashTag bool
-	firstKeyHash := hash.Fnv32(keys[0])
+	firstKeyHash := utils.KeyHash(keys[0])
 	if firstKeyHash != khash {
 		isHashTag = true
-		firstKeyHash = utils.GetHashTagFnv(keys[0])
+		firstKeyHash = utils.GetHashTagKeyHash(keys[0])
 	}
 
 	for i, key := range keys {
 		if i == 0 {
 			khash = firstKeyHash
 		} else if !isHashTag {
-			khash = hash.Fnv32(key)
+			khash = utils.KeyHash(key)
 		}
 		func(key []byte, khash uint32) {
 			if err = btools.CheckKeySize(key); err != nil {
@@ -175,6 +174,26 @@ func (bo *BaseObject) BaseExpireAt(key []byte, khash uint32, when int64) (int64,
 	return 1, nil
 }
 
+// RefreshTTL moves the ttl of a key that already has one to duration seconds
+// from now, a key without ttl is left permanent. The caller holds the key lock
+// and writes mkv back when it reports true.
+func (bo *BaseObject) RefreshTTL(key []byte, mkv *MetaData, duration int64) (bool, error) {
+	if duration <= 0 || mkv.timestamp == 0 {
+		return false, nil
+	}
+
+	oldExpireKey, oekCloser := EncodeExpireKey(key, mkv)
+	defer oekCloser()
+	mkv.SetTimestamp(uint64(tclock.SetTimestampMilli(tclock.GetTimestampSecond() + duration)))
+	newExpireKey, nekCloser := EncodeExpireKey(key, mkv)
+	defer nekCloser()
+
+	if err := bo.UpdateExpire(oldExpireKey, newExpireKey); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func (bo *BaseObject) BasePTTL(key []byte, khash uint32, p bool) (int64, error) {
 	if err := btools.CheckKeySize(key); err != nil {
 		return -2, err
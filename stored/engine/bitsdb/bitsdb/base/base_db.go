@@ -247,6 +247,68 @@ func (b *BaseDB) GetAllDB() []kv.IKVStore {
 	return b.DB.GetAllDB()
 }
 
+func (b *BaseDB) MetaCacheEncoding(key []byte) (vectormap.ValueEncoding, uint32, bool, error) {
+	if b.MetaCache == nil {
+		return 0, 0, false, nil
+	}
+	return b.MetaCache.Encoding(key)
+}
+
+func (b *BaseDB) GCCache() (int, int) {
+	if b.MetaCache == nil {
+		return 0, 0
+	}
+	return b.MetaCache.GCCopy()
+}
+
+func (b *BaseDB) GCCacheShard(i int) (int, int, bool) {
+	if b.MetaCache == nil {
+		return 0, 0, false
+	}
+	return b.MetaCache.GCCopyShard(i)
+}
+
+// CacheKeyMaintenanceCount is the rehash and GC count of the cache shard that
+// holds the meta of key.
+func (b *BaseDB) CacheKeyMaintenanceCount(key []byte, khash uint32) uint64 {
+	if b.MetaCache == nil {
+		return 0
+	}
+	mk, mkCloser := EncodeMetaKey(key, khash)
+	defer mkCloser()
+	return b.MetaCache.KeyMaintenanceCount(mk)
+}
+
+func (b *BaseDB) EvictCache() int {
+	if b.MetaCache == nil {
+		return 0
+	}
+	return b.MetaCache.Eliminate()
+}
+
+func (b *BaseDB) CacheValueSizeHistogram(buckets []uint32) []int {
+	if b.MetaCache == nil {
+		return make([]int, len(buckets)+1)
+	}
+	return b.MetaCache.ValueSizeHistogram(buckets)
+}
+
+func (b *BaseDB) CachePlacement(key []byte, khash uint32) (shard int, group int) {
+	if b.MetaCache == nil {
+		return -1, -1
+	}
+	ek, ekCloser := EncodeMetaKey(key, khash)
+	defer ekCloser()
+	return b.MetaCache.ShardIndex(ek), b.MetaCache.GroupStart(ek)
+}
+
+func (b *BaseDB) CacheMemory() (memCap int64, usedMem int64) {
+	if b.MetaCache == nil {
+		return 0, 0
+	}
+	return int64(b.MetaCache.MaxMem()), int64(b.MetaCache.UsedMem())
+}
+
 func (b *BaseDB) CacheInfo() string {
 	if b.MetaCache == nil {
 		return ""
@@ -0,0 +1,60 @@
+// Copyright 2019-2024 Xu Ruibo (hustxurb@163.com) and Contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package base
+
+import (
+	"bytes"
+
+	"github.com/zuoyebang/bitalostored/butils/vectormap"
+	"github.com/zuoyebang/bitalostored/stored/engine/bitsdb/bitskv/kv"
+)
+
+// WarmCache puts up to max keys with the prefix from kvs into vm and returns
+// the count of keys put, it is used to avoid miss storms on a cold cache.
+func WarmCache(vm *vectormap.VectorMap, kvs kv.IKVStore, prefix []byte, max int) (n int) {
+	if vm == nil || kvs == nil || max <= 0 {
+		return 0
+	}
+
+	it := kvs.NewIter(&kv.IteratorOptions{
+		LowerBound: prefix,
+		UpperBound: prefixUpperBound(prefix),
+		IsAll:      true,
+	})
+	defer it.Close()
+
+	for it.First(); it.Valid() && n < max; it.Next() {
+		key := it.Key()
+		if !bytes.HasPrefix(key, prefix) {
+			break
+		}
+		if vm.RePut(key, it.Value()) {
+			n++
+		}
+	}
+	return n
+}
+
+func prefixUpperBound(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			upper := make([]byte, i+1)
+			copy(upper, prefix)
+			upper[i]++
+			return upper
+		}
+	}
+	return nil
+}
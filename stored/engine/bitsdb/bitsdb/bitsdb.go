@@ -47,6 +47,7 @@ type BitsDB struct {
 
 	baseDb            *base.BaseDB
 	isDelExpireRun    atomic.Int32
+	isCompactRun      atomic.Int32
 	isCheckpoint      atomic.Bool
 	ckpExpLock        sync.Mutex
 	flushTask         *FlushTask
@@ -116,12 +117,20 @@ func (bdb *BitsDB) CheckpointExpireLock(lock bool) {
 	}
 }
 
-func (bdb *BitsDB) FlushAllDB() {
+func (bdb *BitsDB) FlushAllDB() error {
+	var firstErr error
 	var waitChs []<-chan struct{}
 	dbs := bdb.GetAllDB()
 
 	for i := range dbs {
-		if ch, err := dbs[i].AsyncFlush(); err == nil && ch != nil {
+		ch, err := dbs[i].AsyncFlush()
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if ch != nil {
 			waitChs = append(waitChs, ch)
 		}
 	}
@@ -129,12 +138,49 @@ func (bdb *BitsDB) FlushAllDB() {
 	for i := range waitChs {
 		<-waitChs[i]
 	}
+	return firstErr
+}
+
+// FlushDisk flushes the memtables of all dbs while key writes are held off,
+// the flushed state is consistent across dbs. Reads are served throughout.
+func (bdb *BitsDB) FlushDisk() error {
+	unblock := bdb.baseDb.KeyLocker.BlockWrites()
+	defer unblock()
+	return bdb.FlushAllDB()
 }
 
 func (bdb *BitsDB) ClearCache() {
 	bdb.baseDb.ClearCache()
 }
 
+func (bdb *BitsDB) GCCache() (int, int) {
+	return bdb.baseDb.GCCache()
+}
+
+func (bdb *BitsDB) GCCacheShard(i int) (int, int, bool) {
+	return bdb.baseDb.GCCacheShard(i)
+}
+
+func (bdb *BitsDB) CacheKeyMaintenanceCount(key []byte, khash uint32) uint64 {
+	return bdb.baseDb.CacheKeyMaintenanceCount(key, khash)
+}
+
+func (bdb *BitsDB) EvictCache() int {
+	return bdb.baseDb.EvictCache()
+}
+
+func (bdb *BitsDB) CacheValueSizeHistogram(buckets []uint32) []int {
+	return bdb.baseDb.CacheValueSizeHistogram(buckets)
+}
+
+func (bdb *BitsDB) CacheMemory() (int64, int64) {
+	return bdb.baseDb.CacheMemory()
+}
+
+func (bdb *BitsDB) CachePlacement(key []byte, khash uint32) (int, int) {
+	return bdb.baseDb.CachePlacement(key, khash)
+}
+
 func (bdb *BitsDB) Close() {
 	log.Infof("bitsDB Close start")
 	bdb.baseDb.FlushBitmap()
@@ -165,7 +211,17 @@ func (bdb *BitsDB) GetAllDB() []kv.IKVStore {
 	return dbs
 }
 
+func (bdb *BitsDB) IsCompactRun() int {
+	return int(bdb.isCompactRun.Load())
+}
+
+func (bdb *BitsDB) StartCompact() bool {
+	return bdb.isCompactRun.CompareAndSwap(0, 1)
+}
+
 func (bdb *BitsDB) Compact() {
+	defer bdb.isCompactRun.Store(0)
+
 	bdb.baseDb.DB.CompactDB()
 	bdb.HashObj.DataDb.CompactDB()
 	bdb.ListObj.DataDb.CompactDB()
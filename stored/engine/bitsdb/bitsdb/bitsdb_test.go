@@ -15,10 +15,17 @@
 package bitsdb
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"github.com/zuoyebang/bitalostored/butils/vectormap"
+	"github.com/zuoyebang/bitalostored/stored/engine/bitsdb/bitsdb/base"
+	"github.com/zuoyebang/bitalostored/stored/engine/bitsdb/bitskv/kv"
+	kv_bitable "github.com/zuoyebang/bitalostored/stored/engine/bitsdb/bitskv/kv/bitable"
+	"github.com/zuoyebang/bitalostored/stored/engine/bitsdb/btools"
 	"github.com/zuoyebang/bitalostored/stored/engine/bitsdb/dbconfig"
 )
 
@@ -49,3 +56,41 @@ func TestCache_New(t *testing.T) {
 	require.Equal(t, 2048, db.baseDb.MetaCache.Shards())
 	db.Close()
 }
+
+func TestCache_Warm(t *testing.T) {
+	dbPath := testCacheDBPath
+	os.RemoveAll(dbPath)
+	defer os.RemoveAll(dbPath)
+	cfg := dbconfig.NewConfigDefault()
+	cfg.DBPath = dbPath
+	kvs, err := kv_bitable.NewKVStore(filepath.Join(dbPath, kv.DB_TYPE_DIR_META), cfg, btools.NoneType, kv.DB_TYPE_META)
+	require.NoError(t, err)
+	defer kvs.Close()
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, kvs.Set([]byte(fmt.Sprintf("hot:%03d", i)), []byte(fmt.Sprintf("hv%d", i))))
+		require.NoError(t, kvs.Set([]byte(fmt.Sprintf("cold:%03d", i)), []byte(fmt.Sprintf("cv%d", i))))
+	}
+
+	vm := vectormap.NewVectorMap(1024, vectormap.WithBuckets(4))
+	defer vm.Close()
+	require.Equal(t, 60, base.WarmCache(vm, kvs, []byte("hot:"), 60))
+	for i := 0; i < 100; i++ {
+		v, closer, ok := vm.Get([]byte(fmt.Sprintf("hot:%03d", i)))
+		require.Equal(t, i < 60, ok)
+		if ok {
+			require.Equal(t, fmt.Sprintf("hv%d", i), string(v))
+		}
+		if closer != nil {
+			closer()
+		}
+		_, closer, ok = vm.Get([]byte(fmt.Sprintf("cold:%03d", i)))
+		require.False(t, ok)
+		if closer != nil {
+			closer()
+		}
+	}
+
+	require.Equal(t, 100, base.WarmCache(vm, kvs, []byte("hot:"), 1000))
+	require.Equal(t, 0, base.WarmCache(vm, kvs, []byte("none:"), 1000))
+}
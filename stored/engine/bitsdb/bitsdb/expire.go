@@ -19,7 +19,6 @@ import (
 	"runtime/debug"
 	"time"
 
-	"github.com/zuoyebang/bitalostored/butils/hash"
 	"github.com/zuoyebang/bitalostored/stored/engine/bitsdb/bitsdb/base"
 	"github.com/zuoyebang/bitalostored/stored/engine/bitsdb/bitskv"
 	"github.com/zuoyebang/bitalostored/stored/engine/bitsdb/bitskv/kv"
@@ -27,6 +26,7 @@ import (
 	"github.com/zuoyebang/bitalostored/stored/internal/errn"
 	"github.com/zuoyebang/bitalostored/stored/internal/log"
 	"github.com/zuoyebang/bitalostored/stored/internal/tclock"
+	"github.com/zuoyebang/bitalostored/stored/internal/utils"
 )
 
 func (bdb *BitsDB) CheckKvExpire(dbId int, key, value []byte) bool {
@@ -93,7 +93,7 @@ func (bdb *BitsDB) ScanDeleteExpireDb(jobId uint64) {
 			break
 		}
 
-		keyHash := hash.Fnv32(key)
+		keyHash := utils.KeyHash(key)
 		switch dataType {
 		case btools.HASH:
 			err = bdb.HashObj.DeleteDataKeyByExpire(keyVersion, keyHash)
@@ -18,12 +18,12 @@ import (
 	"bytes"
 	"encoding/binary"
 
-	"github.com/zuoyebang/bitalostored/butils/hash"
 	"github.com/zuoyebang/bitalostored/butils/unsafe2"
 	"github.com/zuoyebang/bitalostored/stored/engine/bitsdb/bitsdb/base"
 	"github.com/zuoyebang/bitalostored/stored/engine/bitsdb/bitskv"
 	"github.com/zuoyebang/bitalostored/stored/engine/bitsdb/btools"
 	"github.com/zuoyebang/bitalostored/stored/internal/glob"
+	"github.com/zuoyebang/bitalostored/stored/internal/utils"
 )
 
 func (bdb *BitsDB) Scan(
@@ -38,7 +38,7 @@ func (bdb *BitsDB) Scan(
 	if len(cursor) == 0 || bytes.Equal(cursor, []byte{'0'}) {
 		ek = nil
 	} else {
-		khash := hash.Fnv32(cursor)
+		khash := utils.KeyHash(cursor)
 		var ekCloser func()
 		ek, ekCloser = base.EncodeMetaKey(cursor, khash)
 		defer ekCloser()
@@ -120,7 +120,7 @@ func (bdb *BitsDB) ScanBySlotId(
 		mk = slotIdPrefix[:]
 	} else {
 		var mkCloser func()
-		mk, mkCloser = base.EncodeMetaKey(cursor, hash.Fnv32(cursor))
+		mk, mkCloser = base.EncodeMetaKey(cursor, utils.KeyHash(cursor))
 		defer mkCloser()
 	}
 
@@ -43,14 +43,6 @@ func (l *locker) getRLock() func() {
 	}
 }
 
-func (l *locker) lockKey(cmd string) func() {
-	if resp.IsWriteCmd(cmd) {
-		return l.getWLock()
-	} else {
-		return l.getRLock()
-	}
-}
-
 type ScopeLocker struct {
 	size    uint32
 	lockers []*locker
@@ -80,5 +72,22 @@ func (sl *ScopeLocker) LockReadKey(khash uint32) func() {
 }
 
 func (sl *ScopeLocker) LockKey(khash uint32, cmd string) func() {
-	return sl.lockers[khash&sl.size].lockKey(cmd)
+	if resp.IsWriteCmd(cmd) {
+		return sl.LockWriteKey(khash)
+	}
+	return sl.LockReadKey(khash)
+}
+
+// BlockWrites waits for the key locks in flight and holds off new ones until
+// the returned func is called. It takes every stripe in order, so the key
+// write path pays nothing for it; reads that take no key lock go on.
+func (sl *ScopeLocker) BlockWrites() func() {
+	for _, l := range sl.lockers {
+		l.Lock()
+	}
+	return func() {
+		for _, l := range sl.lockers {
+			l.Unlock()
+		}
+	}
 }
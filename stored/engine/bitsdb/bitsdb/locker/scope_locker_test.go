@@ -35,3 +35,31 @@ func TestScopeLocker(t *testing.T) {
 	unlockFunc()
 	time.Sleep(time.Second)
 }
+
+func TestScopeLockerBlockWrites(t *testing.T) {
+	l := NewScopeLocker(false)
+	unblock := l.BlockWrites()
+	locked := make(chan struct{})
+	for _, key := range []string{"a", "b", "c"} {
+		go func(khash uint32) {
+			unlock := l.LockWriteKey(khash)
+			locked <- struct{}{}
+			unlock()
+		}(hash.Fnv32([]byte(key)))
+	}
+
+	select {
+	case <-locked:
+		t.Fatal("key write lock acquired during BlockWrites")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unblock()
+	for i := 0; i < 3; i++ {
+		select {
+		case <-locked:
+		case <-time.After(time.Second):
+			t.Fatal("key write lock not acquired after unlock")
+		}
+	}
+}
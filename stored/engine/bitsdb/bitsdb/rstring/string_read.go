@@ -15,10 +15,10 @@
 package rstring
 
 import (
-	"github.com/zuoyebang/bitalostored/butils/hash"
 	"github.com/zuoyebang/bitalostored/stored/engine/bitsdb/bitsdb/base"
 	"github.com/zuoyebang/bitalostored/stored/engine/bitsdb/btools"
 	"github.com/zuoyebang/bitalostored/stored/internal/tclock"
+	"github.com/zuoyebang/bitalostored/stored/internal/utils"
 )
 
 func (so *StringObject) TTL(key []byte, khash uint32) (int64, error) {
@@ -81,7 +81,7 @@ func (so *StringObject) MGet(khash uint32, keys ...[]byte) ([][]byte, []func(),
 	valClosers := make([]func(), keyNum)
 
 	var isHashTag bool
-	firstKeyHash := hash.Fnv32(keys[0])
+	firstKeyHash := utils.KeyHash(keys[0])
 	if firstKeyHash != khash {
 		isHashTag = true
 		firstKeyHash = khash
@@ -91,7 +91,7 @@ func (so *StringObject) MGet(khash uint32, keys ...[]byte) ([][]byte, []func(),
 		if i == 0 {
 			khash = firstKeyHash
 		} else if !isHashTag {
-			khash = hash.Fnv32(key)
+			khash = utils.KeyHash(key)
 		}
 		if err := btools.CheckKeySize(keys[i]); err == nil {
 			eks[i], ekClosers[i] = base.EncodeMetaKey(key, khash)
@@ -17,11 +17,11 @@ package rstring
 import (
 	"math"
 
-	"github.com/zuoyebang/bitalostored/butils/hash"
 	"github.com/zuoyebang/bitalostored/stored/engine/bitsdb/bitsdb/base"
 	"github.com/zuoyebang/bitalostored/stored/engine/bitsdb/btools"
 	"github.com/zuoyebang/bitalostored/stored/internal/errn"
 	"github.com/zuoyebang/bitalostored/stored/internal/tclock"
+	"github.com/zuoyebang/bitalostored/stored/internal/utils"
 )
 
 func (so *StringObject) Incr(key []byte, khash uint32) (int64, error) {
@@ -69,7 +69,7 @@ func (so *StringObject) MSet(khash uint32, args ...btools.KVPair) (err error) {
 		return nil
 	}
 	var isHashTag bool
-	firstKeyHash := hash.Fnv32(args[0].Key)
+	firstKeyHash := utils.KeyHash(args[0].Key)
 	if firstKeyHash != khash {
 		isHashTag = true
 		firstKeyHash = khash
@@ -79,7 +79,7 @@ func (so *StringObject) MSet(khash uint32, args ...btools.KVPair) (err error) {
 		if i == 0 {
 			khash = firstKeyHash
 		} else if !isHashTag {
-			khash = hash.Fnv32(args[i].Key)
+			khash = utils.KeyHash(args[i].Key)
 		}
 		if err = so.Set(args[i].Key, khash, args[i].Value); err != nil {
 			break
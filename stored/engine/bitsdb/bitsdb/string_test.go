@@ -865,3 +865,25 @@ func TestKVMissCache(t *testing.T) {
 	require.Equal(t, byte(btools.STRING), cv[0])
 	ccloser()
 }
+
+func TestFlushDisk(t *testing.T) {
+	bdb := testNewBitsDB()
+	defer closeDb(bdb)
+
+	key := []byte("flush_disk")
+	khash := hash.Fnv32(key)
+	if err := bdb.StringObj.Set(key, khash, []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	require.NoError(t, bdb.FlushDisk())
+	for _, db := range bdb.GetAllDB() {
+		ch, err := db.AsyncFlush()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ch != nil {
+			t.Fatalf("db %d memtable not flushed", db.Id())
+		}
+	}
+	testCheckKeyValue(t, bdb, key, khash, []byte("1"))
+}
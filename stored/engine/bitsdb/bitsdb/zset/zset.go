@@ -15,6 +15,8 @@
 package zset
 
 import (
+	"sync/atomic"
+
 	"github.com/zuoyebang/bitalostored/stored/engine/bitsdb/bitsdb/base"
 	"github.com/zuoyebang/bitalostored/stored/engine/bitsdb/bitskv"
 	"github.com/zuoyebang/bitalostored/stored/engine/bitsdb/btools"
@@ -24,6 +26,14 @@ import (
 
 type ZSetObject struct {
 	base.BaseObject
+
+	scoreRangeSteps atomic.Uint64
+}
+
+// ScoreRangeSteps returns how many index entries the score range reads have
+// stepped over, offsets included.
+func (zo *ZSetObject) ScoreRangeSteps() uint64 {
+	return zo.scoreRangeSteps.Load()
 }
 
 func NewZSetObject(baseDb *base.BaseDB, cfg *dbconfig.Config) *ZSetObject {
@@ -16,9 +16,13 @@ package zset
 
 import (
 	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
 
 	"github.com/zuoyebang/bitalostored/butils/numeric"
 	"github.com/zuoyebang/bitalostored/butils/unsafe2"
+	"github.com/zuoyebang/bitalostored/butils/vectormap"
 	"github.com/zuoyebang/bitalostored/stored/engine/bitsdb/bitsdb/base"
 	"github.com/zuoyebang/bitalostored/stored/engine/bitsdb/bitskv"
 	"github.com/zuoyebang/bitalostored/stored/engine/bitsdb/btools"
@@ -29,6 +33,67 @@ func (zo *ZSetObject) ZCard(key []byte, khash uint32) (int64, error) {
 	return zo.BaseSize(key, khash)
 }
 
+func (zo *ZSetObject) DebugInternals(key []byte, khash uint32) ([]byte, error) {
+	if err := btools.CheckKeySize(key); err != nil {
+		return nil, err
+	}
+
+	mk, mkCloser := base.EncodeMetaKey(key, khash)
+	defer mkCloser()
+	mkv, err := zo.GetMetaData(mk)
+	if err != nil {
+		return nil, err
+	}
+	defer base.PutMkvToPool(mkv)
+
+	encoding := "none"
+	enc, vSize, cached, err := zo.BaseDb.MetaCacheEncoding(mk)
+	if err != nil {
+		return nil, err
+	}
+	if cached {
+		encoding = enc.String()
+	}
+
+	var size, memberBytes, indexBytes int64
+	var memberEncs, indexEncs [vectormap.EncodingOverLong + 1]int64
+	if mkv.IsAlive() {
+		size = mkv.Size()
+		var lowerBound [base.DataKeyHeaderLength]byte
+		var upperBound [base.IndexKeyScoreLength]byte
+		keyVersion := mkv.Version()
+		keyKind := mkv.Kind()
+		base.EncodeDataKeyLowerBound(lowerBound[:], keyVersion, khash)
+		base.EncodeZsetIndexKeyUpperBound(upperBound[:], keyVersion, khash)
+		iterOpts := &bitskv.IterOptions{
+			KeyHash:    khash,
+			LowerBound: lowerBound[:],
+			UpperBound: upperBound[:],
+		}
+		it := zo.DataDb.NewIteratorIndex(iterOpts)
+		for it.Seek(lowerBound[:]); it.Valid(); it.Next() {
+			version, _, fp := base.DecodeZsetIndexKey(keyKind, it.RawKey(), it.RawValue())
+			if keyVersion != version {
+				break
+			}
+			mLen := len(fp.Prefix) + len(fp.Suffix)
+			iLen := len(it.RawKey()) + len(it.RawValue())
+			memberEncs[vectormap.EncodingOf(mLen)]++
+			indexEncs[vectormap.EncodingOf(iLen)]++
+			memberBytes += int64(mLen)
+			indexBytes += int64(iLen)
+		}
+		it.Close()
+	}
+
+	return []byte(fmt.Sprintf("members:%d cached:%v encoding:%s bytes:%d "+
+		"member_encoding:inline=%d,overshort=%d,overlong=%d member_bytes:%d "+
+		"index_encoding:inline=%d,overshort=%d,overlong=%d index_bytes:%d",
+		size, cached, encoding, vSize,
+		memberEncs[vectormap.EncodingInline], memberEncs[vectormap.EncodingOverShort], memberEncs[vectormap.EncodingOverLong], memberBytes,
+		indexEncs[vectormap.EncodingInline], indexEncs[vectormap.EncodingOverShort], indexEncs[vectormap.EncodingOverLong], indexBytes)), nil
+}
+
 func (zo *ZSetObject) ZScore(key []byte, khash uint32, member []byte) (float64, error) {
 	if err := btools.CheckKeyAndFieldSize(key, member); err != nil {
 		return 0, err
@@ -97,6 +162,90 @@ func (zo *ZSetObject) ZCount(
 	return n, nil
 }
 
+// zcountApproxBudget bounds the index entries ZCountApprox reads at each end
+// of the score range.
+const zcountApproxBudget = 512
+
+// ZCountApprox returns an estimate of ZCount. Ranges holding more than
+// 2*zcountApproxBudget members are not iterated, the members at both ends are
+// read and their score density is extrapolated over the middle of the range.
+func (zo *ZSetObject) ZCountApprox(
+	key []byte, khash uint32, min float64, max float64, leftClose bool, rightClose bool,
+) (int64, error) {
+	if err := btools.CheckKeySize(key); err != nil {
+		return 0, err
+	}
+
+	mkv, err := zo.GetMetaDataCheckAlive(key, khash)
+	if mkv == nil {
+		return 0, err
+	}
+	size := mkv.Size()
+	keyVersion := mkv.Version()
+	keyKind := mkv.Kind()
+	base.PutMkvToPool(mkv)
+	if size <= 2*zcountApproxBudget {
+		return zo.ZCount(key, khash, min, max, leftClose, rightClose)
+	}
+
+	var lowerBound [base.IndexKeyScoreLength]byte
+	var upperBound [base.IndexKeyScoreUpperBoundLength]byte
+	base.EncodeZsetIndexKeyScore(lowerBound[:], keyVersion, khash, min)
+	base.EncodeZsetIndexKeyScoreUpperBound(upperBound[:], keyVersion, khash, max)
+	iterOpts := &bitskv.IterOptions{
+		KeyHash:    khash,
+		LowerBound: lowerBound[:],
+		UpperBound: upperBound[:],
+	}
+	it := zo.DataDb.NewIteratorIndex(iterOpts)
+	defer it.Close()
+
+	var head, tail int64
+	var headFirst, headLast, tailFirst, tailLast float64
+	for it.Seek(lowerBound[:]); it.Valid() && head < zcountApproxBudget; it.Next() {
+		version, score, _ := base.DecodeZsetIndexKey(keyKind, it.RawKey(), nil)
+		if keyVersion != version || (rightClose && score == max) {
+			return head, nil
+		}
+		if leftClose && score == min {
+			continue
+		}
+		if head == 0 {
+			headFirst = score
+		}
+		headLast = score
+		head++
+	}
+	if !it.Valid() {
+		return head, nil
+	}
+
+	for it.SeekLT(upperBound[:]); it.Valid() && tail < zcountApproxBudget; it.Prev() {
+		version, score, _ := base.DecodeZsetIndexKey(keyKind, it.RawKey(), nil)
+		if keyVersion != version || score <= headLast {
+			break
+		}
+		if rightClose && score == max {
+			continue
+		}
+		if tail == 0 {
+			tailLast = score
+		}
+		tailFirst = score
+		tail++
+	}
+
+	span := (headLast - headFirst) + (tailLast - tailFirst)
+	if tail < zcountApproxBudget || span <= 0 {
+		return zo.ZCount(key, khash, min, max, leftClose, rightClose)
+	}
+	n := head + tail + int64(float64(head+tail)/span*(tailFirst-headLast))
+	if n > size {
+		n = size
+	}
+	return n, nil
+}
+
 func (zo *ZSetObject) ZRange(
 	key []byte, khash uint32, start int64, stop int64,
 ) ([]btools.ScorePair, error) {
@@ -154,6 +303,209 @@ func (zo *ZSetObject) ZRange(
 	return res, nil
 }
 
+// ZRandMember samples members in one pass over the score index without
+// loading the whole set. A positive count keeps a reservoir of up to count
+// distinct members, a negative count picks -count members with repeats by
+// walking sorted random positions.
+func (zo *ZSetObject) ZRandMember(key []byte, khash uint32, count int64) ([]btools.ScorePair, error) {
+	if count == 0 {
+		return nil, nil
+	}
+	if err := btools.CheckKeySize(key); err != nil {
+		return nil, err
+	}
+
+	mkv, err := zo.GetMetaDataCheckAlive(key, khash)
+	if mkv == nil {
+		return nil, err
+	}
+	defer base.PutMkvToPool(mkv)
+
+	size := mkv.Size()
+	if size <= 0 {
+		return nil, nil
+	}
+
+	repeated := count < 0
+	if repeated {
+		count = -count
+	}
+	var picks []randPick
+	if repeated {
+		picks = randPicks(size, count)
+	}
+	nv := count
+	if !repeated && nv > size {
+		nv = size
+	}
+	res := make([]btools.ScorePair, 0, nv)
+
+	var index int64
+	var lowerBound [base.DataKeyHeaderLength]byte
+	var upperBound [base.IndexKeyScoreLength]byte
+	keyVersion := mkv.Version()
+	keyKind := mkv.Kind()
+	base.EncodeDataKeyLowerBound(lowerBound[:], keyVersion, khash)
+	base.EncodeZsetIndexKeyUpperBound(upperBound[:], keyVersion, khash)
+	iterOpts := &bitskv.IterOptions{
+		KeyHash:    khash,
+		LowerBound: lowerBound[:],
+		UpperBound: upperBound[:],
+	}
+	it := zo.DataDb.NewIteratorIndex(iterOpts)
+	defer it.Close()
+	for it.Seek(lowerBound[:]); it.Valid(); it.Next() {
+		if repeated && len(picks) == 0 {
+			break
+		}
+		if repeated && picks[0].index != index {
+			index++
+			continue
+		}
+
+		version, score, fp := base.DecodeZsetIndexKey(keyKind, it.RawKey(), it.RawValue())
+		if keyVersion != version {
+			break
+		}
+		if repeated {
+			sp := btools.ScorePair{Member: fp.Merge(), Score: score}
+			for n := picks[0].times; n > 0; n-- {
+				res = append(res, sp)
+			}
+			picks = picks[1:]
+		} else if int64(len(res)) < count {
+			res = append(res, btools.ScorePair{Member: fp.Merge(), Score: score})
+		} else if j := rand.Int63n(index + 1); j < count {
+			res[j] = btools.ScorePair{Member: fp.Merge(), Score: score}
+		}
+		index++
+	}
+
+	rand.Shuffle(len(res), func(i, j int) {
+		res[i], res[j] = res[j], res[i]
+	})
+	return res, nil
+}
+
+type randPick struct {
+	index int64
+	times int64
+}
+
+// randPicks draws count indexes in [0, size) with repeats and returns them
+// sorted by index, allocating by the smaller of count and size.
+func randPicks(size, count int64) []randPick {
+	if count < size {
+		idx := make([]int64, count)
+		for i := range idx {
+			idx[i] = rand.Int63n(size)
+		}
+		sort.Slice(idx, func(i, j int) bool {
+			return idx[i] < idx[j]
+		})
+		picks := make([]randPick, 0, count)
+		for _, i := range idx {
+			if n := len(picks); n > 0 && picks[n-1].index == i {
+				picks[n-1].times++
+			} else {
+				picks = append(picks, randPick{index: i, times: 1})
+			}
+		}
+		return picks
+	}
+
+	times := make([]int64, size)
+	for i := int64(0); i < count; i++ {
+		times[rand.Int63n(size)]++
+	}
+	picks := make([]randPick, 0, size)
+	for i, n := range times {
+		if n > 0 {
+			picks = append(picks, randPick{index: int64(i), times: n})
+		}
+	}
+	return picks
+}
+
+// ZInterCard counts the members found in every set at keys, stopping once the
+// count reaches limit when it is positive. It walks the smallest set and
+// probes the data keys of the others, no member is kept.
+func (zo *ZSetObject) ZInterCard(keys [][]byte, khashes []uint32, limit int64) (int64, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	mkvs := make([]*base.MetaData, len(keys))
+	defer func() {
+		for _, mkv := range mkvs {
+			if mkv != nil {
+				base.PutMkvToPool(mkv)
+			}
+		}
+	}()
+	smallest := 0
+	for i, key := range keys {
+		if err := btools.CheckKeySize(key); err != nil {
+			return 0, err
+		}
+		mkv, err := zo.GetMetaDataCheckAlive(key, khashes[i])
+		if mkv == nil {
+			return 0, err
+		}
+		mkvs[i] = mkv
+		if mkv.Size() < mkvs[smallest].Size() {
+			smallest = i
+		}
+	}
+
+	var n int64
+	var ekf [base.DataKeyZsetLength]byte
+	var lowerBound [base.DataKeyHeaderLength]byte
+	var upperBound [base.IndexKeyScoreLength]byte
+	khash := khashes[smallest]
+	keyVersion := mkvs[smallest].Version()
+	keyKind := mkvs[smallest].Kind()
+	base.EncodeDataKeyLowerBound(lowerBound[:], keyVersion, khash)
+	base.EncodeZsetIndexKeyUpperBound(upperBound[:], keyVersion, khash)
+	iterOpts := &bitskv.IterOptions{
+		KeyHash:    khash,
+		LowerBound: lowerBound[:],
+		UpperBound: upperBound[:],
+	}
+	it := zo.DataDb.NewIteratorIndex(iterOpts)
+	defer it.Close()
+	for it.Seek(lowerBound[:]); it.Valid(); it.Next() {
+		version, _, fp := base.DecodeZsetIndexKey(keyKind, it.RawKey(), it.RawValue())
+		if version != keyVersion {
+			break
+		}
+
+		member := fp.Merge()
+		found := true
+		for i, mkv := range mkvs {
+			if i == smallest {
+				continue
+			}
+			ekfLen := base.EncodeZsetDataKey(ekf[:], mkv.Version(), khashes[i], member, mkv.IsZsetOld())
+			exist, err := zo.IsExistData(ekf[:ekfLen])
+			if err != nil {
+				return 0, err
+			}
+			if !exist {
+				found = false
+				break
+			}
+		}
+		if found {
+			n++
+			if limit > 0 && n >= limit {
+				break
+			}
+		}
+	}
+	return n, nil
+}
+
 func (zo *ZSetObject) ZRevRange(
 	key []byte, khash uint32, start int64, stop int64,
 ) ([]btools.ScorePair, error) {
@@ -217,24 +569,50 @@ func (zo *ZSetObject) ZRangeByScore(
 	if offset < 0 {
 		return res, nil
 	}
-	if err := btools.CheckKeySize(key); err != nil {
+
+	nv := count
+	if nv <= 0 || nv > 256 {
+		nv = 256
+	}
+	res = make([]btools.ScorePair, 0, nv)
+	err = zo.ZRangeByScoreFunc(key, khash, min, max, leftClose, rightClose, offset, count, func(member btools.FieldPair, score float64) {
+		res = append(res, btools.ScorePair{
+			Member: member.Merge(),
+			Score:  score,
+		})
+	})
+	if err != nil {
 		return nil, err
 	}
+	return res, nil
+}
+
+// ZRangeByScoreFunc calls fn for every member of ZRangeByScore in order. The
+// member only stays valid until fn returns.
+func (zo *ZSetObject) ZRangeByScoreFunc(
+	key []byte, khash uint32, min float64, max float64, leftClose bool, rightClose bool, offset int, count int,
+	fn func(member btools.FieldPair, score float64),
+) error {
+	if offset < 0 {
+		return nil
+	}
+	if err := btools.CheckKeySize(key); err != nil {
+		return err
+	}
 
 	mkv, err := zo.GetMetaDataCheckAlive(key, khash)
 	if mkv == nil {
-		return nil, err
+		return err
 	}
 	defer base.PutMkvToPool(mkv)
 
 	stopIndex := mkv.Size() - 1
-	skipped := 0
-	nv := count
-
-	if nv <= 0 || nv > 256 {
-		nv = 256
+	if int64(offset) > stopIndex {
+		return nil
 	}
-	res = make([]btools.ScorePair, 0, nv)
+
+	skipped := 0
+	found := 0
 	keyVersion := mkv.Version()
 	keyKind := mkv.Kind()
 
@@ -247,9 +625,11 @@ func (zo *ZSetObject) ZRangeByScore(
 		KeyHash:    khash,
 		UpperBound: upperBound[:],
 	}
+	var steps uint64
 	it := zo.DataDb.NewIteratorIndex(iterOpts)
 	defer it.Close()
 	for it.Seek(lowerBound[:]); it.Valid() && index <= stopIndex; it.Next() {
+		steps++
 		version, score, fp := base.DecodeZsetIndexKey(keyKind, it.RawKey(), it.RawValue())
 		if keyVersion != version {
 			break
@@ -259,11 +639,9 @@ func (zo *ZSetObject) ZRangeByScore(
 		}
 		if !leftClose || score > min {
 			if skipped >= offset {
-				res = append(res, btools.ScorePair{
-					Member: fp.Merge(),
-					Score:  score,
-				})
-				if count > 0 && len(res) == count {
+				fn(fp, score)
+				found++
+				if count > 0 && found == count {
 					break
 				}
 			}
@@ -275,7 +653,8 @@ func (zo *ZSetObject) ZRangeByScore(
 			break
 		}
 	}
-	return res, nil
+	zo.scoreRangeSteps.Add(steps)
+	return nil
 }
 
 func (zo *ZSetObject) ZRevRangeByScore(
@@ -291,13 +670,17 @@ func (zo *ZSetObject) ZRevRangeByScore(
 	}
 	defer base.PutMkvToPool(mkv)
 
+	left := mkv.Size()
+	if int64(offset) >= left {
+		return []btools.ScorePair{}, nil
+	}
+
 	skipped := 0
 	nv := count
 	if nv <= 0 || nv > 256 {
 		nv = 256
 	}
 	res := make([]btools.ScorePair, 0, nv)
-	left := mkv.Size()
 	keyVersion := mkv.Version()
 	keyKind := mkv.Kind()
 
@@ -312,7 +695,9 @@ func (zo *ZSetObject) ZRevRangeByScore(
 	}
 	it := zo.DataDb.NewIteratorIndex(iterOpts)
 	defer it.Close()
+	var steps uint64
 	for it.SeekLT(upperBound[:]); it.Valid() && left > 0; it.Prev() {
+		steps++
 		left--
 		leftPass := false
 		rightPass := false
@@ -343,6 +728,7 @@ func (zo *ZSetObject) ZRevRangeByScore(
 			break
 		}
 	}
+	zo.scoreRangeSteps.Add(steps)
 	return res, nil
 }
 
@@ -357,6 +743,23 @@ func (zo *ZSetObject) ZRevRank(key []byte, khash uint32, member []byte) (int64,
 func (zo *ZSetObject) ZRangeByLex(
 	key []byte, khash uint32, min []byte, max []byte, leftClose bool, rightClose bool, offset int, count int,
 ) ([][]byte, error) {
+	pairs, err := zo.ZRangeByLexGeneric(key, khash, min, max, leftClose, rightClose, offset, count, false)
+	if err != nil || pairs == nil {
+		return nil, err
+	}
+	res := make([][]byte, len(pairs))
+	for i := range pairs {
+		res[i] = pairs[i].Member
+	}
+	return res, nil
+}
+
+// ZRangeByLexGeneric returns the members of a lex range with the scores read
+// from their index entries, walking the index backwards when reverse is set so
+// that offset and count apply from the largest member.
+func (zo *ZSetObject) ZRangeByLexGeneric(
+	key []byte, khash uint32, min []byte, max []byte, leftClose bool, rightClose bool, offset int, count int, reverse bool,
+) ([]btools.ScorePair, error) {
 	if err := btools.CheckKeySize(key); err != nil {
 		return nil, err
 	}
@@ -375,27 +778,33 @@ func (zo *ZSetObject) ZRangeByLex(
 		rightNotLimit = true
 	}
 
-	res := make([][]byte, 0, 4)
-	stopIndex := mkv.Size() - 1
+	res := make([]btools.ScorePair, 0, 4)
+	left := mkv.Size()
 	skipped := 0
 	keyVersion := mkv.Version()
 	keyKind := mkv.Kind()
 
-	var index int64
 	var lowerBound [base.DataKeyHeaderLength]byte
 	var upperBound [base.IndexKeyScoreLength]byte
 	base.EncodeDataKeyLowerBound(lowerBound[:], keyVersion, khash)
 	base.EncodeZsetIndexKeyUpperBound(upperBound[:], keyVersion, khash)
 	iterOpts := &bitskv.IterOptions{
 		KeyHash:    khash,
+		LowerBound: lowerBound[:],
 		UpperBound: upperBound[:],
 	}
 	it := zo.DataDb.NewIteratorIndex(iterOpts)
 	defer it.Close()
-	for it.Seek(lowerBound[:]); it.Valid() && index <= stopIndex; it.Next() {
+	if reverse {
+		it.SeekLT(upperBound[:])
+	} else {
+		it.Seek(lowerBound[:])
+	}
+	for ; it.Valid() && left > 0; zrangeByLexStep(it, reverse) {
+		left--
 		leftPass := false
 		rightPass := false
-		version, _, fp := base.DecodeZsetIndexKey(keyKind, it.RawKey(), it.RawValue())
+		version, score, fp := base.DecodeZsetIndexKey(keyKind, it.RawKey(), it.RawValue())
 		if keyVersion != version {
 			break
 		}
@@ -415,16 +824,15 @@ func (zo *ZSetObject) ZRangeByLex(
 				skipped++
 				continue
 			}
-			res = append(res, member)
+			res = append(res, btools.ScorePair{
+				Member: member,
+				Score:  score,
+			})
 			if count > 0 && len(res) == count {
 				break
 			}
 		}
-		if !rightPass {
-			break
-		}
-		index++
-		if index > stopIndex {
+		if (!reverse && !rightPass) || (reverse && !leftPass) {
 			break
 		}
 	}
@@ -432,6 +840,14 @@ func (zo *ZSetObject) ZRangeByLex(
 	return res, nil
 }
 
+func zrangeByLexStep(it *bitskv.Iterator, reverse bool) {
+	if reverse {
+		it.Prev()
+	} else {
+		it.Next()
+	}
+}
+
 func (zo *ZSetObject) ZLexCount(
 	key []byte, khash uint32, min []byte, max []byte, leftClose bool, rightClose bool,
 ) (int64, error) {
@@ -31,7 +31,14 @@ func setZsetOldDataType(mkv *base.MetaData) {
 	}
 }
 
+// ZAdd keeps the TTL of a live key; only a new or expired key starts without one.
 func (zo *ZSetObject) ZAdd(key []byte, khash uint32, isOld bool, args ...btools.ScorePair) (int64, error) {
+	return zo.ZAddWithOptions(key, khash, isOld, btools.ZAddOptions{}, args...)
+}
+
+func (zo *ZSetObject) ZAddWithOptions(
+	key []byte, khash uint32, isOld bool, opts btools.ZAddOptions, args ...btools.ScorePair,
+) (int64, error) {
 	if err := btools.CheckKeySize(key); err != nil {
 		return 0, err
 	}
@@ -65,7 +72,7 @@ func (zo *ZSetObject) ZAdd(key []byte, khash uint32, isOld bool, args ...btools.
 	indexWb := zo.GetIndexWriteBatchFromPool()
 	defer zo.PutWriteBatchToPool(indexWb)
 
-	var count int64
+	var added, changed int64
 	var scoreBuf [base.ScoreLength]byte
 	var ekfBuf [base.DataKeyZsetLength]byte
 	keyVersion := mkv.Version()
@@ -89,14 +96,23 @@ func (zo *ZSetObject) ZAdd(key []byte, khash uint32, isOld bool, args ...btools.
 			}
 		}()
 
+		var oldScore float64
+		if exist {
+			oldScore = numeric.ByteSortToFloat64(value)
+		}
+		if opts.Skip(exist, oldScore, score) {
+			return nil
+		}
 		if !exist {
-			count++
+			added++
 			mkv.IncrSize(1)
 		} else {
-			oldScore := numeric.ByteSortToFloat64(value)
 			if oldScore == score {
 				return nil
 			}
+			if opts.Changed(oldScore, score) {
+				changed++
+			}
 			zo.deleteZsetIndexKey(indexWb, keyVersion, keyKind, khash, oldScore, member)
 		}
 
@@ -124,23 +140,39 @@ func (zo *ZSetObject) ZAdd(key []byte, khash uint32, isOld bool, args ...btools.
 	if err = indexWb.Commit(); err != nil {
 		return 0, err
 	}
-	if count > 0 {
+	refreshed, err := zo.RefreshTTL(key, mkv, opts.RefreshTTL)
+	if err != nil {
+		return 0, err
+	}
+	if added > 0 || refreshed {
 		if err = zo.SetMetaData(mk, mkv); err != nil {
 			return 0, err
 		}
 	}
 
-	return count, err
+	if opts.CH {
+		return added + changed, nil
+	}
+	return added, nil
 }
 
-func (zo *ZSetObject) ZIncrBy(key []byte, khash uint32, isOld bool, delta float64, member []byte) (float64, error) {
-	if err := btools.CheckKeyAndFieldSize(key, member); err != nil {
+// ZStore replaces key with args under a single key lock. The members are
+// written under a new version and the meta is switched last, so readers see
+// either the old set or the new one, and a failed write leaves the old set.
+// The old version is left to the expire GC, key is deleted when args is empty.
+func (zo *ZSetObject) ZStore(key []byte, khash uint32, args ...btools.ScorePair) (int64, error) {
+	if err := btools.CheckKeySize(key); err != nil {
 		return 0, err
 	}
 
 	unlockKey := zo.LockKey(khash)
 	defer unlockKey()
 
+	bitmapExist, _ := zo.BaseDb.ClearBitmap(key, true)
+	if bitmapExist && len(args) == 0 {
+		return 0, nil
+	}
+
 	mk, mkCloser := base.EncodeMetaKey(key, khash)
 	defer mkCloser()
 	mkv, err := zo.GetMetaDataNoneType(mk)
@@ -149,9 +181,103 @@ func (zo *ZSetObject) ZIncrBy(key []byte, khash uint32, isOld bool, delta float6
 	}
 	defer base.PutMkvToPool(mkv)
 
-	kexist := mkv.IsAlive()
-	if !kexist {
-		mkv.Reuse(zo.DataType, zo.GetNextKeyId())
+	var oldExpireKey, newExpireKey []byte
+	if mkv.IsAlive() && mkv.GetDataType() != btools.STRING {
+		var oekCloser, nekCloser func()
+		oldExpireKey, oekCloser = base.EncodeExpireKey(key, mkv)
+		defer oekCloser()
+		mkv.Del()
+		newExpireKey, nekCloser = base.EncodeExpireKey(key, mkv)
+		defer nekCloser()
+	}
+
+	if len(args) == 0 {
+		if newExpireKey == nil {
+			if mkv.IsAlive() {
+				return 0, zo.BaseDb.DeleteMetaKey(mk)
+			}
+			return 0, nil
+		}
+		if err = zo.SetMetaData(mk, mkv); err != nil {
+			return 0, err
+		}
+		return 0, zo.UpdateExpire(oldExpireKey, newExpireKey)
+	}
+
+	mkv.Reuse(zo.DataType, zo.GetNextKeyId())
+
+	dataWb := zo.GetDataWriteBatchFromPool()
+	defer zo.PutWriteBatchToPool(dataWb)
+	indexWb := zo.GetIndexWriteBatchFromPool()
+	defer zo.PutWriteBatchToPool(indexWb)
+
+	var scoreBuf [base.ScoreLength]byte
+	var ekfBuf [base.DataKeyZsetLength]byte
+	keyVersion := mkv.Version()
+	keyKind := mkv.Kind()
+	isZsetOld := mkv.IsZsetOld()
+	argsDup := make(map[string]struct{}, len(args))
+	for i := range args {
+		member := args[i].Member
+		if err = btools.CheckFieldSize(member); err != nil {
+			return 0, err
+		}
+		if _, exist := argsDup[unsafe2.String(member)]; exist {
+			continue
+		}
+		argsDup[unsafe2.String(member)] = struct{}{}
+
+		ekfLen := base.EncodeZsetDataKey(ekfBuf[:], keyVersion, khash, member, isZsetOld)
+		dataWb.Put(ekfBuf[:ekfLen], numeric.Float64ToByteSort(args[i].Score, scoreBuf[:]))
+		zo.setZsetIndexValue(indexWb, keyVersion, keyKind, khash, args[i].Score, member)
+		mkv.IncrSize(1)
+	}
+
+	if err = dataWb.Commit(); err != nil {
+		return 0, err
+	}
+	if err = indexWb.Commit(); err != nil {
+		return 0, err
+	}
+	if err = zo.SetMetaData(mk, mkv); err != nil {
+		return 0, err
+	}
+	if oldExpireKey != nil {
+		if err = zo.UpdateExpire(oldExpireKey, newExpireKey); err != nil {
+			return 0, err
+		}
+	}
+	return int64(mkv.Size()), nil
+}
+
+func (zo *ZSetObject) ZIncrBy(key []byte, khash uint32, isOld bool, delta float64, member []byte) (float64, error) {
+	score, _, err := zo.ZIncrByWithOptions(key, khash, isOld, btools.ZAddOptions{}, delta, member)
+	return score, err
+}
+
+// ZIncrByWithOptions is ZIncrBy under the ZADD INCR conditions, ok is false
+// when opts skip the update.
+func (zo *ZSetObject) ZIncrByWithOptions(
+	key []byte, khash uint32, isOld bool, opts btools.ZAddOptions, delta float64, member []byte,
+) (score float64, ok bool, err error) {
+	if err = btools.CheckKeyAndFieldSize(key, member); err != nil {
+		return 0, false, err
+	}
+
+	unlockKey := zo.LockKey(khash)
+	defer unlockKey()
+
+	mk, mkCloser := base.EncodeMetaKey(key, khash)
+	defer mkCloser()
+	mkv, err := zo.GetMetaDataNoneType(mk)
+	if err != nil {
+		return 0, false, err
+	}
+	defer base.PutMkvToPool(mkv)
+
+	kexist, err := zo.CheckMetaData(mkv)
+	if err != nil {
+		return 0, false, err
 	}
 
 	if isOld {
@@ -177,6 +303,9 @@ func (zo *ZSetObject) ZIncrBy(key []byte, khash uint32, isOld bool, delta float6
 	var updateCache func() = nil
 
 	if !kexist {
+		if opts.Skip(false, 0, delta) {
+			return 0, false, nil
+		}
 		mkv.IncrSize(1)
 		newScore = delta
 		var meta [base.MetaMixValueLen]byte
@@ -198,16 +327,31 @@ func (zo *ZSetObject) ZIncrBy(key []byte, khash uint32, isOld bool, delta float6
 			}
 		}()
 		if e != nil {
-			return 0, e
+			return 0, false, e
 		}
 		oldScore := float64(0)
 		if mbexist {
 			oldScore = numeric.ByteSortToFloat64(value)
-			if delta == 0 {
-				return oldScore, nil
+		}
+		if opts.Skip(mbexist, oldScore, oldScore+delta) {
+			return 0, false, nil
+		}
+		refreshed, e := zo.RefreshTTL(key, mkv, opts.RefreshTTL)
+		if e != nil {
+			return 0, false, e
+		}
+		if mbexist && delta == 0 {
+			if refreshed {
+				if err = zo.SetMetaData(mk, mkv); err != nil {
+					return 0, false, err
+				}
 			}
-		} else {
+			return oldScore, true, nil
+		}
+		if !mbexist {
 			mkv.IncrSize(1)
+		}
+		if !mbexist || refreshed {
 			var meta [base.MetaMixValueLen]byte
 			base.EncodeMetaDbValueForMix(meta[:], mkv)
 			metaWb.Put(mk, meta[:])
@@ -224,18 +368,18 @@ func (zo *ZSetObject) ZIncrBy(key []byte, khash uint32, isOld bool, delta float6
 	}
 
 	if err = dataWb.Commit(); err != nil {
-		return 0, err
+		return 0, false, err
 	}
 	if err = indexWb.Commit(); err != nil {
-		return 0, err
+		return 0, false, err
 	}
 	if err = metaWb.Commit(); err != nil {
-		return 0, err
+		return 0, false, err
 	} else if updateCache != nil {
 		updateCache()
 	}
 
-	return newScore, nil
+	return newScore, true, nil
 }
 
 func (zo *ZSetObject) ZRem(key []byte, khash uint32, members ...[]byte) (int64, error) {
@@ -319,6 +463,95 @@ func (zo *ZSetObject) ZRem(key []byte, khash uint32, members ...[]byte) (int64,
 	return count, err
 }
 
+// ZPop removes and returns up to count members with the lowest scores, or
+// the highest ones when max is set, in pop order.
+func (zo *ZSetObject) ZPop(key []byte, khash uint32, count int64, max bool) ([]btools.ScorePair, error) {
+	if err := btools.CheckKeySize(key); err != nil {
+		return nil, err
+	}
+	if count <= 0 {
+		return nil, nil
+	}
+
+	unlockKey := zo.LockKey(khash)
+	defer unlockKey()
+
+	mk, mkCloser := base.EncodeMetaKey(key, khash)
+	defer mkCloser()
+	mkv, err := zo.GetMetaData(mk)
+	if err != nil {
+		return nil, err
+	}
+	defer base.PutMkvToPool(mkv)
+	if !mkv.IsAlive() {
+		return nil, nil
+	}
+
+	if size := mkv.Size(); count > size {
+		count = size
+	}
+	res := make([]btools.ScorePair, 0, count)
+
+	dataWb := zo.GetDataWriteBatchFromPool()
+	defer zo.PutWriteBatchToPool(dataWb)
+	indexWb := zo.GetIndexWriteBatchFromPool()
+	defer zo.PutWriteBatchToPool(indexWb)
+
+	var dataKey [base.DataKeyZsetLength]byte
+	var lowerBound [base.DataKeyHeaderLength]byte
+	var upperBound [base.IndexKeyScoreLength]byte
+
+	keyVersion := mkv.Version()
+	keyKind := mkv.Kind()
+	isZsetOld := mkv.IsZsetOld()
+	base.EncodeDataKeyLowerBound(lowerBound[:], keyVersion, khash)
+	base.EncodeZsetIndexKeyUpperBound(upperBound[:], keyVersion, khash)
+	iterOpts := &bitskv.IterOptions{
+		KeyHash:    khash,
+		LowerBound: lowerBound[:],
+		UpperBound: upperBound[:],
+	}
+	it := zo.DataDb.NewIteratorIndex(iterOpts)
+	defer it.Close()
+
+	if max {
+		it.SeekLT(upperBound[:])
+	} else {
+		it.Seek(lowerBound[:])
+	}
+	for it.Valid() && int64(len(res)) < count {
+		indexKey := it.RawKey()
+		version, score, fp := base.DecodeZsetIndexKey(keyKind, indexKey, it.RawValue())
+		if version != keyVersion {
+			break
+		}
+		member := fp.Merge()
+		dataKeyLen := base.EncodeZsetDataKey(dataKey[:], keyVersion, khash, member, isZsetOld)
+		dataWb.Delete(dataKey[:dataKeyLen])
+		indexWb.Delete(indexKey)
+		res = append(res, btools.ScorePair{Member: member, Score: score})
+		if max {
+			it.Prev()
+		} else {
+			it.Next()
+		}
+	}
+
+	if len(res) > 0 {
+		if err = dataWb.Commit(); err != nil {
+			return nil, err
+		}
+		if err = indexWb.Commit(); err != nil {
+			return nil, err
+		}
+		mkv.DecrSize(uint32(len(res)))
+		if err = zo.SetMetaData(mk, mkv); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
 func (zo *ZSetObject) ZRemRangeByRank(key []byte, khash uint32, start int64, stop int64) (int64, error) {
 	if err := btools.CheckKeySize(key); err != nil {
 		return 0, err
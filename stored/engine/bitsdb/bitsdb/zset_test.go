@@ -22,6 +22,7 @@ import (
 	"math"
 	"math/rand"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
@@ -240,6 +241,128 @@ func TestZSet(t *testing.T) {
 	}
 }
 
+func TestZSetCardMeta(t *testing.T) {
+	for _, isOld := range []bool{true, false} {
+		t.Run(fmt.Sprintf("isOld=%v", isOld), func(t *testing.T) {
+			cores := testTwoBitsCores()
+			defer closeCores(cores)
+
+			for _, cr := range cores {
+				bdb := cr.db
+				key := []byte("testdb_zset_card_meta")
+				khash := hash.Fnv32(key)
+				members := make(map[string]struct{})
+				for i := 0; i < 300; i++ {
+					member := []byte(fmt.Sprintf("member_%d", rand.Intn(50)))
+					if rand.Intn(3) == 0 {
+						_, err := bdb.ZsetObj.ZRem(key, khash, member)
+						require.NoError(t, err)
+						delete(members, string(member))
+					} else {
+						_, err := bdb.ZsetObj.ZAdd(key, khash, isOld, spair(float64(i), member))
+						require.NoError(t, err)
+						members[string(member)] = struct{}{}
+					}
+
+					n, err := bdb.ZsetObj.ZCard(key, khash)
+					require.NoError(t, err)
+					require.Equal(t, int64(len(members)), n)
+
+					mkv, err := bdb.ZsetObj.GetMetaDataCheckAlive(key, khash)
+					require.NoError(t, err)
+					if mkv == nil {
+						require.Equal(t, 0, len(members))
+						continue
+					}
+					require.Equal(t, n, mkv.Size())
+					base.PutMkvToPool(mkv)
+				}
+				_, err := bdb.StringObj.Del(khash, key)
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestZSetWrongType(t *testing.T) {
+	cores := testTwoBitsCores()
+	defer closeCores(cores)
+
+	for _, cr := range cores {
+		bdb := cr.db
+		key := []byte("testdb_zset_wrong_type")
+		khash := hash.Fnv32(key)
+		require.NoError(t, bdb.StringObj.Set(key, khash, []byte("v")))
+
+		_, err := bdb.ZsetObj.ZAdd(key, khash, false, spair(1, []byte("a")))
+		require.Equal(t, errn.ErrWrongType, err)
+		_, err = bdb.ZsetObj.ZIncrBy(key, khash, false, 1, []byte("a"))
+		require.Equal(t, errn.ErrWrongType, err)
+		_, err = bdb.ZsetObj.ZCard(key, khash)
+		require.Equal(t, errn.ErrWrongType, err)
+		_, err = bdb.ZsetObj.ZScore(key, khash, []byte("a"))
+		require.Equal(t, errn.ErrWrongType, err)
+		_, err = bdb.ZsetObj.ZRange(key, khash, 0, -1)
+		require.Equal(t, errn.ErrWrongType, err)
+		_, err = bdb.ZsetObj.ZRem(key, khash, []byte("a"))
+		require.Equal(t, errn.ErrWrongType, err)
+
+		v, vcloser, err := bdb.StringObj.Get(key, khash)
+		require.NoError(t, err)
+		require.Equal(t, []byte("v"), v)
+		if vcloser != nil {
+			vcloser()
+		}
+		_, err = bdb.StringObj.Del(khash, key)
+		require.NoError(t, err)
+	}
+}
+
+func TestZSetCountApprox(t *testing.T) {
+	cores := testTwoBitsCores()
+	defer closeCores(cores)
+
+	for _, cr := range cores {
+		bdb := cr.db
+		key := []byte("testdb_zset_count_approx")
+		khash := hash.Fnv32(key)
+		const total = 20000
+		pairs := make([]btools.ScorePair, 0, 1000)
+		for i := 0; i < total; i++ {
+			pairs = append(pairs, spair(float64(i), []byte(fmt.Sprintf("member_%d", i))))
+			if len(pairs) == cap(pairs) {
+				_, err := bdb.ZsetObj.ZAdd(key, khash, false, pairs...)
+				require.NoError(t, err)
+				pairs = pairs[:0]
+			}
+		}
+
+		for _, r := range []struct {
+			min, max              float64
+			leftClose, rightClose bool
+		}{
+			{10, 100, false, false},
+			{10, 100, true, true},
+			{1000, 15000, false, false},
+			{1000, 15000, true, true},
+			{-math.MaxFloat64, math.MaxFloat64, false, false},
+			{5000, 5000, false, false},
+		} {
+			exact, err := bdb.ZsetObj.ZCount(key, khash, r.min, r.max, r.leftClose, r.rightClose)
+			require.NoError(t, err)
+			approx, err := bdb.ZsetObj.ZCountApprox(key, khash, r.min, r.max, r.leftClose, r.rightClose)
+			require.NoError(t, err)
+			if exact <= 1024 {
+				require.Equal(t, exact, approx)
+			} else {
+				require.InDelta(t, exact, approx, float64(exact)*0.02)
+			}
+		}
+		_, err := bdb.StringObj.Del(khash, key)
+		require.NoError(t, err)
+	}
+}
+
 func TestZSetIncrBy(t *testing.T) {
 	for _, isOld := range []bool{true, false} {
 		t.Run(fmt.Sprintf("isOld=%v", isOld), func(t *testing.T) {
@@ -807,6 +930,12 @@ func TestZsetLex(t *testing.T) {
 					t.Fatal("must equal b, c, d, e, f", fmt.Sprintf("%q", ay))
 				}
 
+				if ay, err := bdb.ZsetObj.ZRangeByLexGeneric(key, khash, []byte("aaa"), []byte("g"), false, true, 1, 2, true); err != nil {
+					t.Fatal(err)
+				} else if !reflect.DeepEqual(ay, []btools.ScorePair{spair(0, []byte("e")), spair(0, []byte("d"))}) {
+					t.Fatal("must equal e, d", ay)
+				}
+
 				if n, err := bdb.ZsetObj.ZLexCount(key, khash, []byte{'-'}, []byte{'+'}, false, false); err != nil {
 					t.Fatal(err)
 				} else if n != 7 {
@@ -1028,3 +1157,517 @@ func TestZsetZRem(t *testing.T) {
 		})
 	}
 }
+
+func TestZsetRangeByScoreOffset(t *testing.T) {
+	cores := testTwoBitsCores()
+	defer closeCores(cores)
+
+	for _, cr := range cores {
+		bdb := cr.db
+		key := []byte("testdb_zset_offset")
+		khash := hash.Fnv32(key)
+		membCnt := 10
+		for i := 0; i < membCnt; i++ {
+			if n, err := bdb.ZsetObj.ZAdd(key, khash, false, spair(float64(i), []byte(fmt.Sprintf("m%d", i)))); err != nil {
+				t.Fatal(err)
+			} else if n != 1 {
+				t.Fatal(n)
+			}
+		}
+
+		steps := bdb.ZsetObj.ScoreRangeSteps()
+		for _, offset := range []int{membCnt, membCnt + 1, 1000000} {
+			if res, err := bdb.ZsetObj.ZRangeByScore(key, khash, -math.MaxFloat64, math.MaxFloat64, false, false, offset, 10); err != nil {
+				t.Fatal(err)
+			} else if res == nil || len(res) != 0 {
+				t.Fatal("ZRangeByScore offset past end not empty", offset, res)
+			}
+			if res, err := bdb.ZsetObj.ZRevRangeByScore(key, khash, -math.MaxFloat64, math.MaxFloat64, false, false, offset, 10); err != nil {
+				t.Fatal(err)
+			} else if res == nil || len(res) != 0 {
+				t.Fatal("ZRevRangeByScore offset past end not empty", offset, res)
+			}
+		}
+		if n := bdb.ZsetObj.ScoreRangeSteps() - steps; n != 0 {
+			t.Fatal("offset past end iterated", n)
+		}
+
+		steps = bdb.ZsetObj.ScoreRangeSteps()
+		if res, err := bdb.ZsetObj.ZRangeByScore(key, khash, -math.MaxFloat64, math.MaxFloat64, false, false, 2, 3); err != nil {
+			t.Fatal(err)
+		} else if len(res) != 3 || res[0].Score != 2 {
+			t.Fatal("ZRangeByScore offset err", res)
+		}
+		if n := bdb.ZsetObj.ScoreRangeSteps() - steps; n != 5 {
+			t.Fatal("ZRangeByScore offset 2 count 3 steps", n)
+		}
+		steps = bdb.ZsetObj.ScoreRangeSteps()
+		if res, err := bdb.ZsetObj.ZRevRangeByScore(key, khash, -math.MaxFloat64, math.MaxFloat64, false, false, 2, 3); err != nil {
+			t.Fatal(err)
+		} else if len(res) != 3 || res[0].Score != float64(membCnt-3) {
+			t.Fatal("ZRevRangeByScore offset err", res)
+		}
+		if n := bdb.ZsetObj.ScoreRangeSteps() - steps; n != 5 {
+			t.Fatal("ZRevRangeByScore offset 2 count 3 steps", n)
+		}
+
+		if res, err := bdb.ZsetObj.ZRangeByScore(key, khash, -math.MaxFloat64, math.MaxFloat64, false, false, membCnt-1, 10); err != nil {
+			t.Fatal(err)
+		} else if len(res) != 1 || res[0].Score != float64(membCnt-1) {
+			t.Fatal("ZRangeByScore last offset err", res)
+		}
+		if res, err := bdb.ZsetObj.ZRevRangeByScore(key, khash, -math.MaxFloat64, math.MaxFloat64, false, false, membCnt-1, 10); err != nil {
+			t.Fatal(err)
+		} else if len(res) != 1 || res[0].Score != 0 {
+			t.Fatal("ZRevRangeByScore last offset err", res)
+		}
+	}
+}
+
+func TestZSetAddKeepTTL(t *testing.T) {
+	for _, isOld := range []bool{true, false} {
+		t.Run(fmt.Sprintf("isOld=%v", isOld), func(t *testing.T) {
+			cores := testTwoBitsCores()
+			defer closeCores(cores)
+
+			for _, cr := range cores {
+				bdb := cr.db
+				key := []byte("zadd_keepttl")
+				khash := hash.Fnv32(key)
+				if _, err := bdb.ZsetObj.ZAdd(key, khash, isOld, spair(1, []byte("a"))); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := bdb.StringObj.Expire(key, khash, 100); err != nil {
+					t.Fatal(err)
+				}
+
+				if n, err := bdb.ZsetObj.ZAdd(key, khash, isOld, spair(1, []byte("a"))); err != nil {
+					t.Fatal(err)
+				} else if n != 0 {
+					t.Fatal(n)
+				}
+				if n, err := bdb.ZsetObj.ZAdd(key, khash, isOld, spair(2, []byte("a"))); err != nil {
+					t.Fatal(err)
+				} else if n != 0 {
+					t.Fatal(n)
+				}
+				if n, err := bdb.ZsetObj.ZAdd(key, khash, isOld, spair(3, []byte("b"))); err != nil {
+					t.Fatal(err)
+				} else if n != 1 {
+					t.Fatal(n)
+				}
+				if _, err := bdb.ZsetObj.ZIncrBy(key, khash, isOld, 1, []byte("c")); err != nil {
+					t.Fatal(err)
+				}
+
+				if n, err := bdb.StringObj.TTL(key, khash); err != nil {
+					t.Fatal(err)
+				} else if n <= 0 || n > 100 {
+					t.Fatal("ttl not kept", n)
+				}
+				if n, err := bdb.ZsetObj.ZCard(key, khash); err != nil {
+					t.Fatal(err)
+				} else if n != 3 {
+					t.Fatal(n)
+				}
+			}
+		})
+	}
+}
+
+func TestZSetCountEqualBounds(t *testing.T) {
+	for _, isOld := range []bool{true, false} {
+		t.Run(fmt.Sprintf("isOld=%v", isOld), func(t *testing.T) {
+			cores := testTwoBitsCores()
+			defer closeCores(cores)
+
+			for _, cr := range cores {
+				bdb := cr.db
+				single := []byte("zcount_equal_single")
+				multi := []byte("zcount_equal_multi")
+				if _, err := bdb.ZsetObj.ZAdd(single, hash.Fnv32(single), isOld, spair(5, []byte("a"))); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := bdb.ZsetObj.ZAdd(multi, hash.Fnv32(multi), isOld,
+					spair(4, []byte("a")), spair(5, []byte("b")), spair(6, []byte("c"))); err != nil {
+					t.Fatal(err)
+				}
+
+				cases := []struct {
+					min, max              float64
+					leftClose, rightClose bool
+					expect                int64
+				}{
+					{5, 5, true, true, 0},
+					{5, 5, false, false, 1},
+					{5, 5, true, false, 0},
+					{5, 5, false, true, 0},
+				}
+				for _, key := range [][]byte{single, multi} {
+					for _, cs := range cases {
+						n, err := bdb.ZsetObj.ZCount(key, hash.Fnv32(key), cs.min, cs.max, cs.leftClose, cs.rightClose)
+						if err != nil {
+							t.Fatal(err)
+						}
+						if n != cs.expect {
+							t.Fatalf("key:%s case:%+v n:%d", key, cs, n)
+						}
+					}
+				}
+
+				if n, err := bdb.ZsetObj.ZCount(multi, hash.Fnv32(multi), 4, 6, true, true); err != nil {
+					t.Fatal(err)
+				} else if n != 1 {
+					t.Fatal(n)
+				}
+			}
+		})
+	}
+}
+
+func TestZSetAddOptions(t *testing.T) {
+	cores := testTwoBitsCores()
+	defer closeCores(cores)
+
+	for _, cr := range cores {
+		bdb := cr.db
+		key := []byte("testdb_zset_add_options")
+		khash := hash.Fnv32(key)
+		zadd := func(opts btools.ZAddOptions, pairs ...btools.ScorePair) int64 {
+			n, err := bdb.ZsetObj.ZAddWithOptions(key, khash, false, opts, pairs...)
+			require.NoError(t, err)
+			return n
+		}
+		zscore := func(member string) float64 {
+			score, err := bdb.ZsetObj.ZScore(key, khash, []byte(member))
+			require.NoError(t, err)
+			return score
+		}
+
+		require.Equal(t, int64(2), zadd(btools.ZAddOptions{}, spair(1, []byte("a")), spair(2, []byte("b"))))
+		require.Equal(t, int64(1), zadd(btools.ZAddOptions{NX: true}, spair(10, []byte("a")), spair(3, []byte("c"))))
+		require.Equal(t, float64(1), zscore("a"))
+		require.Equal(t, int64(0), zadd(btools.ZAddOptions{XX: true}, spair(10, []byte("a")), spair(4, []byte("d"))))
+		require.Equal(t, float64(10), zscore("a"))
+		_, err := bdb.ZsetObj.ZScore(key, khash, []byte("d"))
+		require.Equal(t, errn.ErrZsetMemberNil, err)
+		require.Equal(t, int64(1), zadd(btools.ZAddOptions{GT: true, CH: true}, spair(5, []byte("a")), spair(5, []byte("b"))))
+		require.Equal(t, float64(10), zscore("a"))
+		require.Equal(t, int64(1), zadd(btools.ZAddOptions{LT: true, CH: true}, spair(20, []byte("a")), spair(1, []byte("b"))))
+		require.Equal(t, float64(1), zscore("b"))
+		card, err := bdb.ZsetObj.ZCard(key, khash)
+		require.NoError(t, err)
+		require.Equal(t, int64(3), card)
+
+		_, ok, err := bdb.ZsetObj.ZIncrByWithOptions(key, khash, false, btools.ZAddOptions{NX: true}, 1, []byte("a"))
+		require.NoError(t, err)
+		require.False(t, ok)
+		_, ok, err = bdb.ZsetObj.ZIncrByWithOptions(key, khash, false, btools.ZAddOptions{XX: true}, 1, []byte("none"))
+		require.NoError(t, err)
+		require.False(t, ok)
+		score, ok, err := bdb.ZsetObj.ZIncrByWithOptions(key, khash, false, btools.ZAddOptions{GT: true}, 1, []byte("a"))
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, float64(11), score)
+		require.Equal(t, float64(11), zscore("a"))
+
+		_, err = bdb.StringObj.Del(khash, key)
+		require.NoError(t, err)
+	}
+}
+
+func TestZSetAddEpsilon(t *testing.T) {
+	cores := testTwoBitsCores()
+	defer closeCores(cores)
+
+	for _, cr := range cores {
+		bdb := cr.db
+		key := []byte("testdb_zset_add_epsilon")
+		khash := hash.Fnv32(key)
+		zadd := func(opts btools.ZAddOptions, score float64) int64 {
+			n, err := bdb.ZsetObj.ZAddWithOptions(key, khash, false, opts, spair(score, []byte("a")))
+			require.NoError(t, err)
+			return n
+		}
+
+		drifted := 0.0
+		for i := 0; i < 10; i++ {
+			drifted += 0.1
+		}
+		require.NotEqual(t, float64(1), drifted)
+		require.Equal(t, int64(1), zadd(btools.ZAddOptions{}, drifted))
+
+		require.Equal(t, int64(0), zadd(btools.ZAddOptions{CH: true, Epsilon: 1e-9}, 1))
+		score, err := bdb.ZsetObj.ZScore(key, khash, []byte("a"))
+		require.NoError(t, err)
+		require.Equal(t, float64(1), score)
+
+		require.Equal(t, int64(1), zadd(btools.ZAddOptions{CH: true}, drifted))
+		require.Equal(t, int64(1), zadd(btools.ZAddOptions{CH: true, Epsilon: 1e-9}, 1.5))
+		score, err = bdb.ZsetObj.ZScore(key, khash, []byte("a"))
+		require.NoError(t, err)
+		require.Equal(t, 1.5, score)
+
+		_, err = bdb.StringObj.Del(khash, key)
+		require.NoError(t, err)
+	}
+}
+
+func TestZSetRandMember(t *testing.T) {
+	cores := testTwoBitsCores()
+	defer closeCores(cores)
+
+	for _, cr := range cores {
+		bdb := cr.db
+		key := []byte("testdb_zset_randmember")
+		khash := hash.Fnv32(key)
+		membCnt := 20
+		scores := make(map[string]float64, membCnt)
+		for i := 0; i < membCnt; i++ {
+			member := []byte(fmt.Sprintf("m%d", i))
+			if i%2 == 0 {
+				member = append(member, testRandBytes(base.KeyFieldCompressSize)...)
+			}
+			scores[string(member)] = float64(i)
+			if _, err := bdb.ZsetObj.ZAdd(key, khash, false, spair(float64(i), member)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		check := func(count int64, wantLen int, distinct bool) {
+			res, err := bdb.ZsetObj.ZRandMember(key, khash, count)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(res) != wantLen {
+				t.Fatalf("count %d len %d want %d", count, len(res), wantLen)
+			}
+			seen := make(map[string]bool, len(res))
+			for _, sp := range res {
+				score, ok := scores[string(sp.Member)]
+				if !ok || score != sp.Score {
+					t.Fatalf("count %d bad pair %s %v", count, sp.Member, sp.Score)
+				}
+				if distinct && seen[string(sp.Member)] {
+					t.Fatalf("count %d repeated %s", count, sp.Member)
+				}
+				seen[string(sp.Member)] = true
+			}
+		}
+		check(0, 0, true)
+		check(1, 1, true)
+		check(5, 5, true)
+		check(int64(membCnt), membCnt, true)
+		check(100, membCnt, true)
+		check(-5, 5, false)
+		check(-100, 100, false)
+
+		picked := make(map[string]bool, membCnt)
+		for i := 0; i < 200 && len(picked) < membCnt; i++ {
+			res, err := bdb.ZsetObj.ZRandMember(key, khash, 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, sp := range res {
+				picked[string(sp.Member)] = true
+			}
+		}
+		if len(picked) != membCnt {
+			t.Fatalf("sampled %d of %d members", len(picked), membCnt)
+		}
+
+		if res, err := bdb.ZsetObj.ZRandMember([]byte("testdb_zset_randmember_none"), khash, -3); err != nil || len(res) != 0 {
+			t.Fatal("missing key", res, err)
+		}
+	}
+}
+
+func TestZSetPop(t *testing.T) {
+	cores := testTwoBitsCores()
+	defer closeCores(cores)
+
+	for _, cr := range cores {
+		bdb := cr.db
+		key := []byte("testdb_zset_pop")
+		khash := hash.Fnv32(key)
+		long := testRandBytes(base.KeyFieldCompressSize)
+		for i, member := range [][]byte{[]byte("a"), []byte("b"), long, []byte("d"), []byte("e")} {
+			if _, err := bdb.ZsetObj.ZAdd(key, khash, false, spair(float64(i), member)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		check := func(count int64, max bool, want ...string) {
+			res, err := bdb.ZsetObj.ZPop(key, khash, count, max)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(res) != len(want) {
+				t.Fatalf("count %d max %v got %d want %v", count, max, len(res), want)
+			}
+			for i := range res {
+				if string(res[i].Member) != want[i] {
+					t.Fatalf("count %d max %v pos %d got %s want %s", count, max, i, res[i].Member, want[i])
+				}
+			}
+		}
+		check(1, false, "a")
+		check(2, true, "e", "d")
+		check(0, false)
+		if n, err := bdb.ZsetObj.ZCard(key, khash); err != nil || n != 2 {
+			t.Fatal("zcard after pop", n, err)
+		}
+		if _, err := bdb.ZsetObj.ZScore(key, khash, []byte("a")); err == nil {
+			t.Fatal("popped member still has a score")
+		}
+		check(10, false, "b", string(long))
+		check(1, false)
+		if n, err := bdb.ZsetObj.ZCard(key, khash); err != nil || n != 0 {
+			t.Fatal("zcard after pop all", n, err)
+		}
+	}
+}
+
+func TestZSetInterCard(t *testing.T) {
+	cores := testTwoBitsCores()
+	defer closeCores(cores)
+
+	for _, cr := range cores {
+		bdb := cr.db
+		keys := [][]byte{[]byte("testdb_zset_intercard_1"), []byte("testdb_zset_intercard_2"), []byte("testdb_zset_intercard_3")}
+		khashes := make([]uint32, len(keys))
+		for i, key := range keys {
+			khashes[i] = hash.Fnv32(key)
+		}
+		long := testRandBytes(base.KeyFieldCompressSize)
+		add := func(i int, members ...[]byte) {
+			for j, member := range members {
+				_, err := bdb.ZsetObj.ZAdd(keys[i], khashes[i], false, spair(float64(j), member))
+				require.NoError(t, err)
+			}
+		}
+		add(0, []byte("a"), []byte("b"), []byte("c"), []byte("d"), long)
+		add(1, []byte("b"), []byte("c"), []byte("d"), long, []byte("x"), []byte("y"))
+		add(2, []byte("c"), []byte("d"), long)
+
+		card := func(limit int64, n ...int) int64 {
+			ks, hs := make([][]byte, 0, len(n)), make([]uint32, 0, len(n))
+			for _, i := range n {
+				ks, hs = append(ks, keys[i]), append(hs, khashes[i])
+			}
+			res, err := bdb.ZsetObj.ZInterCard(ks, hs, limit)
+			require.NoError(t, err)
+			return res
+		}
+		require.Equal(t, int64(5), card(0, 0))
+		require.Equal(t, int64(4), card(0, 0, 1))
+		require.Equal(t, int64(3), card(0, 0, 1, 2))
+		require.Equal(t, int64(3), card(0, 2, 1, 0))
+		require.Equal(t, int64(2), card(2, 0, 1, 2))
+		require.Equal(t, int64(3), card(10, 0, 1, 2))
+
+		res, err := bdb.ZsetObj.ZInterCard([][]byte{keys[0], []byte("testdb_zset_intercard_none")}, []uint32{khashes[0], hash.Fnv32([]byte("testdb_zset_intercard_none"))}, 0)
+		require.NoError(t, err)
+		require.Equal(t, int64(0), res)
+	}
+}
+
+func TestZSetDebugInternals(t *testing.T) {
+	maxFieldSize := btools.MaxFieldSize
+	btools.MaxFieldSize = 40 << 10
+	defer func() {
+		btools.MaxFieldSize = maxFieldSize
+	}()
+
+	cores := testTwoBitsCores()
+	defer closeCores(cores)
+
+	for _, cr := range cores {
+		bdb := cr.db
+		key := []byte("testdb_zset_debug_internals")
+		khash := hash.Fnv32(key)
+
+		info, err := bdb.ZsetObj.DebugInternals(key, khash)
+		require.NoError(t, err)
+		require.Contains(t, string(info), "members:0 ")
+		require.Contains(t, string(info), "member_encoding:inline=0,overshort=0,overlong=0 member_bytes:0")
+
+		for i, c := range []struct {
+			size   int
+			expect string
+		}{
+			{127, "member_encoding:inline=1,overshort=0,overlong=0 member_bytes:127"},
+			{128, "member_encoding:inline=1,overshort=1,overlong=0 member_bytes:255"},
+			{(1 << 15) - 2, "member_encoding:inline=1,overshort=2,overlong=0 member_bytes:33021"},
+			{(1 << 15) - 1, "member_encoding:inline=1,overshort=2,overlong=1 member_bytes:65788"},
+		} {
+			_, err = bdb.ZsetObj.ZAdd(key, khash, false, spair(float64(i), testRandBytes(c.size)))
+			require.NoError(t, err)
+			info, err = bdb.ZsetObj.DebugInternals(key, khash)
+			require.NoError(t, err)
+			require.Contains(t, string(info), fmt.Sprintf("members:%d ", i+1))
+			require.Contains(t, string(info), c.expect)
+		}
+		require.Contains(t, string(info), "index_encoding:inline=0,overshort=2,overlong=2")
+	}
+}
+
+func TestZSetStore(t *testing.T) {
+	cores := testTwoBitsCores()
+	defer closeCores(cores)
+
+	for _, cr := range cores {
+		bdb := cr.db
+		key := []byte("testdb_zset_store")
+		khash := hash.Fnv32(key)
+
+		_, err := bdb.ZsetObj.ZAdd(key, khash, false, spair(1, []byte("a")), spair(2, []byte("b")))
+		require.NoError(t, err)
+		_, err = bdb.StringObj.Expire(key, khash, 100)
+		require.NoError(t, err)
+
+		n, err := bdb.ZsetObj.ZStore(key, khash, spair(3, []byte("c")), spair(4, []byte("c")))
+		require.NoError(t, err)
+		require.Equal(t, int64(1), n)
+		res, err := bdb.ZsetObj.ZRange(key, khash, 0, -1)
+		require.NoError(t, err)
+		require.Equal(t, []btools.ScorePair{spair(3, []byte("c"))}, res)
+		ttl, err := bdb.StringObj.TTL(key, khash)
+		require.NoError(t, err)
+		require.Equal(t, int64(-1), ttl)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				args := make([]btools.ScorePair, 0, 16)
+				for j := 0; j < 16; j++ {
+					args = append(args, spair(float64(j), []byte(fmt.Sprintf("m%d_%d", i, j))))
+				}
+				if _, e := bdb.ZsetObj.ZStore(key, khash, args...); e != nil {
+					t.Error(e)
+				}
+			}(i)
+		}
+		wg.Wait()
+		card, err := bdb.ZsetObj.ZCard(key, khash)
+		require.NoError(t, err)
+		require.Equal(t, int64(16), card)
+
+		n, err = bdb.ZsetObj.ZStore(key, khash)
+		require.NoError(t, err)
+		require.Equal(t, int64(0), n)
+		card, err = bdb.ZsetObj.ZCard(key, khash)
+		require.NoError(t, err)
+		require.Equal(t, int64(0), card)
+
+		require.NoError(t, bdb.StringObj.Set(key, khash, []byte("v")))
+		n, err = bdb.ZsetObj.ZStore(key, khash, spair(1, []byte("a")))
+		require.NoError(t, err)
+		require.Equal(t, int64(1), n)
+		typ, err := bdb.StringObj.Type(key, khash)
+		require.NoError(t, err)
+		require.Equal(t, "zset", typ)
+	}
+}
@@ -14,7 +14,10 @@
 
 package btools
 
-import "bytes"
+import (
+	"bytes"
+	"math"
+)
 
 type DataType uint8
 
@@ -100,6 +103,37 @@ type ScorePair struct {
 	Member []byte
 }
 
+// ZAddOptions are the update conditions of ZADD, NX only adds new members, XX
+// only updates existing ones, GT/LT only update to a greater/less score and
+// CH counts the changed members instead of the added ones.
+type ZAddOptions struct {
+	NX, XX, GT, LT, CH bool
+	// Epsilon is the largest score difference CH still counts as unchanged,
+	// 0 compares exactly.
+	Epsilon float64
+	// RefreshTTL slides the ttl of a live key that has one to RefreshTTL
+	// seconds from now in the same write, 0 leaves the ttl alone.
+	RefreshTTL int64
+}
+
+// Changed reports whether CH counts the re-score of a member from old to score.
+// The new score is written either way, so every node stores the same value
+// whatever its epsilon.
+func (o ZAddOptions) Changed(old, score float64) bool {
+	if o.Epsilon <= 0 {
+		return old != score
+	}
+	return math.Abs(score-old) > o.Epsilon
+}
+
+// Skip reports whether a member update from old to score is not allowed.
+func (o ZAddOptions) Skip(exist bool, old, score float64) bool {
+	if !exist {
+		return o.XX
+	}
+	return o.NX || (o.GT && score <= old) || (o.LT && score >= old)
+}
+
 type FieldPair struct {
 	Prefix, Suffix []byte
 }
@@ -14,12 +14,25 @@
 
 package engine
 
-import "github.com/zuoyebang/bitalostored/stored/engine/bitsdb/btools"
+import (
+	"bytes"
+	"math"
+	"sort"
+
+	"github.com/zuoyebang/bitalostored/butils/unsafe2"
+	"github.com/zuoyebang/bitalostored/stored/engine/bitsdb/btools"
+)
 
 func (b *Bitalos) ZAdd(
-	key []byte, khash uint32, args ...btools.ScorePair,
+	key []byte, khash uint32, opts btools.ZAddOptions, args ...btools.ScorePair,
 ) (int64, error) {
-	return b.bitsdb.ZsetObj.ZAdd(key, khash, false, args...)
+	return b.bitsdb.ZsetObj.ZAddWithOptions(key, khash, false, opts, args...)
+}
+
+func (b *Bitalos) ZAddIncr(
+	key []byte, khash uint32, opts btools.ZAddOptions, delta float64, member []byte,
+) (float64, bool, error) {
+	return b.bitsdb.ZsetObj.ZIncrByWithOptions(key, khash, false, opts, delta, member)
 }
 
 func (b *Bitalos) ZIncrBy(
@@ -42,6 +55,21 @@ func (b *Bitalos) ZRemRangeByScore(
 	return b.bitsdb.ZsetObj.ZRemRangeByScore(key, khash, min, max, leftClose, rightClose)
 }
 
+// ZMPop pops up to count members from the first key holding any, it returns
+// a nil key when every set is empty.
+func (b *Bitalos) ZMPop(keys [][]byte, khashes []uint32, count int64, max bool) ([]byte, []btools.ScorePair, error) {
+	for i, key := range keys {
+		res, err := b.bitsdb.ZsetObj.ZPop(key, khashes[i], count, max)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(res) > 0 {
+			return key, res, nil
+		}
+	}
+	return nil, nil, nil
+}
+
 func (b *Bitalos) ZRemRangeByRank(
 	key []byte, khash uint32, start int64, stop int64,
 ) (int64, error) {
@@ -65,6 +93,15 @@ func (b *Bitalos) ZRangeByLex(
 	return b.bitsdb.ZsetObj.ZRangeByLex(key, khash, min, max, leftClose, rightClose, offset, count)
 }
 
+func (b *Bitalos) ZRangeByLexGeneric(
+	key []byte, khash uint32,
+	min []byte, max []byte,
+	leftClose bool, rightClose bool,
+	offset int, count int, reverse bool,
+) ([]btools.ScorePair, error) {
+	return b.bitsdb.ZsetObj.ZRangeByLexGeneric(key, khash, min, max, leftClose, rightClose, offset, count, reverse)
+}
+
 func (b *Bitalos) ZRangeGeneric(
 	key []byte, khash uint32, start int64, stop int64, reverse bool,
 ) ([]btools.ScorePair, error) {
@@ -88,6 +125,22 @@ func (b *Bitalos) ZRangeByScoreGeneric(
 	}
 }
 
+// ZRangeByScoreFunc streams the ascending ZRangeByScoreGeneric result to fn
+// without collecting it, the member is only valid inside fn.
+func (b *Bitalos) ZRangeByScoreFunc(
+	key []byte, khash uint32,
+	min float64, max float64,
+	leftClose bool, rightClose bool,
+	offset int, count int,
+	fn func(member btools.FieldPair, score float64),
+) error {
+	return b.bitsdb.ZsetObj.ZRangeByScoreFunc(key, khash, min, max, leftClose, rightClose, offset, count, fn)
+}
+
+func (b *Bitalos) ZRandMember(key []byte, khash uint32, count int64) ([]btools.ScorePair, error) {
+	return b.bitsdb.ZsetObj.ZRandMember(key, khash, count)
+}
+
 func (b *Bitalos) ZRank(key []byte, khash uint32, member []byte) (int64, error) {
 	return b.bitsdb.ZsetObj.ZRank(key, khash, member)
 }
@@ -116,10 +169,177 @@ func (b *Bitalos) ZCount(
 	return b.bitsdb.ZsetObj.ZCount(key, khash, min, max, leftClose, rightClose)
 }
 
+func (b *Bitalos) ZCountApprox(
+	key []byte, khash uint32,
+	min float64, max float64,
+	leftClose bool, rightClose bool,
+) (int64, error) {
+	return b.bitsdb.ZsetObj.ZCountApprox(key, khash, min, max, leftClose, rightClose)
+}
+
 func (b *Bitalos) ZClear(khash uint32, key ...[]byte) (int64, error) {
 	return b.bitsdb.ZsetObj.Del(khash, key...)
 }
 
+// ZRangeStore replaces dst with args, dst is deleted when args is empty.
+func (b *Bitalos) ZRangeStore(dst []byte, khash uint32, args ...btools.ScorePair) (int64, error) {
+	return b.bitsdb.ZsetObj.ZStore(dst, khash, args...)
+}
+
+func (b *Bitalos) ZDebugInternals(key []byte, khash uint32) ([]byte, error) {
+	return b.bitsdb.ZsetObj.DebugInternals(key, khash)
+}
+
 func (b *Bitalos) ZCard(key []byte, khash uint32) (int64, error) {
 	return b.bitsdb.ZsetObj.ZCard(key, khash)
 }
+
+func (b *Bitalos) ZInterCard(keys [][]byte, khashes []uint32, limit int64) (int64, error) {
+	return b.bitsdb.ZsetObj.ZInterCard(keys, khashes, limit)
+}
+
+// ZCombine merges the sorted sets at keys, each read under its khashes entry.
+// Scores are scaled by weights and folded by aggregate for members found in
+// several sets; without union only members present in every set are kept.
+// A union with limit >= 0 stops reading once it has more than limit members
+// and returns just limit+1 of them, enough to tell the result is too large.
+func (b *Bitalos) ZCombine(
+	keys [][]byte, khashes []uint32, weights []float64,
+	aggregate func(a, b float64) float64, union bool, limit int,
+) ([]btools.ScorePair, error) {
+	stop := int64(-1)
+	if union && limit >= 0 {
+		stop = int64(limit)
+	}
+	var result map[string]float64
+	var members [][]byte
+	for i, key := range keys {
+		datas, err := b.ZRangeGeneric(key, khashes[i], 0, stop, false)
+		if err != nil {
+			return nil, err
+		}
+
+		weight := float64(1)
+		if weights != nil {
+			weight = weights[i]
+		}
+
+		cur := make(map[string]float64, len(datas))
+		for _, sp := range datas {
+			score := sp.Score * weight
+			if math.IsNaN(score) {
+				score = 0
+			}
+			cur[unsafe2.String(sp.Member)] = score
+		}
+
+		if i == 0 {
+			result = cur
+			for _, sp := range datas {
+				members = append(members, sp.Member)
+			}
+		} else if union {
+			for _, sp := range datas {
+				m := unsafe2.String(sp.Member)
+				if old, ok := result[m]; ok {
+					result[m] = aggregate(old, cur[m])
+				} else {
+					result[m] = cur[m]
+					members = append(members, sp.Member)
+				}
+			}
+		} else {
+			n := 0
+			for _, member := range members {
+				m := unsafe2.String(member)
+				if score, ok := cur[m]; ok {
+					result[m] = aggregate(result[m], score)
+					members[n] = member
+					n++
+				} else {
+					delete(result, m)
+				}
+			}
+			members = members[:n]
+		}
+
+		if stop >= 0 && len(members) > limit {
+			members = members[:limit+1]
+			break
+		}
+	}
+
+	res := make([]btools.ScorePair, len(members))
+	for i, member := range members {
+		res[i].Member = member
+		res[i].Score = result[unsafe2.String(member)]
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Score != res[j].Score {
+			return res[i].Score < res[j].Score
+		}
+		return bytes.Compare(res[i].Member, res[j].Member) < 0
+	})
+	return res, nil
+}
+
+func (b *Bitalos) ZUnionStore(
+	dst []byte, khash uint32, keys [][]byte, khashes []uint32,
+	weights []float64, aggregate func(a, b float64) float64,
+) (int64, error) {
+	res, err := b.ZCombine(keys, khashes, weights, aggregate, true, -1)
+	if err != nil {
+		return 0, err
+	}
+	return b.ZRangeStore(dst, khash, res...)
+}
+
+func (b *Bitalos) ZInterStore(
+	dst []byte, khash uint32, keys [][]byte, khashes []uint32,
+	weights []float64, aggregate func(a, b float64) float64,
+) (int64, error) {
+	res, err := b.ZCombine(keys, khashes, weights, aggregate, false, -1)
+	if err != nil {
+		return 0, err
+	}
+	return b.ZRangeStore(dst, khash, res...)
+}
+
+// ZDiff returns the members of the first set that are absent from all the
+// others, keeping their scores and order from the first set.
+func (b *Bitalos) ZDiff(keys [][]byte, khashes []uint32) ([]btools.ScorePair, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	datas, err := b.ZRangeGeneric(keys[0], khashes[0], 0, -1, false)
+	if err != nil || len(datas) == 0 {
+		return nil, err
+	}
+
+	excluded := make(map[string]struct{})
+	for i := 1; i < len(keys); i++ {
+		others, err := b.ZRangeGeneric(keys[i], khashes[i], 0, -1, false)
+		if err != nil {
+			return nil, err
+		}
+		for _, sp := range others {
+			excluded[unsafe2.String(sp.Member)] = struct{}{}
+		}
+	}
+
+	res := datas[:0]
+	for _, sp := range datas {
+		if _, ok := excluded[unsafe2.String(sp.Member)]; !ok {
+			res = append(res, sp)
+		}
+	}
+	return res, nil
+}
+
+func (b *Bitalos) ZDiffStore(dst []byte, khash uint32, keys [][]byte, khashes []uint32) (int64, error) {
+	res, err := b.ZDiff(keys, khashes)
+	if err != nil {
+		return 0, err
+	}
+	return b.ZRangeStore(dst, khash, res...)
+}
@@ -24,7 +24,6 @@ import (
 	"time"
 
 	"github.com/gomodule/redigo/redis"
-	"github.com/zuoyebang/bitalostored/butils/hash"
 	"github.com/zuoyebang/bitalostored/butils/unsafe2"
 	"github.com/zuoyebang/bitalostored/stored/engine/bitsdb/bitsdb"
 	"github.com/zuoyebang/bitalostored/stored/engine/bitsdb/bitsdb/locker"
@@ -93,11 +92,11 @@ func (m *Migrate) migrateTTL(key []byte, khash uint32, conn redis.Conn, isHashTa
 
 func (m *Migrate) getKeyHash(key []byte) (uint32, bool) {
 	var isHashTag bool
-	khash := hash.Fnv32(key)
+	khash := utils.KeyHash(key)
 	slotId := khash % utils.TotalSlot
 	if slotId != m.slotId {
 		isHashTag = true
-		khash = utils.GetHashTagFnv(key)
+		khash = utils.GetHashTagKeyHash(key)
 	}
 
 	return khash, isHashTag
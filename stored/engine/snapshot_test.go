@@ -63,7 +63,7 @@ func TestDoCheckpoint(t *testing.T) {
 			Member: []byte("member"),
 			Score:  1,
 		}
-		if n, err := db.ZAdd([]byte("test-zset"), hash.Fnv32([]byte("test-zset")), zaddArgs); err != nil {
+		if n, err := db.ZAdd([]byte("test-zset"), hash.Fnv32([]byte("test-zset")), btools.ZAddOptions{}, zaddArgs); err != nil {
 			t.Fatal(err)
 		} else if n != 1 {
 			t.Fatal(n)
@@ -135,6 +135,11 @@ type ServerConfig struct {
 	DisableEdgeTriggered bool              `toml:"disable_edge_triggered" mapstructure:"disable_edge_triggered"`
 	NetEventLoopNum      int               `toml:"net_event_loop_num" mapstructure:"net_event_loop_num"`
 	NetWriteBuffer       bytesize.Int64    `toml:"net_write_buffer" mapstructure:"net_write_buffer"`
+	RespWriterBuffer     bytesize.Int64    `toml:"resp_writer_buffer" mapstructure:"resp_writer_buffer"`
+
+	OutputBufferHardLimit bytesize.Int64    `toml:"output_buffer_hard_limit" mapstructure:"output_buffer_hard_limit"`
+	OutputBufferSoftLimit bytesize.Int64    `toml:"output_buffer_soft_limit" mapstructure:"output_buffer_soft_limit"`
+	OutputBufferSoftTime  timesize.Duration `toml:"output_buffer_soft_time" mapstructure:"output_buffer_soft_time"`
 
 	SlowShield        bool              `toml:"slow_shield" mapstructure:"slow_shield"`
 	SlowTime          timesize.Duration `toml:"slow_time" mapstructure:"slow_time"`
@@ -142,10 +147,30 @@ type ServerConfig struct {
 	SlowTTL           timesize.Duration `toml:"slow_ttl" mapstructure:"slow_ttl"`
 	SlowMaxExec       int               `toml:"slow_maxexec" mapstructure:"slow_maxexec"`
 	SlowTopN          int               `toml:"slow_topn" mapstructure:"slow_topn"`
-
-	Token             string `toml:"token" mapstructure:"token"`
-	DegradeSingleNode bool   `toml:"degrade_signle_node" mapstructure:"degrade_signle_node"`
-	OpenDistributedTx bool   `toml:"open_distributed_tx" mapstructure:"open_distributed_tx"`
+	SlowLogMaxLen     int               `toml:"slow_log_max_len" mapstructure:"slow_log_max_len"`
+	SlowLogCacheMaint bool              `toml:"slow_log_cache_maint" mapstructure:"slow_log_cache_maint"`
+
+	MaxReplyElements int   `toml:"max_reply_elements" mapstructure:"max_reply_elements"`
+	ZsetRefreshTTL   int64 `toml:"zset_refresh_ttl" mapstructure:"zset_refresh_ttl"`
+
+	// ZaddChEpsilon keeps ZADD CH from counting re-scores closer than it to
+	// the stored score. The new score is still written, only the reply
+	// changes: it hides float noise from ZINCRBY chains at the cost of not
+	// reporting small real moves. 0 compares exactly.
+	ZaddChEpsilon float64 `toml:"zadd_ch_epsilon" mapstructure:"zadd_ch_epsilon"`
+
+	// KeyHash names the key hash function, fnv32 (default) or crc32. It is
+	// part of the stored keys and can't be changed on an existing db, and
+	// proxy and dashboard key_hash must name the same function.
+	KeyHash string `toml:"key_hash" mapstructure:"key_hash"`
+
+	Token              string `toml:"token" mapstructure:"token"`
+	DegradeSingleNode  bool   `toml:"degrade_signle_node" mapstructure:"degrade_signle_node"`
+	OpenDistributedTx  bool   `toml:"open_distributed_tx" mapstructure:"open_distributed_tx"`
+	TxMaxQueueCommands int    `toml:"tx_max_queue_commands" mapstructure:"tx_max_queue_commands"`
+	TxMaxWatchKeys     int    `toml:"tx_max_watch_keys" mapstructure:"tx_max_watch_keys"`
+
+	TxPrepareLockTimeout timesize.Duration `toml:"tx_prepare_lock_timeout" mapstructure:"tx_prepare_lock_timeout"`
 }
 
 type BitalosConfig struct {
@@ -16,11 +16,13 @@ package config
 
 import (
 	"errors"
+	"math"
 	"os"
 	"path"
 	"time"
 
 	"github.com/zuoyebang/bitalostored/butils/bytesize"
+	"github.com/zuoyebang/bitalostored/butils/hash"
 	"github.com/zuoyebang/bitalostored/butils/timesize"
 	"github.com/zuoyebang/bitalostored/stored/internal/log"
 )
@@ -32,6 +34,13 @@ const (
 	MaxCores           = 20
 	MinNetEventLoopNum = 8
 	MaxNetEventLoopNum = 256
+
+	DefaultTxMaxQueueCommands = 10000
+	DefaultTxMaxWatchKeys     = 1000
+
+	DefaultTxPrepareLockTimeout = 50 * time.Millisecond
+
+	DefaultSlowLogMaxLen = 128
 )
 
 func (c *Config) Validate() error {
@@ -74,6 +83,23 @@ func (c *Config) checkServerConfig() error {
 	if c.Server.SlowTime <= 0 {
 		c.Server.SlowTime = timesize.Duration(30 * time.Millisecond)
 	}
+	if c.Server.SlowLogMaxLen <= 0 {
+		c.Server.SlowLogMaxLen = DefaultSlowLogMaxLen
+	}
+	if c.Server.MaxReplyElements < 0 {
+		c.Server.MaxReplyElements = 0
+	}
+	if c.Server.ZsetRefreshTTL < 0 {
+		c.Server.ZsetRefreshTTL = 0
+	}
+	if !(c.Server.ZaddChEpsilon > 0) || math.IsInf(c.Server.ZaddChEpsilon, 1) {
+		c.Server.ZaddChEpsilon = 0
+	}
+	if c.Server.KeyHash == "" {
+		c.Server.KeyHash = hash.KeyHashFnv32
+	} else if err := hash.CheckKeyHash(c.Server.KeyHash); err != nil {
+		return err
+	}
 	if c.Server.Maxclient < 5000 {
 		c.Server.Maxclient = 5000
 	}
@@ -92,6 +118,15 @@ func (c *Config) checkServerConfig() error {
 	if c.Server.NetEventLoopNum > MaxNetEventLoopNum {
 		c.Server.NetEventLoopNum = MaxNetEventLoopNum
 	}
+	if c.Server.TxMaxQueueCommands <= 0 {
+		c.Server.TxMaxQueueCommands = DefaultTxMaxQueueCommands
+	}
+	if c.Server.TxMaxWatchKeys <= 0 {
+		c.Server.TxMaxWatchKeys = DefaultTxMaxWatchKeys
+	}
+	if c.Server.TxPrepareLockTimeout <= 0 {
+		c.Server.TxPrepareLockTimeout = timesize.Duration(DefaultTxPrepareLockTimeout)
+	}
 
 	return nil
 }
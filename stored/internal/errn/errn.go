@@ -19,6 +19,8 @@ import (
 	"fmt"
 )
 
+// Errors replied to clients start with a code such as ERR, WRONGTYPE or
+// READONLY, so clients can tell them apart without matching the message.
 var (
 	ErrSyntax                 = errors.New("ERR syntax error")
 	ErrLenArg                 = errors.New("ERR args len is wrong")
@@ -33,20 +35,33 @@ var (
 	ErrPrepareNested          = errors.New("ERR PREPARE calls can not be nested")
 	ErrExecNotPrepared        = errors.New("ERR Exec not prepared")
 	ErrDiscardNoMulti         = errors.New("ERR DISCARD without MULTI")
+	ErrTxQueueLimit           = errors.New("ERR too many commands queued in MULTI")
+	ErrExecAbort              = errors.New("EXECABORT Transaction discarded because of previous errors.")
+	ErrTxWatchLimit           = errors.New("ERR too many watched keys")
+	ErrReplyTooLarge          = errors.New("ERR reply exceeds max reply elements")
+	ErrOutputBufferLimit      = errors.New("ERR client output buffer limit reached")
+	ErrCompactRunning         = errors.New("ERR compaction already in progress")
+	ErrNoProto                = errors.New("NOPROTO unsupported protocol version")
+	ErrTxExecPanic            = errors.New("ERR command panicked during EXEC")
+	ErrReadOnly               = errors.New("READONLY You can't write against a read only replica.")
 	ErrProtocol               = errors.New("invalid request")
 	ErrRaftNotReady           = errors.New("raft is not ready")
+	ErrNotLeader              = errors.New("ERR node is not the raft leader")
+	ErrTransferTarget         = errors.New("ERR invalid leader transfer target")
 	ErrWrongType              = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
-	ErrKeySize                = errors.New("invalid key size")
-	ErrValueSize              = errors.New("invalid value size")
-	ErrArgsEmpty              = errors.New("invalid args empty")
-	ErrFieldSize              = errors.New("invalid field size")
-	ErrExpireValue            = errors.New("invalid expire value")
-	ErrZSetScoreRange         = errors.New("invalid zset score range")
+	ErrCrossSlot              = errors.New("CROSSSLOT Keys in request don't hash to the same slot")
+	ErrKeySize                = errors.New("ERR invalid key size")
+	ErrValueSize              = errors.New("ERR invalid value size")
+	ErrArgsEmpty              = errors.New("ERR invalid args empty")
+	ErrFieldSize              = errors.New("ERR invalid field size")
+	ErrExpireValue            = errors.New("ERR invalid expire value")
+	ErrZSetScoreRange         = errors.New("ERR invalid zset score range")
+	ErrZAddIncrPair           = errors.New("ERR INCR option supports a single increment-element pair")
 	ErrZsetMemberNil          = errors.New("zset member is nil")
 	ErrClientQuit             = errors.New("remote client quit")
 	ErrSlotIdNotMatch         = errors.New("migrate slotId not match")
 	ErrMigrateRunning         = errors.New("migrate running")
-	ErrDataType               = errors.New("not support dataType")
+	ErrDataType               = errors.New("ERR not support dataType")
 	ErrDbSyncFailRefuse       = errors.New("ERR db syncing/fail, refuse request")
 	ErrNotImplement           = errors.New("command not implement")
 	ErrRangeOffset            = errors.New("ERR offset is out of range")
@@ -125,18 +125,50 @@ func (p *StartRun) GetClusterMembership() (string, RetType, error) {
 	return output, R_SUCCESS, nil
 }
 
+// LeaderTransfer requests a leader transfer to a voting node of the cluster
+// other than this one.
 func (p *StartRun) LeaderTransfer(targetNodeID uint64) (RetType, error) {
 	if !p.RaftReady {
 		return R_NIL_POINTER, errn.ErrRaftNotReady
 	}
+	if targetNodeID == p.NodeID {
+		return R_PARA_ERR, errn.ErrTransferTarget
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.TimeOut)
+	defer cancel()
+	membership, err := p.Nh.SyncGetClusterMembership(ctx, p.Rc.ClusterID)
+	if err != nil {
+		return R_ERROR, err
+	}
+	if _, ok := membership.Nodes[targetNodeID]; !ok {
+		return R_PARA_ERR, errn.ErrTransferTarget
+	}
 
-	err := p.Nh.RequestLeaderTransfer(p.Rc.ClusterID, targetNodeID)
+	err = p.Nh.RequestLeaderTransfer(p.Rc.ClusterID, targetNodeID)
 	if nil != err {
 		return R_ERROR, err
 	}
 	return R_SUCCESS, nil
 }
 
+// GetRaftIndex returns the last committed and the last applied raft index of
+// this node.
+func (p *StartRun) GetRaftIndex() (uint64, uint64, error) {
+	if !p.RaftReady {
+		return 0, 0, errn.ErrRaftNotReady
+	}
+	return p.Nh.GetRaftIndex(p.Rc.ClusterID)
+}
+
+// FlushLogDB flushes and syncs the raft log db of this node.
+func (p *StartRun) FlushLogDB() error {
+	if !p.RaftReady {
+		return errn.ErrRaftNotReady
+	}
+	return p.Nh.FlushLogDB()
+}
+
 func (p *StartRun) GetLeaderId() (uint64, RetType, error) {
 	if !p.RaftReady {
 		return 0, R_NIL_POINTER, errn.ErrRaftNotReady
@@ -321,10 +353,8 @@ func (p *StartRun) registerIsMasterCF(s *server.Server) {
 		if !config.GlobalConfig.Plugin.OpenRaft || config.GlobalConfig.CheckIsDegradeSingleNode() {
 			return true
 		}
-		if s.Info.Cluster.LeaderNodeId == p.NodeID {
-			return true
-		}
-		return false
+		leaderId, ret, _ := p.GetLeaderId()
+		return ret == R_SUCCESS && leaderId == p.NodeID
 	}
 }
 
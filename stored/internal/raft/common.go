@@ -295,6 +295,14 @@ func (p *StartRun) Stop() {
 }
 
 func (p *StartRun) Sync(keyHash uint32, data [][]byte) ([]byte, error) {
+	return p.sync(keyHash, data, p.AsyncPropose)
+}
+
+func (p *StartRun) SyncCommit(keyHash uint32, data [][]byte) ([]byte, error) {
+	return p.sync(keyHash, data, false)
+}
+
+func (p *StartRun) sync(keyHash uint32, data [][]byte, async bool) ([]byte, error) {
 	migrate := false
 
 	b, err := proto.Marshal(&update.ByteSlice{
@@ -307,7 +315,7 @@ func (p *StartRun) Sync(keyHash uint32, data [][]byte) ([]byte, error) {
 		return nil, err
 	}
 
-	if p.AsyncPropose {
+	if async {
 		_, err = p.Propose(b, p.RetryTimes)
 		return nil, err
 	} else {
@@ -334,7 +342,15 @@ func RaftInit(s *server.Server) {
 	})
 
 	s.DoRaftSync = raftInstance.Sync
+	s.DoRaftSyncCommit = raftInstance.SyncCommit
 	s.DoRaftStop = raftInstance.Stop
+	s.DoRaftReadIndex = raftInstance.SyncReadIndex
+	s.DoRaftTransfer = func(targetNodeID uint64) error {
+		_, err := raftInstance.LeaderTransfer(targetNodeID)
+		return err
+	}
+	s.DoRaftIndex = raftInstance.GetRaftIndex
+	s.DoRaftFlushLogDB = raftInstance.FlushLogDB
 }
 
 func RaftStart(s *server.Server) {
@@ -18,6 +18,7 @@ import (
 	"context"
 	"errors"
 
+	braft "github.com/zuoyebang/bitalostored/raft"
 	"github.com/zuoyebang/bitalostored/raft/statemachine"
 	"github.com/zuoyebang/bitalostored/stored/internal/errn"
 )
@@ -43,9 +44,22 @@ func (p *StartRun) SyncPropose(msg []byte) (statemachine.Result, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), p.TimeOut)
 	res, err := p.Nh.SyncPropose(ctx, p.Nh.GetNoOPSession(p.Rc.ClusterID), msg)
 	cancel()
+	if errors.Is(err, braft.ErrClusterNotReady) {
+		err = errn.ErrNotLeader
+	}
 	return res, err
 }
 
+func (p *StartRun) SyncReadIndex() error {
+	if !p.RaftReady {
+		return errn.ErrRaftNotReady
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), p.TimeOut)
+	_, err := p.Nh.SyncRead(ctx, p.Rc.ClusterID, nil)
+	cancel()
+	return err
+}
+
 func (p *StartRun) Propose(msg []byte, retryTime int) (RetType, error) {
 	if !p.RaftReady {
 		return R_NIL_POINTER, errn.ErrRaftNotReady
@@ -15,14 +15,18 @@
 package resp
 
 const (
-	PING     string = "ping"
-	PONG     string = "pong"
-	ECHO     string = "echo"
-	TYPE     string = "type"
-	CONFIG   string = "config"
-	INFO     string = "info"
-	TIME     string = "time"
-	SHUTDOWN string = "shutdown"
+	PING            string = "ping"
+	PONG            string = "pong"
+	ECHO            string = "echo"
+	TYPE            string = "type"
+	CONFIG          string = "config"
+	INFO            string = "info"
+	TIME            string = "time"
+	SHUTDOWN        string = "shutdown"
+	READCONSISTENCY string = "readconsistency"
+	WRITEACK        string = "writeack"
+	HELLO           string = "hello"
+	SLOWLOG         string = "slowlog"
 
 	DEL         string = "del"
 	TTL         string = "ttl"
@@ -118,8 +122,19 @@ const (
 	ZREMRANGEBYLEX   string = "zremrangebylex"
 	ZLEXCOUNT        string = "zlexcount"
 	ZSCAN            string = "zscan"
+	ZUNION           string = "zunion"
+	ZINTER           string = "zinter"
+	ZRANGESTORE      string = "zrangestore"
+	ZUNIONSTORE      string = "zunionstore"
+	ZINTERSTORE      string = "zinterstore"
+	ZDIFF            string = "zdiff"
+	ZDIFFSTORE       string = "zdiffstore"
+	ZRANDMEMBER      string = "zrandmember"
+	ZMPOP            string = "zmpop"
+	ZINTERCARD       string = "zintercard"
 
 	ZCLEAR      string = "zclear"
+	ZUNLINK     string = "zunlink"
 	ZEXPIRE     string = "zexpire"
 	ZEXPIREAT   string = "zexpireat"
 	ZTTL        string = "zttl"
@@ -178,10 +193,11 @@ const (
 )
 
 var commandToWrite = map[string]bool{
-	PING: false,
-	PONG: false,
-	ECHO: false,
-	TYPE: false,
+	PING:  false,
+	PONG:  false,
+	ECHO:  false,
+	HELLO: false,
+	TYPE:  false,
 
 	SCAN:   false,
 	HSCAN:  false,
@@ -298,6 +314,11 @@ var commandToWrite = map[string]bool{
 	ZREMRANGEBYSCORE: true,
 	ZREMRANGEBYRANK:  true,
 	ZREMRANGEBYLEX:   true,
+	ZRANGESTORE:      true,
+	ZUNIONSTORE:      true,
+	ZINTERSTORE:      true,
+	ZDIFFSTORE:       true,
+	ZMPOP:            true,
 
 	ZRANGE:           false,
 	ZREVRANGE:        false,
@@ -310,8 +331,14 @@ var commandToWrite = map[string]bool{
 	ZLEXCOUNT:        false,
 	ZCOUNT:           false,
 	ZCARD:            false,
+	ZUNION:           false,
+	ZINTER:           false,
+	ZDIFF:            false,
+	ZRANDMEMBER:      false,
+	ZINTERCARD:       false,
 
 	ZCLEAR:     true,
+	ZUNLINK:    true,
 	ZEXPIRE:    true,
 	ZEXPIREAT:  true,
 	ZPERSIST:   true,
@@ -344,6 +371,20 @@ func IsWriteCmd(cmd string) bool {
 	return false
 }
 
+// numKeysCommand lists the commands that take numkeys as first argument, their
+// first key follows it.
+var numKeysCommand = map[string]bool{
+	ZUNION:     true,
+	ZINTER:     true,
+	ZDIFF:      true,
+	ZMPOP:      true,
+	ZINTERCARD: true,
+}
+
+func IsNumKeysCmd(cmd string) bool {
+	return numKeysCommand[cmd]
+}
+
 type Command struct {
 	Raw  []byte
 	Args [][]byte
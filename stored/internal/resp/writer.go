@@ -18,15 +18,25 @@ import (
 	"bytes"
 	"io"
 	"strconv"
+	"time"
 
 	"github.com/zuoyebang/bitalostored/butils/deepcopy"
 	"github.com/zuoyebang/bitalostored/butils/extend"
 	"github.com/zuoyebang/bitalostored/butils/unsafe2"
 	"github.com/zuoyebang/bitalostored/stored/engine/bitsdb/btools"
+	"github.com/zuoyebang/bitalostored/stored/internal/errn"
 	"github.com/zuoyebang/bitalostored/stored/internal/log"
 )
 
-const writerBufferSize = 8 << 10
+const (
+	writerBufferSize = 8 << 10
+	maxScratchSize   = 1 << 20
+)
+
+const (
+	ProtoResp2 = 2
+	ProtoResp3 = 3
+)
 
 var (
 	respArray byte = '*'
@@ -34,12 +44,17 @@ var (
 	respErr   byte = '-'
 	respMutil byte = '$'
 	respSinge byte = '+'
+	respMap   byte = '%'
+
+	respVerbatim byte = '='
+	respDouble   byte = ','
 
 	respInternalFieldPair  byte = 'F'
 	respInternalScorePair  byte = 'S'
 	respInternalFVPair     byte = 'V'
 	respInternalSliceArray byte = 's'
 	respInternalArray      byte = 'a'
+	respInternalRaw        byte = 'r'
 
 	Delims    = []byte("\r\n")
 	NullBulk  = []byte("-1")
@@ -54,6 +69,18 @@ type Writer struct {
 	Buf    *bytes.Buffer
 	Cached bool
 	Resps  []RespOuput
+	Proto  int
+
+	softOverAt time.Time
+	scratch    bytes.Buffer
+}
+
+// OutputLimit bounds the output pending on a connection, it is broken above
+// Hard, or above Soft for longer than SoftTime. A zero limit is disabled.
+type OutputLimit struct {
+	Hard     int64
+	Soft     int64
+	SoftTime time.Duration
 }
 
 type RespOuput struct {
@@ -63,12 +90,30 @@ type RespOuput struct {
 }
 
 func NewWriter() *Writer {
+	return NewWriterSize(writerBufferSize)
+}
+
+// NewWriterSize returns a writer whose buffer starts with n bytes capacity,
+// n <= 0 uses the default size.
+func NewWriterSize(n int) *Writer {
+	if n <= 0 {
+		n = writerBufferSize
+	}
 	w := &Writer{
-		Buf: bytes.NewBuffer(make([]byte, 0, writerBufferSize)),
+		Buf:   bytes.NewBuffer(make([]byte, 0, n)),
+		Proto: ProtoResp2,
 	}
 	return w
 }
 
+func (w *Writer) SetProto(proto int) {
+	w.Proto = proto
+}
+
+func (w *Writer) IsResp3() bool {
+	return w.Proto == ProtoResp3
+}
+
 func (w *Writer) SetCached() {
 	w.Cached = true
 }
@@ -135,6 +180,12 @@ func (w *Writer) FlushCached() {
 				out := resp.Output.([]btools.ScorePair)
 				w.WriteScorePairArray(out, resp.WithScores)
 			}
+		case respInternalRaw:
+			w.Buf.Write(resp.Output.([]byte))
+		case respVerbatim:
+			w.writeVerbatim(resp.Output.([]byte))
+		case respDouble:
+			w.WriteDouble(resp.Output.(float64))
 		}
 	}
 	w.Resps = w.Resps[:0]
@@ -200,6 +251,57 @@ func (w *Writer) WriteBulk(b []byte) {
 	w.Buf.Write(Delims)
 }
 
+func (w *Writer) WriteMapLen(n int) {
+	if !w.IsResp3() {
+		w.WriteLen(n * 2)
+		return
+	}
+	w.Buf.WriteByte(respMap)
+	w.Buf.Write(unsafe2.ByteSlice(strconv.Itoa(n)))
+	w.Buf.Write(Delims)
+}
+
+func (w *Writer) WriteVerbatim(format string, data []byte) {
+	if !w.IsResp3() || data == nil {
+		w.WriteBulk(data)
+		return
+	}
+	if len(format) != 3 {
+		format = "txt"
+	}
+	payload := make([]byte, 0, len(format)+1+len(data))
+	payload = append(payload, format...)
+	payload = append(payload, ':')
+	payload = append(payload, data...)
+	if w.Cached {
+		w.Resps = append(w.Resps, RespOuput{Type: respVerbatim, Output: payload})
+		return
+	}
+	w.writeVerbatim(payload)
+}
+
+func (w *Writer) writeVerbatim(payload []byte) {
+	w.Buf.WriteByte(respVerbatim)
+	w.Buf.Write(unsafe2.ByteSlice(strconv.Itoa(len(payload))))
+	w.Buf.Write(Delims)
+	w.Buf.Write(payload)
+	w.Buf.Write(Delims)
+}
+
+func (w *Writer) WriteDouble(f float64) {
+	if w.Cached {
+		w.Resps = append(w.Resps, RespOuput{Type: respDouble, Output: f})
+		return
+	}
+	if !w.IsResp3() {
+		w.WriteBulk(extend.FormatFloat64ToSlice(f))
+		return
+	}
+	w.Buf.WriteByte(respDouble)
+	w.Buf.Write(extend.FormatDoubleToSlice(f))
+	w.Buf.Write(Delims)
+}
+
 func (w *Writer) WriteBulkMulti(bs ...[]byte) {
 	w.Buf.WriteByte(respMutil)
 
@@ -365,13 +467,80 @@ func (w *Writer) WriteScorePairArray(lst []btools.ScorePair, withScores bool) {
 			w.WriteBulk(lst[i].Member)
 
 			if withScores {
-				w.WriteBulk(extend.FormatFloat64ToSlice(lst[i].Score))
+				w.WriteDouble(lst[i].Score)
 			}
 		}
 	}
 }
 
+// ScorePairStream writes a score pair array whose length is unknown until the
+// last pair is added. Pairs are encoded into a scratch buffer of the Writer
+// and Flush prefixes them with the array header, so no ScorePair is built.
+type ScorePairStream struct {
+	w          *Writer
+	withScores bool
+	n          int
+	lst        []btools.ScorePair
+}
+
+func (w *Writer) ScorePairStream(withScores bool) *ScorePairStream {
+	return &ScorePairStream{w: w, withScores: withScores}
+}
+
+func (s *ScorePairStream) Add(member btools.FieldPair, score float64) {
+	if s.w.Cached {
+		s.lst = append(s.lst, btools.ScorePair{Member: member.Merge(), Score: score})
+		return
+	}
+	buf := s.w.Buf
+	s.w.Buf = &s.w.scratch
+	s.w.WriteBulkMulti(member.Prefix, member.Suffix)
+	if s.withScores {
+		s.w.WriteDouble(score)
+	}
+	s.w.Buf = buf
+	s.n++
+}
+
+// Len returns the number of reply elements added so far.
+func (s *ScorePairStream) Len() int {
+	n := s.n + len(s.lst)
+	if s.withScores {
+		return n * 2
+	}
+	return n
+}
+
+func (s *ScorePairStream) Flush() {
+	if s.w.Cached {
+		s.w.WriteScorePairArray(s.lst, s.withScores)
+		s.lst = nil
+		return
+	}
+	s.w.WriteLen(s.Len())
+	s.w.Buf.Write(s.w.scratch.Bytes())
+	s.Discard()
+}
+
+func (s *ScorePairStream) Discard() {
+	s.n = 0
+	s.lst = nil
+	if s.w.scratch.Cap() > maxScratchSize {
+		s.w.scratch = bytes.Buffer{}
+	} else {
+		s.w.scratch.Reset()
+	}
+}
+
 func (w *Writer) WriteBytes(args ...[]byte) {
+	if w.Cached {
+		var raw []byte
+		for _, v := range args {
+			raw = append(raw, v...)
+		}
+		w.Resps = append(w.Resps, RespOuput{Type: respInternalRaw, Output: raw})
+		return
+	}
 	for _, v := range args {
 		w.Buf.Write(v)
 	}
@@ -389,3 +558,31 @@ func (w *Writer) FlushToWriterIO(writer io.Writer) (int, error) {
 	defer w.Buf.Reset()
 	return writer.Write(w.Buf.Bytes())
 }
+
+// FlushToWriterIOLimit flushes like FlushToWriterIO, then returns
+// ErrOutputBufferLimit if the output still pending on writer, as reported by
+// buffered, breaks limit.
+func (w *Writer) FlushToWriterIOLimit(writer io.Writer, buffered func() int, limit OutputLimit) (int, error) {
+	n, err := w.FlushToWriterIO(writer)
+	if err != nil {
+		return n, err
+	}
+	if w.overOutputLimit(int64(buffered()), limit, time.Now()) {
+		return n, errn.ErrOutputBufferLimit
+	}
+	return n, nil
+}
+
+func (w *Writer) overOutputLimit(pending int64, limit OutputLimit, now time.Time) bool {
+	if limit.Hard > 0 && pending > limit.Hard {
+		return true
+	}
+	if limit.Soft <= 0 || pending <= limit.Soft {
+		w.softOverAt = time.Time{}
+		return false
+	}
+	if w.softOverAt.IsZero() {
+		w.softOverAt = now
+	}
+	return now.Sub(w.softOverAt) > limit.SoftTime
+}
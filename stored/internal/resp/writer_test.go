@@ -0,0 +1,212 @@
+// Copyright 2019-2024 Xu Ruibo (hustxurb@163.com) and Contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resp
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/zuoyebang/bitalostored/stored/engine/bitsdb/btools"
+	"github.com/zuoyebang/bitalostored/stored/internal/errn"
+)
+
+func TestWriteVerbatim(t *testing.T) {
+	cases := []struct {
+		proto  int
+		format string
+		data   []byte
+		expect string
+	}{
+		{ProtoResp2, "txt", []byte("hello"), "$5\r\nhello\r\n"},
+		{ProtoResp2, "txt", nil, "$-1\r\n"},
+		{ProtoResp3, "txt", []byte("hello"), "=9\r\ntxt:hello\r\n"},
+		{ProtoResp3, "mkd", []byte("# a"), "=7\r\nmkd:# a\r\n"},
+		{ProtoResp3, "markdown", []byte("a"), "=5\r\ntxt:a\r\n"},
+		{ProtoResp3, "txt", nil, "$-1\r\n"},
+	}
+
+	for i, cs := range cases {
+		w := NewWriter()
+		w.SetProto(cs.proto)
+		w.WriteVerbatim(cs.format, cs.data)
+		if actual := string(w.Bytes()); actual != cs.expect {
+			t.Fatalf("case %d expect:%q actual:%q", i, cs.expect, actual)
+		}
+	}
+}
+
+func TestWriteVerbatimCached(t *testing.T) {
+	for _, proto := range []int{ProtoResp2, ProtoResp3} {
+		w := NewWriter()
+		w.SetProto(proto)
+		w.SetCached()
+		w.WriteInteger(1)
+		w.WriteVerbatim("txt", []byte("hi"))
+		w.UnsetCached()
+		w.FlushCached()
+
+		expect := "*2\r\n:1\r\n$2\r\nhi\r\n"
+		if proto == ProtoResp3 {
+			expect = "*2\r\n:1\r\n=6\r\ntxt:hi\r\n"
+		}
+		if actual := string(w.Bytes()); actual != expect {
+			t.Fatalf("proto %d expect:%q actual:%q", proto, expect, actual)
+		}
+	}
+}
+
+func TestWriteDouble(t *testing.T) {
+	cases := []struct {
+		f     float64
+		resp2 string
+		resp3 string
+	}{
+		{1.5, "$3\r\n1.5\r\n", ",1.5\r\n"},
+		{-2, "$2\r\n-2\r\n", ",-2\r\n"},
+		{0, "$1\r\n0\r\n", ",0\r\n"},
+		{math.Inf(1), "$4\r\n+Inf\r\n", ",inf\r\n"},
+		{math.Inf(-1), "$4\r\n-Inf\r\n", ",-inf\r\n"},
+		{math.NaN(), "$3\r\nNaN\r\n", ",nan\r\n"},
+	}
+
+	for i, cs := range cases {
+		for _, proto := range []int{ProtoResp2, ProtoResp3} {
+			w := NewWriter()
+			w.SetProto(proto)
+			w.WriteDouble(cs.f)
+			expect := cs.resp2
+			if proto == ProtoResp3 {
+				expect = cs.resp3
+			}
+			if actual := string(w.Bytes()); actual != expect {
+				t.Fatalf("case %d proto %d expect:%q actual:%q", i, proto, expect, actual)
+			}
+		}
+	}
+}
+
+func TestWriteScorePairArrayDouble(t *testing.T) {
+	lst := []btools.ScorePair{{Score: 1, Member: []byte("a")}, {Score: math.Inf(1), Member: []byte("b")}}
+	for _, proto := range []int{ProtoResp2, ProtoResp3} {
+		w := NewWriter()
+		w.SetProto(proto)
+		w.WriteScorePairArray(lst, true)
+
+		expect := "*4\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$4\r\n+Inf\r\n"
+		if proto == ProtoResp3 {
+			expect = "*4\r\n$1\r\na\r\n,1\r\n$1\r\nb\r\n,inf\r\n"
+		}
+		if actual := string(w.Bytes()); actual != expect {
+			t.Fatalf("proto %d expect:%q actual:%q", proto, expect, actual)
+		}
+	}
+}
+
+func TestNewWriterSize(t *testing.T) {
+	for _, n := range []int{64, 1 << 20} {
+		if w := NewWriterSize(n); w.Buf.Cap() != n || w.Buf.Len() != 0 {
+			t.Fatalf("size %d cap:%d len:%d", n, w.Buf.Cap(), w.Buf.Len())
+		}
+	}
+	if w := NewWriterSize(0); w.Buf.Cap() != writerBufferSize {
+		t.Fatalf("default cap:%d", w.Buf.Cap())
+	}
+	if w := NewWriter(); w.Buf.Cap() != writerBufferSize || w.Proto != ProtoResp2 {
+		t.Fatalf("writer cap:%d proto:%d", w.Buf.Cap(), w.Proto)
+	}
+}
+
+func BenchmarkWriterLargeReply(b *testing.B) {
+	member := make([]byte, 32)
+	for _, n := range []int{writerBufferSize, 1 << 20} {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			b.ReportAllocs()
+			grows := 0
+			for i := 0; i < b.N; i++ {
+				w := NewWriterSize(n)
+				last := w.Buf.Cap()
+				w.WriteLen(20000)
+				for j := 0; j < 10000; j++ {
+					w.WriteBulk(member)
+					w.WriteBulk(member[:8])
+					if c := w.Buf.Cap(); c != last {
+						grows++
+						last = c
+					}
+				}
+			}
+			b.ReportMetric(float64(grows)/float64(b.N), "grows/op")
+		})
+	}
+}
+
+func TestFlushToWriterIOLimit(t *testing.T) {
+	var conn bytes.Buffer
+	buffered := func() int { return conn.Len() }
+	reply := make([]byte, 1024)
+	limit := OutputLimit{Hard: 8 << 10}
+
+	w := NewWriter()
+	flushes := 0
+	for {
+		w.WriteBulk(reply)
+		_, err := w.FlushToWriterIOLimit(&conn, buffered, limit)
+		flushes++
+		if err == errn.ErrOutputBufferLimit {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if flushes > 100 {
+			t.Fatal("hard limit not reached")
+		}
+	}
+	if conn.Len() <= int(limit.Hard) || flushes != 8 {
+		t.Fatalf("hard limit reached at %d bytes after %d flushes", conn.Len(), flushes)
+	}
+
+	conn.Reset()
+	if _, err := w.FlushToWriterIOLimit(&conn, buffered, OutputLimit{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOutputLimitSoft(t *testing.T) {
+	w := NewWriter()
+	limit := OutputLimit{Hard: 100, Soft: 10, SoftTime: time.Second}
+	now := time.Now()
+	if w.overOutputLimit(20, limit, now) {
+		t.Fatal("soft limit broken at once")
+	}
+	if w.overOutputLimit(20, limit, now.Add(500*time.Millisecond)) {
+		t.Fatal("soft limit broken within soft time")
+	}
+	if !w.overOutputLimit(20, limit, now.Add(1500*time.Millisecond)) {
+		t.Fatal("soft limit not broken after soft time")
+	}
+
+	if w.overOutputLimit(5, limit, now.Add(2*time.Second)) {
+		t.Fatal("below soft limit")
+	}
+	if w.overOutputLimit(20, limit, now.Add(3*time.Second)) {
+		t.Fatal("soft time not restarted after draining")
+	}
+	if !w.overOutputLimit(101, limit, now.Add(3*time.Second)) {
+		t.Fatal("hard limit not broken")
+	}
+}
@@ -44,6 +44,9 @@ type SlowShield struct {
 	totalSlowTime     atomic.Int64
 	slowKey           map[string]int64
 	topSlowKey        map[string]int64
+	quit              chan struct{}
+	closeOnce         sync.Once
+	logf              func(format string, args ...interface{})
 }
 
 var notCheckCmd = map[string]bool{
@@ -53,6 +56,8 @@ var notCheckCmd = map[string]bool{
 func NewSlowShield() *SlowShield {
 	sc := &SlowShield{
 		isOpen: config.GlobalConfig.Server.SlowShield,
+		quit:   make(chan struct{}),
+		logf:   log.Infof,
 	}
 	sc.adjustByGlobalConfig()
 	if sc.isOpen {
@@ -139,24 +144,10 @@ func (sc *SlowShield) doStats() {
 				sc.totalSlowTime.Store(0)
 				topSlowKey := make(map[string]int64, 16)
 
-				if totalSlowTime > 0 && len(lastSlowKey) > 0 {
-					pq := make(PriorityQueue, 0, 10)
-					index := 0
-					for key, slowKeyCost := range lastSlowKey {
-						pq.PushTopN(&Item{
-							value:    key,
-							priority: slowKeyCost,
-						}, sc.topN)
-						index++
-					}
-					heap.Init(&pq)
-
-					for _, it := range pq {
-						keyCostMs := it.priority / 1e6
-						if keyCostMs > sc.keySlowWindowTime.Duration().Milliseconds() {
-							log.Infof("slow shield [cmdkey:%q] [slowtime:%dms]", it.value, keyCostMs)
-							topSlowKey[it.value] = it.priority
-						}
+				if totalSlowTime > 0 {
+					for _, it := range sc.topSlowKeys(lastSlowKey) {
+						log.Infof("slow shield [cmdkey:%q] [slowtime:%dms]", it.value, it.priority/1e6)
+						topSlowKey[it.value] = it.priority
 					}
 				}
 				sc.topSlowKey = topSlowKey
@@ -172,7 +163,57 @@ func (sc *SlowShield) doStats() {
 
 		for {
 			dostat()
-			time.Sleep(sc.ttl.Duration())
+			select {
+			case <-sc.quit:
+				return
+			case <-time.After(sc.ttl.Duration()):
+			}
 		}
 	}()
 }
+
+// Close stops the stats worker and writes the slow keys not yet counted, it
+// is safe to call more than once.
+func (sc *SlowShield) Close() {
+	sc.closeOnce.Do(func() {
+		close(sc.quit)
+		sc.flush()
+	})
+}
+
+func (sc *SlowShield) flush() {
+	sc.mu.Lock()
+	pending := sc.slowKey
+	sc.slowKey = make(map[string]int64, 32)
+	sc.totalSlowTime.Store(0)
+	sc.mu.Unlock()
+
+	for _, it := range sc.topSlowKeys(pending) {
+		sc.logf("slow shield flush [cmdkey:%q] [slowtime:%dms]", it.value, it.priority/1e6)
+	}
+}
+
+// topSlowKeys returns the topN keys of slowKey that were slow for longer than
+// the key slow window.
+func (sc *SlowShield) topSlowKeys(slowKey map[string]int64) []*Item {
+	if len(slowKey) == 0 {
+		return nil
+	}
+
+	pq := make(PriorityQueue, 0, 10)
+	for key, slowKeyCost := range slowKey {
+		pq.PushTopN(&Item{
+			value:    key,
+			priority: slowKeyCost,
+		}, sc.topN)
+	}
+	heap.Init(&pq)
+
+	items := make([]*Item, 0, len(pq))
+	for _, it := range pq {
+		if it.priority/1e6 > sc.keySlowWindowTime.Duration().Milliseconds() {
+			items = append(items, it)
+		}
+	}
+	return items
+}
@@ -15,6 +15,8 @@
 package slowshield
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 )
@@ -44,3 +46,32 @@ func TestNewSlowControl(t *testing.T) {
 		t.Log("not hit slow shield : ", string(cmd2), string(key2))
 	}
 }
+
+func TestSlowShieldCloseFlush(t *testing.T) {
+	sc := NewSlowShield()
+	sc.isOpen = true
+	var written []string
+	sc.logf = func(format string, args ...interface{}) {
+		written = append(written, fmt.Sprintf(format, args...))
+	}
+
+	slow := [][]byte{[]byte("aa"), []byte("bb")}
+	for _, key := range slow {
+		sc.Send("zrange", key, 2*sc.keySlowWindowTime.Duration().Nanoseconds())
+	}
+	sc.Send("zrange", []byte("cc"), time.Millisecond.Nanoseconds())
+
+	sc.Close()
+	if len(written) != len(slow) {
+		t.Fatalf("flush written %d want %d: %v", len(written), len(slow), written)
+	}
+	for _, w := range written {
+		if strings.Contains(w, "zrangecc") {
+			t.Fatalf("flush wrote key under the slow window: %v", written)
+		}
+	}
+	sc.Close()
+	if len(written) != len(slow) {
+		t.Fatalf("second close written %d: %v", len(written), written)
+	}
+}
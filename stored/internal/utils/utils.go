@@ -47,9 +47,14 @@ func ExtractHashTag(key []byte) []byte {
 	return key
 }
 
-func GetHashTagFnv(key []byte) uint32 {
+// KeyHash hashes a key with the cluster key hash, see hash.SetKeyHash.
+func KeyHash(key []byte) uint32 {
+	return hash.KeyHash(key)
+}
+
+func GetHashTagKeyHash(key []byte) uint32 {
 	hashTag := ExtractHashTag(key)
-	khash := hash.Fnv32(hashTag)
+	khash := hash.KeyHash(hashTag)
 	return khash
 }
 
@@ -86,7 +91,7 @@ func GetSlotId(khash uint32) uint16 {
 }
 
 func GetKeySlotId(key []byte) uint32 {
-	return hash.Fnv32(key) % TotalSlot
+	return hash.KeyHash(key) % TotalSlot
 }
 
 func GetCurrentTimeString() string {
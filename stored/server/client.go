@@ -16,13 +16,14 @@ package server
 
 import (
 	"fmt"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/zuoyebang/bitalostored/butils/hash"
 	"github.com/zuoyebang/bitalostored/butils/unsafe2"
 	"github.com/zuoyebang/bitalostored/stored/engine"
+	"github.com/zuoyebang/bitalostored/stored/engine/bitsdb/btools"
 	"github.com/zuoyebang/bitalostored/stored/internal/config"
 	"github.com/zuoyebang/bitalostored/stored/internal/errn"
 	"github.com/zuoyebang/bitalostored/stored/internal/log"
@@ -45,6 +46,8 @@ const (
 	PrepareStateUnlock
 )
 
+const maxScorePairsBuf = 128
+
 var raftClientPool sync.Pool
 
 type Client struct {
@@ -61,10 +64,15 @@ type Client struct {
 	IsMaster       func() bool
 
 	server            *Server
+	isHashTag         bool
+	linearizableRead  bool
+	writeAck          bool
+	scorePairs        []btools.ScorePair
 	remoteAddr        string
 	closed            atomic.Bool
 	txState           int
 	txCommandQueued   bool
+	txAborted         bool
 	watchKeys         map[string]int64
 	commandQueue      [][][]byte
 	hasPrepareLock    atomic.Bool
@@ -75,9 +83,13 @@ type Client struct {
 }
 
 func init() {
+	initRaftClientPool(0)
+}
+
+func initRaftClientPool(writerSize int) {
 	raftClientPool = sync.Pool{
 		New: func() interface{} {
-			return newRaftClient()
+			return newRaftClient(writerSize)
 		},
 	}
 	for i := 0; i < 128; i++ {
@@ -92,6 +104,9 @@ func GetRaftClientFromPool(s *Server, data [][]byte, keyHash uint32) *Client {
 	c.server = s
 	c.IsMaster = s.IsMaster
 	c.KeyHash = keyHash
+	// the raft log only carries the khash, a hash tag khash is the one that
+	// differs from the hash of the whole key
+	c.isHashTag = len(c.Keys) > 0 && keyHash != utils.KeyHash(c.Keys)
 	return c
 }
 
@@ -108,9 +123,9 @@ func PutRaftClientToPool(c *Client) {
 	raftClientPool.Put(c)
 }
 
-func newRaftClient() *Client {
+func newRaftClient(writerSize int) *Client {
 	return &Client{
-		Writer: resp.NewWriter(),
+		Writer: resp.NewWriterSize(writerSize),
 	}
 }
 
@@ -120,7 +135,7 @@ func newConnClient(s *Server, remoteAddr string) *Client {
 		IsMaster:   s.IsMaster,
 		ParseMarks: make([]int, 0, 1<<4),
 		Reader:     resp.NewReader(),
-		Writer:     resp.NewWriter(),
+		Writer:     resp.NewWriterSize(s.writerSize),
 		remoteAddr: remoteAddr,
 		server:     s,
 	}
@@ -137,6 +152,46 @@ func newConnClient(s *Server, remoteAddr string) *Client {
 	return c
 }
 
+// checkReplyLen fails when a reply of n elements is over the max-reply-elements
+// limit, 0 means no limit.
+func (c *Client) checkReplyLen(n int) error {
+	if limit := c.server.maxReplyElements.Load(); limit > 0 && int64(n) > limit {
+		return errn.ErrReplyTooLarge
+	}
+	return nil
+}
+
+// replyReadCount bounds count, the items a range read returns with -1 for all,
+// to one item past max-reply-elements when each item takes elems elements of
+// the reply. The read then stops as soon as checkReplyLen is bound to fail.
+func (c *Client) replyReadCount(count, elems int) int {
+	limit := c.server.maxReplyElements.Load()
+	if limit <= 0 {
+		return count
+	}
+	max := int(limit/int64(elems)) + 1
+	if count < 0 || count > max {
+		return max
+	}
+	return count
+}
+
+func (c *Client) getScorePairs(n int) []btools.ScorePair {
+	if n > maxScorePairsBuf {
+		return make([]btools.ScorePair, n)
+	}
+	if cap(c.scorePairs) < n {
+		c.scorePairs = make([]btools.ScorePair, n, maxScorePairsBuf)
+	}
+	return c.scorePairs[:n]
+}
+
+func (c *Client) putScorePairs(params []btools.ScorePair) {
+	for i := range params {
+		params[i] = btools.ScorePair{}
+	}
+}
+
 func (c *Client) Close() {
 	if !c.closed.CompareAndSwap(false, true) {
 		return
@@ -162,7 +217,9 @@ func (c *Client) FormatData(reqData [][]byte) {
 	} else {
 		c.Cmd = unsafe2.String(LowerSlice(reqData[0]))
 		c.Args = reqData[1:]
-		if len(c.Args) > 0 {
+		if len(c.Args) > 1 && resp.IsNumKeysCmd(c.Cmd) {
+			c.Keys = c.Args[1]
+		} else if len(c.Args) > 0 {
 			c.Keys = c.Args[0]
 		} else {
 			c.Keys = c.Keys[0:0]
@@ -180,6 +237,12 @@ func (c *Client) HandleRequest(reqData [][]byte, isHashTag bool) (err error) {
 	}
 
 	if c.server.openDistributedTx && c.checkCommandEnterQueue() {
+		if c.txAborted || (c.server.txMaxQueueCmds > 0 && len(c.commandQueue) >= c.server.txMaxQueueCmds) {
+			c.txAborted = true
+			c.commandQueue = nil
+			c.Writer.WriteError(errn.ErrTxQueueLimit)
+			return errn.ErrTxQueueLimit
+		}
 		txReqData := make([][]byte, len(reqData))
 		for i := range reqData {
 			txReqData[i] = append([]byte{}, reqData[i]...)
@@ -245,11 +308,9 @@ func (c *Client) HandleRequest(reqData [][]byte, isHashTag bool) (err error) {
 		return errn.ErrSlowShield
 	}
 
-	if !isHashTag {
-		c.KeyHash = hash.Fnv32(c.Keys)
-	} else {
-		c.KeyHash = utils.GetHashTagFnv(c.Keys)
-	}
+	c.isHashTag = isHashTag
+	c.KeyHash = c.keyHash(c.Keys)
+	zstampRefreshTTL(c)
 
 	var isRedirect bool
 	var lockFunc func()
@@ -268,7 +329,14 @@ func (c *Client) HandleRequest(reqData [][]byte, isHashTag bool) (err error) {
 			updateKeyModifyTs()
 		}
 	} else if c.server.isOpenRaft && execCmd.Sync && !config.GlobalConfig.CheckIsDegradeSingleNode() {
+		// raft forwards the proposal of a follower to the leader, a follower
+		// only answers READONLY when no leader took it.
 		err = c.RaftSync()
+		if err == errn.ErrNotLeader && c.server.IsMaster != nil && !c.server.IsMaster() {
+			err = errn.ErrReadOnly
+		}
+	} else if c.linearizableRead && c.server.isOpenRaft && !execCmd.Sync && !execCmd.NoKey && !config.GlobalConfig.CheckIsDegradeSingleNode() {
+		err = c.RaftReadIndex()
 	} else {
 		err = c.ApplyDB(0)
 	}
@@ -278,9 +346,22 @@ func (c *Client) HandleRequest(reqData [][]byte, isHashTag bool) (err error) {
 	return err
 }
 
+// keyHash hashes key the way c.KeyHash was derived from c.Keys, by its hash
+// tag when the request came in hash tag mode.
+func (c *Client) keyHash(key []byte) uint32 {
+	if c.isHashTag {
+		return utils.GetHashTagKeyHash(key)
+	}
+	return utils.KeyHash(key)
+}
+
 func (c *Client) RaftSync() error {
 	start := time.Now()
-	resData, err := c.server.DoRaftSync(c.KeyHash, c.Data)
+	doSync := c.server.DoRaftSync
+	if c.writeAck && c.server.DoRaftSyncCommit != nil {
+		doSync = c.server.DoRaftSyncCommit
+	}
+	resData, err := doSync(c.KeyHash, c.Data)
 	if err != nil {
 		return err
 	}
@@ -293,6 +374,16 @@ func (c *Client) RaftSync() error {
 	}
 }
 
+func (c *Client) RaftReadIndex() error {
+	start := time.Now()
+	if err := c.server.DoRaftReadIndex(); err != nil {
+		return err
+	}
+
+	c.server.Info.Stats.LinearizableRead.Add(1)
+	return c.ApplyDB(time.Since(start).Nanoseconds())
+}
+
 func (c *Client) ApplyDB(raftSyncCostNs int64) error {
 	var err error
 	var ok bool
@@ -308,6 +399,13 @@ func (c *Client) ApplyDB(raftSyncCostNs int64) error {
 		updateKeyModifyTs = c.markWatchKeyModified(execCmd)
 	}
 
+	// only maintenance on the cache shard of the command key flags the entry
+	var maintCnt uint64
+	trackMaint := c.server.slowLogCacheMaint && c.DB != nil && len(c.Keys) > 0
+	if trackMaint {
+		maintCnt = c.DB.CacheKeyMaintenanceCount(c.Keys, c.KeyHash)
+	}
+
 	if err = execCmd.Handler(c); err != nil {
 		if updateKeyModifyTs != nil {
 			updateKeyModifyTs()
@@ -321,12 +419,14 @@ func (c *Client) ApplyDB(raftSyncCostNs int64) error {
 	c.server.Info.Stats.TotolCmd.Add(1)
 
 	costNs := time.Since(c.QueryStartTime).Nanoseconds()
-	if costNs >= config.GlobalConfig.Server.SlowTime.Int64() {
+	if costNs >= c.server.slowTime.Load() {
 		if c.server.slowQuery != nil {
 			c.server.slowQuery.Send(c.Cmd, c.Keys, costNs-raftSyncCostNs)
 		}
 		costUs := costNs / 1000
 		raftSyncCostUs := raftSyncCostNs / 1000
+		cacheMaint := trackMaint && c.DB.CacheKeyMaintenanceCount(c.Keys, c.KeyHash) != maintCnt
+		c.server.slowLog.add(costUs, c.Data, c.remoteAddr, cacheMaint)
 		log.SlowLog(c.remoteAddr, costUs, raftSyncCostUs, c.Data, err)
 	}
 	return err
@@ -378,7 +478,15 @@ func (c *Client) markWatchKeyModified(execCmd *Cmd) func() {
 	modifyFuncs := make([]func(), 0, 1)
 	argNum := len(c.Args)
 	firstPos := 0
+	keySkip := int(execCmd.KeySkip)
 	khash := uint32(0)
+	if resp.IsNumKeysCmd(c.Cmd) {
+		numKeys, err := strconv.Atoi(unsafe2.String(c.Args[0]))
+		if err != nil || numKeys <= 0 || numKeys >= argNum {
+			return nil
+		}
+		firstPos, argNum, keySkip = 1, numKeys+1, 1
+	}
 
 	addMofidyFunc := func(keyByte []byte, khash uint32) {
 		wk := c.server.txLocks.GetWatchKeyWithKhash(khash, unsafe2.String(keyByte))
@@ -402,14 +510,14 @@ func (c *Client) markWatchKeyModified(execCmd *Cmd) func() {
 		}
 	}
 
-	for pos := firstPos; pos < argNum; pos += int(execCmd.KeySkip) {
-		if pos == 0 {
+	for pos := firstPos; pos < argNum; pos += keySkip {
+		if pos == firstPos {
 			khash = c.KeyHash
 		} else {
-			khash = hash.Fnv32(c.Args[pos])
+			khash = utils.KeyHash(c.Args[pos])
 		}
 		addMofidyFunc(c.Args[pos], khash)
-		if execCmd.KeySkip == 0 {
+		if keySkip == 0 {
 			break
 		}
 	}
@@ -434,10 +542,25 @@ func (c *Client) resetTx() {
 		c.server.txParallelCounter.Add(-1)
 	}
 	c.txState = TxStateNone
+	c.txAborted = false
 	c.disableCommandQueued()
 	c.commandQueue = nil
 	c.watchKeys = nil
 	c.hasPrepareLock.Store(false)
+	c.setPrepareState(PrepareStateNone)
+}
+
+func (c *Client) setPrepareState(state int32) {
+	old := c.prepareState.Swap(state)
+	if old == state {
+		return
+	}
+	tx := &c.server.Info.Tx
+	tx.addPrepareState(old, -1)
+	tx.addPrepareState(state, 1)
+	if state == PrepareStateLockFail {
+		tx.PrepareLockFailCnt.Add(1)
+	}
 }
 
 func (c *Client) addWatchKey(txLock *TxLocker, key []byte, ts time.Time) {
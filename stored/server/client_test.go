@@ -0,0 +1,302 @@
+// Copyright 2019-2024 Xu Ruibo (hustxurb@163.com) and Contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/zuoyebang/bitalostored/butils/hash"
+	"github.com/zuoyebang/bitalostored/stored/engine"
+	"github.com/zuoyebang/bitalostored/stored/internal/errn"
+	"github.com/zuoyebang/bitalostored/stored/internal/resp"
+	"github.com/zuoyebang/bitalostored/stored/internal/utils"
+)
+
+func TestRaftSyncWriteAck(t *testing.T) {
+	reply := []byte(":1\r\n")
+	committed := make(chan struct{})
+	s := &Server{
+		DoRaftSync: func(keyHash uint32, data [][]byte) ([]byte, error) {
+			return reply, nil
+		},
+		DoRaftSyncCommit: func(keyHash uint32, data [][]byte) ([]byte, error) {
+			<-committed
+			return reply, nil
+		},
+	}
+	c := &Client{
+		Data:   [][]byte{[]byte("zadd"), []byte("k"), []byte("1"), []byte("m")},
+		Writer: resp.NewWriter(),
+		server: s,
+	}
+
+	if err := c.RaftSync(); err != nil {
+		t.Fatal(err)
+	}
+	if string(c.Writer.Bytes()) != string(reply) {
+		t.Fatalf("reply without ack err %q", c.Writer.Bytes())
+	}
+
+	c.Writer.Reset()
+	c.writeAck = true
+	done := make(chan error, 1)
+	go func() {
+		done <- c.RaftSync()
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("reply returned before raft commit")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(committed)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("reply not returned after raft commit")
+	}
+	if string(c.Writer.Bytes()) != string(reply) {
+		t.Fatalf("reply with ack err %q", c.Writer.Bytes())
+	}
+}
+
+func TestKeyHashSelection(t *testing.T) {
+	if err := hash.SetKeyHash("md5"); err == nil {
+		t.Fatal("unknown key hash accepted")
+	}
+	if err := hash.SetKeyHash(hash.KeyHashCrc32); err != nil {
+		t.Fatal(err)
+	}
+	defer hash.SetKeyHash(hash.KeyHashFnv32)
+
+	s := newTestServer(openTestDB(t))
+	c := newTestClient(s)
+	do := func(isHashTag bool, args ...string) string {
+		return doTestRequest(c, isHashTag, args...)
+	}
+
+	k1, k2 := "keyhash_k1", "keyhash_k2"
+	if reply := do(false, "set", k1, "v1"); reply != "+OK\r\n" {
+		t.Fatalf("set reply %q", reply)
+	}
+	if c.KeyHash != hash.Crc32([]byte(k1)) {
+		t.Fatalf("KeyHash %d want crc32 %d", c.KeyHash, hash.Crc32([]byte(k1)))
+	}
+	do(false, "set", k2, "v2")
+	if reply := do(false, "mget", k1, k2); reply != "*2\r\n$2\r\nv1\r\n$2\r\nv2\r\n" {
+		t.Fatalf("mget reply %q", reply)
+	}
+
+	do(true, "set", "{keyhash}k3", "v3")
+	if c.KeyHash != hash.Crc32([]byte("keyhash")) || c.KeyHash != utils.GetHashTagKeyHash([]byte("{keyhash}k3")) {
+		t.Fatalf("hash tag KeyHash %d", c.KeyHash)
+	}
+
+	if s.txLocks.GetTxLockByKey([]byte(k2)) != s.txLocks.GetTxLock(hash.Crc32([]byte(k2))) {
+		t.Fatal("tx lock not picked by the configured hash")
+	}
+	wk := s.txLocks.GetTxLock(hash.Crc32([]byte(k2))).addWatchKey(c, k2, true)
+	before := wk.modifyTs.Load()
+	time.Sleep(time.Millisecond)
+	do(false, "del", k1, k2)
+	if s.txLocks.GetWatchKey(k2) != wk || wk.modifyTs.Load() == before {
+		t.Fatal("watch key of the second del key not marked modified")
+	}
+	if reply := do(false, "exists", k2); reply != ":0\r\n" {
+		t.Fatalf("exists after del %q", reply)
+	}
+}
+
+func TestNumKeysCmdRouting(t *testing.T) {
+	s := newTestServer(openTestDB(t))
+	c := newTestClient(s)
+	db := s.db
+
+	local, moved := []byte("zmpop_migrating_local"), []byte("zmpop_migrating_moved")
+	if reply := doTestRequest(c, false, "zadd", string(local), "1", "a", "2", "b"); reply != ":2\r\n" {
+		t.Fatalf("zadd reply %q", reply)
+	}
+
+	doTestRequest(c, false, "zintercard", "1", string(local))
+	if string(c.Keys) != string(local) || c.KeyHash != utils.KeyHash(local) {
+		t.Fatalf("zintercard routed by %q", c.Keys)
+	}
+
+	for _, key := range [][]byte{local, moved} {
+		slot := utils.KeyHash(key) % utils.TotalSlot
+		if slot == utils.KeyHash([]byte("1"))%utils.TotalSlot {
+			t.Fatalf("key %s shares the slot of the numkeys literal", key)
+		}
+		db.Migrate = db.NewMigrate(slot, "127.0.0.1:1", "")
+		db.Meta.SetMigrateStatus(engine.MigrateStatusProcess)
+
+		reply := doTestRequest(c, false, "zmpop", "1", string(key), "min")
+		if string(c.Keys) != string(key) || c.KeyHash != utils.KeyHash(key) {
+			t.Fatalf("zmpop routed by %q", c.Keys)
+		}
+		if string(key) == string(local) {
+			if want := "*2\r\n$21\r\nzmpop_migrating_local\r\n*2\r\n$1\r\na\r\n$1\r\n1\r\n"; reply != want {
+				t.Fatalf("zmpop on local key %q want %q", reply, want)
+			}
+		} else if reply == "*-1\r\n" {
+			t.Fatal("zmpop on migrated key answered locally instead of redirecting")
+		}
+
+		db.Meta.SetMigrateStatus(engine.MigrateStatusPrepare)
+		db.Migrate = nil
+	}
+
+	if reply := doTestRequest(c, false, "zcard", string(local)); reply != ":1\r\n" {
+		t.Fatalf("zcard after zmpop %q", reply)
+	}
+}
+
+func TestZSetRefreshTTLInCommand(t *testing.T) {
+	s := newTestServer(openTestDB(t))
+	var proposed [][]byte
+	s.isOpenRaft = true
+	s.DoRaftSync = func(keyHash uint32, data [][]byte) ([]byte, error) {
+		proposed = data
+		return nil, nil
+	}
+	c := newTestClient(s)
+
+	key := "zset_refresh_ttl_in_command"
+	doTestRequest(c, false, "zadd", key, "1", "a")
+
+	for _, tc := range []struct {
+		args  []string
+		reply string
+		want  string
+	}{
+		{[]string{"zadd", key, "2", "b"}, ":1\r\n", "zadd " + key + " REFRESHTTL 1000 2 b"},
+		{[]string{"zincrby", key, "1", "a"}, "$1\r\n2\r\n", "zincrby " + key + " 1 a REFRESHTTL 1000"},
+	} {
+		s.zsetRefreshTTL.Store(1000)
+		doTestRequest(c, false, "zexpire", key, "100")
+		if reply := doTestRequest(c, false, tc.args...); reply != tc.reply {
+			t.Fatalf("%s reply %q", tc.args[0], reply)
+		}
+		cmd := proposed
+		if got := string(bytes.Join(cmd, []byte(" "))); got != tc.want {
+			t.Fatalf("proposed %q want %q", got, tc.want)
+		}
+		if reply := doTestRequest(c, false, "zttl", key); reply != ":1000\r\n" {
+			t.Fatalf("zttl after %s %q", tc.args[0], reply)
+		}
+
+		// a replica applies the carried ttl whatever its own config says
+		s.zsetRefreshTTL.Store(0)
+		doTestRequest(c, false, "zexpire", key, "100")
+		replica := newTestClient(s)
+		replica.FormatData(cmd)
+		if err := replica.ApplyDB(0); err != nil {
+			t.Fatal(err)
+		}
+		if reply := doTestRequest(c, false, "zttl", key); reply != ":1000\r\n" {
+			t.Fatalf("zttl after replayed %s %q", tc.args[0], reply)
+		}
+	}
+}
+
+func TestWriteOnFollower(t *testing.T) {
+	s := newTestServer(openTestDB(t))
+	s.isOpenRaft = true
+	isMaster := false
+	s.IsMaster = func() bool { return isMaster }
+	var proposeErr error
+	s.DoRaftSync = func(keyHash uint32, data [][]byte) ([]byte, error) {
+		if proposeErr != nil {
+			return nil, proposeErr
+		}
+		return []byte(":1\r\n"), nil
+	}
+	c := newTestClient(s)
+
+	if reply := doTestRequest(c, false, "zadd", "zadd_on_follower", "1", "a"); reply != ":1\r\n" {
+		t.Fatalf("forwarded zadd on follower %q", reply)
+	}
+
+	proposeErr = errn.ErrNotLeader
+	want := "-" + errn.ErrReadOnly.Error() + "\r\n"
+	if reply := doTestRequest(c, false, "zadd", "zadd_on_follower", "1", "a"); reply != want {
+		t.Fatalf("zadd on follower without leader %q", reply)
+	}
+
+	isMaster = true
+	want = "-" + errn.ErrNotLeader.Error() + "\r\n"
+	if reply := doTestRequest(c, false, "zadd", "zadd_on_follower", "1", "a"); reply != want {
+		t.Fatalf("zadd on leader losing leadership %q", reply)
+	}
+}
+
+func TestLinearizableReadRouting(t *testing.T) {
+	s := newTestServer(openTestDB(t))
+	s.isOpenRaft = true
+	s.DoRaftSync = func(keyHash uint32, data [][]byte) ([]byte, error) {
+		return nil, nil
+	}
+	var readIndexes int
+	var readIndexErr error
+	s.DoRaftReadIndex = func() error {
+		readIndexes++
+		return readIndexErr
+	}
+	c := newTestClient(s)
+
+	for _, tc := range []struct {
+		args        []string
+		reply       string
+		readIndexes int
+	}{
+		{[]string{"set", "linearizable_read", "v"}, "+OK\r\n", 0},
+		{[]string{"get", "linearizable_read"}, "$1\r\nv\r\n", 0},
+		{[]string{"readconsistency"}, "+local\r\n", 0},
+		{[]string{"readconsistency", "linearizable"}, "+OK\r\n", 0},
+		{[]string{"get", "linearizable_read"}, "$1\r\nv\r\n", 1},
+		{[]string{"exists", "linearizable_read"}, ":1\r\n", 2},
+		{[]string{"set", "linearizable_read", "v2"}, "+OK\r\n", 2},
+		{[]string{"readconsistency"}, "+linearizable\r\n", 2},
+		{[]string{"get", "linearizable_read"}, "$2\r\nv2\r\n", 3},
+		{[]string{"readconsistency", "local"}, "+OK\r\n", 3},
+		{[]string{"get", "linearizable_read"}, "$2\r\nv2\r\n", 3},
+	} {
+		if reply := doTestRequest(c, false, tc.args...); reply != tc.reply {
+			t.Fatalf("%v reply %q", tc.args, reply)
+		}
+		if readIndexes != tc.readIndexes {
+			t.Fatalf("%v read index called %d times, expect %d", tc.args, readIndexes, tc.readIndexes)
+		}
+	}
+	if n := s.Info.Stats.LinearizableRead.Load(); n != 3 {
+		t.Fatalf("linearizable reads %d", n)
+	}
+
+	readIndexErr = errn.ErrNotLeader
+	doTestRequest(c, false, "readconsistency", "linearizable")
+	want := "-" + errn.ErrNotLeader.Error() + "\r\n"
+	if reply := doTestRequest(c, false, "get", "linearizable_read"); reply != want {
+		t.Fatalf("get without read index %q", reply)
+	}
+	if n := s.Info.Stats.LinearizableRead.Load(); n != 3 {
+		t.Fatalf("failed read index counted %d", n)
+	}
+}
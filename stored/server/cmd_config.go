@@ -15,9 +15,12 @@
 package server
 
 import (
+	"math"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/zuoyebang/bitalostored/butils/extend"
 	"github.com/zuoyebang/bitalostored/butils/unsafe2"
 	"github.com/zuoyebang/bitalostored/stored/internal/errn"
 	"github.com/zuoyebang/bitalostored/stored/internal/resp"
@@ -26,6 +29,14 @@ import (
 const (
 	CONFIGGET = "GET"
 	CONFIGSET = "SET"
+
+	CONFIGAUTOCOMPACT       = "AUTOCOMPACT"
+	CONFIGSLOWLOGSLOWERTHAN = "SLOWLOG-LOG-SLOWER-THAN"
+	CONFIGSLOWLOGMAXLEN     = "SLOWLOG-MAX-LEN"
+	CONFIGMAXREPLYELEMENTS  = "MAX-REPLY-ELEMENTS"
+	CONFIGZSETREFRESHTTL    = "ZSET-REFRESH-TTL"
+
+	maxSlowLogLen = 1 << 16
 )
 
 func init() {
@@ -41,16 +52,45 @@ func configCommand(c *Client) error {
 	}
 
 	op := strings.ToUpper(unsafe2.String(args[0]))
-	if op != CONFIGSET {
-		return errn.ErrNotImplement
-	}
-
-	configName := strings.ToUpper(unsafe2.String(args[1]))
-	if configName == "AUTOCOMPACT" {
+	switch op {
+	case CONFIGGET:
+		return configGet(c, strings.ToUpper(unsafe2.String(args[1])))
+	case CONFIGSET:
 		if len(args) < 3 {
 			return errn.CmdParamsErr(resp.CONFIG)
 		}
-		configValue, err := strconv.Atoi(string(args[2]))
+		return configSet(c, strings.ToUpper(unsafe2.String(args[1])), args[2])
+	default:
+		return errn.ErrNotImplement
+	}
+}
+
+func configGet(c *Client, configName string) error {
+	var value int64
+	switch configName {
+	case CONFIGSLOWLOGSLOWERTHAN:
+		value = c.server.slowTime.Load() / int64(time.Microsecond)
+	case CONFIGSLOWLOGMAXLEN:
+		value = int64(c.server.slowLog.getMaxLen())
+	case CONFIGMAXREPLYELEMENTS:
+		value = c.server.maxReplyElements.Load()
+	case CONFIGZSETREFRESHTTL:
+		value = c.server.zsetRefreshTTL.Load()
+	default:
+		return errn.ErrNotImplement
+	}
+
+	c.Writer.WriteSliceArray([][]byte{
+		[]byte(strings.ToLower(configName)),
+		extend.FormatInt64ToSlice(value),
+	})
+	return nil
+}
+
+func configSet(c *Client, configName string, arg []byte) error {
+	switch configName {
+	case CONFIGAUTOCOMPACT:
+		configValue, err := strconv.Atoi(string(arg))
 		if err != nil {
 			return err
 		}
@@ -67,7 +107,35 @@ func configCommand(c *Client) error {
 			c.server.Info.Server.UpdateCache()
 			c.Writer.WriteStatus(resp.ReplyOK)
 		}
-	} else {
+	case CONFIGSLOWLOGSLOWERTHAN:
+		us, err := extend.ParseInt64(unsafe2.String(arg))
+		if err != nil || us < 0 || us > math.MaxInt64/int64(time.Microsecond) {
+			return errn.ErrValue
+		}
+		c.server.slowTime.Store(us * int64(time.Microsecond))
+		c.Writer.WriteStatus(resp.ReplyOK)
+	case CONFIGSLOWLOGMAXLEN:
+		n, err := extend.ParseInt64(unsafe2.String(arg))
+		if err != nil || n <= 0 || n > maxSlowLogLen {
+			return errn.ErrValue
+		}
+		c.server.slowLog.setMaxLen(int(n))
+		c.Writer.WriteStatus(resp.ReplyOK)
+	case CONFIGMAXREPLYELEMENTS:
+		n, err := extend.ParseInt64(unsafe2.String(arg))
+		if err != nil || n < 0 {
+			return errn.ErrValue
+		}
+		c.server.maxReplyElements.Store(n)
+		c.Writer.WriteStatus(resp.ReplyOK)
+	case CONFIGZSETREFRESHTTL:
+		n, err := extend.ParseInt64(unsafe2.String(arg))
+		if err != nil || n < 0 {
+			return errn.ErrValue
+		}
+		c.server.zsetRefreshTTL.Store(n)
+		c.Writer.WriteStatus(resp.ReplyOK)
+	default:
 		return errn.ErrNotImplement
 	}
 	return nil
@@ -0,0 +1,294 @@
+// Copyright 2019-2024 Xu Ruibo (hustxurb@163.com) and Contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/zuoyebang/bitalostored/butils/unsafe2"
+	"github.com/zuoyebang/bitalostored/butils/vectormap"
+	"github.com/zuoyebang/bitalostored/stored/internal/errn"
+	"github.com/zuoyebang/bitalostored/stored/internal/resp"
+	"github.com/zuoyebang/bitalostored/stored/internal/utils"
+)
+
+const (
+	DEBUG = "debug"
+
+	DebugZsetInternals = "ZSET-INTERNALS"
+	DebugCacheGC       = "CACHE-GC"
+	DebugCacheEvict    = "CACHE-EVICT"
+	DebugCacheSizes    = "CACHE-SIZES"
+	DebugZaddDryRun    = "ZADD-DRYRUN"
+	DebugKeyPlacement  = "KEY-PLACEMENT"
+	DebugCacheGCShard  = "CACHE-GC-SHARD"
+	DebugRaftTransfer  = "RAFT-TRANSFER"
+	DebugRaftApplyLag  = "RAFT-APPLY-LAG"
+	DebugFlushDisk     = "FLUSHDISK"
+
+	debugOptHashTag = "HASHTAG"
+)
+
+func init() {
+	AddCommand(map[string]*Cmd{
+		DEBUG: {Sync: false, Handler: debugCommand, NoKey: true},
+	})
+}
+
+func debugCommand(c *Client) error {
+	if !c.server.isDebug {
+		return errn.ErrNotImplement
+	}
+
+	args := c.Args
+	if len(args) < 1 {
+		return errn.CmdParamsErr(DEBUG)
+	}
+
+	switch strings.ToUpper(unsafe2.String(args[0])) {
+	case DebugZsetInternals:
+		return debugZsetInternals(c, args[1:])
+	case DebugCacheGC:
+		return debugCacheGC(c, args[1:])
+	case DebugCacheEvict:
+		return debugCacheEvict(c, args[1:])
+	case DebugCacheSizes:
+		return debugCacheSizes(c, args[1:])
+	case DebugZaddDryRun:
+		return debugZaddDryRun(c, args[1:])
+	case DebugKeyPlacement:
+		return debugKeyPlacement(c, args[1:])
+	case DebugCacheGCShard:
+		return debugCacheGCShard(c, args[1:])
+	case DebugRaftTransfer:
+		return debugRaftTransfer(c, args[1:])
+	case DebugRaftApplyLag:
+		return debugRaftApplyLag(c, args[1:])
+	case DebugFlushDisk:
+		return debugFlushDisk(c, args[1:])
+	default:
+		return errn.ErrSyntax
+	}
+}
+
+func debugZsetInternals(c *Client, args [][]byte) error {
+	if len(args) != 1 {
+		return errn.CmdParamsErr(DEBUG)
+	}
+
+	key := args[0]
+	info, err := c.DB.ZDebugInternals(key, c.keyHash(key))
+	if err != nil {
+		return err
+	}
+
+	c.Writer.WriteBulk(info)
+	return nil
+}
+
+func debugCacheGC(c *Client, args [][]byte) error {
+	if len(args) != 0 {
+		return errn.CmdParamsErr(DEBUG)
+	}
+
+	deadCount, gcMem := c.DB.GCCache()
+	c.Writer.WriteArray([]interface{}{int64(deadCount), int64(gcMem)})
+	return nil
+}
+
+func debugCacheGCShard(c *Client, args [][]byte) error {
+	if len(args) != 1 {
+		return errn.CmdParamsErr(DEBUG)
+	}
+
+	i, err := utils.ByteToInt64(args[0])
+	if err != nil {
+		return errn.ErrValue
+	}
+	deadCount, gcMem, ok := c.DB.GCCacheShard(int(i))
+	if !ok {
+		return errn.ErrValue
+	}
+	c.Writer.WriteArray([]interface{}{int64(deadCount), int64(gcMem)})
+	return nil
+}
+
+func debugCacheEvict(c *Client, args [][]byte) error {
+	if len(args) != 0 {
+		return errn.CmdParamsErr(DEBUG)
+	}
+
+	c.Writer.WriteInteger(int64(c.DB.EvictCache()))
+	return nil
+}
+
+// debugCacheSizes replies the count of cached values per size bin, bins are
+// split by the ascending boundaries in args or by the value encodings.
+func debugCacheSizes(c *Client, args [][]byte) error {
+	buckets := vectormap.ValueClassBuckets()
+	if len(args) > 0 {
+		buckets = make([]uint32, len(args))
+		for i := range args {
+			n, err := utils.ByteToInt64(args[i])
+			if err != nil || n < 0 || n > math.MaxUint32 || (i > 0 && uint32(n) <= buckets[i-1]) {
+				return errn.ErrValue
+			}
+			buckets[i] = uint32(n)
+		}
+	}
+
+	hist := c.DB.CacheValueSizeHistogram(buckets)
+	res := make([]interface{}, len(hist))
+	for i := range hist {
+		res[i] = int64(hist[i])
+	}
+	c.Writer.WriteArray(res)
+	return nil
+}
+
+func debugZaddDryRun(c *Client, args [][]byte) error {
+	if len(args) < 3 || len(args[1:])&1 != 0 {
+		return errn.CmdParamsErr(DEBUG)
+	}
+
+	key := args[0]
+	params, err := zparseScorePairs(args[1:])
+	if err != nil {
+		return err
+	}
+
+	khash := c.keyHash(key)
+	scores := make(map[string]float64, len(params))
+	for i := range params {
+		scores[unsafe2.String(params[i].Member)] = params[i].Score
+	}
+
+	var added, updated int64
+	for member, score := range scores {
+		old, err := c.DB.ZScore(key, khash, unsafe2.ByteSlice(member))
+		if err == errn.ErrZsetMemberNil {
+			added++
+		} else if err != nil {
+			return err
+		} else if old != score {
+			updated++
+		}
+	}
+
+	c.Writer.WriteArray([]interface{}{added, updated})
+	return nil
+}
+
+// debugKeyPlacement replies the key hash, the slot and the meta cache shard
+// and probe start group of a key, HASHTAG hashes the key like lua does.
+func debugKeyPlacement(c *Client, args [][]byte) error {
+	if len(args) != 1 && len(args) != 2 {
+		return errn.CmdParamsErr(DEBUG)
+	}
+
+	key := args[0]
+	var khash uint32
+	if len(args) == 2 {
+		if strings.ToUpper(unsafe2.String(args[1])) != debugOptHashTag {
+			return errn.ErrSyntax
+		}
+		khash = utils.GetHashTagKeyHash(key)
+	} else {
+		khash = utils.KeyHash(key)
+	}
+
+	shard, group := c.DB.CachePlacement(key, khash)
+	c.Writer.WriteArray([]interface{}{
+		int64(khash),
+		int64(utils.GetSlotId(khash)),
+		int64(shard),
+		int64(group),
+	})
+	return nil
+}
+
+func debugRaftTransfer(c *Client, args [][]byte) error {
+	if len(args) != 1 {
+		return errn.CmdParamsErr(DEBUG)
+	}
+
+	targetNodeID, err := strconv.ParseUint(unsafe2.String(args[0]), 10, 64)
+	if err != nil {
+		return errn.ErrValue
+	}
+	if c.server.DoRaftTransfer == nil {
+		return errn.ErrRaftNotReady
+	}
+	if c.server.IsMaster == nil || !c.server.IsMaster() {
+		return errn.ErrNotLeader
+	}
+	if err = c.server.DoRaftTransfer(targetNodeID); err != nil {
+		return err
+	}
+	c.Writer.WriteStatus(resp.ReplyOK)
+	return nil
+}
+
+// debugRaftApplyLag replies the committed and applied raft index of this node
+// and the count of committed entries not applied yet.
+func debugRaftApplyLag(c *Client, args [][]byte) error {
+	if len(args) != 0 {
+		return errn.CmdParamsErr(DEBUG)
+	}
+	if c.server.DoRaftIndex == nil {
+		return errn.ErrRaftNotReady
+	}
+
+	committed, applied, err := c.server.DoRaftIndex()
+	if err != nil {
+		return err
+	}
+	var lag uint64
+	if committed > applied {
+		lag = committed - applied
+	}
+	c.Writer.WriteArray([]interface{}{int64(committed), int64(applied), int64(lag)})
+	return nil
+}
+
+// debugFlushDisk flushes and syncs both the data engine and the raft log db,
+// so a filesystem snapshot taken after the OK reply is consistent. Key writes
+// are held off while the data engine flushes. The raft log db is flushed after
+// they resume: every entry applied to the flushed data was appended to the log
+// before it was applied, so the log only holds more than the data and the
+// extra entries are replayed from the applied index on restart.
+func debugFlushDisk(c *Client, args [][]byte) error {
+	if len(args) != 0 {
+		return errn.CmdParamsErr(DEBUG)
+	}
+
+	db := c.server.GetDB()
+	if db == nil || db.IsBitsdbClosed() {
+		return errn.ErrDbSyncFailRefuse
+	}
+	if err := db.FlushDisk(); err != nil {
+		return err
+	}
+	if c.server.DoRaftFlushLogDB != nil {
+		if err := c.server.DoRaftFlushLogDB(); err != nil {
+			return err
+		}
+	}
+
+	c.Writer.WriteStatus(resp.ReplyOK)
+	return nil
+}
@@ -0,0 +1,274 @@
+// Copyright 2019-2024 Xu Ruibo (hustxurb@163.com) and Contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/zuoyebang/bitalostored/butils/hash"
+	"github.com/zuoyebang/bitalostored/stored/engine/bitsdb/btools"
+	"github.com/zuoyebang/bitalostored/stored/internal/errn"
+	"github.com/zuoyebang/bitalostored/stored/internal/utils"
+)
+
+func TestDebugRaftTransfer(t *testing.T) {
+	isLeader := false
+	var transfers []uint64
+	s := &Server{
+		isDebug:  true,
+		IsMaster: func() bool { return isLeader },
+		DoRaftTransfer: func(targetNodeID uint64) error {
+			if targetNodeID == 1 {
+				return errn.ErrTransferTarget
+			}
+			transfers = append(transfers, targetNodeID)
+			return nil
+		},
+	}
+
+	if _, err := doTestCommand(s, debugCommand, "raft-transfer", "2"); err != errn.ErrNotLeader {
+		t.Fatalf("transfer on follower err %v", err)
+	}
+	if len(transfers) != 0 {
+		t.Fatalf("transfer issued on follower %v", transfers)
+	}
+
+	isLeader = true
+	if reply, err := doTestCommand(s, debugCommand, "raft-transfer", "2"); err != nil || reply != "+OK\r\n" {
+		t.Fatalf("transfer on leader err %q %v", reply, err)
+	}
+	if len(transfers) != 1 || transfers[0] != 2 {
+		t.Fatalf("transfer not issued %v", transfers)
+	}
+	if _, err := doTestCommand(s, debugCommand, "raft-transfer", "1"); err != errn.ErrTransferTarget {
+		t.Fatalf("transfer to ineligible node err %v", err)
+	}
+	if _, err := doTestCommand(s, debugCommand, "raft-transfer", "x"); err != errn.ErrValue {
+		t.Fatalf("transfer to invalid node id err %v", err)
+	}
+	if _, err := doTestCommand(s, debugCommand, "raft-transfer"); err == nil {
+		t.Fatal("transfer without node id should fail")
+	}
+
+	s.DoRaftTransfer = nil
+	if _, err := doTestCommand(s, debugCommand, "raft-transfer", "2"); err != errn.ErrRaftNotReady {
+		t.Fatalf("transfer without raft err %v", err)
+	}
+	if len(transfers) != 1 {
+		t.Fatalf("unexpected transfers %v", transfers)
+	}
+}
+
+func TestDebugRaftApplyLag(t *testing.T) {
+	var committed, applied uint64
+	s := &Server{
+		isDebug: true,
+		DoRaftIndex: func() (uint64, uint64, error) {
+			return committed, applied, nil
+		},
+	}
+
+	for _, tc := range []struct {
+		committed, applied uint64
+		reply              string
+	}{
+		{100, 100, "*3\r\n:100\r\n:100\r\n:0\r\n"},
+		{1500, 1200, "*3\r\n:1500\r\n:1200\r\n:300\r\n"},
+		{7, 9, "*3\r\n:7\r\n:9\r\n:0\r\n"},
+	} {
+		committed, applied = tc.committed, tc.applied
+		reply, err := doTestCommand(s, debugCommand, "raft-apply-lag")
+		if err != nil || reply != tc.reply {
+			t.Fatalf("apply lag %d-%d reply %q err %v", committed, applied, reply, err)
+		}
+	}
+	if _, err := doTestCommand(s, debugCommand, "raft-apply-lag", "x"); err == nil {
+		t.Fatal("apply lag with args should fail")
+	}
+
+	s.DoRaftIndex = nil
+	if _, err := doTestCommand(s, debugCommand, "raft-apply-lag"); err != errn.ErrRaftNotReady {
+		t.Fatalf("apply lag without raft err %v", err)
+	}
+}
+
+func TestDebugFlushDisk(t *testing.T) {
+	db := openTestDB(t)
+	if err := db.Set([]byte("flushdisk"), hash.Fnv32([]byte("flushdisk")), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+
+	var logFlushes int
+	var logErr error
+	s := newTestServer(db)
+	s.isDebug = true
+	s.DoRaftFlushLogDB = func() error {
+		logFlushes++
+		return logErr
+	}
+
+	if reply, err := doTestCommand(s, debugCommand, "flushdisk"); err != nil || reply != "+OK\r\n" {
+		t.Fatalf("flushdisk reply %q err %v", reply, err)
+	}
+	if logFlushes != 1 {
+		t.Fatalf("log db flushed %d times", logFlushes)
+	}
+	if v, closer, err := db.Get([]byte("flushdisk"), hash.Fnv32([]byte("flushdisk"))); err != nil || string(v) != "v" {
+		t.Fatalf("get after flushdisk %q err %v", v, err)
+	} else if closer != nil {
+		closer()
+	}
+
+	logErr = errn.ErrRaftNotReady
+	if _, err := doTestCommand(s, debugCommand, "flushdisk"); err != errn.ErrRaftNotReady {
+		t.Fatalf("flushdisk log db err %v", err)
+	}
+	if _, err := doTestCommand(s, debugCommand, "flushdisk", "x"); err == nil {
+		t.Fatal("flushdisk with args should fail")
+	}
+
+	s.db = nil
+	if _, err := doTestCommand(s, debugCommand, "flushdisk"); err != errn.ErrDbSyncFailRefuse {
+		t.Fatalf("flushdisk without db err %v", err)
+	}
+}
+
+func TestDebugZsetInternals(t *testing.T) {
+	db := openTestDB(t)
+	s := newTestServer(db)
+	s.isDebug = true
+
+	key := []byte("{zdebug}internals")
+	for _, member := range []string{"a", strings.Repeat("b", 200)} {
+		if _, err := db.ZAdd(key, utils.GetHashTagKeyHash(key), btools.ZAddOptions{}, btools.ScorePair{Score: 1, Member: []byte(member)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := newTestClient(s)
+	reply := doTestRequest(c, true, "debug", "zset-internals", string(key))
+	for _, expect := range []string{"members:2 ", "member_encoding:inline=1,overshort=1,overlong=0 member_bytes:201"} {
+		if !strings.Contains(reply, expect) {
+			t.Fatalf("zset internals reply %q missing %q", reply, expect)
+		}
+	}
+
+	reply = doTestRequest(c, false, "debug", "zset-internals", string(key))
+	if !strings.Contains(reply, "members:0 ") {
+		t.Fatalf("zset internals without hashtag reply %q", reply)
+	}
+	if reply = doTestRequest(c, true, "debug", "zset-internals"); !strings.HasPrefix(reply, "-") {
+		t.Fatalf("zset internals without key reply %q", reply)
+	}
+}
+
+func TestDebugZaddDryRun(t *testing.T) {
+	db := openTestDB(t)
+	s := newTestServer(db)
+	s.isDebug = true
+
+	key := []byte("{zdryrun}key")
+	khash := utils.GetHashTagKeyHash(key)
+	if _, err := db.ZAdd(key, khash, btools.ZAddOptions{}, btools.ScorePair{Score: 1, Member: []byte("a")}, btools.ScorePair{Score: 2, Member: []byte("b")}); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestClient(s)
+	args := []string{"debug", "zadd-dryrun", string(key), "1", "a", "3", "b", "4", "c", "5", "d", "6", "d"}
+	if res := testReplyInts(t, doTestRequest(c, true, args...)); !reflect.DeepEqual(res, []int64{2, 1}) {
+		t.Fatalf("dryrun counts %v", res)
+	}
+	if res := testReplyInts(t, doTestRequest(c, false, args...)); !reflect.DeepEqual(res, []int64{4, 0}) {
+		t.Fatalf("dryrun without hashtag counts %v", res)
+	}
+	if reply := doTestRequest(c, true, "debug", "zadd-dryrun", string(key), "notafloat", "a"); !strings.HasPrefix(reply, "-") {
+		t.Fatalf("dryrun with invalid score reply %q", reply)
+	}
+
+	if n, err := db.ZCard(key, khash); err != nil || n != 2 {
+		t.Fatalf("zcard changed by dryrun %d %v", n, err)
+	}
+	if score, err := db.ZScore(key, khash, []byte("b")); err != nil || score != 2 {
+		t.Fatalf("score changed by dryrun %v %v", score, err)
+	}
+}
+
+func TestDebugCacheSizes(t *testing.T) {
+	db := openTestCacheDB(t)
+	s := newTestServer(db)
+	s.isDebug = true
+
+	key := []byte("cache_sizes")
+	if _, err := db.HSet(key, hash.Fnv32(key), []byte("f"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestClient(s)
+	if res := testReplyInts(t, doTestRequest(c, false, "debug", "cache-sizes")); len(res) != 3 {
+		t.Fatalf("cache-sizes default bins %v", res)
+	}
+	if res := testReplyInts(t, doTestRequest(c, false, "debug", "cache-sizes", "16", "128", "1024")); len(res) != 4 {
+		t.Fatalf("cache-sizes custom bins %v", res)
+	}
+	for _, args := range [][]string{{"128", "16"}, {"16", "16"}, {"-1"}, {"abc"}} {
+		if reply := doTestRequest(c, false, append([]string{"debug", "cache-sizes"}, args...)...); !strings.HasPrefix(reply, "-") {
+			t.Fatalf("cache-sizes %v reply %q", args, reply)
+		}
+	}
+}
+
+func TestDebugKeyPlacement(t *testing.T) {
+	db := openTestCacheDB(t)
+	s := newTestServer(db)
+	s.isDebug = true
+
+	c := newTestClient(s)
+	a := testReplyInts(t, doTestRequest(c, false, "debug", "key-placement", "{placement}.a", "hashtag"))
+	b := testReplyInts(t, doTestRequest(c, false, "debug", "key-placement", "{placement}.b", "hashtag"))
+	if len(a) != 4 || len(b) != 4 {
+		t.Fatalf("key-placement reply len %v %v", a, b)
+	}
+	if a[0] != b[0] || a[1] != b[1] || a[2] < 0 {
+		t.Fatalf("hashtag keys placed apart %v %v", a, b)
+	}
+	if reply := doTestRequest(c, false, "debug", "key-placement", "{placement}.a", "unknown"); !strings.HasPrefix(reply, "-") {
+		t.Fatalf("key-placement invalid option reply %q", reply)
+	}
+}
+
+func TestDebugCacheGCShard(t *testing.T) {
+	db := openTestCacheDB(t)
+	s := newTestServer(db)
+	s.isDebug = true
+
+	c := newTestClient(s)
+	for _, idx := range []string{"-1", "1000000", "a"} {
+		if reply := doTestRequest(c, false, "debug", "cache-gc-shard", idx); !strings.HasPrefix(reply, "-") {
+			t.Fatalf("cache-gc-shard %s reply %q", idx, reply)
+		}
+	}
+
+	p := testReplyInts(t, doTestRequest(c, false, "debug", "key-placement", "cache_gc_shard"))
+	if len(p) != 4 || p[2] < 0 {
+		t.Fatalf("key-placement reply %v", p)
+	}
+	res := testReplyInts(t, doTestRequest(c, false, "debug", "cache-gc-shard", strconv.FormatInt(p[2], 10)))
+	if len(res) != 2 || res[0] < 0 || res[1] < 0 {
+		t.Fatalf("cache-gc-shard reply %v", res)
+	}
+}
@@ -79,7 +79,7 @@ func geoaddCommand(c *Client) error {
 		}
 	}
 
-	n, err := c.DB.ZAdd(key, c.KeyHash, params...)
+	n, err := c.DB.ZAdd(key, c.KeyHash, btools.ZAddOptions{}, params...)
 	if err == nil {
 		c.Writer.WriteInteger(n)
 	}
@@ -57,7 +57,12 @@ func keyUniqIdCommand(c *Client) error {
 }
 
 func compactCommand(c *Client) error {
-	c.DB.Compact()
+	if !c.server.isDebug {
+		return errn.ErrNotImplement
+	}
+	if err := c.DB.Compact(); err != nil {
+		return err
+	}
 	c.Writer.WriteStatus("OK")
 	return nil
 }
@@ -0,0 +1,63 @@
+// Copyright 2019-2024 Xu Ruibo (hustxurb@163.com) and Contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zuoyebang/bitalostored/stored/internal/errn"
+)
+
+func TestCompactInProgress(t *testing.T) {
+	db := openTestDB(t)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	db.BeforeCompact = func() {
+		started <- struct{}{}
+		<-release
+	}
+	s := newTestServer(db)
+
+	if _, err := doTestCommand(s, compactCommand); err != errn.ErrNotImplement {
+		t.Fatalf("compact without debug err %v", err)
+	}
+
+	s.isDebug = true
+	if reply, err := doTestCommand(s, compactCommand); err != nil || reply != "+OK\r\n" {
+		t.Fatalf("compact reply %q err %v", reply, err)
+	}
+	<-started
+	if _, err := doTestCommand(s, compactCommand); err != errn.ErrCompactRunning {
+		t.Fatalf("compact in progress err %v", err)
+	}
+
+	waitCompact := func() {
+		deadline := time.Now().Add(time.Minute)
+		for db.IsCompactRun() {
+			if time.Now().After(deadline) {
+				t.Fatal("compact not finished")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	close(release)
+	waitCompact()
+	go func() { <-started }()
+	if reply, err := doTestCommand(s, compactCommand); err != nil || reply != "+OK\r\n" {
+		t.Fatalf("compact after finish reply %q err %v", reply, err)
+	}
+	waitCompact()
+}
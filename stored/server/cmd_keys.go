@@ -202,6 +202,20 @@ func persistCommand(c *Client) error {
 	return nil
 }
 
+func cacheMemoryInfo(c *Client) []byte {
+	memCap, usedMem := c.DB.CacheMemory()
+	remainMem := memCap - usedMem
+	if remainMem < 0 {
+		remainMem = 0
+	}
+
+	info := []byte("# Cache\n")
+	info = utils.AppendInfoInt(info, "cache_mem_cap:", memCap)
+	info = utils.AppendInfoInt(info, "cache_used_mem:", usedMem)
+	info = utils.AppendInfoInt(info, "cache_remain_mem:", remainMem)
+	return append(info, '\n')
+}
+
 func infoCommand(c *Client) error {
 	var info []byte
 	sinfo := c.GetInfo()
@@ -218,13 +232,17 @@ func infoCommand(c *Client) error {
 			info, closer = sinfo.Cluster.Marshal()
 		case "stats":
 			info, closer = sinfo.Stats.Marshal()
+		case "transactions":
+			info, closer = sinfo.Tx.Marshal()
+		case "cache":
+			info = cacheMemoryInfo(c)
 		case "_leader_address":
 			info = []byte(sinfo.Cluster.LeaderAddress)
 		case "_server_address":
 			info = []byte(sinfo.Server.ServerAddress)
 		}
 	}
-	c.Writer.WriteBulk(info)
+	c.Writer.WriteVerbatim("txt", info)
 	if closer != nil {
 		closer()
 	}
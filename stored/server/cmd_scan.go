@@ -50,7 +50,7 @@ var (
 	nilCursorRedis   = []byte("0")
 )
 
-func parseXScanArgs(args [][]byte) (cursor []byte, match string, count int, err error) {
+func parseXScanArgs(args [][]byte) (cursor []byte, match string, count int, noValues bool, err error) {
 	cursor = args[0]
 
 	args = args[1:]
@@ -79,6 +79,8 @@ func parseXScanArgs(args [][]byte) (cursor []byte, match string, count int, err
 			}
 
 			i++
+		case "NOVALUES":
+			noValues = true
 		default:
 			err = fmt.Errorf("invalid argument %s", args[i])
 			return
@@ -89,8 +91,8 @@ func parseXScanArgs(args [][]byte) (cursor []byte, match string, count int, err
 	return
 }
 
-func parseScanArgs(args [][]byte) (cursor []byte, match string, count int, err error) {
-	cursor, match, count, err = parseXScanArgs(args)
+func parseScanArgs(args [][]byte) (cursor []byte, match string, count int, noValues bool, err error) {
+	cursor, match, count, noValues, err = parseXScanArgs(args)
 	if bytes.Compare(cursor, nilCursorRedis) == 0 {
 		cursor = nilCursorBitalos
 	}
@@ -99,7 +101,7 @@ func parseScanArgs(args [][]byte) (cursor []byte, match string, count int, err e
 
 type scanCommandGroup struct {
 	lastCursor []byte
-	parseArgs  func(args [][]byte) (cursor []byte, match string, count int, err error)
+	parseArgs  func(args [][]byte) (cursor []byte, match string, count int, noValues bool, err error)
 }
 
 func (scg scanCommandGroup) xhscanCommand(c *Client) error {
@@ -111,7 +113,7 @@ func (scg scanCommandGroup) xhscanCommand(c *Client) error {
 
 	key := args[0]
 
-	cursor, match, count, err := scg.parseArgs(args[1:])
+	cursor, match, count, noValues, err := scg.parseArgs(args[1:])
 
 	if err != nil {
 		return err
@@ -128,7 +130,11 @@ func (scg scanCommandGroup) xhscanCommand(c *Client) error {
 	vv := make([][]byte, 0, len(ay)*2)
 
 	for _, v := range ay {
-		vv = append(vv, v.Field, v.Value)
+		if noValues {
+			vv = append(vv, v.Field)
+		} else {
+			vv = append(vv, v.Field, v.Value)
+		}
 	}
 
 	data[0] = cursor
@@ -147,10 +153,12 @@ func (scg scanCommandGroup) xsscanCommand(c *Client) error {
 
 	key := args[0]
 
-	cursor, match, count, err := scg.parseArgs(args[1:])
+	cursor, match, count, noValues, err := scg.parseArgs(args[1:])
 
 	if err != nil {
 		return err
+	} else if noValues {
+		return errn.ErrSyntax
 	}
 
 	var ay [][]byte
@@ -178,7 +186,7 @@ func (scg scanCommandGroup) xzscanCommand(c *Client) error {
 
 	key := args[0]
 
-	cursor, match, count, err := scg.parseArgs(args[1:])
+	cursor, match, count, noValues, err := scg.parseArgs(args[1:])
 
 	if err != nil {
 		return err
@@ -194,7 +202,11 @@ func (scg scanCommandGroup) xzscanCommand(c *Client) error {
 	var data [2]interface{}
 	vv := make([][]byte, 0, len(ay)*2)
 	for _, v := range ay {
-		vv = append(vv, v.Member, extend.FormatFloat64ToSlice(v.Score))
+		if noValues {
+			vv = append(vv, v.Member)
+		} else {
+			vv = append(vv, v.Member, extend.FormatFloat64ToSlice(v.Score))
+		}
 	}
 
 	data[0] = cursor
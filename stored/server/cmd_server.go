@@ -16,20 +16,35 @@ package server
 
 import (
 	"os"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/zuoyebang/bitalostored/butils/extend"
+	"github.com/zuoyebang/bitalostored/butils/unsafe2"
 	"github.com/zuoyebang/bitalostored/stored/internal/errn"
 	"github.com/zuoyebang/bitalostored/stored/internal/resp"
 )
 
+const (
+	readConsistencyLocal        = "local"
+	readConsistencyLinearizable = "linearizable"
+
+	writeAckOn  = "on"
+	writeAckOff = "off"
+)
+
 func init() {
 	AddCommand(map[string]*Cmd{
 		resp.PING:     {Sync: false, Handler: pingCommand, NoKey: true},
 		resp.ECHO:     {Sync: false, Handler: echoCommand, NoKey: true},
 		resp.TIME:     {Sync: false, Handler: timeCommand, NoKey: true},
+		resp.HELLO:    {Sync: false, Handler: helloCommand, NoKey: true, NotAllowedInTx: true},
 		resp.SHUTDOWN: {Sync: false, Handler: shutdownCommand, NoKey: true},
+		resp.SLOWLOG:  {Sync: false, Handler: slowlogCommand, NoKey: true, NotAllowedInTx: true},
+
+		resp.READCONSISTENCY: {Sync: false, Handler: readConsistencyCommand, NoKey: true, NotAllowedInTx: true},
+		resp.WRITEACK:        {Sync: false, Handler: writeAckCommand, NoKey: true, NotAllowedInTx: true},
 	})
 }
 
@@ -47,6 +62,74 @@ func echoCommand(c *Client) error {
 	return nil
 }
 
+func helloCommand(c *Client) error {
+	if len(c.Args) > 1 {
+		return errn.CmdParamsErr(resp.HELLO)
+	}
+
+	if len(c.Args) == 1 {
+		proto, err := extend.ParseInt64(unsafe2.String(c.Args[0]))
+		if err != nil || (proto != resp.ProtoResp2 && proto != resp.ProtoResp3) {
+			return errn.ErrNoProto
+		}
+		c.Writer.SetProto(int(proto))
+	}
+
+	c.Writer.WriteMapLen(3)
+	c.Writer.WriteBulk([]byte("server"))
+	c.Writer.WriteBulk([]byte("bitalostored"))
+	c.Writer.WriteBulk([]byte("proto"))
+	c.Writer.WriteInteger(int64(c.Writer.Proto))
+	c.Writer.WriteBulk([]byte("role"))
+	if c.IsMaster() {
+		c.Writer.WriteBulk([]byte("master"))
+	} else {
+		c.Writer.WriteBulk([]byte("slave"))
+	}
+	return nil
+}
+
+func slowlogCommand(c *Client) error {
+	args := c.Args
+	if len(args) < 1 {
+		return errn.CmdParamsErr(resp.SLOWLOG)
+	}
+
+	switch strings.ToUpper(unsafe2.String(args[0])) {
+	case "GET":
+		if len(args) > 2 {
+			return errn.CmdParamsErr(resp.SLOWLOG)
+		}
+		n := int64(10)
+		if len(args) == 2 {
+			var err error
+			if n, err = extend.ParseInt64(unsafe2.String(args[1])); err != nil || n < -1 {
+				return errn.ErrValue
+			}
+		}
+		entries := c.server.slowLog.get(int(n))
+		ay := make([]interface{}, 0, len(entries))
+		for _, e := range entries {
+			// the redis layout (id, time, duration, args, addr, name) comes
+			// first so existing parsers keep working, the cache flag follows
+			var cacheMaint int64
+			if e.cacheMaint {
+				cacheMaint = 1
+			}
+			ay = append(ay, []interface{}{e.id, e.timestamp, e.costUs, e.args, []byte(e.addr), []byte(""), cacheMaint})
+		}
+		c.Writer.WriteArray(ay)
+	case "LEN":
+		c.Writer.WriteInteger(int64(c.server.slowLog.len()))
+	case "RESET":
+		c.server.slowLog.reset()
+		c.Writer.WriteStatus(resp.ReplyOK)
+	default:
+		return errn.ErrSyntax
+	}
+	return nil
+}
+
 func timeCommand(c *Client) error {
 	if len(c.Args) != 0 {
 		return errn.CmdParamsErr(resp.TIME)
@@ -75,3 +158,57 @@ func shutdownCommand(c *Client) error {
 	c.Writer.WriteStatus(resp.ReplyOK)
 	return nil
 }
+
+func readConsistencyCommand(c *Client) error {
+	if len(c.Args) > 1 {
+		return errn.CmdParamsErr(resp.READCONSISTENCY)
+	}
+
+	if len(c.Args) == 0 {
+		if c.linearizableRead {
+			c.Writer.WriteStatus(readConsistencyLinearizable)
+		} else {
+			c.Writer.WriteStatus(readConsistencyLocal)
+		}
+		return nil
+	}
+
+	switch strings.ToLower(unsafe2.String(c.Args[0])) {
+	case readConsistencyLinearizable:
+		c.linearizableRead = true
+	case readConsistencyLocal:
+		c.linearizableRead = false
+	default:
+		return errn.ErrSyntax
+	}
+
+	c.Writer.WriteStatus(resp.ReplyOK)
+	return nil
+}
+
+func writeAckCommand(c *Client) error {
+	if len(c.Args) > 1 {
+		return errn.CmdParamsErr(resp.WRITEACK)
+	}
+
+	if len(c.Args) == 0 {
+		if c.writeAck {
+			c.Writer.WriteStatus(writeAckOn)
+		} else {
+			c.Writer.WriteStatus(writeAckOff)
+		}
+		return nil
+	}
+
+	switch strings.ToLower(unsafe2.String(c.Args[0])) {
+	case writeAckOn:
+		c.writeAck = true
+	case writeAckOff:
+		c.writeAck = false
+	default:
+		return errn.ErrSyntax
+	}
+
+	c.Writer.WriteStatus(resp.ReplyOK)
+	return nil
+}
@@ -15,7 +15,9 @@
 package cmd_test
 
 import (
+	"fmt"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -23,6 +25,7 @@ import (
 	"github.com/zuoyebang/bitalostored/stored/internal/resp"
 
 	"github.com/gomodule/redigo/redis"
+	"github.com/zuoyebang/bitalostored/stored/internal/errn"
 )
 
 func TestExpireKey(t *testing.T) {
@@ -204,3 +207,211 @@ func TestWrongType(t *testing.T) {
 		t.Fatalf("rpush err %v", err)
 	}
 }
+
+func TestWriteAck(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	key := "TestWriteAckKey"
+	c.Do("del", key)
+
+	if mode, err := redis.String(c.Do("writeack")); err != nil {
+		t.Fatal(err)
+	} else if mode != "off" {
+		t.Fatalf("default writeack err %s", mode)
+	}
+	if _, err := c.Do("writeack", "unknown"); err == nil {
+		t.Fatal("invalid writeack should fail")
+	}
+
+	if ok, err := redis.String(c.Do("writeack", "on")); err != nil || ok != resp.ReplyOK {
+		t.Fatalf("writeack err %s %v", ok, err)
+	}
+	if mode, err := redis.String(c.Do("writeack")); err != nil || mode != "on" {
+		t.Fatalf("writeack mode err %s %v", mode, err)
+	}
+	if n, err := redis.Int(c.Do("zadd", key, 1, "a")); err != nil || n != 1 {
+		t.Fatalf("zadd with writeack err %d %v", n, err)
+	}
+	if score, err := redis.Float64(c.Do("zscore", key, "a")); err != nil || score != 1 {
+		t.Fatalf("zscore after writeack err %v %v", score, err)
+	}
+
+	if ok, err := redis.String(c.Do("writeack", "off")); err != nil || ok != resp.ReplyOK {
+		t.Fatalf("writeack err %s %v", ok, err)
+	}
+	c.Do("del", key)
+}
+
+func TestHello(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	res, err := redis.Values(c.Do("hello", 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 6 {
+		t.Fatalf("hello reply len err %d", len(res))
+	}
+	if server, _ := redis.String(res[1], nil); server != "bitalostored" {
+		t.Fatalf("server err %s", server)
+	}
+	if proto, _ := redis.Int(res[3], nil); proto != 2 {
+		t.Fatalf("proto err %d", proto)
+	}
+
+	if _, err := c.Do("hello", 4); err == nil || err.Error() != errn.ErrNoProto.Error() {
+		t.Fatalf("hello 4 should fail err %v", err)
+	}
+
+	if info, err := redis.String(c.Do("info", "stats")); err != nil {
+		t.Fatal(err)
+	} else if !strings.HasPrefix(info, "# Status") {
+		t.Fatalf("info err %s", info)
+	}
+}
+
+func TestSlowlogRuntimeConfig(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	res, err := redis.Strings(c.Do("config", "get", "slowlog-log-slower-than"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 2 || res[0] != "slowlog-log-slower-than" {
+		t.Fatal("config get slowlog-log-slower-than fail", res)
+	}
+	oldSlowerThan := res[1]
+	res, err = redis.Strings(c.Do("config", "get", "slowlog-max-len"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldMaxLen := res[1]
+	defer func() {
+		c.Do("config", "set", "slowlog-log-slower-than", oldSlowerThan)
+		c.Do("config", "set", "slowlog-max-len", oldMaxLen)
+	}()
+
+	if _, err = c.Do("config", "set", "slowlog-log-slower-than", -1); err == nil || err.Error() != errn.ErrValue.Error() {
+		t.Fatal("negative slowlog-log-slower-than should fail", err)
+	}
+	if _, err = c.Do("config", "set", "slowlog-max-len", 0); err == nil || err.Error() != errn.ErrValue.Error() {
+		t.Fatal("zero slowlog-max-len should fail", err)
+	}
+
+	if _, err = c.Do("config", "set", "slowlog-log-slower-than", 0); err != nil {
+		t.Fatal(err)
+	}
+	c.Do("slowlog", "reset")
+
+	key := "TestSlowlogRuntimeConfigKey"
+	if _, err = c.Do("set", key, "v"); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := redis.Values(c.Do("slowlog", "get", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatal("slowlog get len fail", len(entries))
+	}
+	entry, err := redis.Values(entries[0], nil)
+	if err != nil || len(entry) != 7 {
+		t.Fatal("slowlog entry fail", entry, err)
+	}
+	if addr, err := redis.String(entry[4], nil); err != nil || addr == "" {
+		t.Fatal("slowlog entry client addr fail", entry[4], err)
+	}
+	if _, err := redis.String(entry[5], nil); err != nil {
+		t.Fatal("slowlog entry client name fail", entry[5], err)
+	}
+	if maint, err := redis.Int(entry[6], nil); err != nil || (maint != 0 && maint != 1) {
+		t.Fatal("slowlog entry cache maint fail", entry[6], err)
+	}
+	args, err := redis.Strings(entry[3], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(args) != 3 || !strings.EqualFold(args[0], "set") || args[1] != key {
+		t.Fatal("slowlog entry args fail", args)
+	}
+
+	if _, err = c.Do("config", "set", "slowlog-max-len", 2); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		c.Do("get", key)
+	}
+	if n, err := redis.Int(c.Do("slowlog", "len")); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Fatal("slowlog len not capped", n)
+	}
+
+	if _, err = c.Do("config", "set", "slowlog-log-slower-than", 10000000); err != nil {
+		t.Fatal(err)
+	}
+	c.Do("slowlog", "reset")
+	c.Do("get", key)
+	if n, err := redis.Int(c.Do("slowlog", "len")); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatal("fast command recorded as slow", n)
+	}
+}
+
+func getCacheMemoryInfo(t *testing.T, c redis.Conn) map[string]int64 {
+	res, err := redis.String(c.Do("info", "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := make(map[string]int64)
+	for _, line := range strings.Split(res, "\n") {
+		k, v, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info[k] = n
+	}
+	return info
+}
+
+func TestInfoCacheMemory(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	before := getCacheMemoryInfo(t, c)
+	if before["cache_mem_cap"] == 0 {
+		return
+	}
+	if before["cache_used_mem"]+before["cache_remain_mem"] != before["cache_mem_cap"] {
+		t.Fatal("cache memory info err", before)
+	}
+
+	value := strings.Repeat("v", 512)
+	for i := 0; i < 200; i++ {
+		key := fmt.Sprintf("TestInfoCacheMemoryKey%d", i)
+		if _, err := c.Do("hset", key, "f", value); err != nil {
+			t.Fatal(err)
+		}
+		c.Do("hget", key, "f")
+	}
+
+	after := getCacheMemoryInfo(t, c)
+	if after["cache_used_mem"] <= before["cache_used_mem"] {
+		t.Fatal("cache used mem not grow", before, after)
+	}
+	if after["cache_remain_mem"] >= before["cache_remain_mem"] {
+		t.Fatal("cache remain mem not shrink", before, after)
+	}
+
+	for i := 0; i < 200; i++ {
+		c.Do("del", fmt.Sprintf("TestInfoCacheMemoryKey%d", i))
+	}
+}
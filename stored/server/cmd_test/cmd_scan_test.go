@@ -147,3 +147,39 @@ func TestScan(t *testing.T) {
 		}
 	}
 }
+
+func TestZScanNoValues(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	key := "TestZScanNoValuesKey"
+	c.Do("del", key)
+	if _, err := c.Do("zadd", key, 1, "a", 2, "b", 3, "c"); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := redis.Values(c.Do("zscan", key, "0", "count", 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items, err := redis.Strings(res[1], nil); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(items, []string{"a", "1", "b", "2", "c", "3"}) {
+		t.Fatal("zscan default reply err", items)
+	}
+
+	res, err = redis.Values(c.Do("zscan", key, "0", "count", 10, "novalues"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items, err := redis.Strings(res[1], nil); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(items, []string{"a", "b", "c"}) {
+		t.Fatal("zscan novalues reply err", items)
+	}
+
+	if _, err := c.Do("sscan", key, "0", "novalues"); err == nil {
+		t.Fatal("sscan novalues should fail")
+	}
+	c.Do("del", key)
+}
@@ -16,10 +16,14 @@ package cmd_test
 
 import (
 	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/gomodule/redigo/redis"
+	"github.com/zuoyebang/bitalostored/stored/internal/config"
 	"github.com/zuoyebang/bitalostored/stored/internal/errn"
 )
 
@@ -1355,3 +1359,308 @@ func TestTxDiscard3KeyUnlockTimeout(t *testing.T) {
 		}
 	}
 }
+
+func TestTxQueueLimitAbort(t *testing.T) {
+	if isSkipTestTx() {
+		return
+	}
+
+	c := getTestConn()
+	defer c.Close()
+
+	key := "tx-queue-limit-key"
+	if _, err := c.Do("del", key); err != nil {
+		t.Fatal(err)
+	}
+
+	if res, err := redis.String(c.Do("multi")); err != nil {
+		t.Fatal(err)
+	} else if res != "OK" {
+		t.Fatal("res is not ok", res)
+	}
+
+	limit := config.DefaultTxMaxQueueCommands
+	for i := 0; i < limit; i++ {
+		if err := c.Send("incr", key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < limit; i++ {
+		if res, err := redis.String(c.Receive()); err != nil {
+			t.Fatal(err)
+		} else if res != "QUEUED" {
+			t.Fatalf("expect:QUEUED r:%+v", res)
+		}
+	}
+
+	if _, err := c.Do("incr", key); err == nil || err.Error() != errn.ErrTxQueueLimit.Error() {
+		t.Fatal("queue past limit should fail", err)
+	}
+	if _, err := c.Do("prepare"); err == nil || err.Error() != errn.ErrExecAbort.Error() {
+		t.Fatal("prepare aborted tx should fail", err)
+	}
+	if _, err := c.Do("exec"); err == nil || err.Error() != errn.ErrExecAbort.Error() {
+		t.Fatal("exec aborted tx should fail", err)
+	}
+
+	if n, err := redis.Int(c.Do("exists", key)); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatal("aborted tx applied", n)
+	}
+}
+
+func TestTxExecErrorIsolation(t *testing.T) {
+	if isSkipTestTx() {
+		return
+	}
+
+	c := getTestConn()
+	defer c.Close()
+
+	key := "tx-exec-isolation-key"
+	if _, err := c.Do("del", key); err != nil {
+		t.Fatal(err)
+	}
+
+	if res, err := redis.String(c.Do("multi")); err != nil {
+		t.Fatal(err)
+	} else if res != "OK" {
+		t.Fatal("res is not ok", res)
+	}
+
+	for _, args := range [][]interface{}{
+		{key, 1, "m1"},
+		{key, "notafloat", "m2"},
+		{key, 2, "m3"},
+	} {
+		if res, err := redis.String(c.Do("zadd", args...)); err != nil {
+			t.Fatal(err)
+		} else if res != "QUEUED" {
+			t.Fatalf("expect:QUEUED r:%+v", res)
+		}
+	}
+
+	if res, err := redis.String(c.Do("prepare")); err != nil {
+		t.Fatal(err)
+	} else if res != "OK" {
+		t.Fatal("prepare not ok", res)
+	}
+
+	res, err := redis.Values(c.Do("exec"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 3 {
+		t.Fatal("res len != 3", len(res))
+	}
+	if n, ok := res[0].(int64); !ok || n != 1 {
+		t.Fatalf("first reply expect:1 r:%+v", res[0])
+	}
+	if _, ok := res[1].(redis.Error); !ok {
+		t.Fatalf("second reply expect error r:%+v", res[1])
+	}
+	if n, ok := res[2].(int64); !ok || n != 1 {
+		t.Fatalf("third reply expect:1 r:%+v", res[2])
+	}
+
+	if n, err := redis.Int(c.Do("zcard", key)); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Fatal("zcard expect 2", n)
+	}
+}
+
+func TestTxWatchKeyLimit(t *testing.T) {
+	if isSkipTestTx() {
+		return
+	}
+
+	c1 := getTestConn()
+	defer c1.Close()
+
+	c2 := getTestConn()
+	defer c2.Close()
+
+	limit := config.DefaultTxMaxWatchKeys
+	args := make([]interface{}, 0, limit)
+	for i := 0; i < limit; i++ {
+		args = append(args, fmt.Sprintf("tx-watch-limit-%d", i))
+	}
+	if res, err := redis.String(c1.Do("watch", args...)); err != nil {
+		t.Fatal(err)
+	} else if res != "OK" {
+		t.Fatal("res is not ok", res)
+	}
+
+	if _, err := c1.Do("watch", "tx-watch-limit-over"); err == nil || err.Error() != errn.ErrTxWatchLimit.Error() {
+		t.Fatal("watch past limit should fail", err)
+	}
+	if res, err := redis.String(c1.Do("watch", args[0])); err != nil {
+		t.Fatal(err)
+	} else if res != "OK" {
+		t.Fatal("rewatch existing key not ok", res)
+	}
+
+	if _, err := c2.Do("set", args[0], "TestTxWatchKeyLimit-c2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if res, err := redis.String(c1.Do("multi")); err != nil {
+		t.Fatal(err)
+	} else if res != "OK" {
+		t.Fatal("multi not ok", res)
+	}
+	if _, err := c1.Do("get", args[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c1.Do("prepare"); err == nil || err.Error() != errn.ErrWatchKeyChanged.Error() {
+		t.Fatal("prepare should see watched key changed", err)
+	}
+	if _, err := c1.Do("discard"); err != nil && err.Error() != errn.ErrDiscardNoMulti.Error() {
+		t.Fatal(err)
+	}
+
+	if res, err := redis.String(c1.Do("watch", "tx-watch-limit-over")); err != nil {
+		t.Fatal(err)
+	} else if res != "OK" {
+		t.Fatal("watch after reset not ok", res)
+	}
+	if _, err := c1.Do("unwatch"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func getTxInfoField(t *testing.T, c redis.Conn, field string) int64 {
+	res, err := redis.String(c.Do("info", "transactions"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(res, "\n") {
+		if v, ok := strings.CutPrefix(line, field+":"); ok {
+			n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return n
+		}
+	}
+	t.Fatal(field, "not found")
+	return 0
+}
+
+func TestTxInfoPrepareLocked(t *testing.T) {
+	if isSkipTestTx() {
+		return
+	}
+
+	c1 := getTestConn()
+	defer c1.Close()
+
+	c2 := getTestConn()
+	defer c2.Close()
+
+	key := "tx-info-prepare-locked"
+	locked := getTxInfoField(t, c2, "prepare_locked_clients")
+
+	if res, err := redis.String(c1.Do("watch", key)); err != nil {
+		t.Fatal(err)
+	} else if res != "OK" {
+		t.Fatal("res is not ok", res)
+	}
+	if res, err := redis.String(c1.Do("multi")); err != nil {
+		t.Fatal(err)
+	} else if res != "OK" {
+		t.Fatal("multi not ok", res)
+	}
+	if _, err := c1.Do("set", key, "v"); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := redis.String(c1.Do("prepare")); err != nil {
+		t.Fatal(err)
+	} else if res != "OK" {
+		t.Fatal("prepare not ok", res)
+	}
+
+	if n := getTxInfoField(t, c2, "prepare_locked_clients"); n != locked+1 {
+		t.Fatalf("prepare_locked_clients expect:%d actual:%d", locked+1, n)
+	}
+
+	if _, err := c1.Do("exec"); err != nil {
+		t.Fatal(err)
+	}
+	if n := getTxInfoField(t, c2, "prepare_locked_clients"); n != locked {
+		t.Fatalf("prepare_locked_clients expect:%d actual:%d", locked, n)
+	}
+}
+
+func TestTxPrepareLockTimeout(t *testing.T) {
+	if isSkipTestTx() {
+		return
+	}
+
+	c1 := getTestConn()
+	defer c1.Close()
+
+	c2 := getTestConn()
+	defer c2.Close()
+
+	key := "tx-prepare-lock-timeout"
+	for _, c := range []redis.Conn{c1, c2} {
+		if res, err := redis.String(c.Do("multi")); err != nil {
+			t.Fatal(err)
+		} else if res != "OK" {
+			t.Fatal("multi not ok", res)
+		}
+		if _, err := c.Do("set", key, "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if res, err := redis.String(c2.Do("prepare")); err != nil {
+		t.Fatal(err)
+	} else if res != "OK" {
+		t.Fatal("prepare not ok", res)
+	}
+
+	lockFail := getTxInfoField(t, c2, "prepare_lock_fail_total")
+	start := time.Now()
+	if _, err := c1.Do("prepare"); err == nil || err.Error() != errn.ErrPrepareLockFail.Error() {
+		t.Fatal("prepare on locked key should fail", err)
+	}
+	if cost := time.Since(start); cost >= time.Second {
+		t.Fatal("prepare lock wait too long", cost)
+	}
+	if n := getTxInfoField(t, c2, "prepare_lock_fail_total"); n != lockFail+1 {
+		t.Fatalf("prepare_lock_fail_total expect:%d actual:%d", lockFail+1, n)
+	}
+
+	if _, err := c2.Do("exec"); err != nil {
+		t.Fatal(err)
+	}
+
+	if res, err := redis.String(c1.Do("multi")); err != nil {
+		t.Fatal(err)
+	} else if res != "OK" {
+		t.Fatal("multi not ok", res)
+	}
+	if _, err := c1.Do("set", key, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := redis.String(c1.Do("prepare")); err != nil {
+		t.Fatal(err)
+	} else if res != "OK" {
+		t.Fatal("prepare after release not ok", res)
+	}
+	if _, err := c1.Do("exec"); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := redis.String(c1.Do("get", key)); err != nil {
+		t.Fatal(err)
+	} else if res != "v1" {
+		t.Fatal("expect v1 actual", res)
+	}
+}
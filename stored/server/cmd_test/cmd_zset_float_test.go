@@ -620,6 +620,45 @@ func TestZsetFloatErrorParams(t *testing.T) {
 	}
 }
 
+func TestZSetFloatScoreGrammar(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	key := []byte("myzsetfloatgrammar")
+	c.Do("del", key)
+
+	if n, err := redis.Int(c.Do("zadd", key, "1.5", "a")); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatal(n)
+	}
+
+	for _, score := range []string{"1,5", " 1.5", "1.5 ", " 1.5 "} {
+		if _, err := c.Do("zadd", key, score, "b"); err == nil {
+			t.Fatalf("zadd score %q should fail", score)
+		}
+		if _, err := c.Do("zincrby", key, score, "a"); err == nil {
+			t.Fatalf("zincrby score %q should fail", score)
+		}
+		if _, err := c.Do("zcount", key, score, "+inf"); err == nil {
+			t.Fatalf("zcount score %q should fail", score)
+		}
+	}
+
+	for i := 0; i < readNum; i++ {
+		if n, err := redis.Int(c.Do("zcard", key)); err != nil {
+			t.Fatal(err)
+		} else if n != 1 {
+			t.Fatal(n)
+		}
+		if v, err := redis.Float64(c.Do("zscore", key, "a")); err != nil {
+			t.Fatal(err)
+		} else if v != 1.5 {
+			t.Fatal(v)
+		}
+	}
+}
+
 func TestZSetFloatLex(t *testing.T) {
 	c := getTestConn()
 	defer c.Close()
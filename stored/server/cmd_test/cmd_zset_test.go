@@ -16,11 +16,15 @@ package cmd_test
 
 import (
 	"fmt"
+	"math"
 	"reflect"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gomodule/redigo/redis"
+	"github.com/zuoyebang/bitalostored/stored/internal/errn"
 )
 
 func TestZSet(t *testing.T) {
@@ -679,3 +683,958 @@ func TestZSetLex(t *testing.T) {
 		t.Fatal(n)
 	}
 }
+
+func TestZSetRangeScoreHugeOffset(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	key := []byte("myzset_huge_offset")
+	c.Do("del", key)
+	if n, err := redis.Int(c.Do("zadd", key, 1, "a", 2, "b", 3, "c")); err != nil {
+		t.Fatal(err)
+	} else if n != 3 {
+		t.Fatal(n)
+	}
+
+	for i := 0; i < readNum; i++ {
+		if v, err := redis.Values(c.Do("zrangebyscore", key, "-inf", "+inf", "limit", 1000000, 10)); err != nil {
+			t.Fatal(err)
+		} else if len(v) != 0 {
+			t.Fatal(len(v))
+		}
+
+		if v, err := redis.Values(c.Do("zrevrangebyscore", key, "+inf", "-inf", "withscores", "limit", 1000000, 10)); err != nil {
+			t.Fatal(err)
+		} else if len(v) != 0 {
+			t.Fatal(len(v))
+		}
+
+		if v, err := redis.Values(c.Do("zrangebyscore", key, "-inf", "+inf", "limit", 2, 10)); err != nil {
+			t.Fatal(err)
+		} else if err := testZSetRange(v, "c"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c.Do("del", key)
+}
+
+func TestZSetUnionInter(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	key1 := "{TestZSetUnionInter}_1"
+	key2 := "{TestZSetUnionInter}_2"
+	c.Do("del", key1, key2)
+	if _, err := c.Do("zadd", key1, 1, "a", 2, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do("zadd", key2, 1, "a", 2, "b", 3, "c"); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < readNum; i++ {
+		if v, err := redis.Values(c.Do("zunion", 2, key1, key2)); err != nil {
+			t.Fatal(err)
+		} else if err := testZSetRange(v, "a", "c", "b"); err != nil {
+			t.Fatal(err)
+		}
+		if v, err := redis.Values(c.Do("zunion", 2, key1, key2, "withscores")); err != nil {
+			t.Fatal(err)
+		} else if err := testZSetRange(v, "a", 2, "c", 3, "b", 4); err != nil {
+			t.Fatal(err)
+		}
+		if v, err := redis.Values(c.Do("zunion", 2, key1, key2, "weights", 2, 3, "aggregate", "max", "withscores")); err != nil {
+			t.Fatal(err)
+		} else if err := testZSetRange(v, "a", 3, "b", 6, "c", 9); err != nil {
+			t.Fatal(err)
+		}
+
+		if v, err := redis.Values(c.Do("zinter", 2, key1, key2, "withscores")); err != nil {
+			t.Fatal(err)
+		} else if err := testZSetRange(v, "a", 2, "b", 4); err != nil {
+			t.Fatal(err)
+		}
+		if v, err := redis.Values(c.Do("zinter", 2, key1, key2, "weights", 2, 3, "aggregate", "max", "withscores")); err != nil {
+			t.Fatal(err)
+		} else if err := testZSetRange(v, "a", 3, "b", 6); err != nil {
+			t.Fatal(err)
+		}
+		if v, err := redis.Values(c.Do("zinter", 2, key1, "{TestZSetUnionInter}_none")); err != nil {
+			t.Fatal(err)
+		} else if len(v) != 0 {
+			t.Fatal(v)
+		}
+	}
+
+	if _, err := c.Do("zunion", 3, key1, key2); err == nil {
+		t.Fatal("numkeys more than keys should fail")
+	}
+	if _, err := c.Do("zinter", 2, key1, key2, "weights", 1); err == nil {
+		t.Fatal("weights less than numkeys should fail")
+	}
+	if _, err := c.Do("zinter", 2, key1, key2, "aggregate", "avg"); err == nil {
+		t.Fatal("invalid aggregate should fail")
+	}
+	if _, err := c.Do("zunion", 2, key1, "TestZSetUnionInterOther"); err == nil || err.Error() != errn.ErrCrossSlot.Error() {
+		t.Fatal("zunion cross slot", err)
+	}
+}
+
+func TestZSetUnionInfWeights(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	key1 := "{TestZSetUnionInfWeights}_1"
+	key2 := "{TestZSetUnionInfWeights}_2"
+	key3 := "{TestZSetUnionInfWeights}_3"
+	dst := "{TestZSetUnionInfWeights}_dst"
+	c.Do("del", key1, key2, key3, dst)
+	if _, err := c.Do("zadd", key1, 0, "a", 1, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do("zadd", key2, 1, "a", -1, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do("zadd", key3, "inf", "a", 1, "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	checkStored := func(args []interface{}, expect ...interface{}) {
+		t.Helper()
+		if n, err := redis.Int(c.Do(args[0].(string), args[1:]...)); err != nil {
+			t.Fatal(err)
+		} else if n != len(expect)/2 {
+			t.Fatalf("%v stored %d", args, n)
+		}
+		if v, err := redis.Values(c.Do("zrange", dst, 0, -1, "withscores")); err != nil {
+			t.Fatal(err)
+		} else if err := testZSetRange(v, expect...); err != nil {
+			t.Fatalf("%v %v", args, err)
+		}
+	}
+
+	for i := 0; i < readNum; i++ {
+		checkStored([]interface{}{"zunionstore", dst, 2, key1, key2, "weights", "inf", "inf"}, "b", "0", "a", "inf")
+		checkStored([]interface{}{"zunionstore", dst, 2, key1, key2, "weights", "inf", 0}, "a", "0", "b", "inf")
+		checkStored([]interface{}{"zunionstore", dst, 2, key3, key2, "weights", 0, 1}, "b", "-1", "a", "1")
+		checkStored([]interface{}{"zunionstore", dst, 2, key3, key2, "weights", 0, 0}, "a", "0", "b", "0")
+		checkStored([]interface{}{"zinterstore", dst, 2, key1, key2, "weights", "-inf", "inf", "aggregate", "min"}, "b", "-inf", "a", "0")
+	}
+	c.Do("del", key1, key2, key3, dst)
+}
+
+func TestZSetAddKeepTTL(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	key := "TestZSetAddKeepTTL"
+	c.Do("del", key)
+	if _, err := c.Do("zadd", key, 1, "m"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := redis.Int(c.Do("zexpire", key, 100)); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatal(n)
+	}
+
+	if n, err := redis.Int(c.Do("zadd", key, 1, "m")); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatal(n)
+	}
+	if n, err := redis.Int(c.Do("zadd", key, 2, "n")); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatal(n)
+	}
+
+	for i := 0; i < readNum; i++ {
+		if n, err := redis.Int(c.Do("zttl", key)); err != nil {
+			t.Fatal(err)
+		} else if n <= 0 || n > 100 {
+			t.Fatal("zadd cleared ttl", n)
+		}
+	}
+}
+
+func TestZSetCountEqualBounds(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	key := "TestZSetCountEqualBoundsKey"
+	c.Do("del", key)
+	if _, err := c.Do("zadd", key, 5, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, cs := range []struct {
+		min, max string
+		expect   int
+	}{
+		{"(5", "(5", 0},
+		{"5", "5", 1},
+		{"(5", "5", 0},
+		{"5", "(5", 0},
+	} {
+		for i := 0; i < readNum; i++ {
+			if n, err := redis.Int(c.Do("zcount", key, cs.min, cs.max)); err != nil {
+				t.Fatal(err)
+			} else if n != cs.expect {
+				t.Fatalf("zcount %s %s expect:%d actual:%d", cs.min, cs.max, cs.expect, n)
+			}
+		}
+	}
+}
+
+func TestZSetUnlink(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	key := "TestZSetUnlinkKey"
+	c.Do("del", key)
+	members := 100000
+	for i := 0; i < members; i += 1000 {
+		args := []interface{}{key}
+		for j := i; j < i+1000; j++ {
+			args = append(args, j, fmt.Sprintf("member_%d", j))
+		}
+		if _, err := c.Do("zadd", args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := redis.Int(c.Do("zcard", key)); err != nil || n != members {
+		t.Fatal("zcard err", n, err)
+	}
+
+	start := time.Now()
+	if n, err := redis.Int(c.Do("zunlink", key, "TestZSetUnlinkMissing")); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatal("zunlink count err", n)
+	}
+	if cost := time.Since(start); cost > time.Second {
+		t.Fatal("zunlink not lazy", cost)
+	}
+
+	if n, err := redis.Int(c.Do("zcard", key)); err != nil || n != 0 {
+		t.Fatal("zcard after zunlink err", n, err)
+	}
+	if n, err := redis.Int(c.Do("exists", key)); err != nil || n != 0 {
+		t.Fatal("exists after zunlink err", n, err)
+	}
+	if _, err := redis.Float64(c.Do("zscore", key, "member_10")); err != redis.ErrNil {
+		t.Fatal("zscore after zunlink err", err)
+	}
+	if res, err := redis.Strings(c.Do("zrange", key, 0, -1)); err != nil || len(res) != 0 {
+		t.Fatal("zrange after zunlink err", res, err)
+	}
+
+	if _, err := c.Do("zadd", key, 1, "member_1"); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := redis.Strings(c.Do("zrange", key, 0, -1, "withscores")); err != nil || !reflect.DeepEqual(res, []string{"member_1", "1"}) {
+		t.Fatal("zrange after re-add err", res, err)
+	}
+	c.Do("zunlink", key)
+}
+
+func TestZSetMaxReplyElements(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	old, err := redis.Strings(c.Do("config", "get", "max-reply-elements"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Do("config", "set", "max-reply-elements", old[1])
+
+	key := "TestZSetMaxReplyElementsKey"
+	c.Do("del", key)
+	for i := 0; i < 10; i++ {
+		if _, err := c.Do("zadd", key, i, fmt.Sprintf("member_%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := c.Do("config", "set", "max-reply-elements", 8); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < readNum; i++ {
+		if _, err := c.Do("zrange", key, 0, -1); err == nil || err.Error() != errn.ErrReplyTooLarge.Error() {
+			t.Fatal("zrange over max reply elements err", err)
+		}
+		if _, err := c.Do("zrangebyscore", key, "-inf", "+inf"); err == nil || err.Error() != errn.ErrReplyTooLarge.Error() {
+			t.Fatal("zrangebyscore over max reply elements err", err)
+		}
+		if _, err := c.Do("zrange", key, 0, 4, "withscores"); err == nil || err.Error() != errn.ErrReplyTooLarge.Error() {
+			t.Fatal("zrange withscores over max reply elements err", err)
+		}
+		if v, err := redis.Strings(c.Do("zrange", key, 0, 7)); err != nil || len(v) != 8 {
+			t.Fatal("zrange within max reply elements err", v, err)
+		}
+	}
+
+	if _, err := c.Do("config", "set", "max-reply-elements", -1); err == nil {
+		t.Fatal("negative max-reply-elements should fail")
+	}
+	c.Do("del", key)
+}
+
+func TestZSetRefreshTTL(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	old, err := redis.Strings(c.Do("config", "get", "zset-refresh-ttl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Do("config", "set", "zset-refresh-ttl", old[1])
+
+	key := "TestZSetRefreshTTLKey"
+	persistKey := "TestZSetRefreshTTLPersistKey"
+	c.Do("del", key, persistKey)
+	if _, err := c.Do("zadd", key, 1, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do("zadd", persistKey, 1, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do("zexpire", key, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(2 * time.Second)
+	if _, err := c.Do("zadd", key, 2, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := redis.Int64(c.Do("zttl", key)); err != nil || n > 8 {
+		t.Fatal("zttl without refresh err", n, err)
+	}
+
+	if _, err := c.Do("config", "set", "zset-refresh-ttl", 10); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do("zadd", key, 3, "c"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := redis.Int64(c.Do("zttl", key)); err != nil || n != 10 {
+		t.Fatal("zttl after zadd refresh err", n, err)
+	}
+	time.Sleep(2 * time.Second)
+	if _, err := c.Do("zincrby", key, 1, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := redis.Int64(c.Do("zttl", key)); err != nil || n != 10 {
+		t.Fatal("zttl after zincrby refresh err", n, err)
+	}
+
+	if _, err := c.Do("zadd", persistKey, 2, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := redis.Int64(c.Do("zttl", persistKey)); err != nil || n != -1 {
+		t.Fatal("zttl of persistent key err", n, err)
+	}
+
+	if _, err := c.Do("config", "set", "zset-refresh-ttl", -1); err == nil {
+		t.Fatal("negative zset-refresh-ttl should fail")
+	}
+	c.Do("del", key, persistKey)
+}
+
+func TestZSetErrorCode(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	key := "TestZSetErrorCodeKey"
+	c.Do("del", key)
+	if _, err := c.Do("set", key, "v"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do("zadd", key, 1, "a"); err == nil || !strings.HasPrefix(err.Error(), "WRONGTYPE ") {
+		t.Fatal("wrong type reply err", err)
+	}
+	c.Do("del", key)
+
+	for _, r := range [][2]string{{"a", "1"}, {"1", "(b"}, {"", "1"}} {
+		_, err := c.Do("zrangebyscore", key, r[0], r[1])
+		if err == nil || err.Error() != errn.ErrZSetScoreRange.Error() || !strings.HasPrefix(err.Error(), "ERR ") {
+			t.Fatal("score range reply err", r, err)
+		}
+	}
+	if _, err := c.Do("zadd", key, "a", "b"); err == nil || !strings.HasPrefix(err.Error(), "ERR ") {
+		t.Fatal("bad score reply err", err)
+	}
+}
+
+func TestZSetWrongType(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	key := "TestZSetWrongTypeKey"
+	c.Do("del", key)
+	if _, err := c.Do("set", key, "v"); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]interface{}{
+		{"zadd", key, 1, "a"},
+		{"zincrby", key, 1, "a"},
+		{"zcard", key},
+		{"zscore", key, "a"},
+		{"zrange", key, 0, -1},
+		{"zrangebyscore", key, "-inf", "+inf"},
+		{"zrem", key, "a"},
+	} {
+		_, err := c.Do(args[0].(string), args[1:]...)
+		if err == nil || err.Error() != errn.ErrWrongType.Error() {
+			t.Fatal("zset command on string key err", args, err)
+		}
+	}
+	if v, err := redis.String(c.Do("get", key)); err != nil || v != "v" {
+		t.Fatal("string value changed", v, err)
+	}
+	c.Do("del", key)
+}
+
+func TestZSetCountApprox(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	key := "TestZSetCountApproxKey"
+	c.Do("del", key)
+	args := []interface{}{key}
+	for i := 0; i < 10000; i++ {
+		args = append(args, i, fmt.Sprintf("member_%d", i))
+		if len(args) == 1001 {
+			if _, err := c.Do("zadd", args...); err != nil {
+				t.Fatal(err)
+			}
+			args = args[:1]
+		}
+	}
+
+	for _, r := range [][2]string{{"10", "100"}, {"(1000", "8000"}, {"-inf", "+inf"}} {
+		exact, err := redis.Int64(c.Do("zcount", key, r[0], r[1]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		approx, err := redis.Int64(c.Do("zcount", key, r[0], r[1], "approx"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := math.Abs(float64(exact - approx)); diff > float64(exact)*0.02 {
+			t.Fatal("zcount approx out of tolerance", r, exact, approx)
+		}
+	}
+
+	if _, err := c.Do("zcount", key, 0, 1, "exact"); err == nil {
+		t.Fatal("zcount unknown option should fail")
+	}
+	c.Do("del", key)
+}
+
+func TestZSetMixedScores(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	key := "TestZSetMixedScoresKey"
+	c.Do("del", key)
+	if _, err := c.Do("zadd", key,
+		"3", "c", "2.5", "b2", "-1", "n1", "-0.5", "n0", "0", "z",
+		"1700000000", "ts", "1700000000.25", "ts2", "1e3", "k", "2", "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"n1", "-1", "n0", "-0.5", "z", "0", "b", "2", "b2", "2.5", "c", "3",
+		"k", "1000", "ts", "1700000000", "ts2", "1700000000.25",
+	}
+	res, err := redis.Strings(c.Do("zrangebyscore", key, "-inf", "+inf", "withscores"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(want, res) {
+		t.Fatalf("zrangebyscore want %v got %v", want, res)
+	}
+
+	res, err = redis.Strings(c.Do("zrangebyscore", key, "(2", "1000", "withscores"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(want[8:14], res) {
+		t.Fatalf("zrangebyscore want %v got %v", want[8:14], res)
+	}
+
+	if v, err := redis.String(c.Do("zincrby", key, "0.5", "b2")); err != nil || v != "3" {
+		t.Fatal("zincrby to integral score", v, err)
+	}
+	if v, err := redis.String(c.Do("zscore", key, "ts2")); err != nil || v != "1700000000.25" {
+		t.Fatal("zscore fractional", v, err)
+	}
+	c.Do("del", key)
+}
+
+func BenchmarkZRangeByScoreIntScores(b *testing.B) {
+	c := getTestConn()
+	defer c.Close()
+
+	key := "BenchmarkZRangeByScoreIntScoresKey"
+	c.Do("del", key)
+	args := []interface{}{key}
+	for i := 0; i < 10000; i++ {
+		args = append(args, 1700000000+i, fmt.Sprintf("member_%d", i))
+		if len(args) == 1001 {
+			if _, err := c.Do("zadd", args...); err != nil {
+				b.Fatal(err)
+			}
+			args = args[:1]
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.Do("zrangebyscore", key, 1700001000, 1700002000, "withscores"); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	c.Do("del", key)
+}
+
+func TestZAddFlags(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	key := "TestZAddFlagsKey"
+	c.Do("del", key)
+	zscore := func(member string) string {
+		v, err := redis.String(c.Do("zscore", key, member))
+		if err != nil {
+			t.Fatal("zscore", member, err)
+		}
+		return v
+	}
+	zadd := func(want int64, args ...interface{}) {
+		n, err := redis.Int64(c.Do("zadd", append([]interface{}{key}, args...)...))
+		if err != nil || n != want {
+			t.Fatal("zadd", args, n, err)
+		}
+	}
+
+	zadd(2, 1, "a", 2, "b")
+	zadd(1, "nx", 10, "a", 3, "c")
+	if zscore("a") != "1" {
+		t.Fatal("nx updated an existing member")
+	}
+	zadd(0, "xx", 10, "a", 4, "d")
+	if zscore("a") != "10" {
+		t.Fatal("xx did not update an existing member")
+	}
+	if v, err := c.Do("zscore", key, "d"); err != nil || v != nil {
+		t.Fatal("xx added a new member", v, err)
+	}
+	zadd(0, "gt", 5, "a", 5, "b")
+	if zscore("a") != "10" || zscore("b") != "5" {
+		t.Fatal("gt", zscore("a"), zscore("b"))
+	}
+	zadd(0, "lt", 20, "a", 1, "b")
+	if zscore("a") != "10" || zscore("b") != "1" {
+		t.Fatal("lt", zscore("a"), zscore("b"))
+	}
+	zadd(3, "ch", 11, "a", 1, "b", 2, "c", 1, "e")
+	zadd(1, "xx", "ch", "gt", 12, "a", 0, "b")
+
+	zadd(1, "gt", math.MinInt64, "min")
+	zadd(1, "gt", "ch", math.MaxInt64, "min")
+	zadd(0, "gt", "ch", math.MaxInt64, "min")
+	zadd(1, "lt", math.MaxInt64, "max")
+	zadd(1, "lt", "ch", math.MinInt64, "max")
+	zadd(0, "lt", "ch", math.MinInt64, "max")
+	if v, err := redis.Float64(c.Do("zscore", key, "min")); err != nil || v != math.MaxInt64 {
+		t.Fatal("gt to max score bound", v, err)
+	}
+	if v, err := redis.Float64(c.Do("zscore", key, "max")); err != nil || v != math.MinInt64 {
+		t.Fatal("lt to min score bound", v, err)
+	}
+
+	if v, err := redis.String(c.Do("zadd", key, "incr", 5, "a")); err != nil || v != "17" {
+		t.Fatal("zadd incr", v, err)
+	}
+	if v, err := c.Do("zadd", key, "nx", "incr", 5, "a"); err != nil || v != nil {
+		t.Fatal("zadd nx incr existing member", v, err)
+	}
+	if v, err := c.Do("zadd", key, "xx", "incr", 5, "none"); err != nil || v != nil {
+		t.Fatal("zadd xx incr missing member", v, err)
+	}
+	if v, err := c.Do("zadd", key, "gt", "incr", -1, "a"); err != nil || v != nil {
+		t.Fatal("zadd gt incr down", v, err)
+	}
+	if v, err := redis.String(c.Do("zadd", key, "lt", "incr", -1, "a")); err != nil || v != "16" {
+		t.Fatal("zadd lt incr down", v, err)
+	}
+	if _, err := c.Do("zadd", key, "incr", 1, "a", 2, "b"); err == nil || err.Error() != errn.ErrZAddIncrPair.Error() {
+		t.Fatal("zadd incr with two pairs", err)
+	}
+
+	for _, args := range [][]interface{}{
+		{"nx", "xx", 1, "a"},
+		{"nx", "gt", 1, "a"},
+		{"nx", "lt", 1, "a"},
+		{"gt", "lt", 1, "a"},
+	} {
+		if _, err := c.Do("zadd", append([]interface{}{key}, args...)...); err == nil || err.Error() != errn.ErrSyntax.Error() {
+			t.Fatal("zadd illegal flags", args, err)
+		}
+	}
+	if _, err := c.Do("zadd", key, "nx"); err == nil {
+		t.Fatal("zadd flags without pairs should fail")
+	}
+	if _, err := c.Do("zadd", key, "gt", 1e19, "a"); err == nil {
+		t.Fatal("zadd score out of int64 range should fail")
+	}
+	c.Do("del", key)
+}
+
+func TestZRangeStore(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	src := "{TestZRangeStore}src"
+	dst := "{TestZRangeStore}dst"
+	c.Do("del", src, dst)
+	defer c.Do("del", src, dst)
+	if _, err := c.Do("zadd", src, 1, "a", 2, "b", 3, "c", 4, "d", 5, "e"); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(want []string, args ...interface{}) {
+		n, err := redis.Int(c.Do("zrangestore", append([]interface{}{dst, src}, args...)...))
+		if err != nil || n != len(want)/2 {
+			t.Fatal("zrangestore", args, n, err)
+		}
+		got, err := redis.Strings(c.Do("zrange", dst, 0, -1, "withscores"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Fatal("zrangestore", args, got, want)
+		}
+	}
+
+	check([]string{"b", "2", "c", "3"}, 1, 2)
+	check([]string{"d", "4", "e", "5"}, 0, 1, "rev")
+	check([]string{"b", "2", "c", "3", "d", "4"}, "(1", 4, "byscore")
+	check([]string{"c", "3", "d", "4"}, "+inf", "-inf", "byscore", "rev", "limit", 1, 2)
+	check([]string{"b", "2", "c", "3"}, "[b", "(d", "bylex")
+	check([]string{"c", "3", "d", "4"}, "+", "-", "bylex", "rev", "limit", 1, 2)
+	check([]string{"e", "5"}, "+", "-", "bylex", "rev", "limit", 0, 1)
+
+	if _, err := c.Do("set", dst, "v"); err != nil {
+		t.Fatal(err)
+	}
+	check([]string{"a", "1"}, 0, 0)
+
+	if n, err := redis.Int(c.Do("zrangestore", dst, src, 10, 20, "byscore")); err != nil || n != 0 {
+		t.Fatal("zrangestore empty range", n, err)
+	}
+	if n, err := redis.Int(c.Do("exists", dst)); err != nil || n != 0 {
+		t.Fatal("empty zrangestore should delete dst", n, err)
+	}
+
+	for _, args := range [][]interface{}{
+		{0, -1, "limit", 0, 1},
+		{0, -1, "byscore", "bylex"},
+		{0, -1, "withscores"},
+	} {
+		if _, err := c.Do("zrangestore", append([]interface{}{dst, src}, args...)...); err == nil || err.Error() != errn.ErrSyntax.Error() {
+			t.Fatal("zrangestore bad options", args, err)
+		}
+	}
+	if _, err := c.Do("zrangestore", dst, src, 0); err == nil {
+		t.Fatal("zrangestore without max should fail")
+	}
+	if _, err := c.Do("zrangestore", "TestZRangeStoreOther", src, 0, -1); err == nil || err.Error() != errn.ErrCrossSlot.Error() {
+		t.Fatal("zrangestore cross slot", err)
+	}
+}
+
+func TestZRangeUnified(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	key := "TestZRangeUnifiedKey"
+	c.Do("del", key)
+	defer c.Do("del", key)
+	if _, err := c.Do("zadd", key, 1, "a", 2, "b", 3, "c", 4, "d", 5, "e"); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(want []string, args ...interface{}) {
+		got, err := redis.Strings(c.Do("zrange", append([]interface{}{key}, args...)...))
+		if err != nil {
+			t.Fatal("zrange", args, err)
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Fatal("zrange", args, got, want)
+		}
+	}
+
+	check([]string{"a", "b"}, 0, 1)
+	check([]string{"a", "1", "b", "2"}, 0, 1, "withscores")
+	check([]string{"e", "d"}, 0, 1, "rev")
+	check([]string{"b", "c", "d"}, "(1", 4, "byscore")
+	check([]string{"d", "4", "c", "3"}, 4, "(2", "byscore", "rev", "withscores")
+	check([]string{"c", "d"}, "-inf", "+inf", "byscore", "limit", 2, 2)
+	check([]string{"b", "c"}, "[b", "(d", "bylex")
+	check([]string{"d", "c"}, "+", "-", "bylex", "rev", "limit", 1, 2)
+	check([]string{}, "-inf", "+inf", "byscore", "limit", -1, 2)
+
+	for _, args := range [][]interface{}{
+		{0, -1, "limit", 0, 1},
+		{0, -1, "byscore", "bylex"},
+		{"-", "+", "bylex", "withscores"},
+		{0, -1, "byscore", "limit", 0},
+	} {
+		if _, err := c.Do("zrange", append([]interface{}{key}, args...)...); err == nil || err.Error() != errn.ErrSyntax.Error() {
+			t.Fatal("zrange bad options", args, err)
+		}
+	}
+}
+
+func TestZUnionInterStore(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	k1, k2, dst := "{TestZStore}k1", "{TestZStore}k2", "{TestZStore}dst"
+	c.Do("del", k1, k2, dst)
+	defer c.Do("del", k1, k2, dst)
+	if _, err := c.Do("zadd", k1, 1, "a", 2, "b", 3, "c"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do("zadd", k2, 10, "b", 20, "c", 30, "d"); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(cmd string, want []string, args ...interface{}) {
+		n, err := redis.Int(c.Do(cmd, append([]interface{}{dst}, args...)...))
+		if err != nil || n != len(want)/2 {
+			t.Fatal(cmd, args, n, err)
+		}
+		got, err := redis.Strings(c.Do("zrange", dst, 0, -1, "withscores"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Fatal(cmd, args, got, want)
+		}
+	}
+
+	check("zunionstore", []string{"a", "1", "b", "12", "c", "23", "d", "30"}, 2, k1, k2)
+	check("zunionstore", []string{"a", "2", "b", "4", "c", "6", "d", "30"}, 2, k1, k2, "weights", 2, 1, "aggregate", "min")
+	check("zinterstore", []string{"b", "10", "c", "20"}, 2, k1, k2, "aggregate", "max")
+	check("zinterstore", []string{"b", "12", "c", "23"}, 2, k1, k2)
+
+	if n, err := redis.Int(c.Do("zinterstore", dst, 2, k1, "{TestZStore}none")); err != nil || n != 0 {
+		t.Fatal("zinterstore empty", n, err)
+	}
+	if n, err := redis.Int(c.Do("exists", dst)); err != nil || n != 0 {
+		t.Fatal("empty zinterstore should delete dst", n, err)
+	}
+
+	if _, err := c.Do("zunionstore", dst, 2, k1, "TestZStoreOther"); err == nil || err.Error() != errn.ErrCrossSlot.Error() {
+		t.Fatal("zunionstore cross slot", err)
+	}
+	if _, err := c.Do("zunionstore", dst, 2, k1, k2, "weights", 1); err == nil || err.Error() != errn.ErrSyntax.Error() {
+		t.Fatal("zunionstore weights count", err)
+	}
+	if _, err := c.Do("zunionstore", dst, "x", k1); err == nil || err.Error() != errn.ErrValue.Error() {
+		t.Fatal("zunionstore numkeys", err)
+	}
+}
+
+func TestZDiff(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	k1, k2, k3, dst := "{TestZDiff}k1", "{TestZDiff}k2", "{TestZDiff}k3", "{TestZDiff}dst"
+	c.Do("del", k1, k2, k3, dst)
+	defer c.Do("del", k1, k2, k3, dst)
+	if _, err := c.Do("zadd", k1, 1, "a", 2, "b", 3, "c", 4, "d"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do("zadd", k2, 10, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do("zadd", k3, 30, "d", 50, "e"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := redis.Strings(c.Do("zdiff", 3, k1, k2, k3)); err != nil || strings.Join(got, ",") != "a,c" {
+		t.Fatal("zdiff", got, err)
+	}
+	if got, err := redis.Strings(c.Do("zdiff", 2, k1, k2, "withscores")); err != nil || strings.Join(got, ",") != "a,1,c,3,d,4" {
+		t.Fatal("zdiff withscores", got, err)
+	}
+	if got, err := redis.Strings(c.Do("zdiff", 1, "{TestZDiff}none")); err != nil || len(got) != 0 {
+		t.Fatal("zdiff empty", got, err)
+	}
+
+	if n, err := redis.Int(c.Do("zdiffstore", dst, 3, k1, k2, k3)); err != nil || n != 2 {
+		t.Fatal("zdiffstore", n, err)
+	}
+	if got, err := redis.Strings(c.Do("zrange", dst, 0, -1, "withscores")); err != nil || strings.Join(got, ",") != "a,1,c,3" {
+		t.Fatal("zdiffstore result", got, err)
+	}
+	if n, err := redis.Int(c.Do("zdiffstore", dst, 2, k2, k1)); err != nil || n != 0 {
+		t.Fatal("zdiffstore empty", n, err)
+	}
+	if n, err := redis.Int(c.Do("exists", dst)); err != nil || n != 0 {
+		t.Fatal("empty zdiffstore should delete dst", n, err)
+	}
+
+	if _, err := c.Do("zdiff", 2, k1, "TestZDiffOther"); err == nil || err.Error() != errn.ErrCrossSlot.Error() {
+		t.Fatal("zdiff cross slot", err)
+	}
+	if _, err := c.Do("zdiffstore", dst, 2, k1, "TestZDiffOther"); err == nil || err.Error() != errn.ErrCrossSlot.Error() {
+		t.Fatal("zdiffstore cross slot", err)
+	}
+	if _, err := c.Do("zdiff", 2, k1, k2, "aggregate", "min"); err == nil || err.Error() != errn.ErrSyntax.Error() {
+		t.Fatal("zdiff aggregate", err)
+	}
+	if _, err := c.Do("zdiffstore", dst, 1, k1, "withscores"); err == nil || err.Error() != errn.ErrSyntax.Error() {
+		t.Fatal("zdiffstore withscores", err)
+	}
+}
+
+func TestZRandMember(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	key := "TestZRandMember"
+	c.Do("del", key)
+	defer c.Do("del", key)
+	if _, err := c.Do("zadd", key, 1, "a", 2, "b", 3, "c"); err != nil {
+		t.Fatal(err)
+	}
+	scores := map[string]string{"a": "1", "b": "2", "c": "3"}
+
+	if m, err := redis.String(c.Do("zrandmember", key)); err != nil || scores[m] == "" {
+		t.Fatal("zrandmember", m, err)
+	}
+	if m, err := c.Do("zrandmember", "TestZRandMemberNone"); err != nil || m != nil {
+		t.Fatal("zrandmember missing key", m, err)
+	}
+
+	if got, err := redis.Strings(c.Do("zrandmember", key, 2)); err != nil || len(got) != 2 || got[0] == got[1] {
+		t.Fatal("zrandmember positive", got, err)
+	}
+	if got, err := redis.Strings(c.Do("zrandmember", key, 10)); err != nil || len(got) != 3 {
+		t.Fatal("zrandmember over size", got, err)
+	}
+	got, err := redis.Strings(c.Do("zrandmember", key, -7, "withscores"))
+	if err != nil || len(got) != 14 {
+		t.Fatal("zrandmember negative", got, err)
+	}
+	for i := 0; i < len(got); i += 2 {
+		if scores[got[i]] != got[i+1] {
+			t.Fatal("zrandmember withscores", got)
+		}
+	}
+	if got, err := redis.Strings(c.Do("zrandmember", "TestZRandMemberNone", -3)); err != nil || len(got) != 0 {
+		t.Fatal("zrandmember missing key count", got, err)
+	}
+	if got, err := redis.Strings(c.Do("zrandmember", key, 0)); err != nil || len(got) != 0 {
+		t.Fatal("zrandmember zero", got, err)
+	}
+
+	if _, err := c.Do("zrandmember", key, "x"); err == nil || err.Error() != errn.ErrValue.Error() {
+		t.Fatal("zrandmember count", err)
+	}
+	if _, err := c.Do("zrandmember", key, -(1<<16 + 1), "withscores"); err == nil || err.Error() != errn.ErrValue.Error() {
+		t.Fatal("zrandmember count too large", err)
+	}
+	if _, err := c.Do("zrandmember", key, 1, "scores"); err == nil || err.Error() != errn.ErrSyntax.Error() {
+		t.Fatal("zrandmember option", err)
+	}
+}
+
+func TestZMPop(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	k1, k2 := "{TestZMPop}k1", "{TestZMPop}k2"
+	c.Do("del", k1, k2)
+	defer c.Do("del", k1, k2)
+	if _, err := c.Do("zadd", k2, 1, "a", 2, "b", 3, "c"); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(wantKey string, want []string, args ...interface{}) {
+		reply, err := redis.Values(c.Do("zmpop", args...))
+		if err != nil || len(reply) != 2 {
+			t.Fatal(args, reply, err)
+		}
+		key, err := redis.String(reply[0], nil)
+		if err != nil || key != wantKey {
+			t.Fatal(args, key, err)
+		}
+		got, err := redis.Strings(reply[1], nil)
+		if err != nil || strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Fatal(args, got, want, err)
+		}
+	}
+	check(k2, []string{"a", "1"}, 2, k1, k2, "min")
+	check(k2, []string{"c", "3", "b", "2"}, 2, k1, k2, "max", "count", 5)
+
+	if reply, err := c.Do("zmpop", 2, k1, k2, "min"); err != nil || reply != nil {
+		t.Fatal("zmpop empty", reply, err)
+	}
+	if n, err := redis.Int(c.Do("exists", k2)); err != nil || n != 0 {
+		t.Fatal("zmpop should delete emptied key", n, err)
+	}
+
+	if _, err := c.Do("zmpop", 2, k1, "TestZMPopOther", "min"); err == nil || err.Error() != errn.ErrCrossSlot.Error() {
+		t.Fatal("zmpop cross slot", err)
+	}
+	if _, err := c.Do("zmpop", 1, k1, "min", "count", 0); err == nil || err.Error() != errn.ErrValue.Error() {
+		t.Fatal("zmpop count", err)
+	}
+}
+
+func TestZInterCard(t *testing.T) {
+	c := getTestConn()
+	defer c.Close()
+
+	k1, k2 := "{TestZInterCard}k1", "{TestZInterCard}k2"
+	c.Do("del", k1, k2)
+	defer c.Do("del", k1, k2)
+	if _, err := c.Do("zadd", k1, 1, "a", 2, "b", 3, "c", 4, "d"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do("zadd", k2, 10, "b", 20, "c", 30, "d", 40, "e"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		args []interface{}
+		want int
+	}{
+		{[]interface{}{2, k1, k2}, 3},
+		{[]interface{}{2, k1, k2, "limit", 0}, 3},
+		{[]interface{}{2, k1, k2, "limit", 2}, 2},
+		{[]interface{}{1, k1}, 4},
+		{[]interface{}{2, k1, "{TestZInterCard}none"}, 0},
+	} {
+		if n, err := redis.Int(c.Do("zintercard", tt.args...)); err != nil || n != tt.want {
+			t.Fatal("zintercard", tt.args, n, err)
+		}
+	}
+
+	if _, err := c.Do("zintercard", 2, k1, "TestZInterCardOther"); err == nil || err.Error() != errn.ErrCrossSlot.Error() {
+		t.Fatal("zintercard cross slot", err)
+	}
+	if _, err := c.Do("zintercard", 1, k1, "limit", -1); err == nil || err.Error() != errn.ErrValue.Error() {
+		t.Fatal("zintercard limit", err)
+	}
+}
@@ -17,10 +17,10 @@ package server
 import (
 	"time"
 
-	"github.com/zuoyebang/bitalostored/butils/hash"
 	"github.com/zuoyebang/bitalostored/butils/unsafe2"
 	"github.com/zuoyebang/bitalostored/stored/internal/errn"
 	"github.com/zuoyebang/bitalostored/stored/internal/resp"
+	"github.com/zuoyebang/bitalostored/stored/internal/trycatch"
 	"github.com/zuoyebang/bitalostored/stored/internal/utils"
 )
 
@@ -47,6 +47,18 @@ func watchCommand(c *Client) error {
 		return errn.ErrTxNotInMaster
 	}
 
+	if c.server.txMaxWatchKeys > 0 {
+		newKeys := 0
+		for i := range args {
+			if _, ok := c.watchKeys[unsafe2.String(args[i])]; !ok {
+				newKeys++
+			}
+		}
+		if len(c.watchKeys)+newKeys > c.server.txMaxWatchKeys {
+			return errn.ErrTxWatchLimit
+		}
+	}
+
 	c.txState |= TxStateWatch
 
 	var khash uint32
@@ -54,7 +66,7 @@ func watchCommand(c *Client) error {
 		if i == 0 {
 			khash = c.KeyHash
 		} else {
-			khash = hash.Fnv32(args[i])
+			khash = utils.KeyHash(args[i])
 		}
 		c.addWatchKey(c.server.txLocks.GetTxLock(khash), args[i], c.QueryStartTime)
 	}
@@ -114,6 +126,9 @@ func prepareCommand(c *Client) error {
 	if c.txState&TxStatePrepare != 0 {
 		return errn.ErrPrepareNested
 	}
+	if c.txAborted {
+		return errn.ErrExecAbort
+	}
 
 	err := c.prepareSetLock()
 	if err != nil {
@@ -129,7 +144,7 @@ func prepareCommand(c *Client) error {
 
 func (c *Client) prepareSetLock() (err error) {
 	c.hasPrepareLock.Store(false)
-	c.prepareState.Store(PrepareStateNone)
+	c.setPrepareState(PrepareStateNone)
 
 	if len(c.commandQueue) == 0 && len(c.watchKeys) == 0 {
 		return nil
@@ -144,7 +159,7 @@ func (c *Client) prepareSetLock() (err error) {
 		}
 	}
 	if watchKeyModified {
-		c.prepareState.Store(PrepareStateKeyModified)
+		c.setPrepareState(PrepareStateKeyModified)
 		return errn.ErrWatchKeyChanged
 	}
 	if len(c.commandQueue) == 0 {
@@ -181,44 +196,48 @@ func (c *Client) prepareSetLock() (err error) {
 		return nil
 	}
 
-	prepareLockOk := true
 	watchLockers := make([]*TxWatchKey, 0, len(c.watchKeys)+len(updateKeys))
-	var releaseFunc, unlockFunc func()
-
-	unlockFunc = func() {
+	unlockFunc := func() {
 		for i := len(watchLockers) - 1; i >= 0; i-- {
 			watchLockers[i].mu.Unlock()
+			if updateKeys[watchLockers[i].key] {
+				txLocker := c.server.txLocks.GetTxLockByKey(unsafe2.ByteSlice(watchLockers[i].key))
+				txLocker.removeWatchKey(c, watchLockers[i].key)
+			}
 		}
-		time.Sleep(1 * time.Millisecond)
+		watchLockers = watchLockers[:0]
 	}
-
-	for try := 0; try < 3; try++ {
+	tryLockFunc := func() bool {
 		for keyStr := range c.watchKeys {
 			wk := c.server.txLocks.GetWatchKey(keyStr)
 			if !wk.mu.TryLock() {
-				prepareLockOk = false
-				break
+				return false
 			}
 			watchLockers = append(watchLockers, wk)
 		}
-		if !prepareLockOk {
-			unlockFunc()
-			continue
-		}
-
 		for keyStr := range updateKeys {
 			txLock := c.server.txLocks.GetTxLockByKey(unsafe2.ByteSlice(keyStr))
 			wk := txLock.addWatchKey(c, keyStr, false)
 			if !wk.mu.TryLock() {
-				prepareLockOk = false
-				break
+				txLock.removeWatchKey(c, keyStr)
+				return false
 			}
 			watchLockers = append(watchLockers, wk)
 		}
-		break
+		return true
+	}
+
+	deadline := time.Now().Add(c.server.txLockTimeout)
+	for !tryLockFunc() {
+		unlockFunc()
+		if !time.Now().Before(deadline) {
+			c.setPrepareState(PrepareStateLockFail)
+			return errn.ErrPrepareLockFail
+		}
+		time.Sleep(1 * time.Millisecond)
 	}
 
-	releaseFunc = func() {
+	releaseFunc := func() {
 		lockNum := len(watchLockers)
 		if lockNum <= 0 {
 			return
@@ -232,24 +251,19 @@ func (c *Client) prepareSetLock() (err error) {
 		}
 	}
 
-	if !prepareLockOk {
-		c.prepareState.Store(PrepareStateLockFail)
-		return errn.ErrPrepareLockFail
-	}
-
 	c.hasPrepareLock.Store(true)
-	c.prepareState.Store(PrepareStateLocked)
+	c.setPrepareState(PrepareStateLocked)
 
 	c.server.txPrepareWg.Add(1)
 	go func() {
 		defer c.server.txPrepareWg.Done()
 		select {
 		case <-time.After(3 * time.Second):
-			c.prepareState.Store(PrepareStateUnlock)
+			c.setPrepareState(PrepareStateUnlock)
 			releaseFunc()
 			c.resetTx()
 		case <-c.prepareUnlockSig:
-			c.prepareState.Store(PrepareStateUnlock)
+			c.setPrepareState(PrepareStateUnlock)
 			<-c.queueCommandDone
 			releaseFunc()
 
@@ -267,6 +281,10 @@ func execCommand(c *Client) (cerr error) {
 	if len(c.Args) != 0 {
 		return errn.CmdParamsErr(resp.EXEC)
 	}
+	if c.txAborted {
+		c.discard()
+		return errn.ErrExecAbort
+	}
 	if c.txState&TxStatePrepare == 0 {
 		return errn.ErrExecNotPrepared
 	}
@@ -309,13 +327,29 @@ func execCommand(c *Client) (cerr error) {
 	c.disableCommandQueued()
 	c.Writer.SetCached()
 	for _, command := range c.commandQueue {
-		c.HandleRequest(command, false)
+		c.execQueuedCommand(command)
 	}
 	c.Writer.UnsetCached()
 	c.Writer.FlushCached()
 	return nil
 }
 
+func (c *Client) execQueuedCommand(command [][]byte) {
+	n := len(c.Writer.Resps)
+	defer func() {
+		if r := recover(); r != nil {
+			trycatch.Panic("exec queued command", r)
+			c.Writer.Resps = c.Writer.Resps[:n]
+			c.Writer.WriteError(errn.ErrTxExecPanic)
+		}
+	}()
+
+	c.HandleRequest(command, false)
+	if len(c.Writer.Resps) == n {
+		c.Writer.WriteBulk(nil)
+	}
+}
+
 func discardCommand(c *Client) error {
 	if !c.server.openDistributedTx {
 		return errn.ErrTxDisable
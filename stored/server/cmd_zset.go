@@ -48,31 +48,220 @@ func init() {
 		resp.ZCOUNT:           {Sync: resp.IsWriteCmd(resp.ZCOUNT), Handler: zcountCommand},
 		resp.ZCARD:            {Sync: resp.IsWriteCmd(resp.ZCARD), Handler: zcardCommand},
 		resp.ZCLEAR:           {Sync: resp.IsWriteCmd(resp.ZCLEAR), Handler: zclearCommand, KeySkip: 1},
+		resp.ZUNLINK:          {Sync: resp.IsWriteCmd(resp.ZUNLINK), Handler: zunlinkCommand, KeySkip: 1},
 		resp.ZKEYEXISTS:       {Sync: resp.IsWriteCmd(resp.ZKEYEXISTS), Handler: zkeyexistsCommand},
 		resp.ZEXPIRE:          {Sync: resp.IsWriteCmd(resp.ZEXPIRE), Handler: zexpireCommand},
 		resp.ZEXPIREAT:        {Sync: resp.IsWriteCmd(resp.ZEXPIREAT), Handler: zexpireAtCommand},
 		resp.ZTTL:             {Sync: resp.IsWriteCmd(resp.ZTTL), Handler: zttlCommand},
 		resp.ZPERSIST:         {Sync: resp.IsWriteCmd(resp.ZPERSIST), Handler: zpersistCommand},
+		resp.ZUNION:           {Sync: resp.IsWriteCmd(resp.ZUNION), Handler: zunionCommand},
+		resp.ZINTER:           {Sync: resp.IsWriteCmd(resp.ZINTER), Handler: zinterCommand},
+		resp.ZRANGESTORE:      {Sync: resp.IsWriteCmd(resp.ZRANGESTORE), Handler: zrangestoreCommand},
+		resp.ZUNIONSTORE:      {Sync: resp.IsWriteCmd(resp.ZUNIONSTORE), Handler: zunionstoreCommand},
+		resp.ZINTERSTORE:      {Sync: resp.IsWriteCmd(resp.ZINTERSTORE), Handler: zinterstoreCommand},
+		resp.ZDIFF:            {Sync: resp.IsWriteCmd(resp.ZDIFF), Handler: zdiffCommand},
+		resp.ZDIFFSTORE:       {Sync: resp.IsWriteCmd(resp.ZDIFFSTORE), Handler: zdiffstoreCommand},
+		resp.ZRANDMEMBER:      {Sync: resp.IsWriteCmd(resp.ZRANDMEMBER), Handler: zrandmemberCommand},
+		resp.ZMPOP:            {Sync: resp.IsWriteCmd(resp.ZMPOP), Handler: zmpopCommand},
+		resp.ZINTERCARD:       {Sync: resp.IsWriteCmd(resp.ZINTERCARD), Handler: zintercardCommand},
 	})
 }
 
+const (
+	zaddOptNX   = "NX"
+	zaddOptXX   = "XX"
+	zaddOptGT   = "GT"
+	zaddOptLT   = "LT"
+	zaddOptCH   = "CH"
+	zaddOptIncr = "INCR"
+	// zaddOptRefreshTTL carries zset_refresh_ttl inside ZADD and ZINCRBY, the
+	// node taking the request stamps it so every replica and a log replay
+	// slide the ttl by the same value.
+	zaddOptRefreshTTL = "REFRESHTTL"
+)
+
+// zparseAddOptions parses the flags before the score member pairs of ZADD and
+// returns the count of args they take.
+func zparseAddOptions(args [][]byte) (opts btools.ZAddOptions, incr bool, n int, err error) {
+flags:
+	for ; n < len(args); n++ {
+		switch strings.ToUpper(unsafe2.String(args[n])) {
+		case zaddOptNX:
+			opts.NX = true
+		case zaddOptXX:
+			opts.XX = true
+		case zaddOptGT:
+			opts.GT = true
+		case zaddOptLT:
+			opts.LT = true
+		case zaddOptCH:
+			opts.CH = true
+		case zaddOptIncr:
+			incr = true
+		case zaddOptRefreshTTL:
+			if opts.RefreshTTL, err = zparseRefreshTTL(args, n+1); err != nil {
+				return opts, incr, n, err
+			}
+			n++
+		default:
+			break flags
+		}
+	}
+	if (opts.NX && opts.XX) || (opts.GT && opts.LT) || (opts.NX && (opts.GT || opts.LT)) {
+		return opts, incr, n, errn.ErrSyntax
+	}
+	return opts, incr, n, nil
+}
+
 func zaddCommand(c *Client) error {
 	args := c.Args
 	if len(args) < 3 {
 		return errn.CmdParamsErr(resp.ZADD)
 	}
 
-	if len(args[1:])&1 != 0 {
+	key := args[0]
+	opts, incr, n, err := zparseAddOptions(args[1:])
+	if err != nil {
+		return err
+	}
+	pairs := args[1+n:]
+	if len(pairs) == 0 || len(pairs)&1 != 0 {
 		return errn.CmdParamsErr(resp.ZADD)
 	}
+	if incr {
+		return zaddIncr(c, key, opts, pairs)
+	}
 
-	key := args[0]
-	args = args[1:]
+	params := c.getScorePairs(len(pairs) >> 1)
+	defer c.putScorePairs(params)
+	if err = zparseScorePairsTo(params, pairs); err != nil {
+		return err
+	}
+
+	opts.Epsilon = c.server.zaddChEpsilon
+	n64, err := c.DB.ZAdd(key, c.KeyHash, opts, params...)
+	if err == nil {
+		c.Writer.WriteInteger(n64)
+	}
+
+	return err
+}
+
+// zaddIncr replies the new score like ZINCRBY, or nil when opts skip the update.
+func zaddIncr(c *Client, key []byte, opts btools.ZAddOptions, pairs [][]byte) error {
+	if len(pairs) != 2 {
+		return errn.ErrZAddIncrPair
+	}
+	var params [1]btools.ScorePair
+	if err := zparseScorePairsTo(params[:], pairs); err != nil {
+		return err
+	}
+
+	v, ok, err := c.DB.ZAddIncr(key, c.KeyHash, opts, params[0].Score, params[0].Member)
+	if err != nil {
+		return err
+	}
+
+	if ok {
+		c.Writer.WriteDouble(v)
+	} else {
+		c.Writer.WriteBulk(nil)
+	}
+	return nil
+}
+
+// zstampRefreshTTL appends zset_refresh_ttl of this node to a ZADD or ZINCRBY
+// before it is proposed, the ttl is then refreshed in the same write as the
+// members instead of being looked up on each replica.
+func zstampRefreshTTL(c *Client) {
+	ttl := c.server.zsetRefreshTTL.Load()
+	if ttl <= 0 || len(c.Args) < 1 {
+		return
+	}
+
+	flag := [][]byte{[]byte(zaddOptRefreshTTL), strconv.AppendInt(nil, ttl, 10)}
+	var data [][]byte
+	switch c.Cmd {
+	case resp.ZADD:
+		data = make([][]byte, 0, len(c.Data)+len(flag))
+		data = append(data, c.Data[:2]...)
+		data = append(data, flag...)
+		data = append(data, c.Data[2:]...)
+	case resp.ZINCRBY:
+		data = make([][]byte, 0, len(c.Data)+len(flag))
+		data = append(data, c.Data...)
+		data = append(data, flag...)
+	default:
+		return
+	}
+	c.Data = data
+	c.Args = data[1:]
+}
+
+func zparseRefreshTTL(args [][]byte, i int) (int64, error) {
+	if i >= len(args) {
+		return 0, errn.ErrSyntax
+	}
+	ttl, err := strconv.ParseInt(unsafe2.String(args[i]), 10, 64)
+	if err != nil || ttl < 0 {
+		return 0, errn.ErrValue
+	}
+	return ttl, nil
+}
+
+// zreplyElems is the count of reply elements taken by each member.
+func zreplyElems(withScores bool) int {
+	if withScores {
+		return 2
+	}
+	return 1
+}
+
+// zreplyRankRange narrows the ranks start..stop to the items replyReadCount
+// lets through, a negative rank is resolved against the set size first.
+func zreplyRankRange(c *Client, key []byte, khash uint32, start, stop int64, elems int) (int64, int64, error) {
+	max := c.replyReadCount(-1, elems)
+	if max < 0 {
+		return start, stop, nil
+	}
+	if start < 0 || stop < 0 {
+		card, err := c.DB.ZCard(key, khash)
+		if err != nil {
+			return 0, 0, err
+		}
+		if start < 0 {
+			if start += card; start < 0 {
+				start = 0
+			}
+		}
+		if stop < 0 {
+			stop += card
+		}
+	}
+	if stop-start >= int64(max) {
+		stop = start + int64(max) - 1
+	}
+	return start, stop, nil
+}
 
+func zscorePairsReplyLen(datas []btools.ScorePair, withScores bool) int {
+	if withScores {
+		return len(datas) * 2
+	}
+	return len(datas)
+}
+
+func zparseScorePairs(args [][]byte) ([]btools.ScorePair, error) {
 	params := make([]btools.ScorePair, len(args)>>1)
-	for i := 0; i < len(params); i++ {
+	if err := zparseScorePairsTo(params, args); err != nil {
+		return nil, err
+	}
+	return params, nil
+}
 
-		score, err := extend.ParseFloat64(unsafe2.String(args[2*i]))
+func zparseScorePairsTo(params []btools.ScorePair, args [][]byte) error {
+	for i := 0; i < len(params); i++ {
+		score, err := extend.ParseStrictFloat64(unsafe2.String(args[2*i]))
 		if err != nil || score < float64(math.MinInt64) || score > float64(math.MaxInt64) {
 			return errn.ErrValue
 		}
@@ -80,33 +269,34 @@ func zaddCommand(c *Client) error {
 		params[i].Score = score
 		params[i].Member = args[2*i+1]
 	}
-
-	n, err := c.DB.ZAdd(key, c.KeyHash, params...)
-
-	if err == nil {
-		c.Writer.WriteInteger(n)
-	}
-
-	return err
+	return nil
 }
 
 func zincrbyCommand(c *Client) error {
 	args := c.Args
-	if len(args) != 3 {
+	var opts btools.ZAddOptions
+	switch {
+	case len(args) == 3:
+	case len(args) == 5 && strings.EqualFold(unsafe2.String(args[3]), zaddOptRefreshTTL):
+		ttl, err := zparseRefreshTTL(args, 4)
+		if err != nil {
+			return err
+		}
+		opts.RefreshTTL = ttl
+	default:
 		return errn.CmdParamsErr(resp.ZINCRBY)
 	}
 
-	delta, err := extend.ParseFloat64(unsafe2.String(args[1]))
+	delta, err := extend.ParseStrictFloat64(unsafe2.String(args[1]))
 	if err != nil {
 		return errn.ErrValue
 	}
 
 	key := args[0]
 
-	v, err := c.DB.ZIncrBy(key, c.KeyHash, delta, args[2])
-
+	v, _, err := c.DB.ZAddIncr(key, c.KeyHash, opts, delta, args[2])
 	if err == nil {
-		c.Writer.WriteBulk(extend.FormatFloat64ToSlice(v))
+		c.Writer.WriteDouble(v)
 	}
 
 	return err
@@ -231,8 +421,13 @@ func zrangeGeneric(c *Client, reverse bool, cmd string) error {
 		}
 	}
 
+	if start, stop, err = zreplyRankRange(c, key, c.KeyHash, start, stop, zreplyElems(withScores)); err != nil {
+		return err
+	}
 	if datas, err := c.DB.ZRangeGeneric(key, c.KeyHash, start, stop, reverse); err != nil {
 		return err
+	} else if err = c.checkReplyLen(zscorePairsReplyLen(datas, withScores)); err != nil {
+		return err
 	} else {
 		c.Writer.WriteScorePairArray(datas, withScores)
 	}
@@ -240,7 +435,39 @@ func zrangeGeneric(c *Client, reverse bool, cmd string) error {
 }
 
 func zrangeCommand(c *Client) error {
-	return zrangeGeneric(c, false, resp.ZRANGE)
+	args := c.Args
+	if len(args) < 3 {
+		return errn.CmdParamsErr(resp.ZRANGE)
+	}
+	if len(args) == 3 || (len(args) == 4 && strings.ToLower(unsafe2.String(args[3])) == "withscores") {
+		return zrangeGeneric(c, false, resp.ZRANGE)
+	}
+
+	opt, err := zparseRangeOptions(args[3:], true)
+	if err != nil {
+		return err
+	}
+	opt.reply = true
+	datas, err := zrangeByOpt(c, args[0], c.KeyHash, args[1], args[2], opt)
+	if err != nil {
+		return err
+	}
+	if opt.by == zrangeByLex {
+		if err = c.checkReplyLen(len(datas)); err != nil {
+			return err
+		}
+		members := make([][]byte, len(datas))
+		for i := range datas {
+			members[i] = datas[i].Member
+		}
+		c.Writer.WriteSliceArray(members)
+		return nil
+	}
+	if err = c.checkReplyLen(zscorePairsReplyLen(datas, opt.withScores)); err != nil {
+		return err
+	}
+	c.Writer.WriteScorePairArray(datas, opt.withScores)
+	return nil
 }
 
 func zrevrangeCommand(c *Client) error {
@@ -281,9 +508,12 @@ func zrangebylexCommand(c *Client) error {
 	}
 
 	key := args[0]
+	count = c.replyReadCount(count, 1)
 
 	if ay, err := c.DB.ZRangeByLex(key, c.KeyHash, min, max, leftClose, rightClose, offset, count); err != nil {
 		return err
+	} else if err = c.checkReplyLen(len(ay)); err != nil {
+		return err
 	} else {
 		c.Writer.WriteSliceArray(ay)
 	}
@@ -356,8 +586,15 @@ func zrangebyscoreGeneric(c *Client, reverse bool) error {
 		return nil
 	}
 
+	count = c.replyReadCount(count, zreplyElems(withScores))
+	if !reverse {
+		return zrangebyscoreStream(c, key, min, max, leftClose, rightClose, offset, count, withScores)
+	}
+
 	if datas, err := c.DB.ZRangeByScoreGeneric(key, c.KeyHash, min, max, leftClose, rightClose, offset, count, reverse); err != nil {
 		return err
+	} else if err = c.checkReplyLen(zscorePairsReplyLen(datas, withScores)); err != nil {
+		return err
 	} else {
 		c.Writer.WriteScorePairArray(datas, withScores)
 	}
@@ -365,6 +602,24 @@ func zrangebyscoreGeneric(c *Client, reverse bool) error {
 	return nil
 }
 
+// zrangebyscoreStream encodes the members straight from the index iterator
+// into the reply instead of collecting them as ScorePairs first.
+func zrangebyscoreStream(
+	c *Client, key []byte, min, max float64, leftClose, rightClose bool, offset, count int, withScores bool,
+) error {
+	stream := c.Writer.ScorePairStream(withScores)
+	if err := c.DB.ZRangeByScoreFunc(key, c.KeyHash, min, max, leftClose, rightClose, offset, count, stream.Add); err != nil {
+		stream.Discard()
+		return err
+	}
+	if err := c.checkReplyLen(stream.Len()); err != nil {
+		stream.Discard()
+		return err
+	}
+	stream.Flush()
+	return nil
+}
+
 func zrangebyscoreCommand(c *Client) error {
 	return zrangebyscoreGeneric(c, false)
 }
@@ -427,7 +682,7 @@ func zscoreCommand(c *Client) error {
 			return err
 		}
 	} else {
-		c.Writer.WriteBulk(extend.FormatFloat64ToSlice(s))
+		c.Writer.WriteDouble(s)
 	}
 
 	return nil
@@ -455,24 +710,40 @@ func zlexcountCommand(c *Client) error {
 	return nil
 }
 
+const zcountOptApprox = "APPROX"
+
+// zcountCommand replies an estimate from ZCountApprox when the APPROX option
+// is given.
 func zcountCommand(c *Client) error {
 	args := c.Args
-	if len(args) != 3 {
+	if len(args) != 3 && len(args) != 4 {
 		return errn.CmdParamsErr(resp.ZCOUNT)
 	}
 
+	approx := false
+	if len(args) == 4 {
+		if strings.ToUpper(unsafe2.String(args[3])) != zcountOptApprox {
+			return errn.ErrSyntax
+		}
+		approx = true
+	}
+
 	min, max, leftClose, rightClose, err := zparseScoreRange(args[1], args[2])
 
 	if err != nil {
 		return errn.ErrValue
 	}
 
-	if min > max {
+	if min > max || (min == max && (leftClose || rightClose)) {
 		c.Writer.WriteInteger(0)
 		return nil
 	}
 
-	if n, err := c.DB.ZCount(args[0], c.KeyHash, min, max, leftClose, rightClose); err != nil {
+	zcount := c.DB.ZCount
+	if approx {
+		zcount = c.DB.ZCountApprox
+	}
+	if n, err := zcount(args[0], c.KeyHash, min, max, leftClose, rightClose); err != nil {
 		return err
 	} else {
 		c.Writer.WriteInteger(n)
@@ -525,6 +796,21 @@ func zclearCommand(c *Client) error {
 	return err
 }
 
+// zunlinkCommand is the redis style name of ZCLEAR. Both free lazily: only
+// the meta key is tombstoned inline, members are removed by the expire scan.
+func zunlinkCommand(c *Client) error {
+	args := c.Args
+	if len(args) < 1 {
+		return errn.CmdParamsErr(resp.ZUNLINK)
+	}
+
+	n, err := c.DB.ZClear(c.KeyHash, args...)
+	if err == nil {
+		c.Writer.WriteInteger(n)
+	}
+	return err
+}
+
 func zexpireCommand(c *Client) error {
 	args := c.Args
 	if len(args) != 2 {
@@ -648,7 +934,7 @@ func zparseScoreRange(minBuf []byte, maxBuf []byte) (minFloat64 float64, maxFloa
 			minBuf = minBuf[1:]
 		}
 
-		minFloat64, err = extend.ParseFloat64(unsafe2.String(minBuf))
+		minFloat64, err = extend.ParseStrictFloat64(unsafe2.String(minBuf))
 		if err != nil {
 			return 0, 0, leftClose, rightClose, errn.ErrZSetScoreRange
 		}
@@ -665,10 +951,539 @@ func zparseScoreRange(minBuf []byte, maxBuf []byte) (minFloat64 float64, maxFloa
 			maxBuf = maxBuf[1:]
 		}
 
-		maxFloat64, err = extend.ParseFloat64(unsafe2.String(maxBuf))
+		maxFloat64, err = extend.ParseStrictFloat64(unsafe2.String(maxBuf))
 		if err != nil {
 			return minFloat64, maxFloat64, leftClose, rightClose, errn.ErrZSetScoreRange
 		}
 	}
 	return
 }
+
+const (
+	zsetAggregateSum = iota
+	zsetAggregateMin
+	zsetAggregateMax
+)
+
+type zsetOpt struct {
+	keys       [][]byte
+	weights    []float64
+	aggregate  int
+	withScores bool
+}
+
+// zparseNumKeys splits "numkeys key [key ...] ..." into the keys and the
+// arguments following them.
+func zparseNumKeys(args [][]byte, cmd string) (keys, rest [][]byte, err error) {
+	if len(args) < 2 {
+		return nil, nil, errn.CmdParamsErr(cmd)
+	}
+
+	numKeys, err := strconv.Atoi(unsafe2.String(args[0]))
+	if err != nil {
+		return nil, nil, errn.ErrValue
+	}
+	if numKeys <= 0 {
+		return nil, nil, errn.ErrSyntax
+	}
+	if len(args) < numKeys+1 {
+		return nil, nil, errn.ErrSyntax
+	}
+	return args[1 : numKeys+1], args[numKeys+1:], nil
+}
+
+func zparseZsetopt(args [][]byte, cmd string, allowWithScores bool) (*zsetOpt, error) {
+	keys, args, err := zparseNumKeys(args, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	numKeys := len(keys)
+	opt := &zsetOpt{
+		keys:      keys,
+		aggregate: zsetAggregateSum,
+	}
+	for i := 0; i < len(args); i++ {
+		switch strings.ToLower(unsafe2.String(args[i])) {
+		case "weights":
+			if opt.weights != nil || len(args)-i-1 < numKeys {
+				return nil, errn.ErrSyntax
+			}
+			opt.weights = make([]float64, numKeys)
+			for j := 0; j < numKeys; j++ {
+				i++
+				if opt.weights[j], err = extend.ParseStrictFloat64(unsafe2.String(args[i])); err != nil {
+					return nil, errn.ErrValue
+				}
+			}
+		case "aggregate":
+			if i+1 >= len(args) {
+				return nil, errn.ErrSyntax
+			}
+			i++
+			switch strings.ToLower(unsafe2.String(args[i])) {
+			case "sum":
+				opt.aggregate = zsetAggregateSum
+			case "min":
+				opt.aggregate = zsetAggregateMin
+			case "max":
+				opt.aggregate = zsetAggregateMax
+			default:
+				return nil, errn.ErrSyntax
+			}
+		case "withscores":
+			if !allowWithScores {
+				return nil, errn.ErrSyntax
+			}
+			opt.withScores = true
+		default:
+			return nil, errn.ErrSyntax
+		}
+	}
+	return opt, nil
+}
+
+func zaggregateScore(aggregate int, a, b float64) float64 {
+	switch aggregate {
+	case zsetAggregateMin:
+		return math.Min(a, b)
+	case zsetAggregateMax:
+		return math.Max(a, b)
+	default:
+		if s := a + b; !math.IsNaN(s) {
+			return s
+		}
+		return 0
+	}
+}
+
+func (opt *zsetOpt) aggregateFunc() func(a, b float64) float64 {
+	return func(a, b float64) float64 {
+		return zaggregateScore(opt.aggregate, a, b)
+	}
+}
+
+// zsetAggregate combines the source sets of opt, limit >= 0 lets a union stop
+// once it has more members than that. All keys must share the slot of the
+// first one.
+func zsetAggregate(c *Client, opt *zsetOpt, union bool, limit int) ([]btools.ScorePair, error) {
+	khashes, err := zsameSlotKeyHashes(opt.keys[0], opt.keys, c.keyHash)
+	if err != nil {
+		return nil, err
+	}
+	return c.DB.ZCombine(opt.keys, khashes, opt.weights, opt.aggregateFunc(), union, limit)
+}
+
+func zsetOpGeneric(c *Client, cmd string, union bool) error {
+	opt, err := zparseZsetopt(c.Args, cmd, true)
+	if err != nil {
+		return err
+	}
+
+	limit := c.replyReadCount(-1, zreplyElems(opt.withScores))
+	if limit > 0 {
+		limit--
+	}
+	res, err := zsetAggregate(c, opt, union, limit)
+	if err != nil {
+		return err
+	}
+	if err = c.checkReplyLen(zscorePairsReplyLen(res, opt.withScores)); err != nil {
+		return err
+	}
+
+	c.Writer.WriteScorePairArray(res, opt.withScores)
+	return nil
+}
+
+func zunionCommand(c *Client) error {
+	return zsetOpGeneric(c, resp.ZUNION, true)
+}
+
+func zinterCommand(c *Client) error {
+	return zsetOpGeneric(c, resp.ZINTER, false)
+}
+
+const (
+	zrangeByRank = iota
+	zrangeByScore
+	zrangeByLex
+)
+
+type zrangeOpt struct {
+	by         int
+	rev        bool
+	offset     int
+	count      int
+	withScores bool
+	// reply bounds the read to max-reply-elements, the result is replied
+	// instead of stored.
+	reply bool
+}
+
+// zparseRangeOptions parses the BYSCORE, BYLEX, REV, LIMIT and WITHSCORES
+// flags that follow the min and max of the unified ZRANGE form.
+func zparseRangeOptions(args [][]byte, allowWithScores bool) (opt zrangeOpt, err error) {
+	opt.count = -1
+	limit := false
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(unsafe2.String(args[i])) {
+		case "BYSCORE":
+			if opt.by == zrangeByLex {
+				return opt, errn.ErrSyntax
+			}
+			opt.by = zrangeByScore
+		case "BYLEX":
+			if opt.by == zrangeByScore {
+				return opt, errn.ErrSyntax
+			}
+			opt.by = zrangeByLex
+		case "REV":
+			opt.rev = true
+		case "LIMIT":
+			if i+2 >= len(args) {
+				return opt, errn.ErrSyntax
+			}
+			if opt.offset, err = strconv.Atoi(unsafe2.String(args[i+1])); err != nil {
+				return opt, errn.ErrValue
+			}
+			if opt.count, err = strconv.Atoi(unsafe2.String(args[i+2])); err != nil {
+				return opt, errn.ErrValue
+			}
+			limit = true
+			i += 2
+		case "WITHSCORES":
+			if !allowWithScores {
+				return opt, errn.ErrSyntax
+			}
+			opt.withScores = true
+		default:
+			return opt, errn.ErrSyntax
+		}
+	}
+	if limit && opt.by == zrangeByRank {
+		return opt, errn.ErrSyntax
+	}
+	if opt.withScores && opt.by == zrangeByLex {
+		return opt, errn.ErrSyntax
+	}
+	return opt, nil
+}
+
+func zrangeByOpt(c *Client, key []byte, khash uint32, start, stop []byte, opt zrangeOpt) ([]btools.ScorePair, error) {
+	if opt.offset < 0 || opt.count == 0 {
+		return nil, nil
+	}
+	elems := zreplyElems(opt.withScores && opt.by != zrangeByLex)
+	if opt.reply && opt.by != zrangeByRank {
+		opt.count = c.replyReadCount(opt.count, elems)
+	}
+
+	switch opt.by {
+	case zrangeByScore:
+		minScore, maxScore := start, stop
+		if opt.rev {
+			minScore, maxScore = stop, start
+		}
+		min, max, leftClose, rightClose, err := zparseScoreRange(minScore, maxScore)
+		if err != nil {
+			return nil, err
+		}
+		return c.DB.ZRangeByScoreGeneric(key, khash, min, max, leftClose, rightClose, opt.offset, opt.count, opt.rev)
+	case zrangeByLex:
+		minLex, maxLex := start, stop
+		if opt.rev {
+			minLex, maxLex = stop, start
+		}
+		min, max, leftClose, rightClose, err := zparseLexMemberRange(minLex, maxLex)
+		if err != nil {
+			return nil, err
+		}
+		return c.DB.ZRangeByLexGeneric(key, khash, min, max, leftClose, rightClose, opt.offset, opt.count, opt.rev)
+	default:
+		rstart, rstop, err := zparseRange(start, stop)
+		if err != nil {
+			return nil, errn.ErrValue
+		}
+		if opt.reply {
+			if rstart, rstop, err = zreplyRankRange(c, key, khash, rstart, rstop, elems); err != nil {
+				return nil, err
+			}
+		}
+		return c.DB.ZRangeGeneric(key, khash, rstart, rstop, opt.rev)
+	}
+}
+
+func zrangestoreCommand(c *Client) error {
+	args := c.Args
+	if len(args) < 4 {
+		return errn.CmdParamsErr(resp.ZRANGESTORE)
+	}
+
+	opt, err := zparseRangeOptions(args[4:], false)
+	if err != nil {
+		return err
+	}
+	// the stored members keep their scores, BYLEX included
+	opt.withScores = true
+
+	dst, src := args[0], args[1]
+	khashes, err := zsameSlotKeyHashes(dst, [][]byte{src}, c.keyHash)
+	if err != nil {
+		return err
+	}
+	datas, err := zrangeByOpt(c, src, khashes[0], args[2], args[3], opt)
+	if err != nil {
+		return err
+	}
+
+	n, err := c.DB.ZRangeStore(dst, c.KeyHash, datas...)
+	if err != nil {
+		return err
+	}
+	c.Writer.WriteInteger(n)
+	return nil
+}
+
+// zsetOpStoreGeneric writes the union or intersection into dst. All keys must
+// share the hash tag of dst so they live in one slot, otherwise the command
+// fails with a cross slot error.
+func zsetOpStoreGeneric(c *Client, cmd string, union bool) error {
+	args := c.Args
+	if len(args) < 3 {
+		return errn.CmdParamsErr(cmd)
+	}
+
+	opt, err := zparseZsetopt(args[1:], cmd, false)
+	if err != nil {
+		return err
+	}
+
+	dst := args[0]
+	khashes, err := zsameSlotKeyHashes(dst, opt.keys, c.keyHash)
+	if err != nil {
+		return err
+	}
+
+	var n int64
+	if union {
+		n, err = c.DB.ZUnionStore(dst, c.KeyHash, opt.keys, khashes, opt.weights, opt.aggregateFunc())
+	} else {
+		n, err = c.DB.ZInterStore(dst, c.KeyHash, opt.keys, khashes, opt.weights, opt.aggregateFunc())
+	}
+	if err != nil {
+		return err
+	}
+	c.Writer.WriteInteger(n)
+	return nil
+}
+
+func zunionstoreCommand(c *Client) error {
+	return zsetOpStoreGeneric(c, resp.ZUNIONSTORE, true)
+}
+
+func zinterstoreCommand(c *Client) error {
+	return zsetOpStoreGeneric(c, resp.ZINTERSTORE, false)
+}
+
+// zsameSlotKeyHashes checks that keys share the hash tag of ref and hashes each of
+// them with hashFn.
+func zsameSlotKeyHashes(ref []byte, keys [][]byte, hashFn func([]byte) uint32) ([]uint32, error) {
+	slot := utils.GetHashTagKeyHash(ref)
+	khashes := make([]uint32, len(keys))
+	for i, key := range keys {
+		if utils.GetHashTagKeyHash(key) != slot {
+			return nil, errn.ErrCrossSlot
+		}
+		khashes[i] = hashFn(key)
+	}
+	return khashes, nil
+}
+
+func zdiffCommand(c *Client) error {
+	keys, rest, err := zparseNumKeys(c.Args, resp.ZDIFF)
+	if err != nil {
+		return err
+	}
+	withScores := false
+	if len(rest) > 0 {
+		if len(rest) > 1 || strings.ToLower(unsafe2.String(rest[0])) != "withscores" {
+			return errn.ErrSyntax
+		}
+		withScores = true
+	}
+
+	khashes, err := zsameSlotKeyHashes(keys[0], keys, c.keyHash)
+	if err != nil {
+		return err
+	}
+	res, err := c.DB.ZDiff(keys, khashes)
+	if err != nil {
+		return err
+	}
+	if err = c.checkReplyLen(zscorePairsReplyLen(res, withScores)); err != nil {
+		return err
+	}
+
+	c.Writer.WriteScorePairArray(res, withScores)
+	return nil
+}
+
+func zdiffstoreCommand(c *Client) error {
+	args := c.Args
+	if len(args) < 3 {
+		return errn.CmdParamsErr(resp.ZDIFFSTORE)
+	}
+
+	keys, rest, err := zparseNumKeys(args[1:], resp.ZDIFFSTORE)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return errn.ErrSyntax
+	}
+
+	dst := args[0]
+	khashes, err := zsameSlotKeyHashes(dst, keys, c.keyHash)
+	if err != nil {
+		return err
+	}
+	n, err := c.DB.ZDiffStore(dst, c.KeyHash, keys, khashes)
+	if err != nil {
+		return err
+	}
+	c.Writer.WriteInteger(n)
+	return nil
+}
+
+// zrandmemberMaxCount bounds abs(count) of ZRANDMEMBER. A negative count is
+// answered in full even past the set size, so it alone sizes the reply.
+const zrandmemberMaxCount = 1 << 16
+
+func zrandmemberCommand(c *Client) error {
+	args := c.Args
+	if len(args) < 1 || len(args) > 3 {
+		return errn.CmdParamsErr(resp.ZRANDMEMBER)
+	}
+
+	if len(args) == 1 {
+		res, err := c.DB.ZRandMember(args[0], c.KeyHash, 1)
+		if err != nil {
+			return err
+		}
+		if len(res) == 0 {
+			c.Writer.WriteBulk(nil)
+		} else {
+			c.Writer.WriteBulk(res[0].Member)
+		}
+		return nil
+	}
+
+	count, err := strconv.ParseInt(unsafe2.String(args[1]), 10, 64)
+	if err != nil || count < -zrandmemberMaxCount || count > zrandmemberMaxCount {
+		return errn.ErrValue
+	}
+	withScores := false
+	if len(args) == 3 {
+		if strings.ToLower(unsafe2.String(args[2])) != "withscores" {
+			return errn.ErrSyntax
+		}
+		withScores = true
+	}
+	if count < 0 {
+		n := int(-count)
+		if withScores {
+			n *= 2
+		}
+		if err = c.checkReplyLen(n); err != nil {
+			return err
+		}
+	}
+
+	res, err := c.DB.ZRandMember(args[0], c.KeyHash, count)
+	if err != nil {
+		return err
+	}
+	if err = c.checkReplyLen(zscorePairsReplyLen(res, withScores)); err != nil {
+		return err
+	}
+	c.Writer.WriteScorePairArray(res, withScores)
+	return nil
+}
+
+// zmpopCommand pops from the first non-empty key and replies
+// [key, [member score ...]], or a nil array when all keys are empty.
+func zmpopCommand(c *Client) error {
+	keys, rest, err := zparseNumKeys(c.Args, resp.ZMPOP)
+	if err != nil {
+		return err
+	}
+	if len(rest) == 0 {
+		return errn.ErrSyntax
+	}
+
+	var max bool
+	switch strings.ToLower(unsafe2.String(rest[0])) {
+	case "min":
+	case "max":
+		max = true
+	default:
+		return errn.ErrSyntax
+	}
+	count := int64(1)
+	if rest = rest[1:]; len(rest) > 0 {
+		if len(rest) != 2 || strings.ToLower(unsafe2.String(rest[0])) != "count" {
+			return errn.ErrSyntax
+		}
+		if count, err = strconv.ParseInt(unsafe2.String(rest[1]), 10, 64); err != nil || count <= 0 {
+			return errn.ErrValue
+		}
+	}
+
+	khashes, err := zsameSlotKeyHashes(keys[0], keys, c.keyHash)
+	if err != nil {
+		return err
+	}
+	key, res, err := c.DB.ZMPop(keys, khashes, count, max)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		c.Writer.WriteArray(nil)
+		return nil
+	}
+
+	pairs := make([][]byte, 0, len(res)*2)
+	for _, sp := range res {
+		pairs = append(pairs, sp.Member, extend.FormatFloat64ToSlice(sp.Score))
+	}
+	c.Writer.WriteArray([]interface{}{key, pairs})
+	return nil
+}
+
+func zintercardCommand(c *Client) error {
+	keys, rest, err := zparseNumKeys(c.Args, resp.ZINTERCARD)
+	if err != nil {
+		return err
+	}
+	var limit int64
+	if len(rest) > 0 {
+		if len(rest) != 2 || strings.ToLower(unsafe2.String(rest[0])) != "limit" {
+			return errn.ErrSyntax
+		}
+		if limit, err = strconv.ParseInt(unsafe2.String(rest[1]), 10, 64); err != nil || limit < 0 {
+			return errn.ErrValue
+		}
+	}
+
+	khashes, err := zsameSlotKeyHashes(keys[0], keys, c.keyHash)
+	if err != nil {
+		return err
+	}
+	n, err := c.DB.ZInterCard(keys, khashes, limit)
+	if err != nil {
+		return err
+	}
+	c.Writer.WriteInteger(n)
+	return nil
+}
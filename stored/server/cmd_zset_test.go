@@ -0,0 +1,512 @@
+// Copyright 2019-2024 Xu Ruibo (hustxurb@163.com) and Contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/zuoyebang/bitalostored/butils/hash"
+	"github.com/zuoyebang/bitalostored/stored/engine"
+	"github.com/zuoyebang/bitalostored/stored/engine/bitsdb/btools"
+	"github.com/zuoyebang/bitalostored/stored/internal/errn"
+	"github.com/zuoyebang/bitalostored/stored/internal/resp"
+	"github.com/zuoyebang/bitalostored/stored/internal/utils"
+)
+
+var zaddSinglePairArgs = [][]byte{[]byte("1.5"), []byte("member")}
+
+func zaddParseScratch(c *Client) {
+	params := c.getScorePairs(len(zaddSinglePairArgs) >> 1)
+	if err := zparseScorePairsTo(params, zaddSinglePairArgs); err != nil {
+		panic(err)
+	}
+	c.putScorePairs(params)
+}
+
+func TestZAddScorePairsScratch(t *testing.T) {
+	c := &Client{}
+	params := c.getScorePairs(2)
+	if err := zparseScorePairsTo(params, [][]byte{[]byte("1"), []byte("a"), []byte("2"), []byte("b")}); err != nil {
+		t.Fatal(err)
+	}
+	if params[0].Score != 1 || string(params[0].Member) != "a" || params[1].Score != 2 || string(params[1].Member) != "b" {
+		t.Fatalf("parse score pairs err %v", params)
+	}
+	c.putScorePairs(params)
+	if params[0].Member != nil || params[1].Member != nil {
+		t.Fatal("score pairs not reset")
+	}
+	if big := c.getScorePairs(maxScorePairsBuf + 1); len(big) != maxScorePairsBuf+1 || cap(c.scorePairs) != maxScorePairsBuf {
+		t.Fatal("oversize score pairs should not be kept")
+	}
+
+	if n := testing.AllocsPerRun(100, func() { zaddParseScratch(c) }); n != 0 {
+		t.Fatalf("scratch allocs %v", n)
+	}
+	if n := testing.AllocsPerRun(100, func() { zparseScorePairs(zaddSinglePairArgs) }); n == 0 {
+		t.Fatal("expect allocs without scratch")
+	}
+}
+
+func BenchmarkZAddSinglePair(b *testing.B) {
+	b.Run("alloc", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := zparseScorePairs(zaddSinglePairArgs); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("scratch", func(b *testing.B) {
+		c := &Client{}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			zaddParseScratch(c)
+		}
+	})
+}
+
+func TestZAggregateScoreNaN(t *testing.T) {
+	inf := math.Inf(1)
+	for _, tt := range []struct {
+		aggregate int
+		a, b      float64
+		want      float64
+	}{
+		{zsetAggregateSum, inf, math.Inf(-1), 0},
+		{zsetAggregateSum, inf, 1, inf},
+		{zsetAggregateMin, inf, math.Inf(-1), math.Inf(-1)},
+		{zsetAggregateMax, 0, inf, inf},
+	} {
+		if got := zaggregateScore(tt.aggregate, tt.a, tt.b); got != tt.want {
+			t.Fatalf("aggregate %d %v %v got %v want %v", tt.aggregate, tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestZParseAddOptions(t *testing.T) {
+	for _, tt := range []struct {
+		args []string
+		opts btools.ZAddOptions
+		incr bool
+		n    int
+		err  error
+	}{
+		{[]string{"1", "a"}, btools.ZAddOptions{}, false, 0, nil},
+		{[]string{"nx", "1", "a"}, btools.ZAddOptions{NX: true}, false, 1, nil},
+		{[]string{"XX", "ch", "1", "a"}, btools.ZAddOptions{XX: true, CH: true}, false, 2, nil},
+		{[]string{"GT", "CH", "INCR", "1", "a"}, btools.ZAddOptions{GT: true, CH: true}, true, 3, nil},
+		{[]string{"xx", "lt", "1", "a"}, btools.ZAddOptions{XX: true, LT: true}, false, 2, nil},
+		{[]string{"NX", "XX", "1", "a"}, btools.ZAddOptions{}, false, 0, errn.ErrSyntax},
+		{[]string{"NX", "GT", "1", "a"}, btools.ZAddOptions{}, false, 0, errn.ErrSyntax},
+		{[]string{"LT", "NX", "1", "a"}, btools.ZAddOptions{}, false, 0, errn.ErrSyntax},
+		{[]string{"GT", "LT", "1", "a"}, btools.ZAddOptions{}, false, 0, errn.ErrSyntax},
+	} {
+		args := toArgs(tt.args...)
+		opts, incr, n, err := zparseAddOptions(args)
+		if err != tt.err {
+			t.Fatalf("parse %v err %v want %v", tt.args, err, tt.err)
+		}
+		if err == nil && (opts != tt.opts || incr != tt.incr || n != tt.n) {
+			t.Fatalf("parse %v got %+v %v %d", tt.args, opts, incr, n)
+		}
+	}
+}
+
+func TestZParseRangeOptions(t *testing.T) {
+	for _, tt := range []struct {
+		args  []string
+		allow bool
+		opt   zrangeOpt
+		err   error
+	}{
+		{nil, false, zrangeOpt{count: -1}, nil},
+		{[]string{"rev"}, false, zrangeOpt{rev: true, count: -1}, nil},
+		{[]string{"BYSCORE", "LIMIT", "1", "2"}, false, zrangeOpt{by: zrangeByScore, offset: 1, count: 2}, nil},
+		{[]string{"REV", "bylex", "limit", "0", "-1"}, false, zrangeOpt{by: zrangeByLex, rev: true, count: -1}, nil},
+		{[]string{"byscore", "withscores", "rev"}, true, zrangeOpt{by: zrangeByScore, rev: true, count: -1, withScores: true}, nil},
+		{[]string{"BYSCORE", "BYLEX"}, false, zrangeOpt{}, errn.ErrSyntax},
+		{[]string{"LIMIT", "0", "1"}, true, zrangeOpt{}, errn.ErrSyntax},
+		{[]string{"BYSCORE", "LIMIT", "0"}, false, zrangeOpt{}, errn.ErrSyntax},
+		{[]string{"BYSCORE", "LIMIT", "a", "1"}, false, zrangeOpt{}, errn.ErrValue},
+		{[]string{"WITHSCORES"}, false, zrangeOpt{}, errn.ErrSyntax},
+		{[]string{"BYLEX", "WITHSCORES"}, true, zrangeOpt{}, errn.ErrSyntax},
+	} {
+		args := toArgs(tt.args...)
+		opt, err := zparseRangeOptions(args, tt.allow)
+		if err != tt.err {
+			t.Fatalf("parse %v err %v want %v", tt.args, err, tt.err)
+		}
+		if err == nil && opt != tt.opt {
+			t.Fatalf("parse %v got %+v", tt.args, opt)
+		}
+	}
+}
+
+func TestZAddOptionsSkip(t *testing.T) {
+	min, max := float64(math.MinInt64), float64(math.MaxInt64)
+	for _, tt := range []struct {
+		opts  btools.ZAddOptions
+		exist bool
+		old   float64
+		score float64
+		skip  bool
+	}{
+		{btools.ZAddOptions{}, true, 1, 2, false},
+		{btools.ZAddOptions{NX: true}, false, 0, 2, false},
+		{btools.ZAddOptions{NX: true}, true, 1, 2, true},
+		{btools.ZAddOptions{XX: true}, false, 0, 2, true},
+		{btools.ZAddOptions{XX: true}, true, 1, 2, false},
+		{btools.ZAddOptions{GT: true}, false, 0, min, false},
+		{btools.ZAddOptions{GT: true}, true, min, max, false},
+		{btools.ZAddOptions{GT: true}, true, max, max, true},
+		{btools.ZAddOptions{GT: true}, true, max, min, true},
+		{btools.ZAddOptions{LT: true}, false, 0, max, false},
+		{btools.ZAddOptions{LT: true}, true, max, min, false},
+		{btools.ZAddOptions{LT: true}, true, min, min, true},
+		{btools.ZAddOptions{LT: true}, true, min, max, true},
+	} {
+		if skip := tt.opts.Skip(tt.exist, tt.old, tt.score); skip != tt.skip {
+			t.Fatalf("%+v exist %v %v -> %v skip %v", tt.opts, tt.exist, tt.old, tt.score, skip)
+		}
+	}
+}
+
+func TestZSetOpStoreCrossSlot(t *testing.T) {
+	for _, tt := range []struct {
+		args []string
+		err  error
+	}{
+		{[]string{"dst", "2", "a", "b"}, errn.ErrCrossSlot},
+		{[]string{"{t}dst", "2", "{t}a", "{u}b"}, errn.ErrCrossSlot},
+		{[]string{"{t}dst", "2", "{t}a"}, errn.ErrSyntax},
+		{[]string{"{t}dst", "0", "{t}a"}, errn.ErrSyntax},
+		{[]string{"{t}dst", "2", "{t}a", "{t}b", "weights", "1"}, errn.ErrSyntax},
+		{[]string{"{t}dst", "1", "{t}a", "withscores"}, errn.ErrSyntax},
+	} {
+		args := toArgs(tt.args...)
+		c := &Client{Args: args, Keys: args[0], KeyHash: utils.GetHashTagKeyHash(args[0])}
+		if err := zunionstoreCommand(c); err != tt.err {
+			t.Fatalf("zunionstore %v err %v want %v", tt.args, err, tt.err)
+		}
+		if err := zinterstoreCommand(c); err != tt.err {
+			t.Fatalf("zinterstore %v err %v want %v", tt.args, err, tt.err)
+		}
+	}
+}
+
+func TestZSetOpCrossSlot(t *testing.T) {
+	for _, tt := range []struct {
+		args []string
+		err  error
+	}{
+		{[]string{"2", "a", "b"}, errn.ErrCrossSlot},
+		{[]string{"2", "{t}a", "{u}b", "withscores"}, errn.ErrCrossSlot},
+		{[]string{"2", "{t}a"}, errn.ErrSyntax},
+		{[]string{"0", "{t}a"}, errn.ErrSyntax},
+	} {
+		args := toArgs(tt.args...)
+		c := &Client{Args: args, Keys: args[0], KeyHash: utils.KeyHash(args[0]), server: &Server{}}
+		if err := zunionCommand(c); err != tt.err {
+			t.Fatalf("zunion %v err %v want %v", tt.args, err, tt.err)
+		}
+		if err := zinterCommand(c); err != tt.err {
+			t.Fatalf("zinter %v err %v want %v", tt.args, err, tt.err)
+		}
+	}
+}
+
+func TestZDiffArgs(t *testing.T) {
+	for _, tt := range []struct {
+		store bool
+		args  []string
+		err   error
+	}{
+		{false, []string{"2", "a", "b"}, errn.ErrCrossSlot},
+		{false, []string{"2", "{t}a", "{t}b", "weights", "1", "1"}, errn.ErrSyntax},
+		{false, []string{"1", "{t}a", "withscores", "withscores"}, errn.ErrSyntax},
+		{false, []string{"0", "{t}a"}, errn.ErrSyntax},
+		{false, []string{"x", "{t}a"}, errn.ErrValue},
+		{true, []string{"{t}dst", "2", "{t}a", "{u}b"}, errn.ErrCrossSlot},
+		{true, []string{"{t}dst", "1", "{t}a", "withscores"}, errn.ErrSyntax},
+		{true, []string{"{t}dst", "2", "{t}a"}, errn.ErrSyntax},
+	} {
+		args := toArgs(tt.args...)
+		c := &Client{Args: args, Keys: args[0], KeyHash: utils.GetHashTagKeyHash(args[0])}
+		handler := zdiffCommand
+		if tt.store {
+			handler = zdiffstoreCommand
+		}
+		if err := handler(c); err != tt.err {
+			t.Fatalf("store %v %v err %v want %v", tt.store, tt.args, err, tt.err)
+		}
+	}
+}
+
+func openZRangeByScoreDB(tb testing.TB, key []byte, n int) *engine.Bitalos {
+	db := openTestDB(tb)
+	pairs := make([]btools.ScorePair, n)
+	for i := range pairs {
+		member := fmt.Sprintf("member_%d", i)
+		if i%3 == 0 {
+			member += strings.Repeat("x", 64)
+		}
+		pairs[i] = btools.ScorePair{Member: []byte(member), Score: float64(i % 100)}
+	}
+	if _, err := db.ZAdd(key, hash.Fnv32(key), btools.ZAddOptions{}, pairs...); err != nil {
+		tb.Fatal(err)
+	}
+	return db
+}
+
+func zrangebyscoreCollect(c *Client, args ...string) ([]byte, error) {
+	c.Args = c.Args[:0]
+	for _, arg := range args {
+		c.Args = append(c.Args, []byte(arg))
+	}
+	c.Writer.Reset()
+	min, max, leftClose, rightClose, err := zparseScoreRange(c.Args[1], c.Args[2])
+	if err != nil {
+		return nil, err
+	}
+	offset, count, withScores := 0, -1, false
+	for i := 3; i < len(args); i++ {
+		switch args[i] {
+		case "withscores":
+			withScores = true
+		case "limit":
+			fmt.Sscan(args[i+1], &offset)
+			fmt.Sscan(args[i+2], &count)
+			i += 2
+		}
+	}
+	datas, err := c.DB.ZRangeByScoreGeneric(c.Args[0], c.KeyHash, min, max, leftClose, rightClose, offset, count, false)
+	if err != nil {
+		return nil, err
+	}
+	c.Writer.WriteScorePairArray(datas, withScores)
+	if c.Writer.Cached {
+		c.Writer.FlushCached()
+	}
+	return append([]byte(nil), c.Writer.Bytes()...), nil
+}
+
+func zrangebyscoreReply(c *Client, args ...string) ([]byte, error) {
+	c.Args = c.Args[:0]
+	for _, arg := range args {
+		c.Args = append(c.Args, []byte(arg))
+	}
+	c.Writer.Reset()
+	if err := zrangebyscoreCommand(c); err != nil {
+		return nil, err
+	}
+	if c.Writer.Cached {
+		c.Writer.FlushCached()
+	}
+	return append([]byte(nil), c.Writer.Bytes()...), nil
+}
+
+func TestZRangeByScoreStream(t *testing.T) {
+	key := []byte("zrangebyscore_stream")
+	db := openZRangeByScoreDB(t, key, 1000)
+
+	for _, args := range [][]string{
+		{"-inf", "+inf"},
+		{"-inf", "+inf", "withscores"},
+		{"10", "20", "withscores"},
+		{"(10", "(20", "withscores"},
+		{"(10", "20"},
+		{"10", "(20", "withscores", "limit", "5", "7"},
+		{"-inf", "+inf", "limit", "990", "100"},
+		{"-inf", "+inf", "withscores", "limit", "2000", "10"},
+		{"-inf", "+inf", "limit", "-1", "10"},
+		{"200", "300", "withscores"},
+	} {
+		for _, cached := range []bool{false, true} {
+			for _, proto := range []int{resp.ProtoResp2, resp.ProtoResp3} {
+				c := newTestClient(newTestServer(db))
+				c.KeyHash = hash.Fnv32(key)
+				c.Writer.SetProto(proto)
+				if cached {
+					c.Writer.SetCached()
+				}
+				full := append([]string{string(key)}, args...)
+				want, err := zrangebyscoreCollect(c, full...)
+				if err != nil {
+					t.Fatal(args, err)
+				}
+				got, err := zrangebyscoreReply(c, full...)
+				if err != nil {
+					t.Fatal(args, err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Fatalf("%v cached %v proto %d\ngot  %q\nwant %q", args, cached, proto, got, want)
+				}
+			}
+		}
+	}
+
+	c := newTestClient(newTestServer(db))
+	c.KeyHash = hash.Fnv32(key)
+	c.server.maxReplyElements.Store(10)
+	if _, err := zrangebyscoreReply(c, string(key), "-inf", "+inf", "withscores", "limit", "0", "6"); err != errn.ErrReplyTooLarge {
+		t.Fatalf("reply limit err %v", err)
+	}
+	if len(c.Writer.Bytes()) != 0 {
+		t.Fatalf("rejected reply wrote %q", c.Writer.Bytes())
+	}
+	if got, err := zrangebyscoreReply(c, string(key), "-inf", "+inf", "withscores", "limit", "0", "5"); err != nil || !bytes.HasPrefix(got, []byte("*10\r\n")) {
+		t.Fatalf("reply within limit %q err %v", got, err)
+	}
+}
+
+func BenchmarkZRangeByScoreWithScores(b *testing.B) {
+	key := []byte("zrangebyscore_bench")
+	db := openZRangeByScoreDB(b, key, 10000)
+	c := newTestClient(newTestServer(db))
+	c.KeyHash = hash.Fnv32(key)
+
+	b.Run("collect", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := zrangebyscoreCollect(c, string(key), "-inf", "+inf", "withscores"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("stream", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := zrangebyscoreReply(c, string(key), "-inf", "+inf", "withscores"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestZMPopArgs(t *testing.T) {
+	for _, tt := range []struct {
+		args []string
+		err  error
+	}{
+		{[]string{"2", "a", "b", "min"}, errn.ErrCrossSlot},
+		{[]string{"1", "{t}a"}, errn.ErrSyntax},
+		{[]string{"1", "{t}a", "first"}, errn.ErrSyntax},
+		{[]string{"1", "{t}a", "min", "count"}, errn.ErrSyntax},
+		{[]string{"1", "{t}a", "max", "limit", "1"}, errn.ErrSyntax},
+		{[]string{"1", "{t}a", "max", "count", "0"}, errn.ErrValue},
+		{[]string{"1", "{t}a", "max", "count", "x"}, errn.ErrValue},
+		{[]string{"0", "{t}a", "min"}, errn.ErrSyntax},
+	} {
+		args := toArgs(tt.args...)
+		c := &Client{Args: args, Keys: args[0], KeyHash: hash.Fnv32(args[0])}
+		if err := zmpopCommand(c); err != tt.err {
+			t.Fatalf("zmpop %v err %v want %v", tt.args, err, tt.err)
+		}
+	}
+}
+
+func TestZInterCardArgs(t *testing.T) {
+	for _, tt := range []struct {
+		args []string
+		err  error
+	}{
+		{[]string{"2", "a", "b"}, errn.ErrCrossSlot},
+		{[]string{"1", "{t}a", "limit"}, errn.ErrSyntax},
+		{[]string{"1", "{t}a", "count", "1"}, errn.ErrSyntax},
+		{[]string{"1", "{t}a", "limit", "-1"}, errn.ErrValue},
+		{[]string{"1", "{t}a", "limit", "x"}, errn.ErrValue},
+		{[]string{"2", "{t}a"}, errn.ErrSyntax},
+	} {
+		args := toArgs(tt.args...)
+		c := &Client{Args: args, Keys: args[0], KeyHash: utils.KeyHash(args[0])}
+		if err := zintercardCommand(c); err != tt.err {
+			t.Fatalf("zintercard %v err %v want %v", tt.args, err, tt.err)
+		}
+	}
+}
+
+func TestZReplyReadLimit(t *testing.T) {
+	s := newTestServer(openTestDB(t))
+	c := newTestClient(s)
+	key := []byte("zreply_read_limit")
+	args := []string{"zadd", string(key)}
+	for i := 0; i < 10; i++ {
+		args = append(args, strconv.Itoa(i), fmt.Sprintf("m%d", i))
+	}
+	doTestRequest(c, false, args...)
+	doTestRequest(c, false, "zadd", "zreply_read_limit_other", "1", "x", "2", "y")
+	khash := utils.KeyHash(key)
+
+	if n := c.replyReadCount(-1, 1); n != -1 {
+		t.Fatalf("read count without limit %d", n)
+	}
+	s.maxReplyElements.Store(4)
+	for _, tt := range []struct {
+		count, elems, want int
+	}{
+		{-1, 1, 5}, {3, 1, 3}, {9, 1, 5}, {-1, 2, 3},
+	} {
+		if n := c.replyReadCount(tt.count, tt.elems); n != tt.want {
+			t.Fatalf("read count %d elems %d got %d want %d", tt.count, tt.elems, n, tt.want)
+		}
+	}
+
+	for _, tt := range []struct {
+		start, stop         int64
+		elems               int
+		wantStart, wantStop int64
+	}{
+		{0, -1, 1, 0, 4},
+		{0, -1, 2, 0, 2},
+		{-3, -1, 1, 7, 9},
+		{-20, 1, 1, 0, 1},
+		{2, 3, 1, 2, 3},
+		{2, 100, 1, 2, 6},
+	} {
+		start, stop, err := zreplyRankRange(c, key, khash, tt.start, tt.stop, tt.elems)
+		if err != nil || start != tt.wantStart || stop != tt.wantStop {
+			t.Fatalf("rank range %d %d got %d %d err %v", tt.start, tt.stop, start, stop, err)
+		}
+	}
+
+	res, err := s.db.ZCombine([][]byte{key, []byte("zreply_read_limit_other")},
+		[]uint32{khash, utils.KeyHash([]byte("zreply_read_limit_other"))}, nil,
+		func(a, b float64) float64 { return a + b }, true, 4)
+	if err != nil || len(res) != 5 {
+		t.Fatalf("limited union got %d members err %v", len(res), err)
+	}
+
+	want := "-" + errn.ErrReplyTooLarge.Error() + "\r\n"
+	for _, cmd := range [][]string{
+		{"zrange", string(key), "0", "-1"},
+		{"zrange", string(key), "0", "-1", "rev", "withscores"},
+		{"zrangebylex", string(key), "-", "+"},
+		{"zrangebyscore", string(key), "-inf", "+inf"},
+		{"zrevrangebyscore", string(key), "+inf", "-inf"},
+	} {
+		if reply := doTestRequest(c, false, cmd...); reply != want {
+			t.Fatalf("%v reply %q", cmd, reply)
+		}
+	}
+	if reply := doTestRequest(c, false, "zrange", string(key), "0", "3"); !strings.HasPrefix(reply, "*4\r\n") {
+		t.Fatalf("zrange within limit %q", reply)
+	}
+}
@@ -36,6 +36,7 @@ type SInfo struct {
 	Stats          SinfoStats
 	Data           SinfoData
 	RuntimeStats   SRuntimeStats
+	Tx             SinfoTx
 	BitalosdbUsage *bitsdb.BitsUsage
 }
 
@@ -46,6 +47,7 @@ func (sinfo *SInfo) Marshal() ([]byte, func()) {
 	pos += sinfo.Client.AppendTo(buf, pos)
 	pos += sinfo.Cluster.AppendTo(buf, pos)
 	pos += sinfo.Stats.AppendTo(buf, pos)
+	pos += sinfo.Tx.AppendTo(buf, pos)
 	pos += sinfo.Data.AppendTo(buf, pos)
 	pos += sinfo.BitalosdbUsage.AppendTo(buf, pos)
 	pos += sinfo.RuntimeStats.AppendTo(buf, pos)
@@ -252,16 +254,17 @@ const (
 )
 
 type SinfoStats struct {
-	TotolCmd      atomic.Uint64
-	QPS           atomic.Uint64
-	QueueLen      int
-	RaftLogIndex  uint64
-	IsDelExpire   int
-	StartModel    ModelType
-	DbSyncRunning atomic.Int32
-	DbSyncStatus  DbSyncStatusType
-	DbSyncErr     string
-	IsMigrate     atomic.Int32 `json:"is_migrate"`
+	TotolCmd         atomic.Uint64
+	QPS              atomic.Uint64
+	LinearizableRead atomic.Uint64
+	QueueLen         int
+	RaftLogIndex     uint64
+	IsDelExpire      int
+	StartModel       ModelType
+	DbSyncRunning    atomic.Int32
+	DbSyncStatus     DbSyncStatusType
+	DbSyncErr        string
+	IsMigrate        atomic.Int32 `json:"is_migrate"`
 
 	mutex sync.RWMutex
 	cache []byte
@@ -292,6 +295,7 @@ func (ss *SinfoStats) UpdateCache() {
 	ss.cache = append(ss.cache, []byte("# Status\n")...)
 	ss.cache = utils.AppendInfoUint(ss.cache, "total_commands_processed:", ss.TotolCmd.Load())
 	ss.cache = utils.AppendInfoUint(ss.cache, "instantaneous_ops_per_sec:", ss.QPS.Load())
+	ss.cache = utils.AppendInfoUint(ss.cache, "linearizable_read_cmds:", ss.LinearizableRead.Load())
 	ss.cache = utils.AppendInfoUint(ss.cache, "sync_queue_length:", uint64(ss.QueueLen))
 	ss.cache = utils.AppendInfoUint(ss.cache, "raft_log_index:", ss.RaftLogIndex)
 	ss.cache = utils.AppendInfoInt(ss.cache, "is_del_expire:", int64(ss.IsDelExpire))
@@ -302,6 +306,64 @@ func (ss *SinfoStats) UpdateCache() {
 	ss.cache = append(ss.cache, '\n')
 }
 
+type SinfoTx struct {
+	PrepareKeyModified atomic.Int64
+	PrepareLockFail    atomic.Int64
+	PrepareLocked      atomic.Int64
+	PrepareUnlock      atomic.Int64
+	PrepareLockFailCnt atomic.Uint64
+
+	mutex sync.RWMutex
+	cache []byte
+}
+
+func (st *SinfoTx) addPrepareState(state int32, delta int64) {
+	switch state {
+	case PrepareStateKeyModified:
+		st.PrepareKeyModified.Add(delta)
+	case PrepareStateLockFail:
+		st.PrepareLockFail.Add(delta)
+	case PrepareStateLocked:
+		st.PrepareLocked.Add(delta)
+	case PrepareStateUnlock:
+		st.PrepareUnlock.Add(delta)
+	}
+}
+
+func (st *SinfoTx) Marshal() ([]byte, func()) {
+	st.UpdateCache()
+
+	st.mutex.RLock()
+	defer st.mutex.RUnlock()
+
+	info, closer := bytepools.BytePools.GetBytePool(len(st.cache))
+	num := copy(info[0:], st.cache)
+	return info[:num], closer
+}
+
+func (st *SinfoTx) AppendTo(target []byte, pos int) int {
+	st.UpdateCache()
+
+	st.mutex.RLock()
+	defer st.mutex.RUnlock()
+
+	return copy(target[pos:], st.cache)
+}
+
+func (st *SinfoTx) UpdateCache() {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	st.cache = st.cache[:0]
+	st.cache = append(st.cache, []byte("# Transactions\n")...)
+	st.cache = utils.AppendInfoInt(st.cache, "prepare_key_modified_clients:", st.PrepareKeyModified.Load())
+	st.cache = utils.AppendInfoInt(st.cache, "prepare_lock_fail_clients:", st.PrepareLockFail.Load())
+	st.cache = utils.AppendInfoInt(st.cache, "prepare_locked_clients:", st.PrepareLocked.Load())
+	st.cache = utils.AppendInfoInt(st.cache, "prepare_unlock_clients:", st.PrepareUnlock.Load())
+	st.cache = utils.AppendInfoUint(st.cache, "prepare_lock_fail_total:", st.PrepareLockFailCnt.Load())
+	st.cache = append(st.cache, '\n')
+}
+
 func boolToString(ok bool) string {
 	if ok {
 		return "true"
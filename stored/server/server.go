@@ -20,9 +20,11 @@ import (
 	"os"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/cockroachdb/errors"
 	"github.com/panjf2000/gnet/v2"
+	"github.com/zuoyebang/bitalostored/butils/hash"
 	"github.com/zuoyebang/bitalostored/stored/engine"
 	"github.com/zuoyebang/bitalostored/stored/engine/bitsdb/bitsdb"
 	"github.com/zuoyebang/bitalostored/stored/engine/bitsdb/btools"
@@ -45,7 +47,12 @@ type Server struct {
 	MigrateDelToSlave func(keyHash uint32, data [][]byte) error
 	IsWitness         bool
 	DoRaftSync        func(keyHash uint32, data [][]byte) ([]byte, error)
+	DoRaftSyncCommit  func(keyHash uint32, data [][]byte) ([]byte, error)
 	DoRaftStop        func()
+	DoRaftReadIndex   func() error
+	DoRaftTransfer    func(targetNodeID uint64) error
+	DoRaftIndex       func() (committed uint64, applied uint64, err error)
+	DoRaftFlushLogDB  func() error
 	laddr             string
 	db                *engine.Bitalos
 	closed            atomic.Bool
@@ -53,6 +60,14 @@ type Server struct {
 	isDebug           bool
 	isOpenRaft        bool
 	slowQuery         *slowshield.SlowShield
+	slowTime          atomic.Int64
+	maxReplyElements  atomic.Int64
+	zsetRefreshTTL    atomic.Int64
+	zaddChEpsilon     float64
+	slowLog           *slowLog
+	slowLogCacheMaint bool
+	writerSize        int
+	outputLimit       resp.OutputLimit
 	recoverLock       sync.Mutex
 	syncDataDoing     atomic.Int32
 	dbSyncing         atomic.Int32
@@ -60,6 +75,9 @@ type Server struct {
 	expireClosedCh    chan struct{}
 	expireWg          sync.WaitGroup
 	openDistributedTx bool
+	txMaxQueueCmds    int
+	txMaxWatchKeys    int
+	txLockTimeout     time.Duration
 	txLocks           *TxShardLocker
 	txParallelCounter atomic.Int32
 	txPrepareWg       sync.WaitGroup
@@ -67,17 +85,37 @@ type Server struct {
 }
 
 func NewServer() (*Server, error) {
+	if err := hash.SetKeyHash(config.GlobalConfig.Server.KeyHash); err != nil {
+		return nil, errors.Wrap(err, "set key hash err")
+	}
+
 	s := &Server{
 		laddr:             config.GlobalConfig.Server.Address,
 		isDebug:           config.GlobalConfig.Log.IsDebug,
 		slowQuery:         slowshield.NewSlowShield(),
+		slowLog:           newSlowLog(config.GlobalConfig.Server.SlowLogMaxLen),
+		slowLogCacheMaint: config.GlobalConfig.Server.SlowLogCacheMaint,
+		zaddChEpsilon:     config.GlobalConfig.Server.ZaddChEpsilon,
+		writerSize:        config.GlobalConfig.Server.RespWriterBuffer.AsInt(),
 		quit:              make(chan struct{}),
 		recoverLock:       sync.Mutex{},
 		expireClosedCh:    make(chan struct{}),
 		openDistributedTx: config.GlobalConfig.Server.OpenDistributedTx,
+		txMaxQueueCmds:    config.GlobalConfig.Server.TxMaxQueueCommands,
+		txMaxWatchKeys:    config.GlobalConfig.Server.TxMaxWatchKeys,
+		txLockTimeout:     config.GlobalConfig.Server.TxPrepareLockTimeout.Duration(),
 		isOpenRaft:        config.GlobalConfig.Plugin.OpenRaft,
 		IsWitness:         config.GlobalConfig.RaftCluster.IsWitness,
 	}
+	s.slowTime.Store(config.GlobalConfig.Server.SlowTime.Int64())
+	s.maxReplyElements.Store(int64(config.GlobalConfig.Server.MaxReplyElements))
+	s.zsetRefreshTTL.Store(config.GlobalConfig.Server.ZsetRefreshTTL)
+	s.outputLimit = resp.OutputLimit{
+		Hard:     config.GlobalConfig.Server.OutputBufferHardLimit.Int64(),
+		Soft:     config.GlobalConfig.Server.OutputBufferSoftLimit.Int64(),
+		SoftTime: config.GlobalConfig.Server.OutputBufferSoftTime.Duration(),
+	}
+	initRaftClientPool(s.writerSize)
 	s.Info = &SInfo{
 		Client:         SinfoClient{cache: make([]byte, 0, 256)},
 		Cluster:        SinfoCluster{cache: make([]byte, 0, 2048)},
@@ -107,6 +145,7 @@ func NewServer() (*Server, error) {
 
 	if s.openDistributedTx {
 		s.txLocks = NewTxLockers(200)
+		go s.txLocks.runJanitor(s.quit, txJanitorInterval)
 	}
 
 	luaMux := make([]*sync.Mutex, LuaShardCount)
@@ -164,6 +203,9 @@ func (s *Server) Close() {
 
 	s.txPrepareWg.Wait()
 	s.DoRaftStop()
+	if s.slowQuery != nil {
+		s.slowQuery.Close()
+	}
 
 	if !s.IsWitness {
 		s.expireWg.Wait()
@@ -261,7 +303,11 @@ func (s *Server) OnTraffic(conn gnet.Conn) (action gnet.Action) {
 			log.Errorf("conn OnTraffic handle request error %s", err)
 		}
 
-		if _, err = client.Writer.FlushToWriterIO(conn); err != nil {
+		if _, err = client.Writer.FlushToWriterIOLimit(conn, conn.OutboundBuffered, s.outputLimit); err != nil {
+			if err == errn.ErrOutputBufferLimit {
+				log.Warnf("conn OnTraffic close client %s error %s", client.remoteAddr, err)
+				return gnet.Close
+			}
 			log.Errorf("conn OnTraffic write error %s", err)
 		}
 	}
@@ -0,0 +1,115 @@
+// Copyright 2019-2024 Xu Ruibo (hustxurb@163.com) and Contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/zuoyebang/bitalostored/butils/bytesize"
+	"github.com/zuoyebang/bitalostored/stored/engine"
+	"github.com/zuoyebang/bitalostored/stored/internal/config"
+	"github.com/zuoyebang/bitalostored/stored/internal/resp"
+)
+
+// openTestDB opens a standalone db in a temp dir, closed when tb ends.
+func openTestDB(tb testing.TB) *engine.Bitalos {
+	openRaft := config.GlobalConfig.Plugin.OpenRaft
+	config.GlobalConfig.Plugin.OpenRaft = false
+	tb.Cleanup(func() {
+		config.GlobalConfig.Plugin.OpenRaft = openRaft
+	})
+
+	db, err := engine.NewBitalos(tb.TempDir())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(db.Close)
+	return db
+}
+
+// openTestCacheDB is openTestDB with the meta cache enabled.
+func openTestCacheDB(tb testing.TB) *engine.Bitalos {
+	cacheSize := config.GlobalConfig.Bitalos.CacheSize
+	cacheHashSize := config.GlobalConfig.Bitalos.CacheHashSize
+	config.GlobalConfig.Bitalos.CacheSize = 64 * bytesize.MB
+	config.GlobalConfig.Bitalos.CacheHashSize = 1 << 16
+	tb.Cleanup(func() {
+		config.GlobalConfig.Bitalos.CacheSize = cacheSize
+		config.GlobalConfig.Bitalos.CacheHashSize = cacheHashSize
+	})
+	return openTestDB(tb)
+}
+
+// newTestServer returns a server without raft over db, slow queries are not
+// recorded unless the caller lowers slowTime.
+func newTestServer(db *engine.Bitalos) *Server {
+	s := &Server{
+		Info:              &SInfo{},
+		db:                db,
+		slowLog:           newSlowLog(1),
+		openDistributedTx: true,
+		txLocks:           NewTxLockers(16),
+	}
+	s.slowTime.Store(math.MaxInt64)
+	return s
+}
+
+func newTestClient(s *Server) *Client {
+	return &Client{DB: s.db, Writer: resp.NewWriter(), server: s, IsMaster: func() bool { return true }}
+}
+
+// doTestCommand runs handler with args on a new client of s and returns the
+// reply.
+func doTestCommand(s *Server, handler func(*Client) error, args ...string) (string, error) {
+	c := newTestClient(s)
+	c.Args = toArgs(args...)
+	err := handler(c)
+	return string(c.Writer.Bytes()), err
+}
+
+// doTestRequest runs args through HandleRequest and returns the reply.
+func doTestRequest(c *Client, isHashTag bool, args ...string) string {
+	c.Writer.Reset()
+	c.HandleRequest(toArgs(args...), isHashTag)
+	return string(c.Writer.Bytes())
+}
+
+func toArgs(args ...string) [][]byte {
+	res := make([][]byte, len(args))
+	for i := range args {
+		res[i] = []byte(args[i])
+	}
+	return res
+}
+
+// testReplyInts parses an array reply of integers.
+func testReplyInts(tb testing.TB, reply string) []int64 {
+	parts := strings.Split(strings.TrimSuffix(reply, "\r\n"), "\r\n")
+	if !strings.HasPrefix(parts[0], "*") {
+		tb.Fatalf("not an array reply %q", reply)
+	}
+	res := make([]int64, 0, len(parts)-1)
+	for _, part := range parts[1:] {
+		n, err := strconv.ParseInt(strings.TrimPrefix(part, ":"), 10, 64)
+		if err != nil || !strings.HasPrefix(part, ":") {
+			tb.Fatalf("not an integer array reply %q", reply)
+		}
+		res = append(res, n)
+	}
+	return res
+}
@@ -0,0 +1,148 @@
+// Copyright 2019-2024 Xu Ruibo (hustxurb@163.com) and Contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	slowLogMaxArgc   = 32
+	slowLogMaxArgLen = 128
+)
+
+type slowLogEntry struct {
+	id         int64
+	timestamp  int64
+	costUs     int64
+	args       [][]byte
+	addr       string
+	cacheMaint bool
+}
+
+// slowLog keeps the newest maxLen entries in a ring, once full entries[head]
+// is the oldest one and is overwritten by the next add.
+type slowLog struct {
+	mu      sync.Mutex
+	nextId  int64
+	maxLen  int
+	head    int
+	entries []slowLogEntry
+}
+
+func newSlowLog(maxLen int) *slowLog {
+	return &slowLog{maxLen: maxLen}
+}
+
+// add records a command sent from addr; cacheMaint marks that the cache shard
+// of its key ran a rehash or GC copy while it executed.
+func (l *slowLog) add(costUs int64, data [][]byte, addr string, cacheMaint bool) {
+	argc := len(data)
+	if argc > slowLogMaxArgc {
+		argc = slowLogMaxArgc
+	}
+	args := make([][]byte, 0, argc)
+	for i := 0; i < argc; i++ {
+		if i == slowLogMaxArgc-1 && len(data) > slowLogMaxArgc {
+			args = append(args, []byte(fmt.Sprintf("... (%d more arguments)", len(data)-slowLogMaxArgc+1)))
+			break
+		}
+		if len(data[i]) > slowLogMaxArgLen {
+			args = append(args, []byte(fmt.Sprintf("%s... (%d more bytes)", data[i][:slowLogMaxArgLen], len(data[i])-slowLogMaxArgLen)))
+		} else {
+			args = append(args, append([]byte(nil), data[i]...))
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e := slowLogEntry{
+		id:         l.nextId,
+		timestamp:  time.Now().Unix(),
+		costUs:     costUs,
+		args:       args,
+		addr:       addr,
+		cacheMaint: cacheMaint,
+	}
+	l.nextId++
+	if l.maxLen <= 0 {
+		return
+	}
+	if len(l.entries) < l.maxLen {
+		l.entries = append(l.entries, e)
+		return
+	}
+	l.entries[l.head] = e
+	l.head = (l.head + 1) % len(l.entries)
+}
+
+// at returns the i-th entry, oldest first.
+func (l *slowLog) at(i int) slowLogEntry {
+	return l.entries[(l.head+i)%len(l.entries)]
+}
+
+func (l *slowLog) setMaxLen(maxLen int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := len(l.entries)
+	if maxLen < n {
+		n = maxLen
+	}
+	if n < 0 {
+		n = 0
+	}
+	entries := make([]slowLogEntry, 0, n)
+	for i := len(l.entries) - n; i < len(l.entries); i++ {
+		entries = append(entries, l.at(i))
+	}
+	l.entries = entries
+	l.head = 0
+	l.maxLen = maxLen
+}
+
+func (l *slowLog) getMaxLen() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.maxLen
+}
+
+func (l *slowLog) len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.entries)
+}
+
+func (l *slowLog) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = l.entries[:0]
+	l.head = 0
+}
+
+// get returns up to n entries, newest first.
+func (l *slowLog) get(n int) []slowLogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if n < 0 || n > len(l.entries) {
+		n = len(l.entries)
+	}
+	res := make([]slowLogEntry, 0, n)
+	for i := len(l.entries) - 1; i >= len(l.entries)-n; i-- {
+		res = append(res, l.at(i))
+	}
+	return res
+}
@@ -0,0 +1,118 @@
+// Copyright 2019-2024 Xu Ruibo (hustxurb@163.com) and Contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/zuoyebang/bitalostored/butils/hash"
+	"github.com/zuoyebang/bitalostored/stored/internal/config"
+)
+
+func TestSlowLogCacheMaint(t *testing.T) {
+	cacheSize, cacheHashSize := config.GlobalConfig.Bitalos.CacheSize, config.GlobalConfig.Bitalos.CacheHashSize
+	config.GlobalConfig.Bitalos.CacheSize = 64 << 20
+	config.GlobalConfig.Bitalos.CacheHashSize = 1024
+	defer func() {
+		config.GlobalConfig.Bitalos.CacheSize = cacheSize
+		config.GlobalConfig.Bitalos.CacheHashSize = cacheHashSize
+	}()
+
+	db := openTestDB(t)
+	s := newTestServer(db)
+	s.slowTime.Store(0)
+	s.slowLogCacheMaint = true
+	c := newTestClient(s)
+
+	var maint, quiet bool
+	for i := 0; i < 100000 && !(maint && quiet); i++ {
+		key := []byte(fmt.Sprintf("slowlog_zset_%d", i))
+		c.Data = [][]byte{[]byte("zadd"), key, []byte("1"), []byte("m")}
+		c.Cmd, c.Args, c.Keys, c.KeyHash = "zadd", c.Data[1:], key, hash.Fnv32(key)
+		c.QueryStartTime = time.Now()
+		c.Writer.Reset()
+
+		before := db.CacheKeyMaintenanceCount(key, c.KeyHash)
+		if err := c.ApplyDB(0); err != nil {
+			t.Fatal(err)
+		}
+		ran := db.CacheKeyMaintenanceCount(key, c.KeyHash) != before
+		entries := s.slowLog.get(1)
+		if len(entries) != 1 || string(entries[0].args[1]) != string(key) {
+			t.Fatal("slowlog entry missing", i)
+		}
+		if entries[0].cacheMaint != ran {
+			t.Fatalf("zadd %d cacheMaint=%v maintenance=%v", i, entries[0].cacheMaint, ran)
+		}
+		if ran {
+			maint = true
+		} else {
+			quiet = true
+		}
+	}
+	if !maint {
+		t.Fatal("no cache rehash during zadd")
+	}
+	if !quiet {
+		t.Fatal("every zadd flagged")
+	}
+}
+
+func TestSlowLogRing(t *testing.T) {
+	l := newSlowLog(3)
+	ids := func() []int64 {
+		var res []int64
+		for _, e := range l.get(-1) {
+			res = append(res, e.id)
+		}
+		return res
+	}
+	check := func(expect ...int64) {
+		t.Helper()
+		if got := ids(); fmt.Sprint(got) != fmt.Sprint(expect) {
+			t.Fatalf("slowlog ids %v expect %v", got, expect)
+		}
+		if l.len() != len(expect) {
+			t.Fatalf("slowlog len %d expect %d", l.len(), len(expect))
+		}
+	}
+
+	for i := 0; i < 5; i++ {
+		l.add(int64(i), [][]byte{[]byte("get"), []byte("k")}, "", false)
+	}
+	check(4, 3, 2)
+	if e := l.get(1); len(e) != 1 || e[0].id != 4 {
+		t.Fatalf("slowlog get 1 %v", e)
+	}
+
+	l.setMaxLen(2)
+	check(4, 3)
+	l.setMaxLen(4)
+	l.add(5, nil, "", false)
+	l.add(6, nil, "", false)
+	l.add(7, nil, "", false)
+	check(7, 6, 5, 4)
+
+	l.reset()
+	check()
+	l.add(8, nil, "", false)
+	check(8)
+
+	l.setMaxLen(0)
+	l.add(9, nil, "", false)
+	check()
+}
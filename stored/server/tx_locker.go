@@ -17,11 +17,14 @@ package server
 import (
 	"sync"
 	"sync/atomic"
+	"time"
 
-	"github.com/zuoyebang/bitalostored/butils/hash"
 	"github.com/zuoyebang/bitalostored/butils/unsafe2"
+	"github.com/zuoyebang/bitalostored/stored/internal/utils"
 )
 
+const txJanitorInterval = 60 * time.Second
+
 type TxLocker struct {
 	sync.RWMutex
 	txWatchKeys map[string]*TxWatchKey
@@ -58,7 +61,7 @@ func (sl *TxShardLocker) GetTxLock(khash uint32) *TxLocker {
 }
 
 func (sl *TxShardLocker) GetTxLockByKey(key []byte) *TxLocker {
-	return sl.lockers[hash.Fnv32(key)%sl.cap]
+	return sl.lockers[utils.KeyHash(key)%sl.cap]
 }
 
 func (sl *TxShardLocker) GetWatchKeyWithKhash(khash uint32, keyStr string) *TxWatchKey {
@@ -69,7 +72,7 @@ func (sl *TxShardLocker) GetWatchKeyWithKhash(khash uint32, keyStr string) *TxWa
 }
 
 func (sl *TxShardLocker) GetWatchKey(keyStr string) *TxWatchKey {
-	khash := hash.Fnv32(unsafe2.ByteSlice(keyStr))
+	khash := utils.KeyHash(unsafe2.ByteSlice(keyStr))
 	return sl.GetWatchKeyWithKhash(khash, keyStr)
 }
 
@@ -105,3 +108,47 @@ func (txLock *TxLocker) removeWatchKey(c *Client, watchKey string) {
 		}
 	}
 }
+
+// reclaim drops the closed clients of unwatched keys and the keys left without
+// clients. Keys locked by a running prepare are skipped.
+func (txLock *TxLocker) reclaim() (n int) {
+	txLock.Lock()
+	defer txLock.Unlock()
+
+	for key, wk := range txLock.txWatchKeys {
+		if wk.watched.Load() || !wk.mu.TryLock() {
+			continue
+		}
+		for c := range wk.txClients {
+			if c.closed.Load() {
+				delete(wk.txClients, c)
+			}
+		}
+		wk.mu.Unlock()
+		if len(wk.txClients) <= 0 {
+			delete(txLock.txWatchKeys, key)
+			n++
+		}
+	}
+	return n
+}
+
+func (sl *TxShardLocker) reclaim() (n int) {
+	for _, l := range sl.lockers {
+		n += l.reclaim()
+	}
+	return n
+}
+
+func (sl *TxShardLocker) runJanitor(quit <-chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+			sl.reclaim()
+		}
+	}
+}
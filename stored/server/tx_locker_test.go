@@ -0,0 +1,84 @@
+// Copyright 2019-2024 Xu Ruibo (hustxurb@163.com) and Contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTxLockerReclaim(t *testing.T) {
+	sl := NewTxLockers(8)
+	watchCount := func() (n int) {
+		for _, l := range sl.lockers {
+			l.RLock()
+			n += len(l.txWatchKeys)
+			l.RUnlock()
+		}
+		return n
+	}
+
+	live := &Client{}
+	closed := make([]*Client, 10)
+	for i := range closed {
+		closed[i] = &Client{}
+		for j := 0; j < 100; j++ {
+			key := fmt.Sprintf("tx_reclaim_%d_%d", i, j)
+			sl.GetTxLockByKey([]byte(key)).addWatchKey(closed[i], key, false)
+		}
+	}
+	sl.GetTxLockByKey([]byte("tx_reclaim_0_0")).addWatchKey(live, "tx_reclaim_0_0", false)
+	sl.GetTxLockByKey([]byte("tx_reclaim_watched")).addWatchKey(closed[0], "tx_reclaim_watched", true)
+	locked := sl.GetTxLockByKey([]byte("tx_reclaim_locked")).addWatchKey(closed[0], "tx_reclaim_locked", false)
+	locked.mu.Lock()
+
+	if n := sl.reclaim(); n != 0 {
+		t.Fatalf("reclaim live clients got %d", n)
+	}
+	for _, c := range closed {
+		c.closed.Store(true)
+	}
+
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		sl.runJanitor(quit, 10*time.Millisecond)
+		close(done)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for watchCount() != 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(quit)
+	<-done
+	if n := watchCount(); n != 3 {
+		t.Fatalf("watch keys after reclaim got %d", n)
+	}
+	if wk := sl.GetWatchKey("tx_reclaim_0_0"); wk == nil || len(wk.txClients) != 1 {
+		t.Fatal("watch key of live client reclaimed")
+	}
+	if sl.GetWatchKey("tx_reclaim_watched") == nil {
+		t.Fatal("watched key reclaimed")
+	}
+	if sl.GetWatchKey("tx_reclaim_locked") == nil {
+		t.Fatal("locked watch key reclaimed")
+	}
+
+	locked.mu.Unlock()
+	if n := sl.reclaim(); n != 1 || sl.GetWatchKey("tx_reclaim_locked") != nil {
+		t.Fatalf("reclaim unlocked key got %d", n)
+	}
+}